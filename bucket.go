@@ -2,6 +2,7 @@ package bolt
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"unsafe"
 )
@@ -41,6 +42,9 @@ type Bucket struct {
 	page     *page              // inline page reference	内联页引用
 	rootNode *node              // materialized node for the root page.
 	nodes    map[pgid]*node     // node cache
+	version  uint64             // incremented whenever a node is modified
+	parent   *Bucket            // bucket containing this bucket, if opened by name
+	name     []byte             // key of this bucket in its parent
 
 	// Sets the threshold for filling nodes when they split. By default,
 	// the bucket will fill to 50% but it can be useful to increase this
@@ -70,6 +74,34 @@ func newBucket(tx *Tx) Bucket {
 	return b
 }
 
+// path returns the names of the buckets from the top-level bucket to b.
+func (b *Bucket) path() [][]byte {
+	var path [][]byte
+	for ; b != nil && b.parent != nil; b = b.parent {
+		path = append(path, b.name)
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+// childPath returns the path of the child bucket with the given name.
+func (b *Bucket) childPath(name []byte) [][]byte {
+	return append(b.path(), cloneBytes(name))
+}
+
+// bucketError returns a *BucketError for the child bucket with the given
+// name.
+func (b *Bucket) bucketError(name []byte, err error) error {
+	return &BucketError{Path: b.childPath(name), Err: err}
+}
+
+// keyError returns a *KeyError for a key in the bucket.
+func (b *Bucket) keyError(key []byte, err error) error {
+	return &KeyError{Bucket: b.path(), Key: cloneBytes(key), Err: err}
+}
+
 // Tx returns the tx of the bucket.
 func (b *Bucket) Tx() *Tx {
 	return b.tx
@@ -89,6 +121,10 @@ func (b *Bucket) Writable() bool {
 // The cursor is only valid as long as the transaction is open.
 // Do not use a cursor after the transaction is closed.
 func (b *Bucket) Cursor() *Cursor {
+	if l := b.tx.recorder(); l != nil {
+		return l.cursor(b)
+	}
+
 	// Update transaction statistics.
 	b.tx.stats.CursorCount++
 
@@ -103,6 +139,10 @@ func (b *Bucket) Cursor() *Cursor {
 // Returns nil if the bucket does not exist.
 // The bucket instance is only valid for the lifetime of the transaction.
 func (b *Bucket) Bucket(name []byte) *Bucket {
+	defer b.tx.catch(nil)
+	if l := b.tx.recorder(); l != nil {
+		return l.bucket(b, name)
+	}
 	if b.buckets != nil {
 		if child := b.buckets[string(name)]; child != nil {
 			return child
@@ -116,10 +156,17 @@ func (b *Bucket) Bucket(name []byte) *Bucket {
 	// Return nil if the key doesn't exist or it is not a bucket.
 	if !bytes.Equal(name, k) || (flags&bucketLeafFlag) == 0 {
 		return nil
+	} else if len(v) < bucketHeaderSize {
+		corrupted(c.pageID(), "bucket header too short: %d bytes", len(v))
 	}
 
-	// Otherwise create a bucket and cache it.
+	// Otherwise create a bucket and cache it. Writable transactions may
+	// change the key so it is copied.
 	var child = b.openBucket(v)
+	child.parent, child.name = b, k
+	if b.tx.writable {
+		child.name = cloneBytes(k)
+	}
 	// 加速缓存的作用
 	if b.buckets != nil {
 		b.buckets[string(name)] = child
@@ -162,13 +209,17 @@ func (b *Bucket) openBucket(value []byte) *Bucket {
 // CreateBucket creates a new bucket at the given key and returns the new bucket.
 // Returns an error if the key already exists, if the bucket name is blank, or if the bucket name is too long.
 // The bucket instance is only valid for the lifetime of the transaction.
-func (b *Bucket) CreateBucket(key []byte) (*Bucket, error) {
+func (b *Bucket) CreateBucket(key []byte) (_ *Bucket, err error) {
+	defer b.tx.catch(&err)
+	if l := b.tx.recorder(); l != nil {
+		return l.createBucket(b, key, opCreateBucket)
+	}
 	if b.tx.db == nil {
 		return nil, ErrTxClosed
 	} else if !b.tx.writable {
 		return nil, ErrTxNotWritable
 	} else if len(key) == 0 {
-		return nil, ErrBucketNameRequired
+		return nil, b.bucketError(key, ErrBucketNameRequired)
 	}
 
 	// Move cursor to correct position.
@@ -181,10 +232,10 @@ func (b *Bucket) CreateBucket(key []byte) (*Bucket, error) {
 	if bytes.Equal(key, k) {
 		// 是桶,已经存在了
 		if (flags & bucketLeafFlag) != 0 {
-			return nil, ErrBucketExists
+			return nil, b.bucketError(key, ErrBucketExists)
 		}
 		// 不是桶、但key已经存在了
-		return nil, ErrIncompatibleValue
+		return nil, b.bucketError(key, ErrIncompatibleValue)
 	}
 
 	// Create empty, inline bucket.
@@ -214,9 +265,14 @@ func (b *Bucket) CreateBucket(key []byte) (*Bucket, error) {
 // CreateBucketIfNotExists creates a new bucket if it doesn't already exist and returns a reference to it.
 // Returns an error if the bucket name is blank, or if the bucket name is too long.
 // The bucket instance is only valid for the lifetime of the transaction.
-func (b *Bucket) CreateBucketIfNotExists(key []byte) (*Bucket, error) {
+func (b *Bucket) CreateBucketIfNotExists(key []byte) (_ *Bucket, err error) {
+	defer b.tx.catch(&err)
+	if l := b.tx.recorder(); l != nil {
+		return l.createBucket(b, key, opCreateBucketIfNotExists)
+	}
+
 	child, err := b.CreateBucket(key)
-	if err == ErrBucketExists {
+	if errors.Is(err, ErrBucketExists) {
 		return b.Bucket(key), nil
 	} else if err != nil {
 		return nil, err
@@ -226,7 +282,11 @@ func (b *Bucket) CreateBucketIfNotExists(key []byte) (*Bucket, error) {
 
 // DeleteBucket deletes a bucket at the given key.
 // Returns an error if the bucket does not exists, or if the key represents a non-bucket value.
-func (b *Bucket) DeleteBucket(key []byte) error {
+func (b *Bucket) DeleteBucket(key []byte) (err error) {
+	defer b.tx.catch(&err)
+	if l := b.tx.recorder(); l != nil {
+		return l.deleteBucket(b, key)
+	}
 	if b.tx.db == nil {
 		return ErrTxClosed
 	} else if !b.Writable() {
@@ -239,18 +299,18 @@ func (b *Bucket) DeleteBucket(key []byte) error {
 
 	// Return an error if bucket doesn't exist or is not a bucket.
 	if !bytes.Equal(key, k) {
-		return ErrBucketNotFound
+		return b.bucketError(key, ErrBucketNotFound)
 	} else if (flags & bucketLeafFlag) == 0 {
-		return ErrIncompatibleValue
+		return b.bucketError(key, ErrIncompatibleValue)
 	}
 
 	// Recursively delete all child buckets.
 	child := b.Bucket(key)
 	// 递归删除子桶
-	err := child.ForEach(func(k, v []byte) error {
+	err = child.ForEach(func(k, v []byte) error {
 		if v == nil {
 			if err := child.DeleteBucket(k); err != nil {
-				return fmt.Errorf("delete bucket: %s", err)
+				return fmt.Errorf("delete bucket: %w", err)
 			}
 		}
 		return nil
@@ -280,6 +340,11 @@ func (b *Bucket) DeleteBucket(key []byte) error {
 // Returns a nil value if the key does not exist or if the key is a nested bucket.
 // The returned value is only valid for the life of the transaction.
 func (b *Bucket) Get(key []byte) []byte {
+	defer b.tx.catch(nil)
+	if l := b.tx.recorder(); l != nil {
+		return l.get(b, key)
+	}
+
 	k, v, flags := b.Cursor().seek(key)
 
 	// Return nil if this is a bucket.
@@ -299,17 +364,21 @@ func (b *Bucket) Get(key []byte) []byte {
 // If the key exist then its previous value will be overwritten.
 // Supplied value must remain valid for the life of the transaction.
 // Returns an error if the bucket was created from a read-only transaction, if the key is blank, if the key is too large, or if the value is too large.
-func (b *Bucket) Put(key []byte, value []byte) error {
+func (b *Bucket) Put(key []byte, value []byte) (err error) {
+	defer b.tx.catch(&err)
+	if l := b.tx.recorder(); l != nil {
+		return l.put(b, key, value)
+	}
 	if b.tx.db == nil {
 		return ErrTxClosed
 	} else if !b.Writable() {
 		return ErrTxNotWritable
 	} else if len(key) == 0 {
-		return ErrKeyRequired
+		return b.keyError(key, ErrKeyRequired)
 	} else if len(key) > MaxKeySize {
-		return ErrKeyTooLarge
+		return b.keyError(key, ErrKeyTooLarge)
 	} else if int64(len(value)) > MaxValueSize {
-		return ErrValueTooLarge
+		return b.keyError(key, ErrValueTooLarge)
 	}
 
 	// Move cursor to correct position.
@@ -319,7 +388,7 @@ func (b *Bucket) Put(key []byte, value []byte) error {
 	// Return an error if there is an existing key with a bucket value.
 	// 已存在叶子节点,无法插入
 	if bytes.Equal(key, k) && (flags&bucketLeafFlag) != 0 {
-		return ErrIncompatibleValue
+		return b.keyError(key, ErrIncompatibleValue)
 	}
 
 	// Insert into node.
@@ -332,7 +401,11 @@ func (b *Bucket) Put(key []byte, value []byte) error {
 // Delete removes a key from the bucket.
 // If the key does not exist then nothing is done and a nil error is returned.
 // Returns an error if the bucket was created from a read-only transaction.
-func (b *Bucket) Delete(key []byte) error {
+func (b *Bucket) Delete(key []byte) (err error) {
+	defer b.tx.catch(&err)
+	if l := b.tx.recorder(); l != nil {
+		return l.delete(b, key)
+	}
 	if b.tx.db == nil {
 		return ErrTxClosed
 	} else if !b.Writable() {
@@ -346,7 +419,7 @@ func (b *Bucket) Delete(key []byte) error {
 	// Return an error if there is already existing bucket value.
 	// 内联桶不能删,只能删key-value对
 	if (flags & bucketLeafFlag) != 0 {
-		return ErrIncompatibleValue
+		return b.keyError(key, ErrIncompatibleValue)
 	}
 
 	// Delete the node if we have a matching key.
@@ -356,10 +429,19 @@ func (b *Bucket) Delete(key []byte) error {
 }
 
 // Sequence returns the current integer for the bucket without incrementing it.
-func (b *Bucket) Sequence() uint64 { return b.bucket.sequence }
+func (b *Bucket) Sequence() uint64 {
+	if l := b.tx.recorder(); l != nil {
+		return l.sequence(b)
+	}
+	return b.bucket.sequence
+}
 
 // SetSequence updates the sequence number for the bucket.
-func (b *Bucket) SetSequence(v uint64) error {
+func (b *Bucket) SetSequence(v uint64) (err error) {
+	defer b.tx.catch(&err)
+	if l := b.tx.recorder(); l != nil {
+		return l.setSequence(b, v)
+	}
 	if b.tx.db == nil {
 		return ErrTxClosed
 	} else if !b.Writable() {
@@ -378,7 +460,11 @@ func (b *Bucket) SetSequence(v uint64) error {
 }
 
 // NextSequence returns an autoincrementing integer for the bucket.
-func (b *Bucket) NextSequence() (uint64, error) {
+func (b *Bucket) NextSequence() (_ uint64, err error) {
+	defer b.tx.catch(&err)
+	if l := b.tx.recorder(); l != nil {
+		return l.nextSequence(b)
+	}
 	if b.tx.db == nil {
 		return 0, ErrTxClosed
 	} else if !b.Writable() {
@@ -398,9 +484,12 @@ func (b *Bucket) NextSequence() (uint64, error) {
 
 // ForEach executes a function for each key/value pair in a bucket.
 // If the provided function returns an error then the iteration is stopped and
-// the error is returned to the caller. The provided function must not modify
-// the bucket; this will result in undefined behavior.
-func (b *Bucket) ForEach(fn func(k, v []byte) error) error {
+// the error is returned to the caller. The provided function may modify the
+// bucket; iteration continues from the key after the one last visited.
+// If the transaction's context is done then the iteration is stopped and
+// the context's error is returned.
+func (b *Bucket) ForEach(fn func(k, v []byte) error) (err error) {
+	defer b.tx.catch(&err)
 	if b.tx.db == nil {
 		return ErrTxClosed
 	}
@@ -410,11 +499,120 @@ func (b *Bucket) ForEach(fn func(k, v []byte) error) error {
 			return err
 		}
 	}
-	return nil
+	return c.Err()
+}
+
+// Count returns the number of keys in the bucket. Nested bucket keys are
+// counted but their contents are not.
+//
+// Branch pages written with DB.OrderStatistics enabled carry the number of
+// keys below each child so the count is computed in logarithmic time. Other
+// subtrees are walked.
+func (b *Bucket) Count() int {
+	defer b.tx.catch(nil)
+	if l := b.tx.recorder(); l != nil {
+		return l.count(b)
+	}
+	if b.tx.db == nil {
+		return 0
+	}
+	return b.keyCount(b.root)
+}
+
+// CountRange returns the number of keys k in the bucket where start <= k < end.
+// A nil start counts from the first key and a nil end counts to the last key.
+func (b *Bucket) CountRange(start, end []byte) int {
+	defer b.tx.catch(nil)
+	if l := b.tx.recorder(); l != nil {
+		return l.countRange(b, start, end)
+	}
+	if b.tx.db == nil {
+		return 0
+	}
+
+	var lo, hi int
+	if start != nil {
+		lo = b.rankOf(start)
+	}
+	if end != nil {
+		hi = b.rankOf(end)
+	} else {
+		hi = b.keyCount(b.root)
+	}
+
+	if hi < lo {
+		return 0
+	}
+	return hi - lo
+}
+
+// rankOf returns the number of keys in the bucket that sort before key.
+func (b *Bucket) rankOf(key []byte) int {
+	c := b.Cursor()
+	c.seek(key)
+	return c.rank()
+}
+
+// keyCount returns the number of keys under a page or node.
+func (b *Bucket) keyCount(id pgid) int {
+	p, n := b.pageNode(id)
+	if n == nil {
+		return b.pageKeyCount(p)
+	}
+
+	var ref = elemRef{node: n}
+	if ref.isLeaf() {
+		return ref.count()
+	}
+	var count int
+	for i := 0; i < ref.count(); i++ {
+		count += b.childKeyCount(&ref, i)
+	}
+	return count
+}
+
+// pageKeyCount returns the number of keys under a page. Stored subtree counts
+// are used when available, otherwise the child pages are visited.
+func (b *Bucket) pageKeyCount(p *page) int {
+	if (p.flags & leafPageFlag) != 0 {
+		return int(p.count)
+	} else if (p.flags & countedPageFlag) != 0 {
+		var count int
+		for _, c := range p.branchPageCounts() {
+			count += int(c)
+		}
+		return count
+	}
+
+	var count int
+	for i := 0; i < int(p.count); i++ {
+		count += b.pageKeyCount(b.tx.page(p.branchPageElement(uint16(i)).pgid))
+	}
+	return count
+}
+
+// childKeyCount returns the number of keys under the child at a given index
+// of a branch page or node.
+func (b *Bucket) childKeyCount(ref *elemRef, index int) int {
+	if ref.node == nil {
+		if (ref.page.flags & countedPageFlag) != 0 {
+			return int(ref.page.branchPageCounts()[index])
+		}
+		return b.pageKeyCount(b.tx.page(ref.page.branchPageElement(uint16(index)).pgid))
+	}
+
+	// Stored counts are stale for children that have been materialized
+	// since they may have been modified in this transaction.
+	inode := &ref.node.inodes[index]
+	if _, child := b.pageNode(inode.pgid); child == nil && ref.node.counted {
+		return int(inode.count)
+	}
+	return b.keyCount(inode.pgid)
 }
 
 // Stat returns stats on a bucket.
 func (b *Bucket) Stats() BucketStats {
+	defer b.tx.catch(nil)
 	var s, subStats BucketStats
 	pageSize := b.tx.db.pageSize
 	s.BucketN += 1
@@ -706,6 +904,15 @@ func (b *Bucket) node(pgid pgid, parent *node) *node {
 	n.read(p)
 	b.nodes[pgid] = n
 
+	// Backfill subtree counts for branch pages written without them so the
+	// counts are carried forward when the node is spilled.
+	if !n.isLeaf && !n.counted && b.tx.db.OrderStatistics {
+		for i := range n.inodes {
+			n.inodes[i].count = uint64(b.pageKeyCount(b.tx.page(n.inodes[i].pgid)))
+		}
+		n.counted = true
+	}
+
 	// Update statistics.
 	b.tx.stats.NodeCount++
 
@@ -718,14 +925,18 @@ func (b *Bucket) free() {
 		return
 	}
 
+	// Optimistic transactions never touch the freelist. The pages are freed
+	// when the deletion is replayed on commit.
 	var tx = b.tx
-	b.forEachPageNode(func(p *page, n *node, _ int) {
-		if p != nil {
-			tx.db.freelist.free(tx.meta.txid, p)
-		} else {
-			n.free()
-		}
-	})
+	if !tx.optimistic {
+		b.forEachPageNode(func(p *page, n *node, _ int) {
+			if p != nil {
+				tx.db.freelist.free(tx.meta.txid, p)
+			} else {
+				n.free()
+			}
+		})
+	}
 	b.root = 0
 }
 
@@ -748,7 +959,7 @@ func (b *Bucket) pageNode(id pgid) (*page, *node) {
 	// differently. We'll return the rootNode (if available) or the fake page.
 	if b.root == 0 {
 		if id != 0 {
-			panic(fmt.Sprintf("inline bucket non-zero page access(2): %d != 0", id))
+			corrupted(id, "inline bucket non-zero page access")
 		}
 		if b.rootNode != nil {
 			return nil, b.rootNode
@@ -764,7 +975,16 @@ func (b *Bucket) pageNode(id pgid) (*page, *node) {
 	}
 
 	// Finally lookup the page from the transaction if no node is materialized.
-	return b.tx.page(id), nil
+	if id < 2 || id >= b.tx.meta.pgid {
+		corrupted(id, "page out of bounds: high water mark %d", b.tx.meta.pgid)
+	}
+	p := b.tx.page(id)
+	if p.id != id {
+		corrupted(id, "page header has id %d", p.id)
+	} else if (p.flags & (branchPageFlag | leafPageFlag)) == 0 {
+		corrupted(id, "invalid page type: %s", p.typ())
+	}
+	return p, nil
 }
 
 // BucketStats records statistics about resources used by a bucket.
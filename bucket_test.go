@@ -250,7 +250,7 @@ func TestBucket_Put_IncompatibleValue(t *testing.T) {
 		if _, err := tx.Bucket([]byte("widgets")).CreateBucket([]byte("foo")); err != nil {
 			t.Fatal(err)
 		}
-		if err := b0.Put([]byte("foo"), []byte("bar")); err != bolt.ErrIncompatibleValue {
+		if err := b0.Put([]byte("foo"), []byte("bar")); !errors.Is(err, bolt.ErrIncompatibleValue) {
 			t.Fatalf("unexpected error: %s", err)
 		}
 		return nil
@@ -524,7 +524,7 @@ func TestBucket_Delete_Bucket(t *testing.T) {
 		if _, err := b.CreateBucket([]byte("foo")); err != nil {
 			t.Fatal(err)
 		}
-		if err := b.Delete([]byte("foo")); err != bolt.ErrIncompatibleValue {
+		if err := b.Delete([]byte("foo")); !errors.Is(err, bolt.ErrIncompatibleValue) {
 			t.Fatalf("unexpected error: %s", err)
 		}
 		return nil
@@ -751,7 +751,7 @@ func TestBucket_CreateBucket_IncompatibleValue(t *testing.T) {
 		if err := widgets.Put([]byte("foo"), []byte("bar")); err != nil {
 			t.Fatal(err)
 		}
-		if _, err := widgets.CreateBucket([]byte("foo")); err != bolt.ErrIncompatibleValue {
+		if _, err := widgets.CreateBucket([]byte("foo")); !errors.Is(err, bolt.ErrIncompatibleValue) {
 			t.Fatalf("unexpected error: %s", err)
 		}
 		return nil
@@ -773,7 +773,7 @@ func TestBucket_DeleteBucket_IncompatibleValue(t *testing.T) {
 		if err := widgets.Put([]byte("foo"), []byte("bar")); err != nil {
 			t.Fatal(err)
 		}
-		if err := tx.Bucket([]byte("widgets")).DeleteBucket([]byte("foo")); err != bolt.ErrIncompatibleValue {
+		if err := tx.Bucket([]byte("widgets")).DeleteBucket([]byte("foo")); !errors.Is(err, bolt.ErrIncompatibleValue) {
 			t.Fatalf("unexpected error: %s", err)
 		}
 		return nil
@@ -1006,6 +1006,53 @@ func TestBucket_ForEach(t *testing.T) {
 	}
 }
 
+// Ensure that the bucket can be modified from within ForEach.
+func TestBucket_ForEach_Modify(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put(u64tob(uint64(i)), []byte("0")); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Replace each key with a larger one that sorts after the current key.
+		var keys []uint64
+		if err := b.ForEach(func(k, v []byte) error {
+			keys = append(keys, btou64(k))
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+			if i := btou64(k); i < 1000 {
+				return b.Put(u64tob(i+1000), []byte("1"))
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) != 2000 {
+			t.Fatalf("unexpected key count: %d", len(keys))
+		}
+		for i, k := range keys {
+			if k != uint64(i) {
+				t.Fatalf("unexpected key: %d != %d", k, i)
+			}
+		}
+		if n := b.Count(); n != 0 {
+			t.Fatalf("unexpected count: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
 // Ensure a database can stop iteration early.
 func TestBucket_ForEach_ShortCircuit(t *testing.T) {
 	db := MustOpenDB()
@@ -1079,10 +1126,10 @@ func TestBucket_Put_EmptyKey(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		if err := b.Put([]byte(""), []byte("bar")); err != bolt.ErrKeyRequired {
+		if err := b.Put([]byte(""), []byte("bar")); !errors.Is(err, bolt.ErrKeyRequired) {
 			t.Fatalf("unexpected error: %s", err)
 		}
-		if err := b.Put(nil, []byte("bar")); err != bolt.ErrKeyRequired {
+		if err := b.Put(nil, []byte("bar")); !errors.Is(err, bolt.ErrKeyRequired) {
 			t.Fatalf("unexpected error: %s", err)
 		}
 		return nil
@@ -1100,7 +1147,7 @@ func TestBucket_Put_KeyTooLarge(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		if err := b.Put(make([]byte, 32769), []byte("bar")); err != bolt.ErrKeyTooLarge {
+		if err := b.Put(make([]byte, 32769), []byte("bar")); !errors.Is(err, bolt.ErrKeyTooLarge) {
 			t.Fatalf("unexpected error: %s", err)
 		}
 		return nil
@@ -1124,7 +1171,7 @@ func TestBucket_Put_ValueTooLarge(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		if err := b.Put([]byte("foo"), make([]byte, bolt.MaxValueSize+1)); err != bolt.ErrValueTooLarge {
+		if err := b.Put([]byte("foo"), make([]byte, bolt.MaxValueSize+1)); !errors.Is(err, bolt.ErrValueTooLarge) {
 			t.Fatalf("unexpected error: %s", err)
 		}
 		return nil
@@ -1133,6 +1180,115 @@ func TestBucket_Put_ValueTooLarge(t *testing.T) {
 	}
 }
 
+// Ensure a bucket can count its keys with and without stored subtree counts.
+func TestBucket_Count(t *testing.T) {
+	for _, counted := range []bool{false, true} {
+		db := MustOpenWithOption(&bolt.Options{OrderStatistics: counted})
+
+		// Insert enough keys to create several levels of branch pages.
+		const n = 20000
+		if err := db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucket([]byte("widgets"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < n; i++ {
+				if err := b.Put(u64tob(uint64(i)), []byte("0123456789")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := b.CreateBucket([]byte("zzz")); err != nil {
+				t.Fatal(err)
+			}
+			if c := b.Count(); c != n+1 {
+				t.Fatalf("unexpected count in tx: %d", c)
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		// Delete every third key to force rebalancing.
+		var exp = n + 1
+		if err := db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte("widgets"))
+			for i := 0; i < n; i += 3 {
+				if err := b.Delete(u64tob(uint64(i))); err != nil {
+					t.Fatal(err)
+				}
+				exp--
+			}
+			if c := b.Count(); c != exp {
+				t.Fatalf("unexpected count before commit: %d != %d", c, exp)
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte("widgets"))
+			if c := b.Count(); c != exp {
+				t.Fatalf("unexpected count (counted=%v): %d != %d", counted, c, exp)
+			} else if keyN := b.Stats().KeyN; c != keyN {
+				t.Fatalf("count does not match stats: %d != %d", c, keyN)
+			}
+			if c := tx.Bucket([]byte("widgets")).Bucket([]byte("zzz")).Count(); c != 0 {
+				t.Fatalf("unexpected nested count: %d", c)
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		db.MustClose()
+	}
+}
+
+// Ensure that keys are counted correctly for a range.
+func TestBucket_CountRange(t *testing.T) {
+	db := MustOpenWithOption(&bolt.Options{OrderStatistics: true})
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 10000; i += 2 {
+			if err := b.Put(u64tob(uint64(i)), []byte("0123456789")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		for _, tt := range []struct {
+			start, end []byte
+			exp        int
+		}{
+			{nil, nil, 5000},
+			{u64tob(0), u64tob(10), 5},
+			{u64tob(1), u64tob(11), 5},
+			{u64tob(1000), nil, 4500},
+			{nil, u64tob(1000), 500},
+			{u64tob(2000), u64tob(1000), 0},
+			{u64tob(20000), nil, 0},
+		} {
+			if n := b.CountRange(tt.start, tt.end); n != tt.exp {
+				t.Fatalf("unexpected count for [%x, %x): %d != %d", tt.start, tt.end, n, tt.exp)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
 // Ensure a bucket can calculate stats.
 func TestBucket_Stats(t *testing.T) {
 	db := MustOpenDB()
@@ -0,0 +1,203 @@
+package bolt
+
+import (
+	"bytes"
+	"iter"
+)
+
+// BulkLoad fills an empty bucket with key/value pairs from a sorted sequence.
+// Keys must be unique and in ascending order.
+//
+// Instead of inserting each key into a node and splitting nodes on commit,
+// leaf and branch pages are built bottom-up at the bucket's FillPercent and
+// written to the data file as soon as they are full. Only one page per level
+// of the tree is held in memory so this is much faster and uses far less
+// memory than calling Put() for large inputs. Set FillPercent to 1.0 before
+// loading if the bucket will mostly be read or appended to.
+//
+// Pages are written without resizing the mmap so keys and values read earlier
+// in the transaction stay valid and the sequence may read from other buckets
+// in the same transaction. The mmap is resized when the transaction commits.
+// Keys and values are copied so the sequence may reuse them.
+//
+// Returns ErrBucketNotEmpty if the bucket has any keys and ErrKeysUnsorted
+// if a key is not greater than the previous key. Pages written before an
+// error are released when the transaction is committed or rolled back.
+//
+// Optimistic transactions cannot write pages before they commit so the keys
+// are inserted with Put() instead.
+func (b *Bucket) BulkLoad(seq iter.Seq2[[]byte, []byte]) (err error) {
+	defer b.tx.catch(&err)
+	if b.tx.db == nil {
+		return ErrTxClosed
+	} else if !b.Writable() {
+		return ErrTxNotWritable
+	} else if k, _ := b.Cursor().First(); k != nil {
+		return &BucketError{Path: b.path(), Err: ErrBucketNotEmpty}
+	}
+
+	l := &bulkLoader{bucket: b, threshold: b.threshold()}
+	var prev []byte
+	for k, v := range seq {
+		if len(k) == 0 {
+			return l.abort(b.keyError(k, ErrKeyRequired))
+		} else if len(k) > MaxKeySize {
+			return l.abort(b.keyError(k, ErrKeyTooLarge))
+		} else if int64(len(v)) > MaxValueSize {
+			return l.abort(b.keyError(k, ErrValueTooLarge))
+		} else if prev != nil && bytes.Compare(k, prev) <= 0 {
+			return l.abort(b.keyError(k, ErrKeysUnsorted))
+		}
+
+		k, v = cloneBytes(k), cloneBytes(v)
+		if b.tx.optimistic {
+			if err := b.Put(k, v); err != nil {
+				return err
+			}
+			prev = k
+			continue
+		}
+		if err := l.add(0, inode{key: k, value: v}); err != nil {
+			return l.abort(err)
+		}
+		prev = k
+	}
+
+	// Stop if the sequence was empty.
+	if len(l.levels) == 0 {
+		return nil
+	}
+
+	// Small inputs fit within a single leaf so insert them normally. This
+	// lets the bucket be stored inline.
+	if len(l.pgids) == 0 {
+		for _, item := range l.levels[0] {
+			if err := b.Put(item.key, item.value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Write out the partial page at each level. The top level always holds
+	// at least two children so it becomes the root.
+	var root pgid
+	for i := 0; i < len(l.levels); i++ {
+		parent, err := l.write(i)
+		if err != nil {
+			return l.abort(err)
+		} else if i == len(l.levels)-1 {
+			root = parent.pgid
+			break
+		} else if err := l.add(i+1, parent); err != nil {
+			return l.abort(err)
+		}
+	}
+
+	// Replace the empty root with the new tree. The root is materialized so
+	// that the bucket header in the parent is updated on commit.
+	if b.root != 0 {
+		b.free()
+	}
+	b.root = root
+	b.page = nil
+	b.rootNode = nil
+	b.nodes = make(map[pgid]*node)
+	b.node(b.root, nil)
+	b.version++
+
+	return nil
+}
+
+// threshold returns the size that pages are filled to before splitting.
+func (b *Bucket) threshold() int {
+	var fillPercent = b.FillPercent
+	if fillPercent < minFillPercent {
+		fillPercent = minFillPercent
+	} else if fillPercent > maxFillPercent {
+		fillPercent = maxFillPercent
+	}
+	return int(float64(b.tx.db.pageSize) * fillPercent)
+}
+
+// bulkLoader builds the levels of a B+tree from sorted keys. levels[0] holds
+// the elements of the current leaf and each higher level holds the elements
+// of the current branch page one level up.
+type bulkLoader struct {
+	bucket    *Bucket
+	threshold int
+	levels    []inodes
+	sizes     []int
+	pgids     []pgid // pages written so far
+}
+
+// add appends an element to the current page at a level. The current page is
+// written out first if the element would push it past the fill threshold.
+func (l *bulkLoader) add(level int, item inode) error {
+	if level == len(l.levels) {
+		l.levels = append(l.levels, nil)
+		l.sizes = append(l.sizes, pageHeaderSize)
+	}
+
+	n := l.node(level)
+	elsize := n.pageElementSize() + len(item.key) + len(item.value)
+	if len(l.levels[level]) >= minKeysPerPage && l.sizes[level]+elsize > l.threshold {
+		parent, err := l.write(level)
+		if err != nil {
+			return err
+		} else if err := l.add(level+1, parent); err != nil {
+			return err
+		}
+	}
+
+	l.levels[level] = append(l.levels[level], item)
+	l.sizes[level] += elsize
+	return nil
+}
+
+// write writes the current page at a level to disk and returns the element
+// that references it from the level above.
+func (l *bulkLoader) write(level int) (inode, error) {
+	var tx = l.bucket.tx
+	n := l.node(level)
+
+	// Allocate and write the page directly instead of holding it in the
+	// transaction's dirty page cache until commit.
+	p := tx.db.allocateUnmapped((n.size() / tx.db.pageSize) + 1)
+	n.write(p)
+	id := p.id
+	l.pgids = append(l.pgids, id)
+	tx.stats.PageCount++
+	tx.stats.PageAlloc += (int(p.overflow) + 1) * tx.db.pageSize
+	err := tx.writePage(p)
+	tx.db.recyclePage(p)
+	if err != nil {
+		return inode{}, err
+	}
+
+	count, _ := n.keyCount()
+	parent := inode{key: n.inodes[0].key, pgid: id, count: count}
+	l.levels[level] = nil
+	l.sizes[level] = pageHeaderSize
+	return parent, nil
+}
+
+// node returns a node wrapping the current page at a level.
+func (l *bulkLoader) node(level int) *node {
+	return &node{
+		bucket:  l.bucket,
+		isLeaf:  level == 0,
+		counted: level > 0 && l.bucket.tx.db.OrderStatistics,
+		inodes:  l.levels[level],
+	}
+}
+
+// abort releases the pages written so far and returns err.
+func (l *bulkLoader) abort(err error) error {
+	var tx = l.bucket.tx
+	for _, id := range l.pgids {
+		tx.db.freelist.free(tx.meta.txid, tx.page(id))
+	}
+	l.pgids = nil
+	return err
+}
@@ -0,0 +1,413 @@
+package bolt_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"iter"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// seqN returns a sequence of n sorted keys. Every 1000th value is larger than
+// a page so that overflow pages are written.
+func seqN(n int) iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		k, v := make([]byte, 8), make([]byte, 0, 5000)
+		for i := 0; i < n; i++ {
+			copy(k, u64tob(uint64(i)))
+			v = v[:0]
+			if i%1000 == 999 {
+				v = append(v, bytes.Repeat([]byte{'x'}, 4500)...)
+			}
+			v = append(v, fmt.Sprintf("%d", i)...)
+			if !yield(k, v) {
+				return
+			}
+		}
+	}
+}
+
+// Ensure that a bucket can be bulk loaded with sorted keys.
+func TestBucket_BulkLoad(t *testing.T) {
+	for _, orderStatistics := range []bool{false, true} {
+		for _, fillPercent := range []float64{bolt.DefaultFillPercent, 1.0} {
+			t.Run(fmt.Sprintf("stats=%v,fill=%v", orderStatistics, fillPercent), func(t *testing.T) {
+				const count = 50000
+				db := MustOpenWithOption(&bolt.Options{OrderStatistics: orderStatistics})
+				defer db.MustClose()
+
+				if err := db.Update(func(tx *bolt.Tx) error {
+					b, err := tx.CreateBucket([]byte("widgets"))
+					if err != nil {
+						t.Fatal(err)
+					}
+					b.FillPercent = fillPercent
+					if err := b.BulkLoad(seqN(count)); err != nil {
+						t.Fatal(err)
+					}
+
+					// Keys are readable and writable before commit.
+					if v := b.Get(u64tob(1234)); string(v) != "1234" {
+						t.Fatalf("unexpected value: %q", v)
+					}
+					if err := b.Put(u64tob(count), []byte("last")); err != nil {
+						t.Fatal(err)
+					}
+					return nil
+				}); err != nil {
+					t.Fatal(err)
+				}
+
+				if err := db.View(func(tx *bolt.Tx) error {
+					b := tx.Bucket([]byte("widgets"))
+					var i int
+					for k, v := range b.All() {
+						if btou64(k) != uint64(i) {
+							t.Fatalf("unexpected key: %d != %d", btou64(k), i)
+						} else if i < count && !bytes.HasSuffix(v, []byte(fmt.Sprintf("x%d", i))) && string(v) != fmt.Sprintf("%d", i) {
+							t.Fatalf("unexpected value for %d: %q", i, v)
+						}
+						i++
+					}
+					if i != count+1 {
+						t.Fatalf("unexpected key count: %d", i)
+					} else if n := b.Count(); n != count+1 {
+						t.Fatalf("unexpected count: %d", n)
+					} else if stats := b.Stats(); stats.KeyN != count+1 || stats.Depth < 3 {
+						t.Fatalf("unexpected stats: %+v", stats)
+					}
+					return nil
+				}); err != nil {
+					t.Fatal(err)
+				}
+			})
+		}
+	}
+}
+
+// Ensure that a small bulk load is stored inline.
+func TestBucket_BulkLoad_Inline(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b.BulkLoad(seqN(10))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		stats := tx.Bucket([]byte("widgets")).Stats()
+		if stats.KeyN != 10 || stats.InlineBucketN != 1 {
+			t.Fatalf("unexpected stats: %+v", stats)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that a bucket which has been emptied can be bulk loaded and that
+// its old pages are released.
+func TestBucket_BulkLoad_Emptied(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b.BulkLoad(seqN(5000))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if err := b.BulkLoad(seqN(10)); !errors.Is(err, bolt.ErrBucketNotEmpty) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			if err := c.Delete(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return b.BulkLoad(seqN(20000))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		if n := tx.Bucket([]byte("widgets")).Stats().KeyN; n != 20000 {
+			t.Fatalf("unexpected KeyN: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that invalid input is rejected and that pages written before the
+// error are released.
+func TestBucket_BulkLoad_Invalid(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		unsorted := func(yield func([]byte, []byte) bool) {
+			for k, v := range seqN(10000) {
+				if !yield(k, v) {
+					return
+				}
+			}
+			yield(u64tob(10), []byte("0"))
+		}
+		if err := b.BulkLoad(unsorted); !errors.Is(err, bolt.ErrKeysUnsorted) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		empty := func(yield func([]byte, []byte) bool) {
+			yield([]byte{}, []byte("0"))
+		}
+		if err := b.BulkLoad(empty); !errors.Is(err, bolt.ErrKeyRequired) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if k, _ := b.Cursor().First(); k != nil {
+			t.Fatalf("unexpected key: %x", k)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The read-only transaction cannot bulk load.
+	if err := db.View(func(tx *bolt.Tx) error {
+		if err := tx.Bucket([]byte("widgets")).BulkLoad(seqN(1)); err != bolt.ErrTxNotWritable {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that a rolled back bulk load leaves the database unchanged.
+func TestBucket_BulkLoad_Rollback(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Bucket([]byte("widgets")).BulkLoad(seqN(20000)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		if k, _ := tx.Bucket([]byte("widgets")).Cursor().First(); k != nil {
+			t.Fatalf("unexpected key: %x", k)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that pages bulk loaded beyond the mmap can be read after commit
+// when the pages written by the commit itself come from the freelist.
+func TestBucket_BulkLoad_FreePages(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	// Free a short run of pages, too short for the bulk loaded values but
+	// long enough for the pages written by the commit.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("tmp"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 20; i++ {
+			if err := b.Put(u64tob(uint64(i)), make([]byte, 3000)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte("tmp"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b.BulkLoad(func(yield func([]byte, []byte) bool) {
+			v := make([]byte, 100000)
+			for i := 0; i < 200; i++ {
+				if !yield(u64tob(uint64(i)), v) {
+					return
+				}
+			}
+		})
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Read every value in a new transaction.
+	if err := db.View(func(tx *bolt.Tx) error {
+		var n int
+		if err := tx.Bucket([]byte("widgets")).ForEach(func(k, v []byte) error {
+			if len(v) != 100000 {
+				t.Fatalf("unexpected value size for %x: %d", k, len(v))
+			}
+			n++
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if n != 200 {
+			t.Fatalf("unexpected count: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that data read earlier in a transaction stays valid while a bulk
+// load grows the file and that the load can read from the same transaction.
+func TestBucket_BulkLoad_SameTx(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		src, err := tx.CreateBucket([]byte("src"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := src.BulkLoad(seqN(20000)); err != nil {
+			t.Fatal(err)
+		}
+		small, err := tx.CreateBucket([]byte("small"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return small.Put([]byte("foo"), []byte("bar"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		small := tx.Bucket([]byte("small"))
+		src := tx.Bucket([]byte("src"))
+		v := src.Get(u64tob(100))
+		c := src.Cursor()
+		c.Seek(u64tob(500))
+
+		// Copy the source bucket and then load far more data than is mapped.
+		dst, err := tx.CreateBucket([]byte("dst"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := dst.BulkLoad(src.All()); err != nil {
+			t.Fatal(err)
+		}
+		big, err := tx.CreateBucket([]byte("big"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := big.BulkLoad(func(yield func([]byte, []byte) bool) {
+			for i := 0; i < 20000; i++ {
+				if !yield(u64tob(uint64(i)), make([]byte, 1000)) {
+					return
+				}
+			}
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if string(v) != "100" {
+			t.Fatalf("unexpected value: %q", v)
+		} else if v := small.Get([]byte("foo")); string(v) != "bar" {
+			t.Fatalf("unexpected value: %q", v)
+		} else if k, _ := c.Next(); btou64(k) != 501 {
+			t.Fatalf("unexpected key: %x", k)
+		}
+
+		// Loaded data can be read back before commit.
+		var n int
+		for k, v := range dst.All() {
+			if !bytes.Equal(v, src.Get(k)) {
+				t.Fatalf("unexpected value for %x", k)
+			}
+			n++
+		}
+		if n != 20000 {
+			t.Fatalf("unexpected key count: %d", n)
+		} else if v := big.Get(u64tob(19999)); len(v) != 1000 {
+			t.Fatalf("unexpected value length: %d", len(v))
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		if n := tx.Bucket([]byte("dst")).Stats().KeyN; n != 20000 {
+			t.Fatalf("unexpected KeyN: %d", n)
+		} else if n := tx.Bucket([]byte("big")).Stats().KeyN; n != 20000 {
+			t.Fatalf("unexpected KeyN: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func BenchmarkBucket_BulkLoad(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		db := MustOpenDB()
+		if err := db.Update(func(tx *bolt.Tx) error {
+			bkt, err := tx.CreateBucket([]byte("widgets"))
+			if err != nil {
+				b.Fatal(err)
+			}
+			bkt.FillPercent = 1.0
+			return bkt.BulkLoad(seqN(100000))
+		}); err != nil {
+			b.Fatal(err)
+		}
+		db.MustClose()
+	}
+}
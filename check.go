@@ -0,0 +1,464 @@
+package bolt
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// Kinds of problems found by Tx.Check(). These are the values of
+// CheckError.Kind.
+const (
+	CheckDoubleFree     = "double-free"         // a page is in the freelist more than once
+	CheckUnreachable    = "unreachable"         // a page is neither reachable nor free
+	CheckMultipleRefs   = "multiple-references" // a page is referenced more than once
+	CheckReachableFreed = "reachable-freed"     // a reachable page is in the freelist
+	CheckOutOfBounds    = "out-of-bounds"       // a page id is at or above the high water mark
+	CheckPageHeader     = "page-header"         // a page has an invalid type or id
+	CheckOverflow       = "overflow"            // a page or its elements extend past its end
+	CheckKeyOrder       = "key-order"           // keys are empty or not in ascending order
+	CheckBranchBounds   = "branch-bounds"       // a child page has keys outside its branch element
+	CheckBranchCount    = "branch-count"        // a counted branch page has a wrong key count
+	CheckInlineBucket   = "inline-bucket"       // an inline bucket value is not a valid leaf page
+	CheckFreelist       = "freelist"            // the freelist page or its ids are invalid
+	CheckCorrupted      = "corrupted"           // reading stopped at a *CorruptionError
+)
+
+// CheckOptions configures Tx.CheckWithOptions().
+type CheckOptions struct {
+	// Parallelism is the maximum number of goroutines that check bucket
+	// subtrees at the same time. If zero, GOMAXPROCS is used. Set to 1 to
+	// check the database from a single goroutine.
+	Parallelism int
+
+	// MaxErrors stops the check once this many problems have been found.
+	// If zero, all problems are reported.
+	MaxErrors int
+}
+
+// CheckError describes a problem found by Tx.Check(). It matches
+// ErrCorrupted with errors.Is.
+type CheckError struct {
+	Kind   string   // kind of problem, one of the Check constants
+	PageID int      // page where the problem was found
+	Bucket [][]byte // path of the bucket containing the page, if known
+	Key    []byte   // key where the problem was found, if any
+	Reason string   // description of the problem
+}
+
+// Error returns a description of the problem.
+func (e *CheckError) Error() string {
+	s := fmt.Sprintf("page %d: %s", e.PageID, e.Reason)
+	if e.Bucket != nil {
+		s += ": bucket " + formatPath(e.Bucket)
+	}
+	if e.Key != nil {
+		s += ": key " + formatKey(e.Key)
+	}
+	return s
+}
+
+// Is returns true if target is ErrCorrupted.
+func (e *CheckError) Is(target error) bool {
+	return target == ErrCorrupted
+}
+
+// CheckWithOptions performs consistency checks on the database for this
+// transaction like Check(). Each error sent on the channel is a *CheckError.
+// If opts is nil then the default options are used.
+//
+// Besides page reachability, it verifies page headers and overflow lengths,
+// key order within and across sibling pages, that the keys of each child
+// page lie between its branch key and the next one, subtree key counts,
+// the layout of inline buckets and the contents of the freelist. Nested
+// buckets are checked in parallel so errors are not sent in page order.
+func (tx *Tx) CheckWithOptions(opts *CheckOptions) <-chan error {
+	if opts == nil {
+		opts = &CheckOptions{}
+	}
+	n := opts.Parallelism
+	if n <= 0 {
+		n = runtime.GOMAXPROCS(0)
+	}
+	c := &checker{
+		tx:        tx,
+		ch:        make(chan error),
+		max:       int64(opts.MaxErrors),
+		sem:       make(chan struct{}, n-1),
+		reachable: make(map[pgid]bool),
+	}
+	go c.run()
+	return c.ch
+}
+
+// checker holds the state of a consistency check.
+type checker struct {
+	tx  *Tx
+	ch  chan error
+	max int64
+	n   int64
+
+	// sem limits the number of extra goroutines checking buckets.
+	sem chan struct{}
+	wg  sync.WaitGroup
+
+	freed     map[pgid]bool
+	mu        sync.Mutex
+	reachable map[pgid]bool
+}
+
+// report sends a problem unless MaxErrors problems have been sent.
+func (c *checker) report(kind string, id pgid, path [][]byte, key []byte, format string, v ...interface{}) {
+	if n := atomic.AddInt64(&c.n, 1); c.max > 0 && n > c.max {
+		return
+	}
+	e := &CheckError{Kind: kind, PageID: int(id), Reason: fmt.Sprintf(format, v...)}
+	for _, name := range path {
+		e.Bucket = append(e.Bucket, cloneBytes(name))
+	}
+	if key != nil {
+		e.Key = cloneBytes(key)
+	}
+	c.ch <- e
+}
+
+// done returns true once MaxErrors problems have been found.
+func (c *checker) done() bool {
+	return c.max > 0 && atomic.LoadInt64(&c.n) >= c.max
+}
+
+// recover reports a panic caused by a *CorruptionError. Other panics are
+// propagated. It must be deferred directly.
+func (c *checker) recover() {
+	if r := recover(); r != nil {
+		err, ok := r.(*CorruptionError)
+		if !ok {
+			panic(r)
+		}
+		c.report(CheckCorrupted, pgid(err.PageID), nil, nil, "%s", err.Reason)
+	}
+}
+
+// run performs the check and closes the channel.
+func (c *checker) run() {
+	defer close(c.ch)
+	defer c.recover()
+
+	tx := c.tx
+	c.mark(0, 0, nil)
+	c.mark(1, 0, nil)
+	c.checkFreelist()
+
+	// Walk the buckets and wait for the goroutines started along the way.
+	c.checkBucket(nil, tx.meta.root.root, nil)
+	c.wg.Wait()
+
+	// Ensure all pages below high water mark are either reachable or freed.
+	for i := pgid(0); i < tx.meta.pgid && !c.done(); i++ {
+		if !c.reachable[i] && !c.freed[i] {
+			c.report(CheckUnreachable, i, nil, nil, "unreachable unfreed")
+		}
+	}
+}
+
+// mark records a page and its overflow pages as reachable. Returns false if
+// the page was already reachable.
+func (c *checker) mark(id pgid, overflow uint32, path [][]byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ok := true
+	for i := pgid(0); i <= pgid(overflow); i++ {
+		if c.reachable[id+i] {
+			c.report(CheckMultipleRefs, id+i, path, nil, "multiple references")
+			ok = false
+		}
+		c.reachable[id+i] = true
+		if c.freed[id+i] {
+			c.report(CheckReachableFreed, id+i, path, nil, "reachable freed")
+		}
+	}
+	return ok
+}
+
+// checkFreelist builds the set of free pages. Read-only transactions use
+// the freelist page written with their meta page. Writable transactions
+// use the freelist of the database, which includes their own changes.
+func (c *checker) checkFreelist() {
+	tx := c.tx
+	c.freed = make(map[pgid]bool)
+
+	id := tx.meta.freelist
+	if id < 2 || id >= tx.meta.pgid {
+		c.report(CheckFreelist, id, nil, nil, "freelist page out of bounds: %d", int(tx.meta.pgid))
+		return
+	}
+	p := tx.page(id)
+	if p.flags&freelistPageFlag == 0 {
+		c.report(CheckFreelist, id, nil, nil, "invalid freelist page type: %s", p.typ())
+		return
+	} else if id+pgid(p.overflow) >= tx.meta.pgid {
+		c.report(CheckOverflow, id, nil, nil, "overflow past high water mark: %d", p.overflow)
+		return
+	}
+	c.mark(id, p.overflow, nil)
+
+	var ids []pgid
+	if tx.writable {
+		ids = make([]pgid, tx.db.freelist.count())
+		tx.db.freelist.copyall(ids)
+	} else {
+		idx, count := 0, int(p.count)
+		if count == 0xFFFF {
+			idx, count = 1, int(((*[maxAllocSize]pgid)(unsafe.Pointer(&p.ptr)))[0])
+		}
+		size := (int(p.overflow) + 1) * tx.db.pageSize
+		if pageHeaderSize+(idx+count)*int(unsafe.Sizeof(pgid(0))) > size {
+			c.report(CheckOverflow, id, nil, nil, "freelist of %d ids past end of page", count)
+			return
+		}
+		if count > 0 {
+			ids = ((*[maxAllocSize]pgid)(unsafe.Pointer(&p.ptr)))[idx : idx+count : idx+count]
+		}
+	}
+
+	for i, fid := range ids {
+		if fid < 2 || fid >= tx.meta.pgid {
+			c.report(CheckFreelist, fid, nil, nil, "free page out of bounds: %d", int(tx.meta.pgid))
+		} else if c.freed[fid] {
+			c.report(CheckDoubleFree, fid, nil, nil, "already freed")
+		} else if i > 0 && fid < ids[i-1] {
+			c.report(CheckFreelist, fid, nil, nil, "free page ids not sorted: %d after %d", fid, ids[i-1])
+		}
+		c.freed[fid] = true
+	}
+}
+
+// checkBucket checks a bucket with the given root page. Inline buckets pass
+// the part of their value holding the page instead. The bucket is checked by
+// another goroutine if the parallelism allows it.
+func (c *checker) checkBucket(path [][]byte, root pgid, inline []byte) {
+	if c.done() {
+		return
+	}
+	select {
+	case c.sem <- struct{}{}:
+		c.wg.Add(1)
+		go func() {
+			defer func() {
+				<-c.sem
+				c.wg.Done()
+			}()
+			c.walkBucket(path, root, inline)
+		}()
+	default:
+		c.walkBucket(path, root, inline)
+	}
+}
+
+// walkBucket checks the pages of a bucket.
+func (c *checker) walkBucket(path [][]byte, root pgid, inline []byte) {
+	defer c.recover()
+	w := &bucketWalker{checker: c, path: path}
+	if inline != nil {
+		w.walkPage(0, (*page)(unsafe.Pointer(&inline[0])), len(inline), nil, nil)
+		return
+	}
+	w.walk(root, nil, nil)
+}
+
+// bucketWalker checks the pages of a single bucket in key order.
+type bucketWalker struct {
+	*checker
+	path [][]byte
+	prev []byte // last key of the previous leaf page
+}
+
+// walk checks the page with the given id and its children. Keys on the page
+// must be greater or equal to lower and less than upper, if set. Returns the
+// number of keys in the subtree or -1 if the subtree is invalid.
+func (w *bucketWalker) walk(id pgid, lower, upper []byte) int {
+	tx := w.tx
+	if w.done() {
+		return -1
+	} else if id < 2 || id >= tx.meta.pgid {
+		w.report(CheckOutOfBounds, id, w.path, nil, "out of bounds: %d", int(tx.meta.pgid))
+		return -1
+	}
+
+	p := tx.page(id)
+	if p.id != id {
+		w.report(CheckPageHeader, id, w.path, nil, "page header has id %d", p.id)
+		return -1
+	} else if p.flags&(branchPageFlag|leafPageFlag) == 0 {
+		w.report(CheckPageHeader, id, w.path, nil, "invalid type: %s", p.typ())
+		return -1
+	} else if id+pgid(p.overflow) >= tx.meta.pgid {
+		w.report(CheckOverflow, id, w.path, nil, "overflow past high water mark: %d", p.overflow)
+		return -1
+	} else if !w.mark(id, p.overflow, w.path) {
+		return -1
+	}
+	return w.walkPage(id, p, (int(p.overflow)+1)*tx.db.pageSize, lower, upper)
+}
+
+// walkPage checks the elements of a page of the given size, which is an
+// inline page if id is zero. Returns the number of keys below the page or -1
+// if it is invalid.
+func (w *bucketWalker) walkPage(id pgid, p *page, size int, lower, upper []byte) int {
+	if !w.checkLayout(id, p, size) {
+		return -1
+	}
+
+	isLeaf := p.flags&leafPageFlag != 0
+	key := func(i int) []byte {
+		if isLeaf {
+			return p.leafPageElement(uint16(i)).key()
+		}
+		return p.branchPageElement(uint16(i)).key()
+	}
+
+	// Ensure keys are sorted and lie within the bounds of the branch element.
+	for i := 0; i < int(p.count); i++ {
+		k := key(i)
+		if len(k) == 0 {
+			w.report(CheckKeyOrder, id, w.path, nil, "zero-length key at index %d", i)
+			return -1
+		} else if i > 0 && bytes.Compare(key(i-1), k) >= 0 {
+			w.report(CheckKeyOrder, id, w.path, k, "key not greater than previous key %s", formatKey(key(i-1)))
+		} else if i == 0 && lower != nil && bytes.Compare(k, lower) < 0 {
+			w.report(CheckBranchBounds, id, w.path, k, "key before branch key %s", formatKey(lower))
+		}
+	}
+	if p.count > 0 && upper != nil {
+		if last := key(int(p.count) - 1); bytes.Compare(last, upper) >= 0 {
+			w.report(CheckBranchBounds, id, w.path, last, "key not before next branch key %s", formatKey(upper))
+		}
+	}
+
+	if isLeaf {
+		return w.walkLeaf(id, p)
+	}
+
+	// Check children and their key counts.
+	var counts []uint64
+	if p.flags&countedPageFlag != 0 {
+		counts = p.branchPageCounts()
+	}
+	total := 0
+	for i := 0; i < int(p.count); i++ {
+		next := upper
+		if i+1 < int(p.count) {
+			next = key(i + 1)
+		}
+		n := w.walk(p.branchPageElement(uint16(i)).pgid, key(i), next)
+		if n < 0 || total < 0 {
+			total = -1
+			continue
+		} else if counts != nil && counts[i] != uint64(n) {
+			w.report(CheckBranchCount, id, w.path, key(i), "child has %d keys, branch count is %d", n, counts[i])
+		}
+		total += n
+	}
+	return total
+}
+
+// walkLeaf checks the keys of a leaf page against the previous leaf page
+// and checks its nested buckets. Returns the number of keys on the page.
+func (w *bucketWalker) walkLeaf(id pgid, p *page) int {
+	if p.count == 0 {
+		return 0
+	}
+	if first := p.leafPageElement(0).key(); w.prev != nil && bytes.Compare(w.prev, first) >= 0 {
+		w.report(CheckKeyOrder, id, w.path, first, "key not greater than last key of previous page %s", formatKey(w.prev))
+	}
+	w.prev = p.leafPageElement(p.count - 1).key()
+
+	for i := 0; i < int(p.count); i++ {
+		elem := p.leafPageElement(uint16(i))
+		if elem.flags&bucketLeafFlag == 0 {
+			continue
+		}
+		k, v := elem.key(), elem.value()
+		if id == 0 {
+			w.report(CheckInlineBucket, id, w.path, k, "nested bucket in inline bucket")
+			continue
+		} else if len(v) < bucketHeaderSize {
+			w.report(CheckInlineBucket, id, w.path, k, "bucket header too short: %d bytes", len(v))
+			continue
+		}
+
+		// If unaligned load/stores are broken on this arch and value is
+		// unaligned simply clone to an aligned byte array.
+		if brokenUnaligned && uintptr(unsafe.Pointer(&v[0]))&3 != 0 {
+			v = cloneBytes(v)
+		}
+		path := append(append([][]byte{}, w.path...), k)
+		b := (*bucket)(unsafe.Pointer(&v[0]))
+		if b.root != 0 {
+			w.checkBucket(path, b.root, nil)
+			continue
+		}
+
+		// Inline pages are checked against the length of the value.
+		if len(v) < bucketHeaderSize+pageHeaderSize {
+			w.report(CheckInlineBucket, id, w.path, k, "inline bucket too short: %d bytes", len(v))
+			continue
+		}
+		inline := (*page)(unsafe.Pointer(&v[bucketHeaderSize]))
+		if inline.flags != leafPageFlag {
+			w.report(CheckInlineBucket, id, w.path, k, "invalid inline page type: %s", inline.typ())
+			continue
+		}
+		w.checkBucket(path, 0, v[bucketHeaderSize:])
+	}
+	return int(p.count)
+}
+
+// checkLayout ensures the elements of a page and their keys and values fit
+// within size bytes.
+func (w *bucketWalker) checkLayout(id pgid, p *page, size int) bool {
+	kind := CheckOverflow
+	if id == 0 {
+		kind = CheckInlineBucket
+	}
+	if reason := p.layoutError(size); reason != "" {
+		w.report(kind, id, w.path, nil, "%s", reason)
+		return false
+	}
+	return true
+}
+
+// layoutError returns a description of the first element of a branch or
+// leaf page that does not fit within size bytes. Returns an empty string if
+// all elements fit.
+func (p *page) layoutError(size int) string {
+	elemSize := branchPageElementSize
+	if p.flags&leafPageFlag != 0 {
+		elemSize = leafPageElementSize
+	} else if p.flags&countedPageFlag != 0 {
+		elemSize += branchPageCountSize
+	}
+	if pageHeaderSize+int(p.count)*elemSize > size {
+		return fmt.Sprintf("%d elements past end of page", p.count)
+	}
+
+	for i := 0; i < int(p.count); i++ {
+		var off, n uint64
+		if p.flags&leafPageFlag != 0 {
+			elem := p.leafPageElement(uint16(i))
+			off = uint64(pageHeaderSize + i*leafPageElementSize)
+			n = uint64(elem.pos) + uint64(elem.ksize) + uint64(elem.vsize)
+		} else {
+			elem := p.branchPageElement(uint16(i))
+			off = uint64(pageHeaderSize + i*branchPageElementSize)
+			n = uint64(elem.pos) + uint64(elem.ksize)
+		}
+		if off+n > uint64(size) {
+			return fmt.Sprintf("element %d past end of page", i)
+		}
+	}
+	return ""
+}
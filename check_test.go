@@ -0,0 +1,129 @@
+package bolt_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// Ensure that a valid database with nested, inline and counted buckets
+// passes the check at any parallelism.
+func TestTx_CheckWithOptions(t *testing.T) {
+	db := MustOpenWithOption(&bolt.Options{OrderStatistics: true})
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for i := 0; i < 10; i++ {
+			b, err := tx.CreateBucket([]byte(fmt.Sprintf("bucket%d", i)))
+			if err != nil {
+				return err
+			}
+			if _, err := b.CreateBucket([]byte("inline")); err != nil {
+				return err
+			}
+			child, err := b.CreateBucket([]byte("child"))
+			if err != nil {
+				return err
+			}
+			for j := 0; j < 1000*i; j++ {
+				if err := child.Put(u64tob(uint64(j)), make([]byte, 50)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, n := range []int{1, 4} {
+		errs := mustCheck(t, db.DB, &bolt.CheckOptions{Parallelism: n})
+		if len(errs) > 0 {
+			t.Fatalf("parallelism %d: unexpected errors: %v", n, errs)
+		}
+	}
+}
+
+// Ensure that an invalid page type is reported and its children are
+// reported as unreachable.
+func TestTx_CheckWithOptions_PageHeader(t *testing.T) {
+	db, root := mustOpenCorrupted(t)
+	defer db.close()
+
+	errs := mustCheck(t, db.DB, nil)
+	var found, unreachable bool
+	for _, e := range errs {
+		switch e.Kind {
+		case bolt.CheckPageHeader:
+			found = e.PageID == root && reflect.DeepEqual(e.Bucket, [][]byte{[]byte("widgets")}) && e.Key == nil
+		case bolt.CheckUnreachable:
+			unreachable = true
+		}
+		if !errors.Is(e, bolt.ErrCorrupted) {
+			t.Fatalf("expected ErrCorrupted: %v", e)
+		}
+	}
+	if !found || !unreachable {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	// Stop after the first error.
+	if errs := mustCheck(t, db.DB, &bolt.CheckOptions{MaxErrors: 1}); len(errs) != 1 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+}
+
+// Ensure that keys out of order across sibling pages are reported.
+func TestTx_CheckWithOptions_KeyOrder(t *testing.T) {
+	db, _ := mustOpenCorruptedWith(t, func(f *os.File, root, pageSize int) error {
+		// Read the id of the second child of the root branch page.
+		buf := make([]byte, 8)
+		if _, err := f.ReadAt(buf, int64(root*pageSize+16+16+8)); err != nil {
+			return err
+		}
+		child := int(binary.LittleEndian.Uint64(buf))
+
+		// Overwrite the first key of the child with zeros.
+		if _, err := f.ReadAt(buf, int64(child*pageSize+16)); err != nil {
+			return err
+		}
+		pos := int(binary.LittleEndian.Uint32(buf[4:]))
+		_, err := f.WriteAt(make([]byte, 8), int64(child*pageSize+16+pos))
+		return err
+	})
+	defer db.close()
+
+	kinds := make(map[string]bool)
+	for _, e := range mustCheck(t, db.DB, nil) {
+		kinds[e.Kind] = true
+		if !reflect.DeepEqual(e.Bucket, [][]byte{[]byte("widgets")}) {
+			t.Fatalf("unexpected bucket: %v", e)
+		}
+	}
+	if !kinds[bolt.CheckKeyOrder] || !kinds[bolt.CheckBranchBounds] {
+		t.Fatalf("unexpected errors: %v", kinds)
+	}
+}
+
+// mustCheck returns the errors found by checking the database.
+func mustCheck(t *testing.T, db *bolt.DB, opts *bolt.CheckOptions) []*bolt.CheckError {
+	var errs []*bolt.CheckError
+	if err := db.View(func(tx *bolt.Tx) error {
+		for err := range tx.CheckWithOptions(opts) {
+			var e *bolt.CheckError
+			if !errors.As(err, &e) {
+				t.Fatalf("unexpected error type: %T", err)
+			}
+			errs = append(errs, e)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return errs
+}
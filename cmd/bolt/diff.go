@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/boltdb/bolt/exchange"
+)
+
+// DiffCommand represents the "diff" command execution.
+type DiffCommand struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	Buckets     []string
+	JSON        bool
+	Summary     bool
+	KeyFormat   string
+	ValueFormat string
+}
+
+// newDiffCommand returns a DiffCommand.
+func newDiffCommand(m *Main) *DiffCommand {
+	return &DiffCommand{
+		Stdin:  m.Stdin,
+		Stdout: m.Stdout,
+		Stderr: m.Stderr,
+	}
+}
+
+// Run executes the command.
+func (cmd *DiffCommand) Run(args ...string) error {
+	// Parse flags.
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.Var((*stringSlice)(&cmd.Buckets), "bucket", "")
+	fs.BoolVar(&cmd.JSON, "json", false, "")
+	fs.BoolVar(&cmd.Summary, "summary", false, "")
+	fs.StringVar(&cmd.KeyFormat, "key-format", string(exchange.UTF8), "")
+	fs.StringVar(&cmd.ValueFormat, "value-format", string(exchange.UTF8), "")
+	if err := fs.Parse(args); err == flag.ErrHelp {
+		fmt.Fprintln(cmd.Stderr, cmd.Usage())
+		return ErrUsage
+	} else if err != nil {
+		return err
+	} else if err := checkFormats(cmd.KeyFormat, cmd.ValueFormat); err != nil {
+		return err
+	}
+
+	var paths [][][]byte
+	for _, s := range cmd.Buckets {
+		path, err := exchange.ParsePath(s, exchange.UTF8)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, path)
+	}
+
+	// Require both database paths.
+	var dbs [2]*bolt.DB
+	for i := range dbs {
+		path, err := requirePath(fs.Arg(i))
+		if err != nil {
+			return err
+		}
+		db, err := bolt.Open(path, 0666, &bolt.Options{ReadOnly: true})
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		dbs[i] = db
+	}
+
+	d := &differ{}
+	if !cmd.JSON && !cmd.Summary {
+		d.fn = cmd.print
+	} else if !cmd.Summary {
+		d.fn = func(c diffChange) { d.changes = append(d.changes, c) }
+	}
+	if err := dbs[0].View(func(a *bolt.Tx) error {
+		return dbs[1].View(func(b *bolt.Tx) error {
+			if len(paths) == 0 {
+				d.diff(nil, diffNode{tx: a}, diffNode{tx: b})
+				return nil
+			}
+			for _, path := range paths {
+				if err := d.diffPath(path, a, b); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}); err != nil {
+		return err
+	}
+
+	if cmd.JSON {
+		if d.changes == nil && !cmd.Summary {
+			d.changes = []diffChange{}
+		}
+		enc := json.NewEncoder(cmd.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(diffReport{Summary: d.summary, Changes: d.changes})
+	}
+
+	s := d.summary
+	fmt.Fprintf(cmd.Stdout, "buckets: %d added, %d removed, %d changed\n", s.BucketsAdded, s.BucketsRemoved, s.BucketsChanged)
+	fmt.Fprintf(cmd.Stdout, "keys: %d added, %d removed, %d changed\n", s.KeysAdded, s.KeysRemoved, s.KeysChanged)
+	return nil
+}
+
+// print writes a change as a line of text.
+func (cmd *DiffCommand) print(c diffChange) {
+	op := map[string]string{diffAdded: "+", diffRemoved: "-", diffChanged: "~"}[c.Op]
+	path := "/" + exchange.FormatPath(c.Bucket, exchange.Encoding(cmd.KeyFormat))
+	switch {
+	case c.Key == nil && c.Op == diffChanged:
+		fmt.Fprintf(cmd.Stdout, "%s bucket %s: sequence %d -> %d\n", op, path, *c.OldSequence, *c.NewSequence)
+	case c.Key == nil:
+		fmt.Fprintf(cmd.Stdout, "%s bucket %s (%d keys)\n", op, path, c.Keys)
+	case c.Op == diffAdded:
+		fmt.Fprintf(cmd.Stdout, "%s key %s: %s = %s\n", op, path, encode(cmd.KeyFormat, c.Key), encode(cmd.ValueFormat, c.New))
+	case c.Op == diffRemoved:
+		fmt.Fprintf(cmd.Stdout, "%s key %s: %s = %s\n", op, path, encode(cmd.KeyFormat, c.Key), encode(cmd.ValueFormat, c.Old))
+	default:
+		fmt.Fprintf(cmd.Stdout, "%s key %s: %s = %s -> %s\n", op, path, encode(cmd.KeyFormat, c.Key), encode(cmd.ValueFormat, c.Old), encode(cmd.ValueFormat, c.New))
+	}
+}
+
+// Operations of a diffChange.
+const (
+	diffAdded   = "added"
+	diffRemoved = "removed"
+	diffChanged = "changed"
+)
+
+// diffReport is the output of "bolt diff -json". Bucket names, keys and
+// values are base64 encoded like the records of "bolt import".
+type diffReport struct {
+	Summary diffSummary  `json:"summary"`
+	Changes []diffChange `json:"changes,omitempty"`
+}
+
+// diffSummary counts the changes between two databases. Keys in added and
+// removed buckets are counted as added and removed keys.
+type diffSummary struct {
+	BucketsAdded   int `json:"buckets_added"`
+	BucketsRemoved int `json:"buckets_removed"`
+	BucketsChanged int `json:"buckets_changed"`
+	KeysAdded      int `json:"keys_added"`
+	KeysRemoved    int `json:"keys_removed"`
+	KeysChanged    int `json:"keys_changed"`
+}
+
+// diffChange is an added, removed or changed bucket or key. Key is nil for
+// buckets. Buckets are changed when their sequence differs.
+type diffChange struct {
+	Op          string   `json:"op"`
+	Bucket      [][]byte `json:"bucket"`
+	Key         []byte   `json:"key,omitempty"`
+	Old         []byte   `json:"old,omitempty"`
+	New         []byte   `json:"new,omitempty"`
+	Keys        int      `json:"keys,omitempty"`
+	OldSequence *uint64  `json:"old_sequence,omitempty"`
+	NewSequence *uint64  `json:"new_sequence,omitempty"`
+}
+
+// diffNode is a bucket, or the top level of a database if b is nil.
+type diffNode struct {
+	tx *bolt.Tx
+	b  *bolt.Bucket
+}
+
+// cursor returns a cursor over the keys of the node.
+func (n diffNode) cursor() *bolt.Cursor {
+	if n.b == nil {
+		return n.tx.Cursor()
+	}
+	return n.b.Cursor()
+}
+
+// child returns the nested bucket with the given name.
+func (n diffNode) child(name []byte) *bolt.Bucket {
+	if n.b == nil {
+		return n.tx.Bucket(name)
+	}
+	return n.b.Bucket(name)
+}
+
+// differ compares the trees of two databases and reports changes to fn.
+type differ struct {
+	fn      func(diffChange)
+	summary diffSummary
+	changes []diffChange
+}
+
+// diffPath compares the buckets at path in a and b.
+func (d *differ) diffPath(path [][]byte, a, b *bolt.Tx) error {
+	parent, name := path[:len(path)-1], path[len(path)-1]
+	var nodes [2]diffNode
+	for i, tx := range []*bolt.Tx{a, b} {
+		nodes[i] = diffNode{tx: tx}
+		if len(parent) > 0 {
+			pb, err := bucketAt(tx, parent)
+			if err != nil {
+				continue
+			}
+			nodes[i].b = pb
+		}
+		nodes[i].b = nodes[i].child(name)
+	}
+
+	switch {
+	case nodes[0].b == nil && nodes[1].b == nil:
+		return &bolt.BucketError{Path: path, Err: bolt.ErrBucketNotFound}
+	case nodes[0].b == nil:
+		d.bucket(diffAdded, path, nodes[1].b)
+	case nodes[1].b == nil:
+		d.bucket(diffRemoved, path, nodes[0].b)
+	default:
+		d.diff(path, nodes[0], nodes[1])
+	}
+	return nil
+}
+
+// diff compares the keys and nested buckets of a and b in key order.
+func (d *differ) diff(path [][]byte, a, b diffNode) {
+	if a.b != nil && a.b.Sequence() != b.b.Sequence() {
+		o, n := a.b.Sequence(), b.b.Sequence()
+		d.summary.BucketsChanged++
+		d.report(diffChange{Op: diffChanged, Bucket: path, OldSequence: &o, NewSequence: &n})
+	}
+
+	ca, cb := a.cursor(), b.cursor()
+	ka, va := ca.First()
+	kb, vb := cb.First()
+	for ka != nil || kb != nil {
+		cmp := bytes.Compare(ka, kb)
+		if ka == nil {
+			cmp = 1
+		} else if kb == nil {
+			cmp = -1
+		}
+
+		switch {
+		case cmp < 0:
+			d.entry(diffRemoved, path, a, ka, va)
+		case cmp > 0:
+			d.entry(diffAdded, path, b, kb, vb)
+		case va == nil && vb == nil:
+			child := append(clonePath(path), clone(ka))
+			d.diff(child, diffNode{b: a.child(ka)}, diffNode{b: b.child(kb)})
+		case va == nil || vb == nil:
+			d.entry(diffRemoved, path, a, ka, va)
+			d.entry(diffAdded, path, b, kb, vb)
+		case !bytes.Equal(va, vb):
+			d.summary.KeysChanged++
+			d.report(diffChange{Op: diffChanged, Bucket: path, Key: clone(ka), Old: clone(va), New: clone(vb)})
+		}
+
+		if cmp <= 0 {
+			ka, va = ca.Next()
+		}
+		if cmp >= 0 {
+			kb, vb = cb.Next()
+		}
+	}
+}
+
+// entry reports a key or nested bucket of n that exists on one side only.
+func (d *differ) entry(op string, path [][]byte, n diffNode, k, v []byte) {
+	if v == nil {
+		d.bucket(op, append(clonePath(path), clone(k)), n.child(k))
+		return
+	}
+	c := diffChange{Op: op, Bucket: path, Key: clone(k)}
+	if op == diffAdded {
+		d.summary.KeysAdded++
+		c.New = clone(v)
+	} else {
+		d.summary.KeysRemoved++
+		c.Old = clone(v)
+	}
+	d.report(c)
+}
+
+// bucket reports a bucket that exists on one side only with the number of
+// keys it contains, including those of nested buckets.
+func (d *differ) bucket(op string, path [][]byte, b *bolt.Bucket) {
+	n := countKeys(b)
+	if op == diffAdded {
+		d.summary.BucketsAdded++
+		d.summary.KeysAdded += n
+	} else {
+		d.summary.BucketsRemoved++
+		d.summary.KeysRemoved += n
+	}
+	d.report(diffChange{Op: op, Bucket: path, Keys: n})
+}
+
+// report passes a change to fn, if set.
+func (d *differ) report(c diffChange) {
+	if d.fn != nil {
+		d.fn(c)
+	}
+}
+
+// countKeys returns the number of keys in b and its nested buckets.
+func countKeys(b *bolt.Bucket) int {
+	var n int
+	_ = b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			n += countKeys(b.Bucket(k))
+		} else {
+			n++
+		}
+		return nil
+	})
+	return n
+}
+
+// clone returns a copy of b, which is only valid during its transaction.
+func clone(b []byte) []byte {
+	return append([]byte{}, b...)
+}
+
+// clonePath returns a copy of path that can be appended to.
+func clonePath(path [][]byte) [][]byte {
+	return append([][]byte{}, path...)
+}
+
+// Usage returns the help message.
+func (cmd *DiffCommand) Usage() string {
+	return strings.TrimLeft(`
+usage: bolt diff [options] A B
+
+Diff compares the databases at paths A and B bucket by bucket in key order and
+prints the buckets and keys that were added in B, removed from A or changed,
+followed by a summary:
+
+	+ bucket /widgets/new (3 keys)
+	- key /widgets: foo = bar
+	~ key /widgets: baz = bat -> qux
+	~ bucket /widgets: sequence 3 -> 5
+
+Buckets that exist on one side only are printed once with the number of keys
+they contain. Buckets are changed when their sequence differs. Both databases
+are opened read-only.
+
+Additional options include:
+
+	-bucket PATH
+		Compares only the bucket at PATH, given as UTF-8 names joined by
+		"/". May be given more than once. Defaults to all buckets.
+
+	-json
+		Prints the summary and changes as JSON. Bucket names, keys and
+		values are base64 encoded.
+
+	-summary
+		Prints only the summary.
+
+	-key-format FORMAT
+		Encoding of bucket names and keys: utf8, hex or base64.
+		Defaults to utf8.
+
+	-value-format FORMAT
+		Encoding of values: utf8, hex or base64. Defaults to utf8.
+`, "\n")
+}
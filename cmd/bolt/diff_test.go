@@ -0,0 +1,93 @@
+package main_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// Ensure added, removed and changed buckets and keys are reported in order.
+func TestDiffCommand(t *testing.T) {
+	a, b := MustOpen(0666, nil), MustOpen(0666, nil)
+	defer a.Close()
+	defer b.Close()
+	fill := func(db *DB, keys map[string]string, seq uint64) {
+		if err := db.Update(func(tx *bolt.Tx) error {
+			for path, v := range keys {
+				names := strings.Split(path, "/")
+				b, err := tx.CreateBucketIfNotExists([]byte(names[0]))
+				for _, name := range names[1 : len(names)-1] {
+					if err == nil {
+						b, err = b.CreateBucketIfNotExists([]byte(name))
+					}
+				}
+				if err != nil {
+					return err
+				} else if err := b.Put([]byte(names[len(names)-1]), []byte(v)); err != nil {
+					return err
+				}
+			}
+			return tx.Bucket([]byte("widgets")).SetSequence(seq)
+		}); err != nil {
+			t.Fatal(err)
+		}
+		db.DB.Close()
+	}
+	fill(a, map[string]string{
+		"widgets/a": "1", "widgets/b": "2", "widgets/c": "3",
+		"widgets/x/y": "4", "old/k": "5", "same/k": "6", "same/n/k": "7",
+	}, 1)
+	fill(b, map[string]string{
+		"widgets/b": "2", "widgets/c": "33", "widgets/d": "4",
+		"widgets/x": "key", "new/k1": "5", "new/k2": "5", "same/k": "6", "same/n/k": "7",
+	}, 2)
+
+	m := NewMain()
+	if err := m.Run("diff", a.Path, b.Path); err != nil {
+		t.Fatal(err)
+	} else if exp := strings.Join([]string{
+		"+ bucket /new (2 keys)",
+		"- bucket /old (1 keys)",
+		"~ bucket /widgets: sequence 1 -> 2",
+		"- key /widgets: a = 1",
+		"~ key /widgets: c = 3 -> 33",
+		"+ key /widgets: d = 4",
+		"- bucket /widgets/x (1 keys)",
+		"+ key /widgets: x = key",
+		"buckets: 1 added, 2 removed, 1 changed",
+		"keys: 4 added, 3 removed, 1 changed",
+		"",
+	}, "\n"); m.Stdout.String() != exp {
+		t.Fatalf("unexpected output:\n%s", m.Stdout.String())
+	}
+
+	// Buckets can be selected and changes printed as JSON.
+	m = NewMain()
+	if err := m.Run("diff", "-json", "-bucket", "widgets/x", "-bucket", "same", a.Path, b.Path); err != nil {
+		t.Fatal(err)
+	}
+	var report struct {
+		Summary map[string]int
+		Changes []struct {
+			Op     string
+			Bucket [][]byte
+			Keys   int
+		}
+	}
+	if err := json.Unmarshal(m.Stdout.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	} else if report.Summary["buckets_removed"] != 1 || report.Summary["keys_removed"] != 1 || len(report.Changes) != 1 {
+		t.Fatalf("unexpected report: %s", m.Stdout.String())
+	} else if c := report.Changes[0]; c.Op != "removed" || len(c.Bucket) != 2 || string(c.Bucket[1]) != "x" || c.Keys != 1 {
+		t.Fatalf("unexpected change: %+v", c)
+	}
+
+	// Missing buckets and paths are reported.
+	if err := NewMain().Run("diff", "-bucket", "missing", a.Path, b.Path); err == nil || !strings.Contains(err.Error(), "bucket not found") {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := NewMain().Run("diff", a.Path); err == nil {
+		t.Fatal("expected error")
+	}
+}
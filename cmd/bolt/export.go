@@ -0,0 +1,175 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/boltdb/bolt/exchange"
+)
+
+// ExportCommand represents the "export" command execution.
+type ExportCommand struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	Path        string
+	OutPath     string
+	Buckets     []string
+	Format      string
+	KeyFormat   string
+	ValueFormat string
+}
+
+// newExportCommand returns an ExportCommand.
+func newExportCommand(m *Main) *ExportCommand {
+	return &ExportCommand{
+		Stdin:  m.Stdin,
+		Stdout: m.Stdout,
+		Stderr: m.Stderr,
+	}
+}
+
+// Run executes the command.
+func (cmd *ExportCommand) Run(args ...string) error {
+	// Parse flags.
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.StringVar(&cmd.OutPath, "o", "", "")
+	fs.Var((*stringSlice)(&cmd.Buckets), "bucket", "")
+	fs.StringVar(&cmd.Format, "format", string(exchange.JSONLines), "")
+	fs.StringVar(&cmd.KeyFormat, "key-format", string(exchange.Base64), "")
+	fs.StringVar(&cmd.ValueFormat, "value-format", string(exchange.Base64), "")
+	if err := fs.Parse(args); err == flag.ErrHelp {
+		fmt.Fprintln(cmd.Stderr, cmd.Usage())
+		return ErrUsage
+	} else if err != nil {
+		return err
+	}
+
+	var paths [][][]byte
+	for _, s := range cmd.Buckets {
+		path, err := exchange.ParsePath(s, exchange.UTF8)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, path)
+	}
+
+	// Require database path.
+	cmd.Path = fs.Arg(0)
+	if cmd.Path == "" {
+		return ErrPathRequired
+	} else if _, err := os.Stat(cmd.Path); os.IsNotExist(err) {
+		return ErrFileNotFound
+	}
+
+	// Write to a file if one is given. Otherwise write to stdout.
+	out := cmd.Stdout
+	var file *os.File
+	if cmd.OutPath != "" && cmd.OutPath != "-" {
+		f, err := os.Create(cmd.OutPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out, file = f, f
+	}
+	w, err := exchange.NewWriter(out, &exchange.Options{
+		Format:        exchange.Format(cmd.Format),
+		KeyEncoding:   exchange.Encoding(cmd.KeyFormat),
+		ValueEncoding: exchange.Encoding(cmd.ValueFormat),
+	})
+	if err != nil {
+		return err
+	}
+
+	db, err := bolt.Open(cmd.Path, 0666, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var n int
+	if err := db.View(func(tx *bolt.Tx) error {
+		var err error
+		n, err = exchange.Export(tx, w, paths...)
+		return err
+	}); err != nil {
+		return err
+	} else if err := w.Flush(); err != nil {
+		return err
+	}
+
+	// Report the number of keys unless the output is on stdout.
+	if file != nil {
+		if err := file.Close(); err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.Stdout, "exported %d keys\n", n)
+	}
+	return nil
+}
+
+// Usage returns the help message.
+func (cmd *ExportCommand) Usage() string {
+	return strings.TrimLeft(`
+usage: bolt export [options] PATH
+
+Export writes the buckets of the database at PATH, with their nested buckets,
+sequences and keys, to stdout or to a file. The output can be loaded with
+"bolt import".
+
+By default, the output contains one JSON object per line with the path of the
+bucket and a base64 encoded key and value. Objects without a key hold the
+sequence of their bucket and precede its keys:
+
+	{"bucket":["d2lkZ2V0cw=="],"sequence":3}
+	{"bucket":["d2lkZ2V0cw=="],"key":"Zm9v","value":"YmFy"}
+
+CSV output starts with a header naming the bucket, key, value and sequence
+columns. Bucket paths join the encoded names with "/", with "%" and "/" in
+encoded names escaped as "%25" and "%2F".
+
+Additional options include:
+
+	-o FILE
+		Writes the output to FILE instead of stdout.
+
+	-bucket PATH
+		Exports only the bucket at PATH, given as UTF-8 names joined by
+		"/". May be given more than once. Defaults to all buckets.
+
+	-format FORMAT
+		Format of the output: jsonl or csv. Defaults to jsonl.
+
+	-key-format FORMAT
+		Encoding of bucket names and keys: utf8, hex or base64.
+		Defaults to base64.
+
+	-value-format FORMAT
+		Encoding of values: utf8, hex or base64. Defaults to base64.
+
+Export fails if a bucket name, key or value written as utf8 is not valid
+UTF-8.
+`, "\n")
+}
+
+// stringSlice is a flag that can be given more than once.
+type stringSlice []string
+
+// String returns the values joined by commas.
+func (s *stringSlice) String() string {
+	return strings.Join(*s, ",")
+}
+
+// Set appends a value.
+func (s *stringSlice) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
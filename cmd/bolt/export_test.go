@@ -0,0 +1,84 @@
+package main_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// Ensure the "export" command writes buckets that "import" loads back.
+func TestExportCommand_Run(t *testing.T) {
+	db := MustOpen(0666, nil)
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		} else if err := b.SetSequence(10); err != nil {
+			return err
+		}
+		child, err := b.CreateBucket([]byte("child"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 100; i++ {
+			if err := child.Put([]byte(fmt.Sprintf("%03d", i)), []byte(fmt.Sprint(i))); err != nil {
+				return err
+			}
+		}
+		_, err = tx.CreateBucket([]byte("other"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.DB.Close()
+	defer db.Close()
+
+	// Export a single bucket as CSV to stdout.
+	m := NewMain()
+	if err := m.Run("export", "-format", "csv", "-key-format", "utf8", "-value-format", "hex", "-bucket", "widgets/child", db.Path); err != nil {
+		t.Fatal(err)
+	} else if exp := "bucket,key,value,sequence\nwidgets/child,,,0\nwidgets/child,000,30,\n"; m.Stdout.String()[:len(exp)] != exp {
+		t.Fatalf("unexpected output: %q", m.Stdout.String())
+	}
+
+	// Export everything to a file and import it into a new database.
+	out := db.Path + ".jsonl"
+	defer os.Remove(out)
+	m = NewMain()
+	if err := m.Run("export", "-o", out, db.Path); err != nil {
+		t.Fatal(err)
+	} else if m.Stdout.String() != "exported 100 keys\n" {
+		t.Fatalf("unexpected output: %q", m.Stdout.String())
+	}
+
+	dst := MustOpen(0666, nil)
+	dst.DB.Close()
+	defer dst.Close()
+	m = NewMain()
+	if err := m.Run("import", dst.Path, out); err != nil {
+		t.Fatal(err)
+	} else if m.Stdout.String() != "imported 100 keys\n" {
+		t.Fatalf("unexpected output: %q", m.Stdout.String())
+	}
+
+	d, err := bolt.Open(dst.Path, 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if err := d.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if b.Sequence() != 10 {
+			t.Fatalf("unexpected sequence: %d", b.Sequence())
+		} else if v := b.Bucket([]byte("child")).Get([]byte("042")); string(v) != "42" {
+			t.Fatalf("unexpected value: %q", v)
+		} else if tx.Bucket([]byte("other")) == nil {
+			t.Fatal("expected bucket")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/boltdb/bolt/exchange"
+)
+
+// ImportCommand represents the "import" command execution.
+type ImportCommand struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	Path        string
+	Bucket      string
+	Bulk        bool
+	FillPercent float64
+	TxMaxSize   int64
+	Format      string
+	KeyFormat   string
+	ValueFormat string
+}
+
+// newImportCommand returns an ImportCommand.
+func newImportCommand(m *Main) *ImportCommand {
+	return &ImportCommand{
+		Stdin:  m.Stdin,
+		Stdout: m.Stdout,
+		Stderr: m.Stderr,
+	}
+}
+
+// Run executes the command.
+func (cmd *ImportCommand) Run(args ...string) error {
+	// Parse flags.
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.StringVar(&cmd.Bucket, "bucket", "", "")
+	fs.BoolVar(&cmd.Bulk, "bulk", false, "")
+	fs.Float64Var(&cmd.FillPercent, "fill-percent", bolt.DefaultFillPercent, "")
+	fs.Int64Var(&cmd.TxMaxSize, "tx-max-size", 65536, "")
+	fs.StringVar(&cmd.Format, "format", string(exchange.JSONLines), "")
+	fs.StringVar(&cmd.KeyFormat, "key-format", string(exchange.Base64), "")
+	fs.StringVar(&cmd.ValueFormat, "value-format", string(exchange.Base64), "")
+	if err := fs.Parse(args); err == flag.ErrHelp {
+		fmt.Fprintln(cmd.Stderr, cmd.Usage())
+		return ErrUsage
+	} else if err != nil {
+		return err
+	} else if cmd.Bulk && cmd.Bucket == "" {
+		return fmt.Errorf("bucket required")
+	}
+	bucket, err := exchange.ParsePath(cmd.Bucket, exchange.UTF8)
+	if err != nil {
+		return err
+	}
+
+	// Require database path.
+	cmd.Path = fs.Arg(0)
+	if cmd.Path == "" {
+		return ErrPathRequired
+	}
+
+	// Read from a file if one is given. Otherwise read from stdin.
+	r := cmd.Stdin
+	if path := fs.Arg(1); path != "" && path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	rd, err := exchange.NewReader(r, &exchange.Options{
+		Format:        exchange.Format(cmd.Format),
+		KeyEncoding:   exchange.Encoding(cmd.KeyFormat),
+		ValueEncoding: exchange.Encoding(cmd.ValueFormat),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Open database.
+	db, err := bolt.Open(cmd.Path, 0666, nil)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var n int
+	if cmd.Bulk {
+		n, err = cmd.bulkLoad(db, rd, bucket)
+	} else {
+		n, err = exchange.Import(db, rd, &exchange.ImportOptions{
+			Bucket:      bucket,
+			TxMaxSize:   int(cmd.TxMaxSize),
+			FillPercent: cmd.FillPercent,
+		})
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.Stdout, "imported %d keys\n", n)
+
+	return nil
+}
+
+// bulkLoad loads sorted records into an empty bucket in a single transaction.
+// Records must not name a bucket.
+func (cmd *ImportCommand) bulkLoad(db *bolt.DB, rd *exchange.Reader, bucket [][]byte) (n int, err error) {
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(bucket[0])
+		for i := 1; err == nil && i < len(bucket); i++ {
+			b, err = b.CreateBucketIfNotExists(bucket[i])
+		}
+		if err != nil {
+			return err
+		}
+		b.FillPercent = cmd.FillPercent
+
+		// Stop the sequence on the first reading error and report it once
+		// the load returns.
+		var readErr error
+		if err := b.BulkLoad(func(yield func([]byte, []byte) bool) {
+			for {
+				rec, err := rd.Read()
+				if err == io.EOF {
+					return
+				} else if err != nil {
+					readErr = err
+					return
+				} else if len(rec.Bucket) > 0 {
+					readErr = fmt.Errorf("line %d: bucket not allowed when bulk loading", rd.Line())
+					return
+				} else if rec.Key == nil {
+					continue
+				}
+				n++
+				if !yield(rec.Key, rec.Value) {
+					return
+				}
+			}
+		}); err != nil {
+			return fmt.Errorf("line %d: %w", rd.Line(), err)
+		}
+		return readErr
+	})
+	return n, err
+}
+
+// Usage returns the help message.
+func (cmd *ImportCommand) Usage() string {
+	return strings.TrimLeft(`
+usage: bolt import [options] PATH [FILE]
+
+Import reads buckets and key/value pairs from FILE, or from stdin if FILE is
+not given, and stores them in the database at PATH. Buckets are created if
+they do not exist and existing keys are overwritten. The input is usually
+written by "bolt export".
+
+By default, the input contains one JSON object per line with the path of the
+bucket and a base64 encoded key and value. Objects without a key set the
+sequence of their bucket:
+
+	{"bucket":["d2lkZ2V0cw=="],"sequence":3}
+	{"bucket":["d2lkZ2V0cw=="],"key":"Zm9v","value":"YmFy"}
+
+CSV input starts with a header naming the bucket, key, value and sequence
+columns. Bucket paths join the encoded names with "/".
+
+Additional options include:
+
+	-bucket PATH
+		Imports below the bucket at PATH, given as UTF-8 names joined
+		by "/". Records without a bucket are stored in it.
+
+	-bulk
+		Builds the bucket given by -bucket bottom-up from sorted input
+		in one transaction. The bucket must be empty, keys must be in
+		ascending order and records must not name a bucket.
+
+	-format FORMAT
+		Format of the input: jsonl or csv. Defaults to jsonl.
+
+	-key-format FORMAT
+		Encoding of bucket names and keys: utf8, hex or base64.
+		Defaults to base64.
+
+	-value-format FORMAT
+		Encoding of values: utf8, hex or base64. Defaults to base64.
+
+	-fill-percent NUM
+		Sets how full pages are filled before splitting. Use 1.0 for
+		read-mostly data. Defaults to 0.5.
+
+	-tx-max-size NUM
+		Specifies the maximum size of individual transactions when not
+		bulk loading. Defaults to 64KB.
+`, "\n")
+}
@@ -0,0 +1,79 @@
+package main_test
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// Ensure the "import" command loads keys with and without bulk loading.
+func TestImportCommand_Run(t *testing.T) {
+	for _, bulk := range []bool{false, true} {
+		t.Run(fmt.Sprintf("bulk=%v", bulk), func(t *testing.T) {
+			const count = 10000
+			db := MustOpen(0666, nil)
+			db.DB.Close()
+			defer db.Close()
+
+			m := NewMain()
+			enc := json.NewEncoder(&m.Stdin)
+			for i := 0; i < count; i++ {
+				k := make([]byte, 8)
+				binary.BigEndian.PutUint64(k, uint64(i))
+				if err := enc.Encode(map[string][]byte{"key": k, "value": []byte(fmt.Sprint(i))}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			args := []string{"import", "-bucket", "widgets", "-fill-percent", "1.0"}
+			if bulk {
+				args = append(args, "-bulk")
+			}
+			if err := m.Run(append(args, db.Path)...); err != nil {
+				t.Fatal(err)
+			} else if exp := fmt.Sprintf("imported %d keys\n", count); m.Stdout.String() != exp {
+				t.Fatalf("unexpected stdout: %q", m.Stdout.String())
+			}
+
+			var err error
+			if db.DB, err = bolt.Open(db.Path, 0666, nil); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.View(func(tx *bolt.Tx) error {
+				b := tx.Bucket([]byte("widgets"))
+				if n := b.Stats().KeyN; n != count {
+					t.Fatalf("unexpected KeyN: %d", n)
+				}
+				k := make([]byte, 8)
+				binary.BigEndian.PutUint64(k, 1234)
+				if v := b.Get(k); string(v) != "1234" {
+					t.Fatalf("unexpected value: %q", v)
+				}
+				for err := range tx.Check() {
+					t.Fatal(err)
+				}
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// Ensure the "import" command rejects unsorted input when bulk loading.
+func TestImportCommand_Run_BulkUnsorted(t *testing.T) {
+	db := MustOpen(0666, nil)
+	db.DB.Close()
+	defer db.Close()
+
+	m := NewMain()
+	m.Stdin.WriteString(`{"key":"Yg==","value":"MQ=="}` + "\n" + `{"key":"YQ==","value":"Mg=="}` + "\n")
+	if err := m.Run("import", "-bucket", "widgets", "-bulk", db.Path); !errors.Is(err, bolt.ErrKeysUnsorted) || !strings.HasPrefix(err.Error(), "line 2: ") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,560 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/boltdb/bolt/exchange"
+)
+
+// GetCommand represents the "get" command execution.
+type GetCommand struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	KeyFormat   string
+	ValueFormat string
+}
+
+// newGetCommand returns a GetCommand.
+func newGetCommand(m *Main) *GetCommand {
+	return &GetCommand{
+		Stdin:  m.Stdin,
+		Stdout: m.Stdout,
+		Stderr: m.Stderr,
+	}
+}
+
+// Run executes the command.
+func (cmd *GetCommand) Run(args ...string) error {
+	// Parse flags.
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.StringVar(&cmd.KeyFormat, "key-format", string(exchange.UTF8), "")
+	fs.StringVar(&cmd.ValueFormat, "value-format", string(exchange.UTF8), "")
+	if err := fs.Parse(args); err == flag.ErrHelp {
+		fmt.Fprintln(cmd.Stderr, cmd.Usage())
+		return ErrUsage
+	} else if err != nil {
+		return err
+	} else if err := checkFormats(cmd.KeyFormat, cmd.ValueFormat); err != nil {
+		return err
+	}
+
+	// Require database path, a bucket and a key.
+	path, err := requirePath(fs.Arg(0))
+	if err != nil {
+		return err
+	} else if fs.NArg() < 3 {
+		return fmt.Errorf("bucket and key required")
+	}
+	names, err := decodeArgs(cmd.KeyFormat, fs.Args()[1:])
+	if err != nil {
+		return err
+	}
+	key := names[len(names)-1]
+
+	db, err := bolt.Open(path, 0666, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.View(func(tx *bolt.Tx) error {
+		b, err := bucketAt(tx, names[:len(names)-1])
+		if err != nil {
+			return err
+		}
+		if b.Bucket(key) != nil {
+			return &bolt.KeyError{Bucket: names[:len(names)-1], Key: key, Err: bolt.ErrIncompatibleValue}
+		}
+		v := b.Get(key)
+		if v == nil {
+			return &bolt.KeyError{Bucket: names[:len(names)-1], Key: key, Err: ErrKeyNotFound}
+		}
+		fmt.Fprintln(cmd.Stdout, encode(cmd.ValueFormat, v))
+		return nil
+	})
+}
+
+// Usage returns the help message.
+func (cmd *GetCommand) Usage() string {
+	return strings.TrimLeft(`
+usage: bolt get [options] PATH BUCKET... KEY
+
+Get prints the value of KEY in the bucket at the path given by the names of
+the bucket and its parents.
+
+Additional options include:
+
+	-key-format FORMAT
+		Encoding of bucket names and keys: utf8, hex or base64.
+		Defaults to utf8.
+
+	-value-format FORMAT
+		Encoding of the printed value: utf8, hex or base64.
+		Defaults to utf8.
+`, "\n")
+}
+
+// PutCommand represents the "put" command execution.
+type PutCommand struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	KeyFormat   string
+	ValueFormat string
+	Create      bool
+}
+
+// newPutCommand returns a PutCommand.
+func newPutCommand(m *Main) *PutCommand {
+	return &PutCommand{
+		Stdin:  m.Stdin,
+		Stdout: m.Stdout,
+		Stderr: m.Stderr,
+	}
+}
+
+// Run executes the command.
+func (cmd *PutCommand) Run(args ...string) error {
+	// Parse flags.
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.StringVar(&cmd.KeyFormat, "key-format", string(exchange.UTF8), "")
+	fs.StringVar(&cmd.ValueFormat, "value-format", string(exchange.UTF8), "")
+	fs.BoolVar(&cmd.Create, "create", false, "")
+	if err := fs.Parse(args); err == flag.ErrHelp {
+		fmt.Fprintln(cmd.Stderr, cmd.Usage())
+		return ErrUsage
+	} else if err != nil {
+		return err
+	} else if err := checkFormats(cmd.KeyFormat, cmd.ValueFormat); err != nil {
+		return err
+	}
+
+	// Require database path, a bucket, a key and a value.
+	path, err := requirePath(fs.Arg(0))
+	if err != nil {
+		return err
+	} else if fs.NArg() < 4 {
+		return fmt.Errorf("bucket, key and value required")
+	}
+	names, err := decodeArgs(cmd.KeyFormat, fs.Args()[1:fs.NArg()-1])
+	if err != nil {
+		return err
+	}
+	key := names[len(names)-1]
+
+	// Read the value from stdin if it is "-".
+	arg := fs.Arg(fs.NArg() - 1)
+	if arg == "-" {
+		buf, err := ioutil.ReadAll(cmd.Stdin)
+		if err != nil {
+			return err
+		}
+		arg = string(buf)
+		if cmd.ValueFormat != string(exchange.UTF8) {
+			arg = strings.TrimSpace(arg)
+		}
+	}
+	value, err := decode(cmd.ValueFormat, arg)
+	if err != nil {
+		return fmt.Errorf("invalid %s value: %s", cmd.ValueFormat, err)
+	}
+
+	db, err := bolt.Open(path, 0666, nil)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Update(func(tx *bolt.Tx) error {
+		var b *bolt.Bucket
+		var err error
+		if cmd.Create {
+			b, err = createBucketAt(tx, names[:len(names)-1])
+		} else {
+			b, err = bucketAt(tx, names[:len(names)-1])
+		}
+		if err != nil {
+			return err
+		}
+		return b.Put(key, value)
+	})
+}
+
+// Usage returns the help message.
+func (cmd *PutCommand) Usage() string {
+	return strings.TrimLeft(`
+usage: bolt put [options] PATH BUCKET... KEY VALUE
+
+Put sets KEY to VALUE in the bucket at the path given by the names of the
+bucket and its parents. If VALUE is "-", the value is read from stdin.
+
+Additional options include:
+
+	-create
+		Create the bucket and its parents if they do not exist.
+
+	-key-format FORMAT
+		Encoding of bucket names and keys: utf8, hex or base64.
+		Defaults to utf8.
+
+	-value-format FORMAT
+		Encoding of the value: utf8, hex or base64. Values in utf8
+		read from stdin are used as is. Defaults to utf8.
+`, "\n")
+}
+
+// DeleteCommand represents the "delete" command execution.
+type DeleteCommand struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	KeyFormat string
+	Bucket    bool
+}
+
+// newDeleteCommand returns a DeleteCommand.
+func newDeleteCommand(m *Main) *DeleteCommand {
+	return &DeleteCommand{
+		Stdin:  m.Stdin,
+		Stdout: m.Stdout,
+		Stderr: m.Stderr,
+	}
+}
+
+// Run executes the command.
+func (cmd *DeleteCommand) Run(args ...string) error {
+	// Parse flags.
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.StringVar(&cmd.KeyFormat, "key-format", string(exchange.UTF8), "")
+	fs.BoolVar(&cmd.Bucket, "bucket", false, "")
+	if err := fs.Parse(args); err == flag.ErrHelp {
+		fmt.Fprintln(cmd.Stderr, cmd.Usage())
+		return ErrUsage
+	} else if err != nil {
+		return err
+	} else if err := checkFormats(cmd.KeyFormat); err != nil {
+		return err
+	}
+
+	// Require database path and a bucket. Keys also need their bucket.
+	path, err := requirePath(fs.Arg(0))
+	if err != nil {
+		return err
+	} else if fs.NArg() < 2 || (!cmd.Bucket && fs.NArg() < 3) {
+		return fmt.Errorf("bucket and key required")
+	}
+	names, err := decodeArgs(cmd.KeyFormat, fs.Args()[1:])
+	if err != nil {
+		return err
+	}
+	key := names[len(names)-1]
+
+	db, err := bolt.Open(path, 0666, nil)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Update(func(tx *bolt.Tx) error {
+		// Delete top-level buckets from the transaction.
+		if cmd.Bucket && len(names) == 1 {
+			return tx.DeleteBucket(key)
+		}
+
+		b, err := bucketAt(tx, names[:len(names)-1])
+		if err != nil {
+			return err
+		} else if cmd.Bucket {
+			return b.DeleteBucket(key)
+		} else if b.Get(key) == nil && b.Bucket(key) == nil {
+			return &bolt.KeyError{Bucket: names[:len(names)-1], Key: key, Err: ErrKeyNotFound}
+		}
+		return b.Delete(key)
+	})
+}
+
+// Usage returns the help message.
+func (cmd *DeleteCommand) Usage() string {
+	return strings.TrimLeft(`
+usage: bolt delete [options] PATH BUCKET... KEY
+
+Delete removes KEY from the bucket at the path given by the names of the
+bucket and its parents.
+
+Additional options include:
+
+	-bucket
+		Delete the bucket at the path given by the arguments, and all
+		of its keys, instead of a key.
+
+	-key-format FORMAT
+		Encoding of bucket names and keys: utf8, hex or base64.
+		Defaults to utf8.
+`, "\n")
+}
+
+// KeysCommand represents the "keys" command execution.
+type KeysCommand struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	KeyFormat   string
+	ValueFormat string
+	Values      bool
+}
+
+// newKeysCommand returns a KeysCommand.
+func newKeysCommand(m *Main) *KeysCommand {
+	return &KeysCommand{
+		Stdin:  m.Stdin,
+		Stdout: m.Stdout,
+		Stderr: m.Stderr,
+	}
+}
+
+// Run executes the command.
+func (cmd *KeysCommand) Run(args ...string) error {
+	// Parse flags.
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.StringVar(&cmd.KeyFormat, "key-format", string(exchange.UTF8), "")
+	fs.StringVar(&cmd.ValueFormat, "value-format", string(exchange.UTF8), "")
+	fs.BoolVar(&cmd.Values, "values", false, "")
+	if err := fs.Parse(args); err == flag.ErrHelp {
+		fmt.Fprintln(cmd.Stderr, cmd.Usage())
+		return ErrUsage
+	} else if err != nil {
+		return err
+	} else if err := checkFormats(cmd.KeyFormat, cmd.ValueFormat); err != nil {
+		return err
+	}
+
+	// Require database path and a bucket.
+	path, err := requirePath(fs.Arg(0))
+	if err != nil {
+		return err
+	} else if fs.NArg() < 2 {
+		return fmt.Errorf("bucket required")
+	}
+	names, err := decodeArgs(cmd.KeyFormat, fs.Args()[1:])
+	if err != nil {
+		return err
+	}
+
+	db, err := bolt.Open(path, 0666, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.View(func(tx *bolt.Tx) error {
+		b, err := bucketAt(tx, names)
+		if err != nil {
+			return err
+		}
+
+		// Print each key, followed by its value if requested. Nested
+		// buckets have no value.
+		return b.ForEach(func(k, v []byte) error {
+			if !cmd.Values || v == nil {
+				_, err := fmt.Fprintln(cmd.Stdout, encode(cmd.KeyFormat, k))
+				return err
+			}
+			_, err := fmt.Fprintf(cmd.Stdout, "%s %s\n", encode(cmd.KeyFormat, k), encode(cmd.ValueFormat, v))
+			return err
+		})
+	})
+}
+
+// Usage returns the help message.
+func (cmd *KeysCommand) Usage() string {
+	return strings.TrimLeft(`
+usage: bolt keys [options] PATH BUCKET...
+
+Keys prints the keys in the bucket at the path given by the names of the
+bucket and its parents, one per line in key order. Nested buckets are
+included.
+
+Additional options include:
+
+	-values
+		Print the value after each key, separated by a space.
+
+	-key-format FORMAT
+		Encoding of bucket names and keys: utf8, hex or base64.
+		Defaults to utf8.
+
+	-value-format FORMAT
+		Encoding of the printed values: utf8, hex or base64.
+		Defaults to utf8.
+`, "\n")
+}
+
+// BucketsCommand represents the "buckets" command execution.
+type BucketsCommand struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	KeyFormat string
+}
+
+// newBucketsCommand returns a BucketsCommand.
+func newBucketsCommand(m *Main) *BucketsCommand {
+	return &BucketsCommand{
+		Stdin:  m.Stdin,
+		Stdout: m.Stdout,
+		Stderr: m.Stderr,
+	}
+}
+
+// Run executes the command.
+func (cmd *BucketsCommand) Run(args ...string) error {
+	// Parse flags.
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.StringVar(&cmd.KeyFormat, "key-format", string(exchange.UTF8), "")
+	if err := fs.Parse(args); err == flag.ErrHelp {
+		fmt.Fprintln(cmd.Stderr, cmd.Usage())
+		return ErrUsage
+	} else if err != nil {
+		return err
+	} else if err := checkFormats(cmd.KeyFormat); err != nil {
+		return err
+	}
+
+	// Require database path.
+	path, err := requirePath(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	names, err := decodeArgs(cmd.KeyFormat, fs.Args()[1:])
+	if err != nil {
+		return err
+	}
+
+	db, err := bolt.Open(path, 0666, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.View(func(tx *bolt.Tx) error {
+		// List top-level buckets if no path is given.
+		if len(names) == 0 {
+			return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+				_, err := fmt.Fprintln(cmd.Stdout, encode(cmd.KeyFormat, name))
+				return err
+			})
+		}
+
+		b, err := bucketAt(tx, names)
+		if err != nil {
+			return err
+		}
+		return b.ForEach(func(k, v []byte) error {
+			if v != nil {
+				return nil
+			}
+			_, err := fmt.Fprintln(cmd.Stdout, encode(cmd.KeyFormat, k))
+			return err
+		})
+	})
+}
+
+// Usage returns the help message.
+func (cmd *BucketsCommand) Usage() string {
+	return strings.TrimLeft(`
+usage: bolt buckets [options] PATH [BUCKET...]
+
+Buckets prints the names of the nested buckets in the bucket at the path
+given by the names of the bucket and its parents, one per line. The top-level
+buckets are printed if no bucket is given.
+
+Additional options include:
+
+	-key-format FORMAT
+		Encoding of bucket names: utf8, hex or base64. Defaults to utf8.
+`, "\n")
+}
+
+// requirePath returns path if it names an existing file.
+func requirePath(path string) (string, error) {
+	if path == "" {
+		return "", ErrPathRequired
+	} else if _, err := os.Stat(path); os.IsNotExist(err) {
+		return "", ErrFileNotFound
+	}
+	return path, nil
+}
+
+// bucketAt returns the bucket at path.
+func bucketAt(tx *bolt.Tx, path [][]byte) (*bolt.Bucket, error) {
+	b := tx.Bucket(path[0])
+	for i := 1; b != nil && i < len(path); i++ {
+		if b = b.Bucket(path[i]); b == nil {
+			return nil, &bolt.BucketError{Path: path[:i+1], Err: bolt.ErrBucketNotFound}
+		}
+	}
+	if b == nil {
+		return nil, &bolt.BucketError{Path: path[:1], Err: bolt.ErrBucketNotFound}
+	}
+	return b, nil
+}
+
+// createBucketAt returns the bucket at path, creating it and its parents if
+// they do not exist.
+func createBucketAt(tx *bolt.Tx, path [][]byte) (*bolt.Bucket, error) {
+	b, err := tx.CreateBucketIfNotExists(path[0])
+	for i := 1; err == nil && i < len(path); i++ {
+		b, err = b.CreateBucketIfNotExists(path[i])
+	}
+	return b, err
+}
+
+// checkFormats returns an error if a format is not a known encoding.
+func checkFormats(formats ...string) error {
+	for _, format := range formats {
+		if err := exchange.Encoding(format).Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decode returns the bytes of a string in the given format.
+func decode(format, s string) ([]byte, error) {
+	return exchange.Encoding(format).Decode(s)
+}
+
+// decodeArgs decodes each argument in the given format.
+func decodeArgs(format string, args []string) ([][]byte, error) {
+	var a [][]byte
+	for _, arg := range args {
+		b, err := decode(format, arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %q: %s", format, arg, err)
+		}
+		a = append(a, b)
+	}
+	return a, nil
+}
+
+// encode returns bytes as a string in the given format.
+func encode(format string, b []byte) string {
+	return exchange.Encoding(format).Encode(b)
+}
@@ -0,0 +1,88 @@
+package main_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/boltdb/bolt/cmd/bolt"
+)
+
+// Ensure keys can be written, read, listed and deleted in nested buckets.
+func TestKeyValueCommands(t *testing.T) {
+	db := MustOpen(0666, nil)
+	db.DB.Close()
+	defer db.Close()
+
+	run := func(stdin string, args ...string) (string, error) {
+		m := NewMain()
+		m.Stdin.WriteString(stdin)
+		err := m.Run(args...)
+		return m.Stdout.String(), err
+	}
+
+	// Buckets must exist unless they are created.
+	if _, err := run("", "put", db.Path, "widgets", "foo", "bar"); !errors.Is(err, bolt.ErrBucketNotFound) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := run("", "put", "-create", db.Path, "widgets", "sub", "foo", "bar"); err != nil {
+		t.Fatal(err)
+	} else if _, err := run("", "put", "-key-format", "hex", "-value-format", "base64", db.Path, "7769646765747a", "00ff", "AQI="); err == nil {
+		t.Fatal("expected error")
+	} else if _, err := run("", "put", "-key-format", "hex", "-value-format", "base64", db.Path, "77696467657473", "00ff", "AQI="); err != nil {
+		t.Fatal(err)
+	} else if _, err := run("line 1\nline 2\n", "put", db.Path, "widgets", "baz", "-"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Values are printed in the requested format.
+	if out, err := run("", "get", db.Path, "widgets", "sub", "foo"); err != nil {
+		t.Fatal(err)
+	} else if out != "bar\n" {
+		t.Fatalf("unexpected value: %q", out)
+	} else if out, _ := run("", "get", "-key-format", "base64", "-value-format", "hex", db.Path, "d2lkZ2V0cw==", "AP8="); out != "0102\n" {
+		t.Fatalf("unexpected value: %q", out)
+	} else if out, _ := run("", "get", db.Path, "widgets", "baz"); out != "line 1\nline 2\n\n" {
+		t.Fatalf("unexpected value: %q", out)
+	} else if _, err := run("", "get", db.Path, "widgets", "missing"); !errors.Is(err, main.ErrKeyNotFound) {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, err := run("", "get", db.Path, "widgets", "sub"); !errors.Is(err, bolt.ErrIncompatibleValue) {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, err := run("", "get", "-key-format", "rot13", db.Path, "widgets", "foo"); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// Keys include nested buckets. Buckets only list buckets.
+	if out, err := run("", "keys", "-key-format", "hex", "-values", db.Path, "77696467657473"); err != nil {
+		t.Fatal(err)
+	} else if exp := "00ff \x01\x02\n62617a line 1\nline 2\n\n737562\n"; out != exp {
+		t.Fatalf("unexpected keys: %q", out)
+	} else if out, _ := run("", "buckets", db.Path); out != "widgets\n" {
+		t.Fatalf("unexpected buckets: %q", out)
+	} else if out, _ := run("", "buckets", db.Path, "widgets"); out != "sub\n" {
+		t.Fatalf("unexpected buckets: %q", out)
+	}
+
+	// Keys and buckets can be deleted.
+	if _, err := run("", "delete", db.Path, "widgets", "baz"); err != nil {
+		t.Fatal(err)
+	} else if _, err := run("", "delete", db.Path, "widgets", "baz"); !errors.Is(err, main.ErrKeyNotFound) {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, err := run("", "delete", db.Path, "widgets", "sub"); !errors.Is(err, bolt.ErrIncompatibleValue) {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, err := run("", "delete", "-bucket", db.Path, "widgets", "sub"); err != nil {
+		t.Fatal(err)
+	} else if out, _ := run("", "keys", db.Path, "widgets"); out != "\x00\xff\n" {
+		t.Fatalf("unexpected keys: %q", out)
+	} else if _, err := run("", "delete", "-bucket", db.Path, "widgets"); err != nil {
+		t.Fatal(err)
+	} else if out, _ := run("", "buckets", db.Path); out != "" {
+		t.Fatalf("unexpected buckets: %q", out)
+	}
+
+	// Errors name the bucket path.
+	if _, err := run("", "keys", db.Path, "widgets", "sub"); err == nil || !strings.Contains(err.Error(), `bucket "widgets"`) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -3,23 +3,31 @@ package main
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"io/ioutil"
+	"math"
+	"math/bits"
 	"math/rand"
 	"os"
 	"runtime"
 	"runtime/pprof"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 	"unicode"
 	"unicode/utf8"
 	"unsafe"
 
 	"github.com/boltdb/bolt"
+	"github.com/boltdb/bolt/exchange"
 )
 
 var (
@@ -54,6 +62,9 @@ var (
 
 	// ErrPageFreed is returned when reading a page that has already been freed.
 	ErrPageFreed = errors.New("page freed")
+
+	// ErrKeyNotFound is returned when a key does not exist in its bucket.
+	ErrKeyNotFound = errors.New("key not found")
 )
 
 // PageHeaderSize represents the size of the bolt.page header.
@@ -100,20 +111,46 @@ func (m *Main) Run(args ...string) error {
 		return ErrUsage
 	case "bench":
 		return newBenchCommand(m).Run(args[1:]...)
+	case "buckets":
+		return newBucketsCommand(m).Run(args[1:]...)
 	case "check":
 		return newCheckCommand(m).Run(args[1:]...)
 	case "compact":
 		return newCompactCommand(m).Run(args[1:]...)
+	case "delete":
+		return newDeleteCommand(m).Run(args[1:]...)
+	case "diff":
+		return newDiffCommand(m).Run(args[1:]...)
 	case "dump":
 		return newDumpCommand(m).Run(args[1:]...)
+	case "export":
+		return newExportCommand(m).Run(args[1:]...)
+	case "get":
+		return newGetCommand(m).Run(args[1:]...)
+	case "import":
+		return newImportCommand(m).Run(args[1:]...)
 	case "info":
 		return newInfoCommand(m).Run(args[1:]...)
+	case "keys":
+		return newKeysCommand(m).Run(args[1:]...)
 	case "page":
 		return newPageCommand(m).Run(args[1:]...)
+	case "pagemap":
+		return newPagemapCommand(m).Run(args[1:]...)
 	case "pages":
 		return newPagesCommand(m).Run(args[1:]...)
+	case "put":
+		return newPutCommand(m).Run(args[1:]...)
+	case "repair":
+		return newRepairCommand(m).Run(args[1:]...)
+	case "shell":
+		return newShellCommand(m).Run(args[1:]...)
 	case "stats":
 		return newStatsCommand(m).Run(args[1:]...)
+	case "surgery":
+		return newSurgeryCommand(m).Run(args[1:]...)
+	case "top":
+		return newTopCommand(m).Run(args[1:]...)
 	default:
 		return ErrUnknownCommand
 	}
@@ -131,12 +168,25 @@ Usage:
 The commands are:
 
     bench       run synthetic benchmark against bolt
+    buckets     print the names of buckets
     check       verifies integrity of bolt database
     compact     copies a bolt database, compacting it in the process
+    delete      removes a key or a bucket
+    diff        compares the buckets and keys of two databases
+    export      writes buckets and keys as JSON Lines or CSV
+    get         print the value of a key
+    import      loads buckets and keys written by export
     info        print basic info
     help        print this screen
+    keys        print the keys of a bucket
+    pagemap     draws the pages of a database and their free spans
     pages       print list of pages with their types
+    put         sets the value of a key
+    repair      recovers keys from a damaged database into a new one
+    shell       edits buckets and keys interactively
     stats       iterate over all pages and generate usage stats
+    surgery     edits the pages of a copy of a damaged database
+    top         monitors the transactions and size of a running database
 
 Use "bolt [command] -h" for more information about a command.
 `, "\n")
@@ -163,6 +213,11 @@ func (cmd *CheckCommand) Run(args ...string) error {
 	// Parse flags.
 	fs := flag.NewFlagSet("", flag.ContinueOnError)
 	help := fs.Bool("h", false, "")
+	asJSON := fs.Bool("json", false, "")
+	var opts bolt.CheckOptions
+	fs.IntVar(&opts.Parallelism, "parallel", 0, "")
+	fs.IntVar(&opts.MaxErrors, "max-errors", 0, "")
+	fs.SetOutput(ioutil.Discard)
 	if err := fs.Parse(args); err != nil {
 		return err
 	} else if *help {
@@ -187,22 +242,34 @@ func (cmd *CheckCommand) Run(args ...string) error {
 
 	// Perform consistency check.
 	return db.View(func(tx *bolt.Tx) error {
-		var count int
-		ch := tx.Check()
-	loop:
-		for {
-			select {
-			case err, ok := <-ch:
-				if !ok {
-					break loop
-				}
+		var findings []checkFinding
+		for err := range tx.CheckWithOptions(&opts) {
+			if !*asJSON {
 				fmt.Fprintln(cmd.Stdout, err)
-				count++
 			}
+			findings = append(findings, newCheckFinding(err))
+		}
+
+		if *asJSON {
+			sort.SliceStable(findings, func(i, j int) bool {
+				if findings[i].PageID != findings[j].PageID {
+					return findings[i].PageID < findings[j].PageID
+				}
+				return findings[i].Kind < findings[j].Kind
+			})
+			enc := json.NewEncoder(cmd.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(checkReport{OK: len(findings) == 0, Errors: findings}); err != nil {
+				return err
+			}
+			if len(findings) > 0 {
+				return ErrCorrupt
+			}
+			return nil
 		}
 
 		// Print summary of errors.
-		if count > 0 {
+		if count := len(findings); count > 0 {
 			fmt.Fprintf(cmd.Stdout, "%d errors found\n", count)
 			return ErrCorrupt
 		}
@@ -213,17 +280,59 @@ func (cmd *CheckCommand) Run(args ...string) error {
 	})
 }
 
+// checkReport is the output of "bolt check -json".
+type checkReport struct {
+	OK     bool           `json:"ok"`
+	Errors []checkFinding `json:"errors"`
+}
+
+// checkFinding is a problem found by "bolt check -json". Bucket names and
+// keys are base64 encoded like the records of "bolt import".
+type checkFinding struct {
+	Kind    string   `json:"kind"`
+	PageID  int      `json:"page"`
+	Bucket  [][]byte `json:"bucket,omitempty"`
+	Key     []byte   `json:"key,omitempty"`
+	Reason  string   `json:"reason"`
+	Message string   `json:"message"`
+}
+
+// newCheckFinding converts an error from Tx.Check() to a finding.
+func newCheckFinding(err error) checkFinding {
+	f := checkFinding{Kind: bolt.CheckCorrupted, Reason: err.Error(), Message: err.Error()}
+	var e *bolt.CheckError
+	if errors.As(err, &e) {
+		f.Kind, f.PageID, f.Bucket, f.Key, f.Reason = e.Kind, e.PageID, e.Bucket, e.Key, e.Reason
+	}
+	return f
+}
+
 // Usage returns the help message.
 func (cmd *CheckCommand) Usage() string {
 	return strings.TrimLeft(`
-usage: bolt check PATH
+usage: bolt check [options] PATH
 
 Check opens a database at PATH and runs an exhaustive check to verify that
 all pages are accessible or are marked as freed. It also verifies that no
-pages are double referenced.
+pages are double referenced, that page headers and overflow lengths are
+valid, that keys are sorted within and across pages and lie within the
+bounds of their branch elements, that inline buckets are laid out correctly
+and that the freelist is valid.
 
 Verification errors will stream out as they are found and the process will
-return after all pages have been checked.
+return after all pages have been checked. Nested buckets are checked in
+parallel so errors are not printed in page order.
+
+Options:
+
+	-json
+		Print a JSON report of the errors sorted by page once the check
+		completes. Bucket names and keys are base64 encoded.
+	-parallel N
+		Check at most N buckets at the same time. Defaults to the number
+		of CPUs.
+	-max-errors N
+		Stop after N errors. Defaults to no limit.
 `, "\n")
 }
 
@@ -749,6 +858,11 @@ type StatsCommand struct {
 	Stdin  io.Reader
 	Stdout io.Writer
 	Stderr io.Writer
+
+	JSON      bool
+	PerBucket bool
+	Depth     int
+	KeyFormat string
 }
 
 // NewStatsCommand returns a StatsCommand.
@@ -765,11 +879,18 @@ func (cmd *StatsCommand) Run(args ...string) error {
 	// Parse flags.
 	fs := flag.NewFlagSet("", flag.ContinueOnError)
 	help := fs.Bool("h", false, "")
+	fs.BoolVar(&cmd.JSON, "json", false, "")
+	fs.BoolVar(&cmd.PerBucket, "per-bucket", false, "")
+	fs.IntVar(&cmd.Depth, "depth", 1, "")
+	fs.StringVar(&cmd.KeyFormat, "key-format", string(exchange.UTF8), "")
+	fs.SetOutput(ioutil.Discard)
 	if err := fs.Parse(args); err != nil {
 		return err
 	} else if *help {
 		fmt.Fprintln(cmd.Stderr, cmd.Usage())
 		return ErrUsage
+	} else if err := checkFormats(cmd.KeyFormat); err != nil {
+		return err
 	}
 
 	// Require database path.
@@ -790,68 +911,167 @@ func (cmd *StatsCommand) Run(args ...string) error {
 	return db.View(func(tx *bolt.Tx) error {
 		var s bolt.BucketStats
 		var count int
+		var report statsReport
 		if err := tx.ForEach(func(name []byte, b *bolt.Bucket) error {
 			if bytes.HasPrefix(name, []byte(prefix)) {
 				s.Add(b.Stats())
 				count += 1
+				if cmd.JSON {
+					report.Aggregate.KeySizes.addBucket(b, true)
+					report.Aggregate.ValueSizes.addBucket(b, false)
+				}
+				if cmd.PerBucket {
+					cmd.collect(&report, [][]byte{name}, b)
+				}
 			}
 			return nil
 		}); err != nil {
 			return err
 		}
 
-		fmt.Fprintf(cmd.Stdout, "Aggregate statistics for %d buckets\n\n", count)
-
-		fmt.Fprintln(cmd.Stdout, "Page count statistics")
-		fmt.Fprintf(cmd.Stdout, "\tNumber of logical branch pages: %d\n", s.BranchPageN)
-		fmt.Fprintf(cmd.Stdout, "\tNumber of physical branch overflow pages: %d\n", s.BranchOverflowN)
-		fmt.Fprintf(cmd.Stdout, "\tNumber of logical leaf pages: %d\n", s.LeafPageN)
-		fmt.Fprintf(cmd.Stdout, "\tNumber of physical leaf overflow pages: %d\n", s.LeafOverflowN)
-
-		fmt.Fprintln(cmd.Stdout, "Tree statistics")
-		fmt.Fprintf(cmd.Stdout, "\tNumber of keys/value pairs: %d\n", s.KeyN)
-		fmt.Fprintf(cmd.Stdout, "\tNumber of levels in B+tree: %d\n", s.Depth)
-
-		fmt.Fprintln(cmd.Stdout, "Page size utilization")
-		fmt.Fprintf(cmd.Stdout, "\tBytes allocated for physical branch pages: %d\n", s.BranchAlloc)
-		var percentage int
-		if s.BranchAlloc != 0 {
-			percentage = int(float32(s.BranchInuse) * 100.0 / float32(s.BranchAlloc))
-		}
-		fmt.Fprintf(cmd.Stdout, "\tBytes actually used for branch data: %d (%d%%)\n", s.BranchInuse, percentage)
-		fmt.Fprintf(cmd.Stdout, "\tBytes allocated for physical leaf pages: %d\n", s.LeafAlloc)
-		percentage = 0
-		if s.LeafAlloc != 0 {
-			percentage = int(float32(s.LeafInuse) * 100.0 / float32(s.LeafAlloc))
+		if cmd.JSON {
+			report.Aggregate.BucketCount = count
+			report.Aggregate.Stats = s
+			report.Aggregate.InlineBucketN = s.InlineBucketN
+			report.Aggregate.PagedBucketN = s.BucketN - s.InlineBucketN
+			enc := json.NewEncoder(cmd.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(report)
 		}
-		fmt.Fprintf(cmd.Stdout, "\tBytes actually used for leaf data: %d (%d%%)\n", s.LeafInuse, percentage)
 
-		fmt.Fprintln(cmd.Stdout, "Bucket statistics")
-		fmt.Fprintf(cmd.Stdout, "\tTotal number of buckets: %d\n", s.BucketN)
-		percentage = 0
-		if s.BucketN != 0 {
-			percentage = int(float32(s.InlineBucketN) * 100.0 / float32(s.BucketN))
-		}
-		fmt.Fprintf(cmd.Stdout, "\tTotal number on inlined buckets: %d (%d%%)\n", s.InlineBucketN, percentage)
-		percentage = 0
-		if s.LeafInuse != 0 {
-			percentage = int(float32(s.InlineBucketInuse) * 100.0 / float32(s.LeafInuse))
+		if cmd.PerBucket {
+			for i, b := range report.Buckets {
+				if i > 0 {
+					fmt.Fprintln(cmd.Stdout)
+				}
+				kind := "paged"
+				if b.Inline {
+					kind = "inline"
+				}
+				fmt.Fprintf(cmd.Stdout, "Statistics for bucket %s (%s)\n\n", b.Path, kind)
+				cmd.printStats(b.Stats)
+				fmt.Fprintln(cmd.Stdout, "Key sizes")
+				cmd.printHistogram(&b.KeySizes)
+				fmt.Fprintln(cmd.Stdout, "Value sizes")
+				cmd.printHistogram(&b.ValueSizes)
+			}
+			return nil
 		}
-		fmt.Fprintf(cmd.Stdout, "\tBytes used for inlined buckets: %d (%d%%)\n", s.InlineBucketInuse, percentage)
 
+		fmt.Fprintf(cmd.Stdout, "Aggregate statistics for %d buckets\n\n", count)
+		cmd.printStats(s)
 		return nil
 	})
 }
 
+// collect adds the statistics of b and, up to the maximum depth, of its
+// nested buckets to the report.
+func (cmd *StatsCommand) collect(report *statsReport, path [][]byte, b *bolt.Bucket) {
+	s := statsBucket{
+		Path:   exchange.FormatPath(path, exchange.Encoding(cmd.KeyFormat)),
+		Depth:  len(path),
+		Inline: b.Root() == 0,
+		Stats:  b.Stats(),
+	}
+	s.InlineBucketN = s.Stats.InlineBucketN
+	s.PagedBucketN = s.Stats.BucketN - s.Stats.InlineBucketN
+	s.KeySizes.addBucket(b, true)
+	s.ValueSizes.addBucket(b, false)
+	report.Buckets = append(report.Buckets, s)
+
+	if cmd.Depth > 0 && len(path) >= cmd.Depth {
+		return
+	}
+	_ = b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			cmd.collect(report, append(path[:len(path):len(path)], k), b.Bucket(k))
+		}
+		return nil
+	})
+}
+
+// printStats writes the page, tree, utilization and bucket statistics.
+func (cmd *StatsCommand) printStats(s bolt.BucketStats) {
+	fmt.Fprintln(cmd.Stdout, "Page count statistics")
+	fmt.Fprintf(cmd.Stdout, "\tNumber of logical branch pages: %d\n", s.BranchPageN)
+	fmt.Fprintf(cmd.Stdout, "\tNumber of physical branch overflow pages: %d\n", s.BranchOverflowN)
+	fmt.Fprintf(cmd.Stdout, "\tNumber of logical leaf pages: %d\n", s.LeafPageN)
+	fmt.Fprintf(cmd.Stdout, "\tNumber of physical leaf overflow pages: %d\n", s.LeafOverflowN)
+
+	fmt.Fprintln(cmd.Stdout, "Tree statistics")
+	fmt.Fprintf(cmd.Stdout, "\tNumber of keys/value pairs: %d\n", s.KeyN)
+	fmt.Fprintf(cmd.Stdout, "\tNumber of levels in B+tree: %d\n", s.Depth)
+
+	fmt.Fprintln(cmd.Stdout, "Page size utilization")
+	fmt.Fprintf(cmd.Stdout, "\tBytes allocated for physical branch pages: %d\n", s.BranchAlloc)
+	var percentage int
+	if s.BranchAlloc != 0 {
+		percentage = int(float32(s.BranchInuse) * 100.0 / float32(s.BranchAlloc))
+	}
+	fmt.Fprintf(cmd.Stdout, "\tBytes actually used for branch data: %d (%d%%)\n", s.BranchInuse, percentage)
+	fmt.Fprintf(cmd.Stdout, "\tBytes allocated for physical leaf pages: %d\n", s.LeafAlloc)
+	percentage = 0
+	if s.LeafAlloc != 0 {
+		percentage = int(float32(s.LeafInuse) * 100.0 / float32(s.LeafAlloc))
+	}
+	fmt.Fprintf(cmd.Stdout, "\tBytes actually used for leaf data: %d (%d%%)\n", s.LeafInuse, percentage)
+
+	fmt.Fprintln(cmd.Stdout, "Bucket statistics")
+	fmt.Fprintf(cmd.Stdout, "\tTotal number of buckets: %d\n", s.BucketN)
+	percentage = 0
+	if s.BucketN != 0 {
+		percentage = int(float32(s.InlineBucketN) * 100.0 / float32(s.BucketN))
+	}
+	fmt.Fprintf(cmd.Stdout, "\tTotal number on inlined buckets: %d (%d%%)\n", s.InlineBucketN, percentage)
+	percentage = 0
+	if s.LeafInuse != 0 {
+		percentage = int(float32(s.InlineBucketInuse) * 100.0 / float32(s.LeafInuse))
+	}
+	fmt.Fprintf(cmd.Stdout, "\tBytes used for inlined buckets: %d (%d%%)\n", s.InlineBucketInuse, percentage)
+}
+
+// printHistogram writes a size histogram with one line per non-empty bin.
+func (cmd *StatsCommand) printHistogram(h *statsHistogram) {
+	var mean float64
+	if h.Count > 0 {
+		mean = float64(h.Total) / float64(h.Count)
+	}
+	fmt.Fprintf(cmd.Stdout, "\tCount: %d, min: %d, max: %d, mean: %.1f\n", h.Count, h.Min, h.Max, mean)
+	for _, bin := range h.Bins {
+		fmt.Fprintf(cmd.Stdout, "\t%d-%d bytes: %d\n", bin.Min, bin.Max, bin.Count)
+	}
+}
+
 // Usage returns the help message.
 func (cmd *StatsCommand) Usage() string {
 	return strings.TrimLeft(`
-usage: bolt stats PATH
+usage: bolt stats [options] PATH [PREFIX]
 
 Stats performs an extensive search of the database to track every page
 reference. It starts at the current meta page and recursively iterates
 through every accessible bucket.
 
+Statistics are aggregated over the top-level buckets whose name starts with
+PREFIX. The statistics of a bucket include its nested buckets.
+
+Additional options include:
+
+    -per-bucket
+        Reports the statistics of each bucket path individually, with key
+        and value size histograms and whether the bucket is inline.
+
+    -depth N
+        Reports nested buckets down to N levels with -per-bucket. Top-level
+        buckets are level 1. Use 0 for all levels. Defaults to 1.
+
+    -json
+        Prints the aggregate statistics, size histograms and, with
+        -per-bucket, the bucket statistics as JSON.
+
+    -key-format FORMAT
+        Encoding of bucket names in paths: utf8, hex or base64.
+        Defaults to utf8.
+
 The following errors can be reported:
 
     already freed
@@ -879,6 +1099,93 @@ experience corruption, please submit a ticket to the Bolt project page:
 `, "\n")
 }
 
+// statsReport is the output of "bolt stats -json".
+type statsReport struct {
+	Aggregate statsAggregate `json:"aggregate"`
+	Buckets   []statsBucket  `json:"buckets,omitempty"`
+}
+
+// statsAggregate is the statistics of all matching top-level buckets.
+type statsAggregate struct {
+	BucketCount   int              `json:"bucket_count"`
+	Stats         bolt.BucketStats `json:"stats"`
+	InlineBucketN int              `json:"inline_buckets"`
+	PagedBucketN  int              `json:"paged_buckets"`
+	KeySizes      statsHistogram   `json:"key_sizes"`
+	ValueSizes    statsHistogram   `json:"value_sizes"`
+}
+
+// statsBucket is the statistics of a bucket including its nested buckets.
+// Depth is 1 for top-level buckets.
+type statsBucket struct {
+	Path          string           `json:"path"`
+	Depth         int              `json:"depth"`
+	Inline        bool             `json:"inline"`
+	Stats         bolt.BucketStats `json:"stats"`
+	InlineBucketN int              `json:"inline_buckets"`
+	PagedBucketN  int              `json:"paged_buckets"`
+	KeySizes      statsHistogram   `json:"key_sizes"`
+	ValueSizes    statsHistogram   `json:"value_sizes"`
+}
+
+// statsHistogram counts sizes in power of two bins. Only non-empty bins are
+// listed.
+type statsHistogram struct {
+	Count int        `json:"count"`
+	Total int64      `json:"total"`
+	Min   int        `json:"min"`
+	Max   int        `json:"max"`
+	Bins  []statsBin `json:"bins"`
+}
+
+// statsBin counts the sizes from Min to Max bytes inclusive.
+type statsBin struct {
+	Min   int `json:"min"`
+	Max   int `json:"max"`
+	Count int `json:"count"`
+}
+
+// add counts a size. Size 0 has its own bin, other sizes are binned by
+// their highest bit.
+func (h *statsHistogram) add(size int) {
+	if h.Count == 0 || size < h.Min {
+		h.Min = size
+	}
+	if size > h.Max {
+		h.Max = size
+	}
+	h.Count++
+	h.Total += int64(size)
+
+	lo, hi := 0, 0
+	if size > 0 {
+		lo = 1 << uint(bits.Len(uint(size))-1)
+		hi = lo*2 - 1
+	}
+	i := sort.Search(len(h.Bins), func(i int) bool { return h.Bins[i].Min >= lo })
+	if i == len(h.Bins) || h.Bins[i].Min != lo {
+		h.Bins = append(h.Bins, statsBin{})
+		copy(h.Bins[i+1:], h.Bins[i:])
+		h.Bins[i] = statsBin{Min: lo, Max: hi}
+	}
+	h.Bins[i].Count++
+}
+
+// addBucket counts the key or value sizes of b and its nested buckets.
+// Nested bucket entries are not counted.
+func (h *statsHistogram) addBucket(b *bolt.Bucket, keys bool) {
+	_ = b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			h.addBucket(b.Bucket(k), keys)
+		} else if keys {
+			h.add(len(k))
+		} else {
+			h.add(len(v))
+		}
+		return nil
+	})
+}
+
 var benchBucketName = []byte("bench")
 
 // BenchCommand represents the "bench" command execution.
@@ -886,6 +1193,11 @@ type BenchCommand struct {
 	Stdin  io.Reader
 	Stdout io.Writer
 	Stderr io.Writer
+
+	// Keys written to the bench bucket, used by random reads and the
+	// mixed workload.
+	mu   sync.Mutex
+	keys [][]byte
 }
 
 // NewBenchCommand returns a BenchCommand using the
@@ -931,10 +1243,44 @@ func (cmd *BenchCommand) Run(args ...string) error {
 		return fmt.Errorf("bench: read: %s", err)
 	}
 
+	// Run reads and writes together.
+	if options.Mix >= 0 {
+		if err := cmd.runMixed(db, options, &results); err != nil {
+			return fmt.Errorf("bench: mixed: %s", err)
+		}
+	}
+
 	// Print results.
-	fmt.Fprintf(os.Stderr, "# Write\t%v\t(%v/op)\t(%v op/sec)\n", results.WriteDuration, results.WriteOpDuration(), results.WriteOpsPerSecond())
-	fmt.Fprintf(os.Stderr, "# Read\t%v\t(%v/op)\t(%v op/sec)\n", results.ReadDuration, results.ReadOpDuration(), results.ReadOpsPerSecond())
-	fmt.Fprintln(os.Stderr, "")
+	report := results.report(options)
+	if options.JSON {
+		b, err := json.MarshalIndent(report, "", "\t")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(cmd.Stdout, string(b))
+	} else {
+		fmt.Fprintf(cmd.Stderr, "# Write\t%v\t(%v/op)\t(%v op/sec)\n", results.WriteDuration, results.WriteOpDuration(), results.WriteOpsPerSecond())
+		fmt.Fprintf(cmd.Stderr, "# Read\t%v\t(%v/op)\t(%v op/sec)\n", results.ReadDuration, results.ReadOpDuration(), results.ReadOpsPerSecond())
+		if options.Mix >= 0 {
+			fmt.Fprintf(cmd.Stderr, "# Mixed\t%v\t(%v/op)\t(%v op/sec)\t(%d reads, %d writes)\n", results.MixedDuration, results.MixedOpDuration(), results.MixedOpsPerSecond(), results.MixedReads, results.MixedOps-results.MixedReads)
+		}
+		for _, phase := range report.Phases {
+			if l := phase.Latency; l != nil {
+				fmt.Fprintf(cmd.Stderr, "# %s latency\tp50 %v\tp90 %v\tp99 %v\tp99.9 %v\tmax %v\n", strings.ToUpper(phase.Name[:1])+phase.Name[1:],
+					time.Duration(l.P50), time.Duration(l.P90), time.Duration(l.P99), time.Duration(l.P999), time.Duration(l.Max))
+			}
+		}
+		fmt.Fprintln(cmd.Stderr, "")
+	}
+
+	// Compare against a previous run.
+	if options.Compare != "" {
+		baseline, err := readBenchReport(options.Compare)
+		if err != nil {
+			return fmt.Errorf("bench: compare: %s", err)
+		}
+		cmd.printComparison(report, baseline)
+	}
 	return nil
 }
 
@@ -958,11 +1304,31 @@ func (cmd *BenchCommand) ParseFlags(args []string) (*BenchOptions, error) {
 	fs.BoolVar(&options.NoSync, "no-sync", false, "")
 	fs.BoolVar(&options.Work, "work", false, "")
 	fs.StringVar(&options.Path, "path", "", "")
+	fs.IntVar(&options.Readers, "readers", 1, "")
+	fs.IntVar(&options.Writers, "writers", 1, "")
+	fs.BoolVar(&options.UseBatch, "use-batch", false, "")
+	fs.IntVar(&options.Mix, "mix", -1, "")
+	fs.Float64Var(&options.ZipfS, "zipf-s", 1.1, "")
+	fs.BoolVar(&options.JSON, "json", false, "")
+	fs.StringVar(&options.Compare, "compare", "", "")
 	fs.SetOutput(cmd.Stderr)
 	if err := fs.Parse(args); err != nil {
 		return nil, err
 	}
 
+	// Validate the workload.
+	nested := options.WriteMode == "seq-nest" || options.WriteMode == "rnd-nest"
+	switch {
+	case options.Readers < 1 || options.Writers < 1:
+		return nil, errors.New("readers and writers must be at least 1")
+	case options.Mix < -1 || options.Mix > 100:
+		return nil, errors.New("mix must be a percentage between 0 and 100")
+	case options.ReadMode == "zipf" && options.ZipfS <= 1:
+		return nil, errors.New("zipf-s must be greater than 1")
+	case nested && (options.ReadMode != "seq" || options.Mix >= 0):
+		return nil, fmt.Errorf("write mode %s only supports sequential reads", options.WriteMode)
+	}
+
 	// Set batch size to iteration size if not set.
 	// Require that batch size can be evenly divided by the iteration count.
 	if options.BatchSize == 0 {
@@ -1021,12 +1387,17 @@ func (cmd *BenchCommand) runWrites(db *bolt.DB, options *BenchOptions, results *
 
 func (cmd *BenchCommand) runWritesSequential(db *bolt.DB, options *BenchOptions, results *BenchResults) error {
 	var i = uint32(0)
-	return cmd.runWritesWithSource(db, options, results, func() uint32 { i++; return i })
+	return cmd.runWritesWithSource(db, options, results, func() uint32 { return atomic.AddUint32(&i, 1) })
 }
 
 func (cmd *BenchCommand) runWritesRandom(db *bolt.DB, options *BenchOptions, results *BenchResults) error {
+	var mu sync.Mutex
 	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-	return cmd.runWritesWithSource(db, options, results, func() uint32 { return r.Uint32() })
+	return cmd.runWritesWithSource(db, options, results, func() uint32 {
+		mu.Lock()
+		defer mu.Unlock()
+		return r.Uint32()
+	})
 }
 
 func (cmd *BenchCommand) runWritesSequentialNested(db *bolt.DB, options *BenchOptions, results *BenchResults) error {
@@ -1042,26 +1413,78 @@ func (cmd *BenchCommand) runWritesRandomNested(db *bolt.DB, options *BenchOption
 func (cmd *BenchCommand) runWritesWithSource(db *bolt.DB, options *BenchOptions, results *BenchResults, keySource func() uint32) error {
 	results.WriteOps = options.Iterations
 
-	for i := 0; i < options.Iterations; i += options.BatchSize {
-		if err := db.Update(func(tx *bolt.Tx) error {
-			b, _ := tx.CreateBucketIfNotExists(benchBucketName)
-			b.FillPercent = options.FillPercent
+	// Writers take batches until all of them are written.
+	batches := int64(options.Iterations / options.BatchSize)
+	var next int64
+	return cmd.runWorkers(options.Writers, &results.WriteLatency, func(_ int, latency *latencyHistogram) error {
+		var keys [][]byte
+		for atomic.AddInt64(&next, 1) <= batches {
+			t := time.Now()
+			n := len(keys)
+			if err := cmd.update(db, options, func(tx *bolt.Tx) error {
+				b, _ := tx.CreateBucketIfNotExists(benchBucketName)
+				b.FillPercent = options.FillPercent
+
+				// Forget keys of an earlier attempt if a batch is retried.
+				keys = keys[:n]
+				for j := 0; j < options.BatchSize; j++ {
+					key := make([]byte, options.KeySize)
+					value := make([]byte, options.ValueSize)
+
+					// Write key as uint32.
+					binary.BigEndian.PutUint32(key, keySource())
+
+					// Insert key/value.
+					if err := b.Put(key, value); err != nil {
+						return err
+					}
+					keys = append(keys, key)
+				}
 
-			for j := 0; j < options.BatchSize; j++ {
-				key := make([]byte, options.KeySize)
-				value := make([]byte, options.ValueSize)
+				return nil
+			}); err != nil {
+				return err
+			}
+			latency.add(time.Since(t))
+		}
 
-				// Write key as uint32.
-				binary.BigEndian.PutUint32(key, keySource())
+		cmd.mu.Lock()
+		cmd.keys = append(cmd.keys, keys...)
+		cmd.mu.Unlock()
+		return nil
+	})
+}
 
-				// Insert key/value.
-				if err := b.Put(key, value); err != nil {
-					return err
-				}
-			}
+// update executes fn in a read-write transaction, using DB.Batch if
+// "-use-batch" is set so that concurrent writers share commits.
+func (cmd *BenchCommand) update(db *bolt.DB, options *BenchOptions, fn func(*bolt.Tx) error) error {
+	if options.UseBatch {
+		return db.Batch(fn)
+	}
+	return db.Update(fn)
+}
 
-			return nil
-		}); err != nil {
+// runWorkers executes fn on n goroutines and waits for them to finish. Each
+// goroutine records latencies into its own histogram which are merged into
+// latency afterwards. Returns the first error.
+func (cmd *BenchCommand) runWorkers(n int, latency *latencyHistogram, fn func(i int, latency *latencyHistogram) error) error {
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	hists := make([]latencyHistogram, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = fn(i, &hists[i])
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range hists {
+		latency.merge(&hists[i])
+	}
+	for _, err := range errs {
+		if err != nil {
 			return err
 		}
 	}
@@ -1120,15 +1543,35 @@ func (cmd *BenchCommand) runReads(db *bolt.DB, options *BenchOptions, results *B
 
 	t := time.Now()
 
+	var mu sync.Mutex
 	var err error
 	switch options.ReadMode {
 	case "seq":
-		switch options.WriteMode {
-		case "seq-nest", "rnd-nest":
-			err = cmd.runReadsSequentialNested(db, options, results)
-		default:
-			err = cmd.runReadsSequential(db, options, results)
-		}
+		err = cmd.runWorkers(options.Readers, &results.ReadLatency, func(_ int, latency *latencyHistogram) error {
+			var r BenchResults
+			var err error
+			switch options.WriteMode {
+			case "seq-nest", "rnd-nest":
+				err = cmd.runReadsSequentialNested(db, options, &r, latency)
+			default:
+				err = cmd.runReadsSequential(db, options, &r, latency)
+			}
+
+			mu.Lock()
+			results.ReadOps += r.ReadOps
+			mu.Unlock()
+			return err
+		})
+	case "rnd", "zipf":
+		err = cmd.runWorkers(options.Readers, &results.ReadLatency, func(i int, latency *latencyHistogram) error {
+			r := rand.New(rand.NewSource(time.Now().UnixNano() + int64(i)))
+			n, err := cmd.runReadsRandom(db, options, cmd.keyPicker(options, r), latency)
+
+			mu.Lock()
+			results.ReadOps += n
+			mu.Unlock()
+			return err
+		})
 	default:
 		return fmt.Errorf("invalid read mode: %s", options.ReadMode)
 	}
@@ -1144,7 +1587,9 @@ func (cmd *BenchCommand) runReads(db *bolt.DB, options *BenchOptions, results *B
 	return err
 }
 
-func (cmd *BenchCommand) runReadsSequential(db *bolt.DB, options *BenchOptions, results *BenchResults) error {
+// runReadsSequential scans the bucket until at least a second has passed and
+// records the latency of each cursor step.
+func (cmd *BenchCommand) runReadsSequential(db *bolt.DB, options *BenchOptions, results *BenchResults, latency *latencyHistogram) error {
 	return db.View(func(tx *bolt.Tx) error {
 		t := time.Now()
 
@@ -1152,11 +1597,14 @@ func (cmd *BenchCommand) runReadsSequential(db *bolt.DB, options *BenchOptions,
 			var count int
 
 			c := tx.Bucket(benchBucketName).Cursor()
+			start := time.Now()
 			for k, v := c.First(); k != nil; k, v = c.Next() {
+				latency.add(time.Since(start))
 				if v == nil {
 					return errors.New("invalid value")
 				}
 				count++
+				start = time.Now()
 			}
 
 			if options.WriteMode == "seq" && count != options.Iterations {
@@ -1175,7 +1623,9 @@ func (cmd *BenchCommand) runReadsSequential(db *bolt.DB, options *BenchOptions,
 	})
 }
 
-func (cmd *BenchCommand) runReadsSequentialNested(db *bolt.DB, options *BenchOptions, results *BenchResults) error {
+// runReadsSequentialNested scans every nested bucket until at least a second
+// has passed and records the latency of each cursor step.
+func (cmd *BenchCommand) runReadsSequentialNested(db *bolt.DB, options *BenchOptions, results *BenchResults, latency *latencyHistogram) error {
 	return db.View(func(tx *bolt.Tx) error {
 		t := time.Now()
 
@@ -1184,11 +1634,14 @@ func (cmd *BenchCommand) runReadsSequentialNested(db *bolt.DB, options *BenchOpt
 			var top = tx.Bucket(benchBucketName)
 			if err := top.ForEach(func(name, _ []byte) error {
 				c := top.Bucket(name).Cursor()
+				start := time.Now()
 				for k, v := c.First(); k != nil; k, v = c.Next() {
+					latency.add(time.Since(start))
 					if v == nil {
 						return ErrInvalidValue
 					}
 					count++
+					start = time.Now()
 				}
 				return nil
 			}); err != nil {
@@ -1211,6 +1664,128 @@ func (cmd *BenchCommand) runReadsSequentialNested(db *bolt.DB, options *BenchOpt
 	})
 }
 
+// runReadsRandom looks up keys chosen by pick until at least a second has
+// passed and returns the number of lookups.
+func (cmd *BenchCommand) runReadsRandom(db *bolt.DB, options *BenchOptions, pick func() []byte, latency *latencyHistogram) (int, error) {
+	var n int
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(benchBucketName)
+		t := time.Now()
+
+		for {
+			for i := 0; i < options.Iterations; i++ {
+				start := time.Now()
+				v := b.Get(pick())
+				latency.add(time.Since(start))
+				if v == nil {
+					return ErrInvalidValue
+				}
+			}
+			n += options.Iterations
+
+			// Make sure we do this for at least a second.
+			if time.Since(t) >= time.Second {
+				break
+			}
+		}
+
+		return nil
+	})
+	return n, err
+}
+
+// keyPicker returns a function choosing written keys using r. Keys follow a
+// zipfian distribution in "zipf" read mode and a uniform one otherwise.
+func (cmd *BenchCommand) keyPicker(options *BenchOptions, r *rand.Rand) func() []byte {
+	keys := cmd.keys
+	if len(keys) == 0 {
+		return func() []byte { return nil }
+	}
+	if options.ReadMode == "zipf" {
+		z := rand.NewZipf(r, options.ZipfS, 1, uint64(len(keys)-1))
+		return func() []byte { return keys[z.Uint64()] }
+	}
+	return func() []byte { return keys[r.Intn(len(keys))] }
+}
+
+// runMixed executes "-count" operations, "-mix" percent of them reads of
+// written keys from "-readers" goroutines and the others writes of random
+// keys in their own transaction from "-writers" goroutines at the same time.
+func (cmd *BenchCommand) runMixed(db *bolt.DB, options *BenchOptions, results *BenchResults) error {
+	if len(cmd.keys) == 0 {
+		return errors.New("no keys written")
+	}
+
+	var nextRead, nextWrite int64
+	reads := int64(options.Iterations * options.Mix / 100)
+	writes := int64(options.Iterations) - reads
+	t := time.Now()
+	err := cmd.runWorkers(options.Readers+options.Writers, &results.MixedLatency, func(i int, latency *latencyHistogram) error {
+		r := rand.New(rand.NewSource(time.Now().UnixNano() + int64(i)))
+
+		// The first goroutines read and the others write.
+		if i < options.Readers {
+			pick := cmd.keyPicker(options, r)
+			for atomic.AddInt64(&nextRead, 1) <= reads {
+				start := time.Now()
+				if err := db.View(func(tx *bolt.Tx) error {
+					if tx.Bucket(benchBucketName).Get(pick()) == nil {
+						return ErrInvalidValue
+					}
+					return nil
+				}); err != nil {
+					return err
+				}
+				latency.add(time.Since(start))
+			}
+			return nil
+		}
+		for atomic.AddInt64(&nextWrite, 1) <= writes {
+			start := time.Now()
+			key := make([]byte, options.KeySize)
+			binary.BigEndian.PutUint32(key, r.Uint32())
+			if err := cmd.update(db, options, func(tx *bolt.Tx) error {
+				return tx.Bucket(benchBucketName).Put(key, make([]byte, options.ValueSize))
+			}); err != nil {
+				return err
+			}
+			latency.add(time.Since(start))
+		}
+		return nil
+	})
+
+	results.MixedOps = options.Iterations
+	results.MixedReads = int(reads)
+	results.MixedDuration = time.Since(t)
+	return err
+}
+
+// printComparison writes the throughput and 99th percentile latency of each
+// phase next to the ones of a baseline report.
+func (cmd *BenchCommand) printComparison(report, baseline *benchReport) {
+	for _, phase := range report.Phases {
+		base := baseline.phase(phase.Name)
+		if base == nil {
+			fmt.Fprintf(cmd.Stderr, "# Compare %s\tnot in baseline\n", phase.Name)
+			continue
+		}
+		line := fmt.Sprintf("# Compare %s\t%d op/sec vs %d (%s)", phase.Name, phase.OpsPerSec, base.OpsPerSec, percentChange(float64(phase.OpsPerSec), float64(base.OpsPerSec)))
+		if phase.Latency != nil && base.Latency != nil {
+			line += fmt.Sprintf("\tp99 %v vs %v (%s)", time.Duration(phase.Latency.P99), time.Duration(base.Latency.P99), percentChange(float64(phase.Latency.P99), float64(base.Latency.P99)))
+		}
+		fmt.Fprintln(cmd.Stderr, line)
+	}
+	fmt.Fprintln(cmd.Stderr, "")
+}
+
+// percentChange formats the relative change from base to v.
+func percentChange(v, base float64) string {
+	if base == 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("%+.1f%%", (v-base)/base*100)
+}
+
 // File handlers for the various profiles.
 var cpuprofile, memprofile, blockprofile *os.File
 
@@ -1288,6 +1863,13 @@ type BenchOptions struct {
 	NoSync        bool
 	Work          bool
 	Path          string
+	Readers       int
+	Writers       int
+	UseBatch      bool
+	Mix           int
+	ZipfS         float64
+	JSON          bool
+	Compare       string
 }
 
 // BenchResults represents the performance results of the benchmark.
@@ -1296,6 +1878,14 @@ type BenchResults struct {
 	WriteDuration time.Duration
 	ReadOps       int
 	ReadDuration  time.Duration
+	MixedOps      int
+	MixedReads    int
+	MixedDuration time.Duration
+
+	// Latencies of write transactions, point reads and mixed operations.
+	WriteLatency latencyHistogram
+	ReadLatency  latencyHistogram
+	MixedLatency latencyHistogram
 }
 
 // Returns the duration for a single write operation.
@@ -1332,6 +1922,173 @@ func (r *BenchResults) ReadOpsPerSecond() int {
 	return int(time.Second) / int(op)
 }
 
+// Returns the duration for a single mixed operation.
+func (r *BenchResults) MixedOpDuration() time.Duration {
+	if r.MixedOps == 0 {
+		return 0
+	}
+	return r.MixedDuration / time.Duration(r.MixedOps)
+}
+
+// Returns average number of mixed operations that can be performed per second.
+func (r *BenchResults) MixedOpsPerSecond() int {
+	var op = r.MixedOpDuration()
+	if op == 0 {
+		return 0
+	}
+	return int(time.Second) / int(op)
+}
+
+// report returns the results in the form written by "-json".
+func (r *BenchResults) report(options *BenchOptions) *benchReport {
+	report := &benchReport{Options: options}
+	report.Phases = append(report.Phases,
+		newBenchPhase("write", r.WriteOps, r.WriteDuration, r.WriteOpsPerSecond(), &r.WriteLatency),
+		newBenchPhase("read", r.ReadOps, r.ReadDuration, r.ReadOpsPerSecond(), &r.ReadLatency),
+	)
+	if r.MixedOps > 0 {
+		report.Phases = append(report.Phases, newBenchPhase("mixed", r.MixedOps, r.MixedDuration, r.MixedOpsPerSecond(), &r.MixedLatency))
+	}
+	return report
+}
+
+// benchReport is the JSON form of a benchmark run.
+type benchReport struct {
+	Options *BenchOptions `json:"options"`
+	Phases  []benchPhase  `json:"phases"`
+}
+
+// phase returns the phase with the given name or nil.
+func (r *benchReport) phase(name string) *benchPhase {
+	for i := range r.Phases {
+		if r.Phases[i].Name == name {
+			return &r.Phases[i]
+		}
+	}
+	return nil
+}
+
+// readBenchReport reads a report written by "bolt bench -json".
+func readBenchReport(path string) (*benchReport, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var r benchReport
+	if err := json.Unmarshal(buf, &r); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return &r, nil
+}
+
+// benchPhase holds the results of the write, read or mixed phase.
+type benchPhase struct {
+	Name       string        `json:"name"`
+	Ops        int           `json:"ops"`
+	DurationNs int64         `json:"duration_ns"`
+	OpsPerSec  int           `json:"ops_per_sec"`
+	Latency    *benchLatency `json:"latency,omitempty"`
+}
+
+// benchLatency holds latency percentiles in nanoseconds.
+type benchLatency struct {
+	Count uint64 `json:"count"`
+	P50   int64  `json:"p50_ns"`
+	P90   int64  `json:"p90_ns"`
+	P99   int64  `json:"p99_ns"`
+	P999  int64  `json:"p999_ns"`
+	Max   int64  `json:"max_ns"`
+}
+
+// newBenchPhase returns a phase. Latencies are omitted if none were recorded.
+func newBenchPhase(name string, ops int, d time.Duration, opsPerSec int, h *latencyHistogram) benchPhase {
+	phase := benchPhase{Name: name, Ops: ops, DurationNs: int64(d), OpsPerSec: opsPerSec}
+	if h.n > 0 {
+		phase.Latency = &benchLatency{
+			Count: h.n,
+			P50:   int64(h.percentile(0.50)),
+			P90:   int64(h.percentile(0.90)),
+			P99:   int64(h.percentile(0.99)),
+			P999:  int64(h.percentile(0.999)),
+			Max:   int64(h.max),
+		}
+	}
+	return phase
+}
+
+// latencyHistogram counts durations in logarithmic bins, eight per power of
+// two, so that percentiles are within about 12% of the recorded values
+// without keeping every sample.
+type latencyHistogram struct {
+	counts [512]uint64
+	n      uint64
+	max    time.Duration
+}
+
+// latencyBin returns the bin of a duration. Durations below 8ns have their
+// own bins.
+func latencyBin(d time.Duration) int {
+	if d < 0 {
+		return 0
+	} else if d < 8 {
+		return int(d)
+	}
+	v := uint64(d)
+	e := uint(bits.Len64(v) - 1)
+	return int(e-2)*8 + int((v>>(e-3))&7)
+}
+
+// latencyBinMax returns the largest duration in a bin.
+func latencyBinMax(i int) time.Duration {
+	if i < 8 {
+		return time.Duration(i)
+	}
+	e, m := uint(i/8+2), uint64(i%8)
+	return time.Duration((8+m)<<(e-3) + 1<<(e-3) - 1)
+}
+
+// add records a duration.
+func (h *latencyHistogram) add(d time.Duration) {
+	h.counts[latencyBin(d)]++
+	h.n++
+	if d > h.max {
+		h.max = d
+	}
+}
+
+// merge adds the durations recorded by other.
+func (h *latencyHistogram) merge(other *latencyHistogram) {
+	for i, c := range other.counts {
+		h.counts[i] += c
+	}
+	h.n += other.n
+	if other.max > h.max {
+		h.max = other.max
+	}
+}
+
+// percentile returns the duration below which a fraction p of the recorded
+// durations fall, rounded up to the end of its bin.
+func (h *latencyHistogram) percentile(p float64) time.Duration {
+	if h.n == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(p * float64(h.n)))
+	if rank == 0 {
+		rank = 1
+	}
+	var sum uint64
+	for i, c := range h.counts {
+		if sum += c; sum >= rank {
+			if d := latencyBinMax(i); d < h.max {
+				return d
+			}
+			break
+		}
+	}
+	return h.max
+}
+
 type PageError struct {
 	ID  int
 	Err error
@@ -1437,8 +2194,22 @@ const (
 	leafPageFlag     = 0x02		//2,叶子节点页
 	metaPageFlag     = 0x04		//4,元数据页
 	freelistPageFlag = 0x10		//16,空闲列表页
+	countedPageFlag  = 0x20		//32, branch page that carries subtree key counts
+)
+
+// DO NOT EDIT. Copied from the "bolt" package.
+const (
+	branchPageElementSize = int(unsafe.Sizeof(branchPageElement{}))
+	branchPageCountSize   = int(unsafe.Sizeof(uint64(0)))
+	leafPageElementSize   = int(unsafe.Sizeof(leafPageElement{}))
 )
 
+// DO NOT EDIT. Copied from the "bolt" package.
+const version = 2
+
+// DO NOT EDIT. Copied from the "bolt" package.
+const magic uint32 = 0xED0CDAED
+
 // DO NOT EDIT. Copied from the "bolt" package.
 const bucketLeafFlag = 0x01
 
@@ -1462,6 +2233,13 @@ type meta struct {
 	checksum uint64		//用于确认 meta page 数据本身的完整性，保证读取的就是上一次正确写入的数据
 }
 
+// DO NOT EDIT. Copied from the "bolt" package.
+func (m *meta) sum64() uint64 {
+	var h = fnv.New64a()
+	_, _ = h.Write((*[unsafe.Offsetof(meta{}.checksum)]byte)(unsafe.Pointer(m))[:])
+	return h.Sum64()
+}
+
 // DO NOT EDIT. Copied from the "bolt" package.
 // Bucket Header
 type bucket struct {
@@ -1508,6 +2286,45 @@ func (p *page) branchPageElement(index uint16) *branchPageElement {
 	return &((*[0x7FFFFFF]branchPageElement)(unsafe.Pointer(&p.ptr)))[index]
 }
 
+// DO NOT EDIT. Copied from the "bolt" package.
+func (p *page) branchPageCounts() []uint64 {
+	if p.count == 0 {
+		return nil
+	}
+	ptr := unsafe.Pointer(uintptr(unsafe.Pointer(&p.ptr)) + uintptr(p.count)*uintptr(branchPageElementSize))
+	return ((*[0x7FFFFFF]uint64)(ptr))[:p.count:p.count]
+}
+
+// DO NOT EDIT. Copied from the "bolt" package.
+func (p *page) layoutError(size int) string {
+	elemSize := branchPageElementSize
+	if p.flags&leafPageFlag != 0 {
+		elemSize = leafPageElementSize
+	} else if p.flags&countedPageFlag != 0 {
+		elemSize += branchPageCountSize
+	}
+	if PageHeaderSize+int(p.count)*elemSize > size {
+		return fmt.Sprintf("%d elements past end of page", p.count)
+	}
+
+	for i := 0; i < int(p.count); i++ {
+		var off, n uint64
+		if p.flags&leafPageFlag != 0 {
+			elem := p.leafPageElement(uint16(i))
+			off = uint64(PageHeaderSize + i*leafPageElementSize)
+			n = uint64(elem.pos) + uint64(elem.ksize) + uint64(elem.vsize)
+		} else {
+			elem := p.branchPageElement(uint16(i))
+			off = uint64(PageHeaderSize + i*branchPageElementSize)
+			n = uint64(elem.pos) + uint64(elem.ksize)
+		}
+		if off+n > uint64(size) {
+			return fmt.Sprintf("element %d past end of page", i)
+		}
+	}
+	return ""
+}
+
 // DO NOT EDIT. Copied from the "bolt" package.
 // 树分支
 type branchPageElement struct {
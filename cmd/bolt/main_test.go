@@ -4,12 +4,14 @@ import (
 	"bytes"
 	crypto "crypto/rand"
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"math/rand"
 	"os"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/boltdb/bolt"
@@ -29,6 +31,94 @@ func TestInfoCommand_Run(t *testing.T) {
 	}
 }
 
+// Ensure the "check" command reports a valid database.
+func TestCheckCommand_Run(t *testing.T) {
+	db := MustOpen(0666, nil)
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.DB.Close()
+	defer db.Close()
+
+	m := NewMain()
+	if err := m.Run("check", "-parallel", "2", db.Path); err != nil {
+		t.Fatal(err)
+	} else if m.Stdout.String() != "OK\n" {
+		t.Fatalf("unexpected output: %s", m.Stdout.String())
+	}
+}
+
+// Ensure the "check" command prints a JSON report of the errors found.
+func TestCheckCommand_Run_JSON(t *testing.T) {
+	db := MustOpen(0666, nil)
+	var root int
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.View(func(tx *bolt.Tx) error {
+		root = int(tx.Bucket([]byte("widgets")).Root())
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	pageSize := db.Info().PageSize
+	db.DB.Close()
+	defer db.Close()
+
+	// Clear the flags of the bucket's root page.
+	f, err := os.OpenFile(db.Path, os.O_RDWR, 0666)
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := f.WriteAt([]byte{0, 0}, int64(root*pageSize+8)); err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewMain()
+	if err := m.Run("check", "-json", db.Path); err != main.ErrCorrupt {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var report struct {
+		OK     bool `json:"ok"`
+		Errors []struct {
+			Kind   string   `json:"kind"`
+			PageID int      `json:"page"`
+			Bucket [][]byte `json:"bucket"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(m.Stdout.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	} else if report.OK || len(report.Errors) == 0 {
+		t.Fatalf("unexpected report: %s", m.Stdout.String())
+	}
+	for i, e := range report.Errors {
+		if i > 0 && e.PageID < report.Errors[i-1].PageID {
+			t.Fatalf("errors not sorted: %+v", report.Errors)
+		} else if e.Kind == bolt.CheckPageHeader {
+			if e.PageID != root || len(e.Bucket) != 1 || string(e.Bucket[0]) != "widgets" {
+				t.Fatalf("unexpected error: %+v", e)
+			}
+			return
+		}
+	}
+	t.Fatalf("page header error not found: %s", m.Stdout.String())
+}
+
 // Ensure the "stats" command executes correctly with an empty database.
 func TestStatsCommand_Run_EmptyDatabase(t *testing.T) {
 	// Ignore
@@ -146,6 +236,199 @@ func TestStatsCommand_Run(t *testing.T) {
 	}
 }
 
+// Ensure the "stats" command reports each bucket path as JSON.
+func TestStatsCommand_Run_PerBucketJSON(t *testing.T) {
+	db := MustOpen(0666, nil)
+	defer db.Close()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("foo"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, i%100)); err != nil {
+				return err
+			}
+		}
+		sub, err := b.CreateBucket([]byte("sub"))
+		if err != nil {
+			return err
+		} else if err := sub.Put([]byte("k"), []byte("value")); err != nil {
+			return err
+		} else if _, err := sub.CreateBucket([]byte("deep")); err != nil {
+			return err
+		}
+		_, err = tx.CreateBucket([]byte("bar"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.DB.Close()
+
+	run := func(args ...string) map[string]interface{} {
+		m := NewMain()
+		if err := m.Run(append([]string{"stats"}, args...)...); err != nil {
+			t.Fatal(err)
+		}
+		var report map[string]interface{}
+		if err := json.Unmarshal(m.Stdout.Bytes(), &report); err != nil {
+			t.Fatal(err)
+		}
+		return report
+	}
+	paths := func(report map[string]interface{}) (a []string) {
+		buckets, _ := report["buckets"].([]interface{})
+		for _, b := range buckets {
+			b := b.(map[string]interface{})
+			a = append(a, fmt.Sprintf("%s:%v", b["path"], b["inline"]))
+		}
+		return a
+	}
+
+	// Without -per-bucket only the aggregate is reported.
+	report := run("-json", db.Path)
+	agg := report["aggregate"].(map[string]interface{})
+	if agg["bucket_count"] != 2.0 || report["buckets"] != nil {
+		t.Fatalf("unexpected report: %v", report)
+	} else if keys := agg["key_sizes"].(map[string]interface{}); keys["count"] != 1001.0 || keys["min"] != 1.0 || keys["max"] != 4.0 {
+		t.Fatalf("unexpected key sizes: %v", keys)
+	}
+
+	// Nested buckets are reported down to the given depth.
+	if exp, got := "[bar:true foo:false]", fmt.Sprint(paths(run("-json", "-per-bucket", db.Path))); got != exp {
+		t.Fatalf("unexpected buckets: %s", got)
+	}
+	report = run("-json", "-per-bucket", "-depth", "0", db.Path, "f")
+	if exp, got := "[foo:false foo/sub:false foo/sub/deep:true]", fmt.Sprint(paths(report)); got != exp {
+		t.Fatalf("unexpected buckets: %s", got)
+	}
+	// Values of 0 to 99 bytes fall in 8 bins.
+	foo := report["buckets"].([]interface{})[0].(map[string]interface{})
+	values := foo["value_sizes"].(map[string]interface{})
+	if bins := values["bins"].([]interface{}); len(bins) != 8 || values["count"] != 1001.0 {
+		t.Fatalf("unexpected value sizes: %v", values)
+	} else if bin := bins[3].(map[string]interface{}); bin["min"] != 4.0 || bin["max"] != 7.0 || bin["count"] != 41.0 {
+		t.Fatalf("unexpected bin: %v", bin)
+	} else if foo["inline_buckets"] != 1.0 || foo["paged_buckets"] != 2.0 {
+		t.Fatalf("unexpected bucket counts: %v", foo)
+	}
+
+	// Text output has a section per bucket.
+	m := NewMain()
+	if err := m.Run("stats", "-per-bucket", "-depth", "2", db.Path); err != nil {
+		t.Fatal(err)
+	} else if out := m.Stdout.String(); !strings.Contains(out, "Statistics for bucket foo/sub (paged)") || strings.Contains(out, "deep") {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+}
+
+// Ensure the "bench" command can run concurrent random reads and a mixed
+// workload and report latencies as JSON.
+func TestBenchCommand_Run_JSON(t *testing.T) {
+	m := NewMain()
+	if err := m.Run("bench", "-count", "200", "-batch-size", "20", "-no-sync",
+		"-writers", "2", "-readers", "2", "-read-mode", "zipf", "-mix", "50", "-json"); err != nil {
+		t.Fatal(err)
+	}
+
+	var report struct {
+		Phases []struct {
+			Name      string `json:"name"`
+			Ops       int    `json:"ops"`
+			OpsPerSec int    `json:"ops_per_sec"`
+			Latency   *struct {
+				Count uint64 `json:"count"`
+				P50   int64  `json:"p50_ns"`
+				P99   int64  `json:"p99_ns"`
+				Max   int64  `json:"max_ns"`
+			} `json:"latency"`
+		} `json:"phases"`
+	}
+	if err := json.Unmarshal(m.Stdout.Bytes(), &report); err != nil {
+		t.Fatalf("unmarshal: %s: %s", err, m.Stdout.String())
+	}
+	if len(report.Phases) != 3 {
+		t.Fatalf("unexpected phases: %+v", report.Phases)
+	}
+	for i, name := range []string{"write", "read", "mixed"} {
+		p := report.Phases[i]
+		if p.Name != name || p.Ops < 200 || p.OpsPerSec == 0 {
+			t.Fatalf("unexpected %s phase: %+v", name, p)
+		} else if p.Latency == nil || p.Latency.Count == 0 {
+			t.Fatalf("expected %s latencies", name)
+		} else if p.Latency.P50 > p.Latency.P99 || p.Latency.P99 > p.Latency.Max {
+			t.Fatalf("unordered %s latencies: %+v", name, *p.Latency)
+		}
+	}
+	if n := report.Phases[0].Latency.Count; n != 10 {
+		t.Fatalf("unexpected write transactions: %d", n)
+	} else if n := report.Phases[2].Latency.Count; n != 200 {
+		t.Fatalf("unexpected mixed operations: %d", n)
+	}
+}
+
+// Ensure the "bench" command records the latency of every key read by a
+// sequential scan.
+func TestBenchCommand_Run_SequentialReads(t *testing.T) {
+	m := NewMain()
+	if err := m.Run("bench", "-count", "100", "-batch-size", "10", "-no-sync", "-readers", "2", "-json"); err != nil {
+		t.Fatal(err)
+	}
+
+	var report struct {
+		Phases []struct {
+			Name    string `json:"name"`
+			Ops     int    `json:"ops"`
+			Latency *struct {
+				Count int `json:"count"`
+			} `json:"latency"`
+		} `json:"phases"`
+	}
+	if err := json.Unmarshal(m.Stdout.Bytes(), &report); err != nil {
+		t.Fatalf("unmarshal: %s: %s", err, m.Stdout.String())
+	}
+	if len(report.Phases) != 2 {
+		t.Fatalf("unexpected phases: %+v", report.Phases)
+	} else if p := report.Phases[1]; p.Name != "read" || p.Latency == nil || p.Latency.Count != p.Ops {
+		t.Fatalf("unexpected read phase: %+v", p)
+	}
+}
+
+// Ensure the "bench" command can compare a run against a saved report.
+func TestBenchCommand_Run_Compare(t *testing.T) {
+	f, err := ioutil.TempFile("", "bolt-bench-baseline-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(`{"phases":[{"name":"write","ops":100,"ops_per_sec":1000}]}`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	m := NewMain()
+	if err := m.Run("bench", "-count", "100", "-no-sync", "-read-mode", "rnd", "-compare", f.Name()); err != nil {
+		t.Fatal(err)
+	}
+	out := m.Stderr.String()
+	if !strings.Contains(out, "# Read latency\tp50 ") {
+		t.Fatalf("expected read latencies: %s", out)
+	} else if !strings.Contains(out, "# Compare write\t") || !strings.Contains(out, " vs 1000 (") {
+		t.Fatalf("expected write comparison: %s", out)
+	} else if !strings.Contains(out, "# Compare read\tnot in baseline") {
+		t.Fatalf("expected missing read phase: %s", out)
+	}
+}
+
+// Ensure the "bench" command rejects point reads of nested buckets.
+func TestBenchCommand_Run_ErrNestedRandomReads(t *testing.T) {
+	m := NewMain()
+	if err := m.Run("bench", "-write-mode", "seq-nest", "-read-mode", "rnd"); err == nil || !strings.Contains(err.Error(), "only supports sequential reads") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
 // Main represents a test wrapper for main.Main that records output.
 type Main struct {
 	*main.Main
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"unsafe"
+
+	"github.com/boltdb/bolt"
+)
+
+// PagemapCommand represents the "pagemap" command execution.
+type PagemapCommand struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	Path    string
+	OutPath string
+	Format  string
+	By      string
+	Width   int
+	Height  int
+}
+
+// newPagemapCommand returns a PagemapCommand.
+func newPagemapCommand(m *Main) *PagemapCommand {
+	return &PagemapCommand{
+		Stdin:  m.Stdin,
+		Stdout: m.Stdout,
+		Stderr: m.Stderr,
+	}
+}
+
+// Run executes the command.
+func (cmd *PagemapCommand) Run(args ...string) error {
+	// Parse flags.
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.StringVar(&cmd.OutPath, "o", "", "")
+	fs.StringVar(&cmd.Format, "format", "ascii", "")
+	fs.StringVar(&cmd.By, "by", "type", "")
+	fs.IntVar(&cmd.Width, "width", 64, "")
+	fs.IntVar(&cmd.Height, "height", 32, "")
+	if err := fs.Parse(args); err == flag.ErrHelp {
+		fmt.Fprintln(cmd.Stderr, cmd.Usage())
+		return ErrUsage
+	} else if err != nil {
+		return err
+	} else if cmd.Format != "ascii" && cmd.Format != "html" && cmd.Format != "svg" {
+		return fmt.Errorf("unknown format %q", cmd.Format)
+	} else if cmd.By != "type" && cmd.By != "bucket" {
+		return fmt.Errorf("unknown grouping %q", cmd.By)
+	} else if cmd.Width <= 0 || cmd.Height <= 0 {
+		return fmt.Errorf("width and height must be positive")
+	}
+
+	// Require database path.
+	path, err := requirePath(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	cmd.Path = path
+
+	pm, err := readPagemap(cmd.Path)
+	if err != nil {
+		return err
+	}
+	for _, w := range pm.warnings {
+		fmt.Fprintln(cmd.Stderr, "warning:", w)
+	}
+
+	// Write to a file if one is given. Otherwise write to stdout.
+	out := cmd.Stdout
+	var file *os.File
+	if cmd.OutPath != "" && cmd.OutPath != "-" {
+		f, err := os.Create(cmd.OutPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out, file = f, f
+	}
+	w := bufio.NewWriter(out)
+
+	cells := pm.cells(cmd.Width*cmd.Height, cmd.By == "bucket")
+	switch cmd.Format {
+	case "ascii":
+		cmd.writeASCII(w, pm, cells)
+	case "svg":
+		cmd.writeSVG(w, pm, cells)
+	case "html":
+		cmd.writeHTML(w, pm, cells)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	} else if file != nil {
+		return file.Close()
+	}
+	return nil
+}
+
+// writeASCII writes one character per cell and the summary.
+func (cmd *PagemapCommand) writeASCII(w io.Writer, pm *pagemap, cells []pagemapCell) {
+	fmt.Fprintf(w, "Page map of %s: %d pages of %d bytes, %d pages per cell\n\n", cmd.Path, len(pm.pages), pm.pageSize, pm.perCell)
+	for i := 0; i < len(cells); i += cmd.Width {
+		row := cells[i:]
+		if len(row) > cmd.Width {
+			row = row[:cmd.Width]
+		}
+		var line strings.Builder
+		for _, c := range row {
+			line.WriteByte(pm.symbol(c.key))
+		}
+		fmt.Fprintf(w, "%10d %s\n", i*pm.perCell, line.String())
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Legend:")
+	for _, key := range pm.legend(cells) {
+		fmt.Fprintf(w, "\t%c %s\n", pm.symbol(key), pm.label(key))
+	}
+	fmt.Fprintln(w)
+	pm.writeSummary(w)
+}
+
+// writeSVG writes a standalone SVG image with one rectangle per cell.
+func (cmd *PagemapCommand) writeSVG(w io.Writer, pm *pagemap, cells []pagemapCell) {
+	const size = 8
+	rows := (len(cells) + cmd.Width - 1) / cmd.Width
+	fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d">`+"\n", cmd.Width*size, rows*size)
+	for i, c := range cells {
+		first := i * pm.perCell
+		last := first + c.n - 1
+		fmt.Fprintf(w, `<rect x="%d" y="%d" width="%d" height="%d" fill="%s"><title>pages %d-%d: %s</title></rect>`+"\n",
+			(i%cmd.Width)*size, (i/cmd.Width)*size, size, size, pm.color(c.key), first, last, html.EscapeString(pm.label(c.key)))
+	}
+	fmt.Fprintln(w, "</svg>")
+}
+
+// writeHTML writes a self-contained HTML page with the SVG map, a legend
+// and the summary.
+func (cmd *PagemapCommand) writeHTML(w io.Writer, pm *pagemap, cells []pagemapCell) {
+	title := html.EscapeString("Page map of " + cmd.Path)
+	fmt.Fprintf(w, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n", title)
+	fmt.Fprintln(w, "<style>body{font-family:sans-serif}svg{border:1px solid #ccc}.key{display:inline-block;width:12px;height:12px;margin-right:6px;border:1px solid #999}</style>")
+	fmt.Fprintf(w, "</head>\n<body>\n<h1>%s</h1>\n", title)
+	fmt.Fprintf(w, "<p>%d pages of %d bytes, %d pages per cell.</p>\n", len(pm.pages), pm.pageSize, pm.perCell)
+	cmd.writeSVG(w, pm, cells)
+	fmt.Fprintln(w, "<ul>")
+	for _, key := range pm.legend(cells) {
+		fmt.Fprintf(w, "<li><span class=\"key\" style=\"background:%s\"></span>%s</li>\n", pm.color(key), html.EscapeString(pm.label(key)))
+	}
+	fmt.Fprintln(w, "</ul>")
+	var summary strings.Builder
+	pm.writeSummary(&summary)
+	fmt.Fprintf(w, "<pre>%s</pre>\n</body>\n</html>\n", html.EscapeString(summary.String()))
+}
+
+// Usage returns the help message.
+func (cmd *PagemapCommand) Usage() string {
+	return strings.TrimLeft(`
+usage: bolt pagemap [options] PATH
+
+Pagemap draws the pages of the database at PATH as a map with one cell per
+page, or per group of pages for large files, followed by a fragmentation
+summary with the free spans of the file and the size of the largest one.
+
+Each cell shows the kind of page that is most common in it: meta, freelist,
+branch, leaf, free, unreachable pages that are neither used nor free, and
+unallocated pages past the high water mark. With -by bucket, branch and leaf
+pages are grouped by the top-level bucket that owns them.
+
+The database is opened read-only so the file does not change while it is
+read.
+
+Additional options include:
+
+	-o FILE
+		Writes the map to FILE instead of stdout.
+
+	-format FORMAT
+		Format of the map: ascii, html or svg. Html is a self-contained
+		page with the svg image, a legend and the summary. Defaults to
+		ascii.
+
+	-by GROUPING
+		Colors cells by page type or by owning bucket: type or bucket.
+		Defaults to type.
+
+	-width N
+		Number of cells per row. Defaults to 64.
+
+	-height N
+		Maximum number of rows. Pages are grouped into cells so that the
+		map fits. Defaults to 32.
+`, "\n")
+}
+
+// Kinds of pages in a pagemap. Branch and leaf pages of a bucket in a map
+// grouped by bucket have the kind pagemapBucket plus the index of the
+// top-level bucket.
+const (
+	pagemapUnallocated = iota
+	pagemapMeta
+	pagemapFreelist
+	pagemapBranch
+	pagemapLeaf
+	pagemapFree
+	pagemapUnreachable
+	pagemapBucket
+)
+
+// pagemapNames are the labels of the page kinds.
+var pagemapNames = []string{"unallocated", "meta", "freelist", "branch", "leaf", "free", "unreachable"}
+
+// pagemapColors are the colors of the page kinds.
+var pagemapColors = []string{"#ffffff", "#444444", "#aa00ff", "#3366cc", "#33aa33", "#e8e8e8", "#ee3333"}
+
+// pagemapSymbols are the ASCII symbols of the page kinds, followed by the
+// symbols of top-level buckets.
+const pagemapSymbols = "_MFBL.?abcdefghijklmnopqrstuvwxyzACDEGHIJKNOPQRSTUVWXYZ0123456789"
+
+// pagemapPage is the kind of a page and the top-level bucket that owns it, or
+// -1 for pages that are not owned by a bucket.
+type pagemapPage struct {
+	kind  uint8
+	owner int32
+}
+
+// pagemapCell is a group of pages drawn as one cell.
+type pagemapCell struct {
+	key int // page kind, or pagemapBucket plus the owner index
+	n   int // number of pages
+}
+
+// pagemap is the kind and owner of every page of a database file.
+type pagemap struct {
+	pageSize int
+	pages    []pagemapPage
+	owners   []string // names of top-level buckets, "/" for the bucket index
+	ownerIDs map[string]int32
+	perCell  int
+	warnings []string
+}
+
+// readPagemap reads the pages of the database at path. A read-only handle
+// is held while reading so that no writer can change the file.
+func readPagemap(path string) (*pagemap, error) {
+	db, err := bolt.Open(path, 0666, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	pageSize, metas, err := readMetas(file)
+	if err != nil {
+		return nil, err
+	}
+	f := &surgeryFile{file: file, w: ioutil.Discard, pageSize: pageSize, metas: metas}
+	_, m := f.active()
+
+	// Pages past the high water mark are unallocated. The others are
+	// unreachable until they are found below a meta page or in the freelist.
+	n := int(info.Size() / int64(pageSize))
+	if n < int(m.pgid) {
+		n = int(m.pgid)
+	}
+	pm := &pagemap{pageSize: pageSize, pages: make([]pagemapPage, n), ownerIDs: make(map[string]int32)}
+	for id := range pm.pages {
+		pm.pages[id].owner = -1
+		if id < int(m.pgid) {
+			pm.pages[id].kind = pagemapUnreachable
+		}
+	}
+	pm.pages[0].kind, pm.pages[1].kind = pagemapMeta, pagemapMeta
+
+	// Mark the freelist and the pages in it.
+	if buf, err := f.readPage(m.freelist); err != nil {
+		pm.warnings = append(pm.warnings, fmt.Sprintf("freelist: %s", err))
+	} else {
+		p := (*page)(unsafe.Pointer(&buf[0]))
+		pm.mark(m.freelist, int(p.overflow), pagemapFreelist, -1)
+		ids := (*[maxAllocSize / 8]pgid)(unsafe.Pointer(&p.ptr))
+		count, start := int(p.count), 0
+		if p.count == 0xFFFF {
+			count, start = int(ids[0]), 1
+		}
+		if PageHeaderSize+(start+count)*8 > len(buf) {
+			pm.warnings = append(pm.warnings, fmt.Sprintf("freelist: %d ids past end of page", count))
+			count = 0
+		}
+		for _, id := range ids[start : start+count] {
+			pm.mark(id, 0, pagemapFree, -1)
+		}
+	}
+
+	pm.walk(f, m.root.root, nil)
+	return pm, nil
+}
+
+// mark sets the kind and owner of a page and its overflow pages.
+func (pm *pagemap) mark(id pgid, overflow int, kind uint8, owner int32) {
+	for i := int(id); i <= int(id)+overflow && i < len(pm.pages); i++ {
+		pm.pages[i] = pagemapPage{kind: kind, owner: owner}
+	}
+}
+
+// walk marks the pages of the tree below a page as owned by the top-level
+// bucket of path, and the pages of its nested buckets.
+func (pm *pagemap) walk(f *surgeryFile, id pgid, path [][]byte) {
+	if int(id) < len(pm.pages) && (pm.pages[id].kind == pagemapBranch || pm.pages[id].kind == pagemapLeaf) {
+		pm.warnings = append(pm.warnings, fmt.Sprintf("page %d: referenced more than once", id))
+		return
+	}
+	buf, err := f.readPage(id)
+	if err != nil {
+		pm.warnings = append(pm.warnings, err.Error())
+		return
+	}
+	p := (*page)(unsafe.Pointer(&buf[0]))
+	if msg := p.layoutError(len(buf)); msg != "" {
+		pm.warnings = append(pm.warnings, fmt.Sprintf("page %d: %s", id, msg))
+		return
+	}
+
+	owner := pm.owner(path)
+	switch {
+	case p.flags&branchPageFlag != 0:
+		pm.mark(id, int(p.overflow), pagemapBranch, owner)
+		for i := uint16(0); i < p.count; i++ {
+			pm.walk(f, p.branchPageElement(i).pgid, path)
+		}
+	case p.flags&leafPageFlag != 0:
+		pm.mark(id, int(p.overflow), pagemapLeaf, owner)
+		for i := uint16(0); i < p.count; i++ {
+			e := p.leafPageElement(i)
+			if e.flags&bucketLeafFlag == 0 || len(e.value()) < int(unsafe.Sizeof(bucket{})) {
+				continue
+			}
+			if b := (*bucket)(unsafe.Pointer(&e.value()[0])); b.root != 0 {
+				pm.walk(f, b.root, append(path[:len(path):len(path)], e.key()))
+			}
+		}
+	default:
+		pm.warnings = append(pm.warnings, fmt.Sprintf("page %d: invalid page type: %s", id, p.Type()))
+	}
+}
+
+// owner returns the index of the top-level bucket of path.
+func (pm *pagemap) owner(path [][]byte) int32 {
+	name := formatPath(path[:min(len(path), 1)])
+	id, ok := pm.ownerIDs[name]
+	if !ok {
+		id = int32(len(pm.owners))
+		pm.owners = append(pm.owners, name)
+		pm.ownerIDs[name] = id
+	}
+	return id
+}
+
+// cells groups the pages into at most limit cells. Each cell has the most
+// common key of its pages.
+func (pm *pagemap) cells(limit int, byBucket bool) []pagemapCell {
+	pm.perCell = (len(pm.pages) + limit - 1) / limit
+	if pm.perCell < 1 {
+		pm.perCell = 1
+	}
+	var cells []pagemapCell
+	counts := make(map[int]int)
+	for i := 0; i < len(pm.pages); i += pm.perCell {
+		for k := range counts {
+			delete(counts, k)
+		}
+		c := pagemapCell{key: -1}
+		for _, p := range pm.pages[i:min(i+pm.perCell, len(pm.pages))] {
+			key := int(p.kind)
+			if byBucket && p.owner >= 0 {
+				key = pagemapBucket + int(p.owner)
+			}
+			counts[key]++
+			if c.key < 0 || counts[key] > counts[c.key] {
+				c.key = key
+			}
+			c.n++
+		}
+		cells = append(cells, c)
+	}
+	return cells
+}
+
+// legend returns the keys used by cells in order.
+func (pm *pagemap) legend(cells []pagemapCell) []int {
+	used := make(map[int]bool)
+	for _, c := range cells {
+		used[c.key] = true
+	}
+	var keys []int
+	for key := 0; key < pagemapBucket+len(pm.owners); key++ {
+		if used[key] {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// label returns the name of a page kind or the path of a bucket.
+func (pm *pagemap) label(key int) string {
+	if key >= pagemapBucket {
+		if name := pm.owners[key-pagemapBucket]; name != "/" {
+			return "bucket " + name
+		}
+		return "bucket index"
+	}
+	return pagemapNames[key]
+}
+
+// symbol returns the ASCII symbol of a key. Buckets past the last symbol
+// share "*".
+func (pm *pagemap) symbol(key int) byte {
+	if key < len(pagemapSymbols) {
+		return pagemapSymbols[key]
+	}
+	return '*'
+}
+
+// color returns the color of a key. Buckets get distinct hues.
+func (pm *pagemap) color(key int) string {
+	if key >= pagemapBucket {
+		return fmt.Sprintf("hsl(%d,60%%,50%%)", ((key-pagemapBucket)*137)%360)
+	}
+	return pagemapColors[key]
+}
+
+// writeSummary writes the page counts and the free spans of the file.
+func (pm *pagemap) writeSummary(w io.Writer) {
+	var counts [pagemapBucket]int
+	var spans statsHistogram
+	largest, largestAt := 0, 0
+	for i := 0; i < len(pm.pages); {
+		counts[pm.pages[i].kind]++
+		if pm.pages[i].kind != pagemapFree {
+			i++
+			continue
+		}
+		j := i + 1
+		for ; j < len(pm.pages) && pm.pages[j].kind == pagemapFree; j++ {
+			counts[pagemapFree]++
+		}
+		spans.add(j - i)
+		if j-i > largest {
+			largest, largestAt = j-i, i
+		}
+		i = j
+	}
+
+	fmt.Fprintf(w, "Pages: %d", len(pm.pages))
+	sep := " ("
+	for kind, n := range counts {
+		if kind != pagemapUnallocated || n > 0 {
+			fmt.Fprintf(w, "%s%d %s", sep, n, pagemapNames[kind])
+			sep = ", "
+		}
+	}
+	fmt.Fprintln(w, ")")
+
+	fmt.Fprintf(w, "Free spans: %d", spans.Count)
+	if spans.Count > 0 {
+		fmt.Fprintf(w, ", largest %d pages at page %d", largest, largestAt)
+	}
+	fmt.Fprintln(w)
+	var fragmentation int
+	if free := counts[pagemapFree]; free > 0 {
+		fragmentation = (free - largest) * 100 / free
+	}
+	fmt.Fprintf(w, "Fragmentation: %d%% of free pages outside the largest span\n", fragmentation)
+	if spans.Count > 0 {
+		fmt.Fprintln(w, "Free span sizes:")
+		for _, bin := range spans.Bins {
+			fmt.Fprintf(w, "\t%d-%d pages: %d\n", bin.Min, bin.Max, bin.Count)
+		}
+	}
+}
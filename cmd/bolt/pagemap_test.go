@@ -0,0 +1,78 @@
+package main_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// Ensure the page map shows page kinds, owning buckets and free spans.
+func TestPagemapCommand(t *testing.T) {
+	db := MustOpen(0666, nil)
+	defer db.Close()
+
+	for _, name := range []string{"widgets", "users", "tmp"} {
+		if err := db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+			for i := 0; i < 1000; i++ {
+				if err := b.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 100)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte("tmp"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	stats := db.Stats()
+	free := stats.FreePageN + stats.PendingPageN
+	db.DB.Close()
+
+	// Every page has its own cell in a large enough map.
+	m := NewMain()
+	if err := m.Run("pagemap", "-width", "100", "-height", "100", db.Path); err != nil {
+		t.Fatal(err)
+	}
+	out := m.Stdout.String()
+	if !strings.Contains(out, ", 1 pages per cell\n") || !strings.Contains(out, "\n         0 MML") {
+		t.Fatalf("unexpected map:\n%s", out)
+	} else if !strings.Contains(out, fmt.Sprintf(" %d free, 0 unreachable)", free)) {
+		t.Fatalf("unexpected free page count, expected %d:\n%s", free, out)
+	} else if !strings.Contains(out, "Free spans: ") || !strings.Contains(out, "Free span sizes:\n") {
+		t.Fatalf("unexpected summary:\n%s", out)
+	}
+
+	// Pages can be grouped by the bucket that owns them.
+	m = NewMain()
+	if err := m.Run("pagemap", "-by", "bucket", db.Path); err != nil {
+		t.Fatal(err)
+	} else if out := m.Stdout.String(); !strings.Contains(out, `bucket "users"`) || !strings.Contains(out, `bucket "widgets"`) || strings.Contains(out, `bucket "tmp"`) {
+		t.Fatalf("unexpected map:\n%s", out)
+	}
+
+	// HTML is written to a file.
+	path := filepath.Join(t.TempDir(), "pagemap.html")
+	if err := NewMain().Run("pagemap", "-format", "html", "-o", path, db.Path); err != nil {
+		t.Fatal(err)
+	} else if buf, err := ioutil.ReadFile(path); err != nil {
+		t.Fatal(err)
+	} else if s := string(buf); !strings.HasPrefix(s, "<!DOCTYPE html>") || !strings.Contains(s, "<svg ") || !strings.Contains(s, "<title>pages 0-") || !strings.HasSuffix(s, "</html>\n") {
+		t.Fatalf("unexpected html:\n%s", s)
+	}
+
+	if err := NewMain().Run("pagemap", "-format", "png", db.Path); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/boltdb/bolt"
+)
+
+// RepairCommand represents the "repair" command execution.
+type RepairCommand struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	SrcPath      string
+	DstPath      string
+	PageSize     int
+	LostAndFound string
+}
+
+// newRepairCommand returns a RepairCommand.
+func newRepairCommand(m *Main) *RepairCommand {
+	return &RepairCommand{
+		Stdin:  m.Stdin,
+		Stdout: m.Stdout,
+		Stderr: m.Stderr,
+	}
+}
+
+// Run executes the command.
+func (cmd *RepairCommand) Run(args ...string) error {
+	// Parse flags.
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.StringVar(&cmd.DstPath, "o", "", "")
+	fs.IntVar(&cmd.PageSize, "page-size", 0, "")
+	fs.StringVar(&cmd.LostAndFound, "lost-found", "lost+found", "")
+	if err := fs.Parse(args); err == flag.ErrHelp {
+		fmt.Fprintln(cmd.Stderr, cmd.Usage())
+		return ErrUsage
+	} else if err != nil {
+		return err
+	} else if cmd.DstPath == "" {
+		return fmt.Errorf("output file required")
+	}
+
+	// Require database path.
+	cmd.SrcPath = fs.Arg(0)
+	if cmd.SrcPath == "" {
+		return ErrPathRequired
+	} else if _, err := os.Stat(cmd.SrcPath); os.IsNotExist(err) {
+		return ErrFileNotFound
+	}
+
+	report, err := bolt.Salvage(cmd.SrcPath, cmd.DstPath, &bolt.SalvageOptions{
+		PageSize:     cmd.PageSize,
+		LostAndFound: cmd.LostAndFound,
+	})
+	if err != nil {
+		return err
+	}
+	cmd.print(report)
+	return nil
+}
+
+// print writes a report to stdout.
+func (cmd *RepairCommand) print(r *bolt.SalvageReport) {
+	w := cmd.Stdout
+	fmt.Fprintf(w, "Page size: %d\n", r.PageSize)
+	if r.Meta < 0 {
+		fmt.Fprintf(w, "Meta page: none\n")
+	} else {
+		fmt.Fprintf(w, "Meta page: %d (txid %d)\n", r.Meta, r.TxID)
+	}
+	fmt.Fprintf(w, "Buckets: %d\n", r.Buckets)
+	fmt.Fprintf(w, "Keys: %d (%d from older meta page, %d from %d orphaned pages)\n", r.Keys, r.OlderKeys, r.OrphanKeys, r.OrphanPages)
+	if r.Skipped > 0 {
+		fmt.Fprintf(w, "Skipped: %d\n", r.Skipped)
+	}
+
+	for _, l := range r.Lost {
+		fmt.Fprintf(w, "lost: page %d: bucket %s: keys [%s, %s): %s (%d keys recovered)\n",
+			l.PageID, formatPath(l.Bucket), formatBound(l.Lower, "-inf"), formatBound(l.Upper, "+inf"), l.Reason, l.Recovered)
+	}
+	for _, s := range r.Warnings {
+		fmt.Fprintf(w, "warning: %s\n", s)
+	}
+}
+
+// formatPath returns a bucket path as slash-separated names.
+func formatPath(path [][]byte) string {
+	if len(path) == 0 {
+		return "/"
+	}
+	names := make([]string, len(path))
+	for i, name := range path {
+		names[i] = formatBound(name, `""`)
+	}
+	return strings.Join(names, "/")
+}
+
+// formatBound returns a key quoted if printable and in hex otherwise.
+// Returns unbounded if the key is nil.
+func formatBound(key []byte, unbounded string) string {
+	if key == nil {
+		return unbounded
+	} else if isPrintable(string(key)) {
+		return fmt.Sprintf("%q", key)
+	}
+	return fmt.Sprintf("%x", key)
+}
+
+// Usage returns the help message.
+func (cmd *RepairCommand) Usage() string {
+	return strings.TrimLeft(`
+usage: bolt repair [options] -o DST SRC
+
+Repair recovers as many buckets and keys as possible from a damaged database
+at SRC path and writes them to a new database at DST path. SRC does not need
+to open and is left untouched. DST must not exist.
+
+The trees of the newest valid meta page are copied first, skipping pages that
+cannot be read. Keys below skipped pages are then restored from the trees of
+the older meta page. Finally every page that is neither reachable nor free is
+scanned and the keys of intact leaf pages are put back into their bucket, or
+into a bucket named after the page below the lost and found bucket if their
+bucket cannot be determined.
+
+A report of the recovered keys and of the lost pages is printed when done.
+
+Additional options include:
+
+	-page-size NUM
+		Page size of SRC. Defaults to the size stored in a valid meta
+		page or to the first common page size that has one.
+
+	-lost-found NAME
+		Name of the top-level bucket receiving keys of orphaned pages.
+		Defaults to "lost+found".
+`, "\n")
+}
@@ -0,0 +1,83 @@
+package main_test
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// Ensure the "repair" command restores keys below a corrupted page from
+// orphaned leaf pages.
+func TestRepairCommand_Run(t *testing.T) {
+	db := MustOpen(0666, nil)
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var root int
+	if err := db.View(func(tx *bolt.Tx) error {
+		root = int(tx.Bucket([]byte("widgets")).Root())
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	pageSize := db.Info().PageSize
+	db.DB.Close()
+	defer db.Close()
+
+	// Clear the flags of the bucket's root page.
+	f, err := os.OpenFile(db.Path, os.O_RDWR, 0666)
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := f.WriteAt([]byte{0, 0}, int64(root*pageSize+8)); err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := MustOpen(0666, nil)
+	dst.Close()
+	defer os.Remove(dst.Path)
+
+	m := NewMain()
+	if err := m.Run("repair", "-o", dst.Path, db.Path); err != nil {
+		t.Fatal(err)
+	}
+	out := m.Stdout.String()
+	if !strings.Contains(out, "Keys: 1000 (0 from older meta page, 1000 from ") ||
+		!strings.Contains(out, fmt.Sprintf(`lost: page %d: bucket "widgets": keys [-inf, +inf): invalid page type`, root)) {
+		t.Fatalf("unexpected output: %s", out)
+	}
+
+	d, err := bolt.Open(dst.Path, 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if err := d.View(func(tx *bolt.Tx) error {
+		if n := tx.Bucket([]byte("widgets")).Stats().KeyN; n != 1000 {
+			t.Fatalf("unexpected key count: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The destination must not exist.
+	if err := NewMain().Run("repair", "-o", dst.Path, db.Path); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,922 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/boltdb/bolt/exchange"
+)
+
+// maxHistory is the number of lines kept in the history file.
+const maxHistory = 1000
+
+// shellCommands are the commands understood by the shell, in sorted order.
+var shellCommands = []string{
+	"begin", "cd", "commit", "del", "exit", "get", "help", "history", "ls",
+	"mkdir", "put", "pwd", "rmdir", "rollback", "scan",
+}
+
+// ShellCommand represents the "shell" command execution.
+type ShellCommand struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	Path        string
+	HistoryPath string
+	KeyFormat   string
+	ValueFormat string
+	ReadOnly    bool
+
+	db      *bolt.DB
+	tx      *bolt.Tx
+	cwd     [][]byte
+	history []string
+}
+
+// newShellCommand returns a ShellCommand.
+func newShellCommand(m *Main) *ShellCommand {
+	return &ShellCommand{
+		Stdin:  m.Stdin,
+		Stdout: m.Stdout,
+		Stderr: m.Stderr,
+	}
+}
+
+// Run executes the command.
+func (cmd *ShellCommand) Run(args ...string) error {
+	// Parse flags.
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.StringVar(&cmd.HistoryPath, "history", "", "")
+	fs.StringVar(&cmd.KeyFormat, "key-format", string(exchange.UTF8), "")
+	fs.StringVar(&cmd.ValueFormat, "value-format", string(exchange.UTF8), "")
+	fs.BoolVar(&cmd.ReadOnly, "read-only", false, "")
+	if err := fs.Parse(args); err == flag.ErrHelp {
+		fmt.Fprintln(cmd.Stderr, cmd.Usage())
+		return ErrUsage
+	} else if err != nil {
+		return err
+	} else if err := checkFormats(cmd.KeyFormat, cmd.ValueFormat); err != nil {
+		return err
+	}
+
+	// Require database path.
+	path, err := requirePath(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	cmd.Path = path
+
+	if err := cmd.Open(cmd.Path); err != nil {
+		return err
+	}
+	defer cmd.Close()
+
+	// Edit lines in raw mode when reading from a terminal. Otherwise read
+	// plain lines without prompts so that scripts can be piped in.
+	var readLine func(prompt string) (string, error)
+	if f, ok := cmd.Stdin.(*os.File); ok && isTerminal(int(f.Fd())) {
+		if cmd.HistoryPath == "" {
+			if home, err := os.UserHomeDir(); err == nil {
+				cmd.HistoryPath = filepath.Join(home, ".bolt_history")
+			}
+		}
+		cmd.loadHistory()
+		e := &lineEditor{
+			fd:       int(f.Fd()),
+			in:       bufio.NewReader(f),
+			out:      cmd.Stdout,
+			history:  &cmd.history,
+			complete: cmd.Complete,
+		}
+		readLine = e.readLine
+	} else {
+		cmd.loadHistory()
+		scanner := bufio.NewScanner(cmd.Stdin)
+		readLine = func(string) (string, error) {
+			if !scanner.Scan() {
+				if err := scanner.Err(); err != nil {
+					return "", err
+				}
+				return "", io.EOF
+			}
+			return scanner.Text(), nil
+		}
+	}
+
+	for {
+		line, err := readLine(cmd.prompt())
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		cmd.history = append(cmd.history, line)
+
+		args, err := splitLine(line)
+		if err == nil {
+			var quit bool
+			if quit, err = cmd.Exec(args...); quit {
+				break
+			}
+		}
+		if err != nil {
+			fmt.Fprintln(cmd.Stderr, "error:", err)
+		}
+	}
+
+	// Discard changes that were not committed.
+	if cmd.tx != nil {
+		fmt.Fprintln(cmd.Stderr, "rolled back open transaction")
+	}
+	if err := cmd.Close(); err != nil {
+		return err
+	}
+	return cmd.saveHistory()
+}
+
+// Open opens the database at path for the shell.
+func (cmd *ShellCommand) Open(path string) error {
+	db, err := bolt.Open(path, 0666, &bolt.Options{ReadOnly: cmd.ReadOnly})
+	if err != nil {
+		return err
+	}
+	cmd.db = db
+	return nil
+}
+
+// Close discards the open transaction, if any, and closes the database.
+func (cmd *ShellCommand) Close() error {
+	if cmd.db == nil {
+		return nil
+	}
+	if cmd.tx != nil {
+		_ = cmd.tx.Rollback()
+		cmd.tx = nil
+	}
+	err := cmd.db.Close()
+	cmd.db = nil
+	return err
+}
+
+// Exec executes a single shell command. Returns true if the shell should exit.
+func (cmd *ShellCommand) Exec(args ...string) (quit bool, err error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+	name, args := args[0], args[1:]
+	switch name {
+	case "begin":
+		return false, cmd.begin(args)
+	case "cd":
+		return false, cmd.cd(args)
+	case "commit":
+		return false, cmd.commit()
+	case "del":
+		return false, cmd.del(args)
+	case "exit", "quit":
+		return true, nil
+	case "get":
+		return false, cmd.get(args)
+	case "help":
+		fmt.Fprint(cmd.Stdout, shellHelp)
+		return false, nil
+	case "history":
+		for i, line := range cmd.history {
+			fmt.Fprintf(cmd.Stdout, "%5d  %s\n", i+1, line)
+		}
+		return false, nil
+	case "ls":
+		return false, cmd.ls(args)
+	case "mkdir":
+		return false, cmd.mkdir(args)
+	case "put":
+		return false, cmd.put(args)
+	case "pwd":
+		fmt.Fprintln(cmd.Stdout, cmd.formatPath(cmd.cwd))
+		return false, nil
+	case "rmdir":
+		return false, cmd.rmdir(args)
+	case "rollback":
+		return false, cmd.rollback()
+	case "scan":
+		return false, cmd.scan(args)
+	default:
+		return false, fmt.Errorf("unknown command %q, type help for a list of commands", name)
+	}
+}
+
+// prompt returns the prompt showing the current bucket and whether a
+// transaction is open.
+func (cmd *ShellCommand) prompt() string {
+	var tx string
+	if cmd.tx != nil {
+		tx = " [tx]"
+		if !cmd.tx.Writable() {
+			tx = " [ro tx]"
+		}
+	}
+	return fmt.Sprintf("bolt %s%s> ", cmd.formatPath(cmd.cwd), tx)
+}
+
+// view runs fn in the open transaction or in a new read-only transaction.
+func (cmd *ShellCommand) view(fn func(*bolt.Tx) error) error {
+	if cmd.tx != nil {
+		return fn(cmd.tx)
+	}
+	return cmd.db.View(fn)
+}
+
+// update runs fn in the open transaction or in a new read-write transaction
+// that is committed if fn succeeds.
+func (cmd *ShellCommand) update(fn func(*bolt.Tx) error) error {
+	if cmd.tx != nil {
+		if !cmd.tx.Writable() {
+			return bolt.ErrTxNotWritable
+		}
+		return fn(cmd.tx)
+	}
+	return cmd.db.Update(fn)
+}
+
+// begin opens a transaction used by the following commands.
+func (cmd *ShellCommand) begin(args []string) error {
+	fs := flag.NewFlagSet("begin", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	readOnly := fs.Bool("read-only", false, "")
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if cmd.tx != nil {
+		return errors.New("transaction already open")
+	}
+	tx, err := cmd.db.Begin(!*readOnly && !cmd.ReadOnly)
+	if err != nil {
+		return err
+	}
+	cmd.tx = tx
+	return nil
+}
+
+// commit commits the open transaction. Read-only transactions are closed.
+func (cmd *ShellCommand) commit() error {
+	if cmd.tx == nil {
+		return errors.New("no open transaction")
+	}
+	tx := cmd.tx
+	cmd.tx = nil
+	if !tx.Writable() {
+		return tx.Rollback()
+	}
+	return tx.Commit()
+}
+
+// rollback discards the changes of the open transaction.
+func (cmd *ShellCommand) rollback() error {
+	if cmd.tx == nil {
+		return errors.New("no open transaction")
+	}
+	tx := cmd.tx
+	cmd.tx = nil
+	return tx.Rollback()
+}
+
+// cd changes the current bucket. Without an argument it returns to the top
+// level.
+func (cmd *ShellCommand) cd(args []string) error {
+	if len(args) > 1 {
+		return errors.New("usage: cd [PATH]")
+	}
+	var path [][]byte
+	if len(args) == 1 {
+		var err error
+		if path, err = cmd.resolve(args[0]); err != nil {
+			return err
+		}
+	}
+	if len(path) > 0 {
+		if err := cmd.view(func(tx *bolt.Tx) error {
+			_, err := bucketAt(tx, path)
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+	cmd.cwd = path
+	return nil
+}
+
+// ls lists the buckets and keys of the current bucket or of the bucket at
+// the given path. Bucket names end with "/".
+func (cmd *ShellCommand) ls(args []string) error {
+	if len(args) > 1 {
+		return errors.New("usage: ls [PATH]")
+	}
+	path := cmd.cwd
+	if len(args) == 1 {
+		var err error
+		if path, err = cmd.resolve(args[0]); err != nil {
+			return err
+		}
+	}
+	return cmd.view(func(tx *bolt.Tx) error {
+		if len(path) == 0 {
+			return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+				fmt.Fprintln(cmd.Stdout, cmd.formatName(name)+"/")
+				return nil
+			})
+		}
+		b, err := bucketAt(tx, path)
+		if err != nil {
+			return err
+		}
+		return b.ForEach(func(k, v []byte) error {
+			if v == nil {
+				fmt.Fprintln(cmd.Stdout, cmd.formatName(k)+"/")
+			} else {
+				fmt.Fprintln(cmd.Stdout, encode(cmd.KeyFormat, k))
+			}
+			return nil
+		})
+	})
+}
+
+// get prints the value of a key in the current bucket.
+func (cmd *ShellCommand) get(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: get KEY")
+	}
+	key, err := cmd.decodeKey(args[0])
+	if err != nil {
+		return err
+	}
+	return cmd.view(func(tx *bolt.Tx) error {
+		b, err := cmd.bucket(tx)
+		if err != nil {
+			return err
+		}
+		if b.Bucket(key) != nil {
+			return &bolt.KeyError{Bucket: cmd.cwd, Key: key, Err: bolt.ErrIncompatibleValue}
+		}
+		v := b.Get(key)
+		if v == nil {
+			return &bolt.KeyError{Bucket: cmd.cwd, Key: key, Err: ErrKeyNotFound}
+		}
+		fmt.Fprintln(cmd.Stdout, encode(cmd.ValueFormat, v))
+		return nil
+	})
+}
+
+// put sets the value of a key in the current bucket.
+func (cmd *ShellCommand) put(args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: put KEY VALUE")
+	}
+	key, err := cmd.decodeKey(args[0])
+	if err != nil {
+		return err
+	}
+	value, err := decode(cmd.ValueFormat, args[1])
+	if err != nil {
+		return fmt.Errorf("invalid %s: %q: %s", cmd.ValueFormat, args[1], err)
+	}
+	return cmd.update(func(tx *bolt.Tx) error {
+		b, err := cmd.bucket(tx)
+		if err != nil {
+			return err
+		}
+		return b.Put(key, value)
+	})
+}
+
+// del removes a key from the current bucket.
+func (cmd *ShellCommand) del(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: del KEY")
+	}
+	key, err := cmd.decodeKey(args[0])
+	if err != nil {
+		return err
+	}
+	return cmd.update(func(tx *bolt.Tx) error {
+		b, err := cmd.bucket(tx)
+		if err != nil {
+			return err
+		}
+		if b.Bucket(key) != nil {
+			return &bolt.KeyError{Bucket: cmd.cwd, Key: key, Err: bolt.ErrIncompatibleValue}
+		} else if b.Get(key) == nil {
+			return &bolt.KeyError{Bucket: cmd.cwd, Key: key, Err: ErrKeyNotFound}
+		}
+		return b.Delete(key)
+	})
+}
+
+// mkdir creates a bucket and its parents if they do not exist.
+func (cmd *ShellCommand) mkdir(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: mkdir PATH")
+	}
+	path, err := cmd.resolve(args[0])
+	if err != nil {
+		return err
+	} else if len(path) == 0 {
+		return bolt.ErrBucketNameRequired
+	}
+	return cmd.update(func(tx *bolt.Tx) error {
+		_, err := createBucketAt(tx, path)
+		return err
+	})
+}
+
+// rmdir removes a bucket with its keys and nested buckets.
+func (cmd *ShellCommand) rmdir(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: rmdir PATH")
+	}
+	path, err := cmd.resolve(args[0])
+	if err != nil {
+		return err
+	} else if len(path) == 0 {
+		return bolt.ErrBucketNameRequired
+	}
+	parent, name := path[:len(path)-1], path[len(path)-1]
+	return cmd.update(func(tx *bolt.Tx) error {
+		if len(parent) == 0 {
+			return tx.DeleteBucket(name)
+		}
+		b, err := bucketAt(tx, parent)
+		if err != nil {
+			return err
+		}
+		return b.DeleteBucket(name)
+	})
+}
+
+// scan prints the keys and values of the current bucket, optionally limited
+// to a prefix, to a range of keys and to a number of keys.
+func (cmd *ShellCommand) scan(args []string) error {
+	fs := flag.NewFlagSet("scan", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	prefixArg := fs.String("prefix", "", "")
+	fromArg := fs.String("from", "", "")
+	toArg := fs.String("to", "", "")
+	limit := fs.Int("limit", 0, "")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("usage: scan [-prefix KEY] [-from KEY] [-to KEY] [-limit N]: %s", err)
+	} else if fs.NArg() > 0 {
+		return errors.New("usage: scan [-prefix KEY] [-from KEY] [-to KEY] [-limit N]")
+	}
+
+	var prefix, from, to []byte
+	for _, a := range []struct {
+		s string
+		b *[]byte
+	}{{*prefixArg, &prefix}, {*fromArg, &from}, {*toArg, &to}} {
+		if a.s == "" {
+			continue
+		}
+		b, err := cmd.decodeKey(a.s)
+		if err != nil {
+			return err
+		}
+		*a.b = b
+	}
+
+	// Start at the greater of the prefix and the lower bound.
+	start := from
+	if bytes.Compare(prefix, start) > 0 {
+		start = prefix
+	}
+
+	return cmd.view(func(tx *bolt.Tx) error {
+		b, err := cmd.bucket(tx)
+		if err != nil {
+			return err
+		}
+		c := b.Cursor()
+		k, v := c.First()
+		if start != nil {
+			k, v = c.Seek(start)
+		}
+		for n := 0; k != nil; k, v = c.Next() {
+			if !bytes.HasPrefix(k, prefix) || (to != nil && bytes.Compare(k, to) >= 0) {
+				break
+			} else if *limit > 0 && n == *limit {
+				break
+			}
+			if v == nil {
+				fmt.Fprintln(cmd.Stdout, cmd.formatName(k)+"/")
+			} else {
+				fmt.Fprintf(cmd.Stdout, "%s %s\n", encode(cmd.KeyFormat, k), encode(cmd.ValueFormat, v))
+			}
+			n++
+		}
+		return nil
+	})
+}
+
+// bucket returns the current bucket.
+func (cmd *ShellCommand) bucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	if len(cmd.cwd) == 0 {
+		return nil, errors.New("not in a bucket, use cd to enter one")
+	}
+	return bucketAt(tx, cmd.cwd)
+}
+
+// decodeKey decodes a key argument in the key format.
+func (cmd *ShellCommand) decodeKey(s string) ([]byte, error) {
+	b, err := decode(cmd.KeyFormat, s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %q: %s", cmd.KeyFormat, s, err)
+	}
+	return b, nil
+}
+
+// resolve returns the bucket path named by s. Paths starting with "/" are
+// absolute, others are relative to the current bucket. Names are separated
+// by "/" and written in the key format with "%" and "/" escaped as "%25" and
+// "%2F". The names "." and ".." refer to the current and parent bucket.
+func (cmd *ShellCommand) resolve(s string) ([][]byte, error) {
+	var path [][]byte
+	if !strings.HasPrefix(s, "/") {
+		path = append(path, cmd.cwd...)
+	}
+	for _, name := range strings.Split(s, "/") {
+		switch name {
+		case "", ".":
+		case "..":
+			if len(path) > 0 {
+				path = path[:len(path)-1]
+			}
+		default:
+			b, err := exchange.ParsePath(name, exchange.Encoding(cmd.KeyFormat))
+			if err != nil {
+				return nil, err
+			}
+			path = append(path, b...)
+		}
+	}
+	return path, nil
+}
+
+// formatPath returns a bucket path as written by resolve, starting with "/".
+func (cmd *ShellCommand) formatPath(path [][]byte) string {
+	return "/" + exchange.FormatPath(path, exchange.Encoding(cmd.KeyFormat))
+}
+
+// formatName returns a bucket name as written in a path.
+func (cmd *ShellCommand) formatName(name []byte) string {
+	return exchange.FormatPath([][]byte{name}, exchange.Encoding(cmd.KeyFormat))
+}
+
+// Complete completes the last word of line. Words after the command are
+// completed with bucket names. Returns the completed line and, if the word
+// is ambiguous, the candidates.
+func (cmd *ShellCommand) Complete(line string) (string, []string) {
+	i := strings.LastIndexAny(line, " \t") + 1
+	head, word := line[:i], line[i:]
+
+	// Complete command names first.
+	var candidates []string
+	if strings.TrimSpace(head) == "" {
+		for _, name := range shellCommands {
+			if strings.HasPrefix(name, word) {
+				candidates = append(candidates, name+" ")
+			}
+		}
+	} else {
+		dir, base := "", word
+		if j := strings.LastIndex(word, "/"); j >= 0 {
+			dir, base = word[:j+1], word[j+1:]
+		}
+		path, err := cmd.resolve(dir)
+		if err != nil {
+			return line, nil
+		}
+		_ = cmd.view(func(tx *bolt.Tx) error {
+			fn := func(name []byte, v []byte) error {
+				if v != nil {
+					return nil
+				} else if s := cmd.formatName(name) + "/"; strings.HasPrefix(s, base) {
+					candidates = append(candidates, s)
+				}
+				return nil
+			}
+			if len(path) == 0 {
+				return tx.ForEach(func(name []byte, _ *bolt.Bucket) error { return fn(name, nil) })
+			}
+			b, err := bucketAt(tx, path)
+			if err != nil {
+				return err
+			}
+			return b.ForEach(fn)
+		})
+		head += dir
+		word = base
+	}
+
+	switch len(candidates) {
+	case 0:
+		return line, nil
+	case 1:
+		return head + candidates[0], nil
+	}
+	sort.Strings(candidates)
+	prefix := candidates[0]
+	for _, s := range candidates[1:] {
+		for !strings.HasPrefix(s, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	if len(prefix) < len(word) {
+		prefix = word
+	}
+	return head + prefix, candidates
+}
+
+// loadHistory reads the history file if there is one.
+func (cmd *ShellCommand) loadHistory() {
+	if cmd.HistoryPath == "" {
+		return
+	}
+	buf, err := ioutil.ReadFile(cmd.HistoryPath)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(buf), "\n") {
+		if line != "" {
+			cmd.history = append(cmd.history, line)
+		}
+	}
+}
+
+// saveHistory writes the most recent lines of history to the history file.
+func (cmd *ShellCommand) saveHistory() error {
+	if cmd.HistoryPath == "" {
+		return nil
+	}
+	lines := cmd.history
+	if len(lines) > maxHistory {
+		lines = lines[len(lines)-maxHistory:]
+	}
+	var buf bytes.Buffer
+	for _, line := range lines {
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	return ioutil.WriteFile(cmd.HistoryPath, buf.Bytes(), 0600)
+}
+
+// splitLine splits a line into words separated by spaces. Words may be
+// quoted with single quotes, taken literally, or with double quotes, which
+// accept Go escape sequences.
+func splitLine(line string) ([]string, error) {
+	var args []string
+	var word strings.Builder
+	inWord := false
+	for i := 0; i < len(line); {
+		switch c := line[i]; c {
+		case ' ', '\t':
+			if inWord {
+				args = append(args, word.String())
+				word.Reset()
+				inWord = false
+			}
+			i++
+		case '"':
+			j := i + 1
+			for ; j < len(line) && line[j] != '"'; j++ {
+				if line[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(line) {
+				return nil, errors.New("unterminated quote")
+			}
+			s, err := strconv.Unquote(line[i : j+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted string %s", line[i:j+1])
+			}
+			word.WriteString(s)
+			inWord = true
+			i = j + 1
+		case '\'':
+			j := strings.IndexByte(line[i+1:], '\'')
+			if j < 0 {
+				return nil, errors.New("unterminated quote")
+			}
+			word.WriteString(line[i+1 : i+1+j])
+			inWord = true
+			i += j + 2
+		default:
+			word.WriteByte(c)
+			inWord = true
+			i++
+		}
+	}
+	if inWord {
+		args = append(args, word.String())
+	}
+	return args, nil
+}
+
+// lineEditor reads lines from a terminal in raw mode with history and
+// completion.
+type lineEditor struct {
+	fd       int
+	in       *bufio.Reader
+	out      io.Writer
+	history  *[]string
+	complete func(line string) (string, []string)
+}
+
+// readLine reads a line after printing prompt. Returns io.EOF on Ctrl-D at
+// the start of an empty line.
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	restore, err := makeRaw(e.fd)
+	if err != nil {
+		return "", err
+	}
+	defer restore()
+
+	var buf []rune
+	pos := 0
+	hist, saved := len(*e.history), ""
+
+	redraw := func() {
+		fmt.Fprintf(e.out, "\r%s%s\x1b[K", prompt, string(buf))
+		if n := len(buf) - pos; n > 0 {
+			fmt.Fprintf(e.out, "\x1b[%dD", n)
+		}
+	}
+	recall := func(i int) {
+		if i < 0 || i > len(*e.history) {
+			return
+		}
+		if hist == len(*e.history) {
+			saved = string(buf)
+		}
+		hist = i
+		if i == len(*e.history) {
+			buf = []rune(saved)
+		} else {
+			buf = []rune((*e.history)[i])
+		}
+		pos = len(buf)
+		redraw()
+	}
+
+	fmt.Fprint(e.out, prompt)
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\n")
+			return string(buf), nil
+		case 1: // Ctrl-A
+			pos = 0
+			redraw()
+		case 3: // Ctrl-C
+			fmt.Fprint(e.out, "^C\n")
+			buf, pos = nil, 0
+			hist = len(*e.history)
+			fmt.Fprint(e.out, prompt)
+		case 4: // Ctrl-D
+			if len(buf) == 0 {
+				fmt.Fprint(e.out, "\n")
+				return "", io.EOF
+			} else if pos < len(buf) {
+				buf = append(buf[:pos], buf[pos+1:]...)
+				redraw()
+			}
+		case 5: // Ctrl-E
+			pos = len(buf)
+			redraw()
+		case 8, 127: // Backspace
+			if pos > 0 {
+				buf = append(buf[:pos-1], buf[pos:]...)
+				pos--
+				redraw()
+			}
+		case 21: // Ctrl-U
+			buf, pos = buf[pos:], 0
+			redraw()
+		case '\t':
+			line, candidates := e.complete(string(buf[:pos]))
+			if len(candidates) > 1 {
+				fmt.Fprintf(e.out, "\n%s\n", strings.Join(candidates, "  "))
+			}
+			head := []rune(line)
+			buf = append(head, buf[pos:]...)
+			pos = len(head)
+			redraw()
+		case 27: // Escape sequences for the arrow, home and end keys.
+			if r, _, _ := e.in.ReadRune(); r != '[' && r != 'O' {
+				continue
+			}
+			r, _, _ := e.in.ReadRune()
+			switch r {
+			case 'A':
+				recall(hist - 1)
+			case 'B':
+				recall(hist + 1)
+			case 'C':
+				if pos < len(buf) {
+					pos++
+					redraw()
+				}
+			case 'D':
+				if pos > 0 {
+					pos--
+					redraw()
+				}
+			case 'H':
+				pos = 0
+				redraw()
+			case 'F':
+				pos = len(buf)
+				redraw()
+			}
+		default:
+			if r >= ' ' {
+				buf = append(buf[:pos], append([]rune{r}, buf[pos:]...)...)
+				pos++
+				redraw()
+			}
+		}
+	}
+}
+
+// Usage returns the help message.
+func (cmd *ShellCommand) Usage() string {
+	return strings.TrimLeft(`
+usage: bolt shell [options] PATH
+
+Shell opens the database at PATH and reads commands from stdin. On a terminal,
+lines can be edited, earlier lines recalled with the up and down arrows and
+bucket names completed with tab.
+
+`+shellHelp+`
+Additional options include:
+
+	-history FILE
+		Reads and saves the history of commands in FILE. Defaults to
+		~/.bolt_history on a terminal.
+
+	-key-format FORMAT
+		Encoding of bucket names and keys: utf8, hex or base64.
+		Defaults to utf8.
+
+	-value-format FORMAT
+		Encoding of values: utf8, hex or base64. Defaults to utf8.
+
+	-read-only
+		Opens the database read-only with a shared lock.
+`, "\n")
+}
+
+// shellHelp describes the commands of the shell.
+const shellHelp = `Commands:
+
+	cd [PATH]             enter a bucket, or the top level without PATH
+	ls [PATH]             list nested buckets, ending with "/", and keys
+	pwd                   print the path of the current bucket
+	mkdir PATH            create a bucket and its parents
+	rmdir PATH            delete a bucket and everything in it
+	get KEY               print the value of a key
+	put KEY VALUE         set the value of a key
+	del KEY               delete a key
+	scan [-prefix KEY] [-from KEY] [-to KEY] [-limit N]
+	                      print keys and values, optionally those with a
+	                      prefix or in the range [from, to)
+	begin [-read-only]    open a transaction for the following commands
+	commit                commit the open transaction
+	rollback              discard the open transaction
+	history               print earlier commands
+	help                  print this help
+	exit                  leave the shell, discarding an open transaction
+
+Bucket paths join names with "/" and are relative to the current bucket
+unless they start with "/". ".." is the parent bucket. Arguments containing
+spaces can be quoted with '...', or with "..." to use Go escape sequences.
+Without begin, each command runs in its own transaction.
+`
@@ -0,0 +1,131 @@
+package main_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt/cmd/bolt"
+)
+
+// Ensure the shell navigates buckets, edits keys and scans ranges.
+func TestShellCommand(t *testing.T) {
+	db := MustOpen(0666, nil)
+	db.DB.Close()
+	defer db.Close()
+
+	m := NewMain()
+	m.Stdin.WriteString(strings.Join([]string{
+		"mkdir widgets/sub",
+		"get foo",
+		"cd widgets",
+		"put foo bar",
+		`put "a b" 'c d'`,
+		"put foo2 baz",
+		"put zoo 1",
+		"ls",
+		"get 'a b'",
+		"get missing",
+		"scan -prefix foo",
+		"scan -from b -to z",
+		"scan -limit 1",
+		"del foo",
+		"cd sub",
+		"pwd",
+		"cd ../..",
+		"cd missing",
+		"ls /widgets/sub",
+		"bogus",
+	}, "\n"))
+	if err := m.Run("shell", db.Path); err != nil {
+		t.Fatal(err)
+	}
+	if exp := strings.Join([]string{
+		"a b", "foo", "foo2", "sub/", "zoo",
+		"c d",
+		"foo bar", "foo2 baz",
+		"foo bar", "foo2 baz", "sub/",
+		"a b c d",
+		"/widgets/sub",
+		"",
+	}, "\n"); m.Stdout.String() != exp {
+		t.Fatalf("unexpected output:\n%s", m.Stdout.String())
+	}
+	if errs := strings.Split(strings.TrimSpace(m.Stderr.String()), "\n"); len(errs) != 4 ||
+		!strings.Contains(errs[0], "not in a bucket") ||
+		!strings.Contains(errs[1], "key not found") ||
+		!strings.Contains(errs[2], "bucket not found") ||
+		!strings.Contains(errs[3], "unknown command") {
+		t.Fatalf("unexpected errors:\n%s", m.Stderr.String())
+	}
+}
+
+// Ensure changes in a transaction are only visible once committed.
+func TestShellCommand_Transaction(t *testing.T) {
+	db := MustOpen(0666, nil)
+	db.DB.Close()
+	defer db.Close()
+
+	run := func(script string) (string, string) {
+		m := NewMain()
+		m.Stdin.WriteString(script)
+		if err := m.Run("shell", db.Path); err != nil {
+			t.Fatal(err)
+		}
+		return m.Stdout.String(), m.Stderr.String()
+	}
+
+	run("mkdir widgets\ncd widgets\nbegin\nput foo bar\nrollback\nbegin\nput baz bat\ncommit\nbegin\nput qux quux\n")
+	if out, errs := run("cd widgets\nls\nbegin -read-only\nput a b\ncommit\ncommit\n"); out != "baz\n" {
+		t.Fatalf("unexpected keys: %q", out)
+	} else if !strings.Contains(errs, "tx not writable") || !strings.Contains(errs, "no open transaction") {
+		t.Fatalf("unexpected errors: %q", errs)
+	}
+}
+
+// Ensure commands and bucket names are completed and history is saved.
+func TestShellCommand_Complete(t *testing.T) {
+	db := MustOpen(0666, nil)
+	db.DB.Close()
+	defer db.Close()
+
+	history := filepath.Join(t.TempDir(), "history")
+	m := NewMain()
+	m.Stdin.WriteString("mkdir widgets/sub\nmkdir widgets/sum\nmkdir wombats\nput\n")
+	if err := m.Run("shell", "-history", history, db.Path); err != nil {
+		t.Fatal(err)
+	} else if buf, err := ioutil.ReadFile(history); err != nil {
+		t.Fatal(err)
+	} else if exp := "mkdir widgets/sub\nmkdir widgets/sum\nmkdir wombats\nput\n"; string(buf) != exp {
+		t.Fatalf("unexpected history: %q", buf)
+	}
+
+	cmd := &main.ShellCommand{KeyFormat: "utf8", ValueFormat: "utf8"}
+	if err := cmd.Open(db.Path); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Close()
+	for _, tt := range []struct {
+		line, exp  string
+		candidates []string
+	}{
+		{"sc", "scan ", nil},
+		{"r", "r", []string{"rmdir ", "rollback "}},
+		{"cd w", "cd w", []string{"widgets/", "wombats/"}},
+		{"cd wi", "cd widgets/", nil},
+		{"ls widgets/s", "ls widgets/su", []string{"sub/", "sum/"}},
+		{"ls /widgets/sub", "ls /widgets/sub/", nil},
+		{"ls missing/", "ls missing/", nil},
+	} {
+		if line, candidates := cmd.Complete(tt.line); line != tt.exp || !reflect.DeepEqual(candidates, tt.candidates) {
+			t.Errorf("%q: unexpected completion: %q %q", tt.line, line, candidates)
+		}
+	}
+	if quit, err := cmd.Exec("cd", "widgets"); err != nil || quit {
+		t.Fatal(quit, err)
+	} else if line, _ := cmd.Complete("cd sum"); line != "cd sum/" {
+		t.Fatalf("unexpected completion: %q", line)
+	}
+}
@@ -0,0 +1,653 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"unsafe"
+
+	"github.com/boltdb/bolt"
+)
+
+// SurgeryCommand represents the "surgery" command execution.
+type SurgeryCommand struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	SrcPath string
+	DstPath string
+	DryRun  bool
+}
+
+// newSurgeryCommand returns a SurgeryCommand.
+func newSurgeryCommand(m *Main) *SurgeryCommand {
+	return &SurgeryCommand{
+		Stdin:  m.Stdin,
+		Stdout: m.Stdout,
+		Stderr: m.Stderr,
+	}
+}
+
+// Run executes the command.
+func (cmd *SurgeryCommand) Run(args ...string) error {
+	// Require an operation at the beginning.
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		fmt.Fprintln(cmd.Stderr, cmd.Usage())
+		return ErrUsage
+	}
+	op := args[0]
+
+	// Parse flags.
+	var pageID, fromID, toID int
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.StringVar(&cmd.DstPath, "o", "", "")
+	fs.BoolVar(&cmd.DryRun, "dry-run", false, "")
+	switch op {
+	case "revert-meta", "rebuild-freelist", "remove-bucket":
+	case "clear-page":
+		fs.IntVar(&pageID, "page", 0, "")
+	case "copy-page":
+		fs.IntVar(&fromID, "from", 0, "")
+		fs.IntVar(&toID, "to", 0, "")
+	default:
+		return ErrUnknownCommand
+	}
+	if err := fs.Parse(args[1:]); err == flag.ErrHelp {
+		fmt.Fprintln(cmd.Stderr, cmd.Usage())
+		return ErrUsage
+	} else if err != nil {
+		return err
+	} else if cmd.DstPath == "" && !cmd.DryRun {
+		return fmt.Errorf("output file required")
+	}
+
+	// Require database path.
+	cmd.SrcPath = fs.Arg(0)
+	if cmd.SrcPath == "" {
+		return ErrPathRequired
+	} else if _, err := os.Stat(cmd.SrcPath); os.IsNotExist(err) {
+		return ErrFileNotFound
+	}
+
+	// Determine the operation. Operations that can leave pages unreachable
+	// rebuild the freelist afterwards.
+	var fn func(f *surgeryFile) error
+	switch op {
+	case "revert-meta":
+		fn = (*surgeryFile).revertMeta
+	case "rebuild-freelist":
+		fn = (*surgeryFile).rebuildFreelist
+	case "clear-page":
+		if pageID == 0 {
+			return ErrPageIDRequired
+		}
+		fn = func(f *surgeryFile) error {
+			if err := f.clearPage(pgid(pageID)); err != nil {
+				return err
+			}
+			return f.rebuildFreelist()
+		}
+	case "copy-page":
+		if fromID == 0 || toID == 0 {
+			return ErrPageIDRequired
+		}
+		fn = func(f *surgeryFile) error {
+			if err := f.copyPage(pgid(fromID), pgid(toID)); err != nil {
+				return err
+			}
+			return f.rebuildFreelist()
+		}
+	case "remove-bucket":
+		if fs.NArg() < 2 {
+			return fmt.Errorf("bucket required")
+		}
+		var names [][]byte
+		for _, name := range fs.Args()[1:] {
+			names = append(names, []byte(name))
+		}
+		fn = func(f *surgeryFile) error {
+			if err := f.removeBucket(names); err != nil {
+				return err
+			}
+			return f.rebuildFreelist()
+		}
+	}
+
+	// Operate on a copy of the database. A dry run uses a temporary copy.
+	path := cmd.DstPath
+	if cmd.DryRun {
+		f, err := ioutil.TempFile("", "bolt-surgery-")
+		if err != nil {
+			return err
+		}
+		_ = f.Close()
+		path = f.Name()
+		defer os.Remove(path)
+	} else if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("destination exists: %s", path)
+	}
+	if err := cmd.edit(path, fn); err != nil {
+		// Remove the partially edited copy so that the surgery can be rerun.
+		if !cmd.DryRun {
+			_ = os.Remove(path)
+		}
+		return err
+	}
+
+	// Check the result.
+	if err := cmd.check(path); err != nil {
+		return err
+	}
+	if cmd.DryRun {
+		fmt.Fprintln(cmd.Stdout, "Dry run: no output written")
+	}
+	return nil
+}
+
+// edit copies the source database to path and applies fn to the copy.
+func (cmd *SurgeryCommand) edit(path string, fn func(*surgeryFile) error) error {
+	if err := copyFile(path, cmd.SrcPath); err != nil {
+		return err
+	}
+
+	f, err := openSurgeryFile(path, cmd.Stdout)
+	if err != nil {
+		return err
+	}
+	if err := fn(f); err != nil {
+		_ = f.close()
+		return err
+	}
+	return f.close()
+}
+
+// check opens the database at path and prints the errors found by checking
+// it. Returns ErrCorrupt if there are any.
+func (cmd *SurgeryCommand) check(path string) error {
+	db, err := bolt.Open(path, 0666, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("check: %s", err)
+	}
+	defer db.Close()
+
+	return db.View(func(tx *bolt.Tx) error {
+		var count int
+		for err := range tx.Check() {
+			fmt.Fprintln(cmd.Stdout, err)
+			count++
+		}
+		if count > 0 {
+			fmt.Fprintf(cmd.Stdout, "Check: %d errors found\n", count)
+			return ErrCorrupt
+		}
+		fmt.Fprintln(cmd.Stdout, "Check: OK")
+		return nil
+	})
+}
+
+// copyFile copies the file at src to dst, truncating dst if it exists.
+func copyFile(dst, src string) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	fi, err := r.Stat()
+	if err != nil {
+		return err
+	}
+
+	w, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// Usage returns the help message.
+func (cmd *SurgeryCommand) Usage() string {
+	return strings.TrimLeft(`
+usage: bolt surgery OPERATION [options] -o DST SRC [BUCKET...]
+
+Surgery copies the database at SRC path to DST path and edits the pages of
+the copy directly to help recover a damaged database. SRC is left untouched
+and DST must not exist. DST is removed if the operation fails. The copy is
+checked when done and any errors found are printed.
+
+The operations are:
+
+	revert-meta
+		Copy the older meta page over the newest one, discarding the
+		last committed transaction.
+
+	rebuild-freelist
+		Rewrite the freelist with every page that is not reachable from
+		the buckets of the newest meta page.
+
+	clear-page -page ID
+		Replace a page with an empty leaf page. Subtree counts of
+		ancestor pages are not updated.
+
+	copy-page -from ID -to ID
+		Copy a page, and its overflow pages, over another page.
+
+	remove-bucket BUCKET...
+		Remove the bucket at the path given by the names of the bucket
+		and its parents, without reading the pages of the bucket.
+
+Operations that can leave pages unreachable also rebuild the freelist.
+
+Additional options include:
+
+	-dry-run
+		Operate on a temporary copy and print the changes and the result
+		of the check without writing DST.
+`, "\n")
+}
+
+// surgeryFile is a database file edited page by page.
+type surgeryFile struct {
+	file     *os.File
+	w        io.Writer // receives a description of each change
+	pageSize int
+	metas    [2]*meta // valid meta pages, nil if invalid
+}
+
+// openSurgeryFile opens the database file at path for editing.
+func openSurgeryFile(path string, w io.Writer) (*surgeryFile, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	pageSize, metas, err := readMetas(file)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return &surgeryFile{file: file, w: w, pageSize: pageSize, metas: metas}, nil
+}
+
+// close syncs and closes the file.
+func (f *surgeryFile) close() error {
+	if err := f.file.Sync(); err != nil {
+		_ = f.file.Close()
+		return err
+	}
+	return f.file.Close()
+}
+
+// readMetas returns the page size and the valid meta pages of a database
+// file. The page size is taken from the first meta page if it is valid.
+// Otherwise the second meta page is looked for at common page sizes.
+func readMetas(r io.ReaderAt) (int, [2]*meta, error) {
+	var metas [2]*meta
+	pageSize := 0
+	if m := readMeta(r, 0); m != nil {
+		pageSize = int(m.pageSize)
+	} else {
+		for _, sz := range []int{os.Getpagesize(), 4096, 8192, 16384, 32768, 65536} {
+			if m := readMeta(r, int64(sz)); m != nil && int(m.pageSize) == sz {
+				pageSize = sz
+				break
+			}
+		}
+	}
+	if pageSize == 0 {
+		return 0, metas, fmt.Errorf("no valid meta page")
+	}
+	metas[0], metas[1] = readMeta(r, 0), readMeta(r, int64(pageSize))
+	return pageSize, metas, nil
+}
+
+// readMeta returns the meta page at the given offset or nil if it is not
+// valid.
+func readMeta(r io.ReaderAt, off int64) *meta {
+	buf := make([]byte, PageHeaderSize+int(unsafe.Sizeof(meta{})))
+	if _, err := r.ReadAt(buf, off); err != nil {
+		return nil
+	}
+	p := (*page)(unsafe.Pointer(&buf[0]))
+	m := (*meta)(unsafe.Pointer(&buf[PageHeaderSize]))
+	if p.flags&metaPageFlag == 0 || m.magic != magic || m.version != version || m.checksum != m.sum64() {
+		return nil
+	}
+	return m
+}
+
+// writeMeta writes a meta page to the given slot with a new checksum.
+func (f *surgeryFile) writeMeta(slot int, m *meta) error {
+	buf := make([]byte, f.pageSize)
+	p := (*page)(unsafe.Pointer(&buf[0]))
+	p.id, p.flags = pgid(slot), metaPageFlag
+	dst := (*meta)(unsafe.Pointer(&buf[PageHeaderSize]))
+	*dst = *m
+	dst.checksum = dst.sum64()
+	if _, err := f.file.WriteAt(buf, int64(slot*f.pageSize)); err != nil {
+		return err
+	}
+	f.metas[slot] = dst
+	return nil
+}
+
+// active returns the slot and contents of the meta page used by Open.
+func (f *surgeryFile) active() (int, *meta) {
+	return activeMeta(f.metas)
+}
+
+// activeMeta returns the slot and contents of the valid meta page with the
+// highest transaction id.
+func activeMeta(metas [2]*meta) (int, *meta) {
+	if metas[0] == nil || (metas[1] != nil && metas[1].txid > metas[0].txid) {
+		return 1, metas[1]
+	}
+	return 0, metas[0]
+}
+
+// readPage reads a page and its overflow pages below the high water mark.
+func (f *surgeryFile) readPage(id pgid) ([]byte, error) {
+	_, m := f.active()
+	if id < 2 || id >= m.pgid {
+		return nil, fmt.Errorf("page %d: %s", id, ErrPageNotFound)
+	}
+	buf := make([]byte, f.pageSize)
+	if _, err := f.file.ReadAt(buf, int64(id)*int64(f.pageSize)); err != nil {
+		return nil, err
+	}
+	p := (*page)(unsafe.Pointer(&buf[0]))
+	if p.id != id {
+		return nil, fmt.Errorf("page %d: page header has id %d", id, p.id)
+	} else if id+pgid(p.overflow) >= m.pgid {
+		return nil, fmt.Errorf("page %d: overflow past high water mark: %d", id, p.overflow)
+	} else if p.overflow == 0 {
+		return buf, nil
+	}
+
+	buf = make([]byte, (int(p.overflow)+1)*f.pageSize)
+	if _, err := f.file.ReadAt(buf, int64(id)*int64(f.pageSize)); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// writePage writes a page at the offset of the id in its header.
+func (f *surgeryFile) writePage(buf []byte) error {
+	p := (*page)(unsafe.Pointer(&buf[0]))
+	_, err := f.file.WriteAt(buf, int64(p.id)*int64(f.pageSize))
+	return err
+}
+
+// revertMeta copies the older meta page over the newest one.
+func (f *surgeryFile) revertMeta() error {
+	slot, m := f.active()
+	older := f.metas[1-slot]
+	if older == nil {
+		return fmt.Errorf("meta page %d is not valid", 1-slot)
+	}
+	if err := f.writeMeta(slot, older); err != nil {
+		return err
+	}
+	fmt.Fprintf(f.w, "Reverted meta page %d from txid %d to txid %d\n", slot, m.txid, older.txid)
+	return nil
+}
+
+// rebuildFreelist writes a new freelist at the end of the file containing
+// every page that is not reachable from the newest meta page. Both meta
+// pages are updated to use it.
+func (f *surgeryFile) rebuildFreelist() error {
+	_, m := f.active()
+	reached := map[pgid]bool{0: true, 1: true}
+	f.reach(m.root.root, reached)
+
+	var ids []pgid
+	for id := pgid(2); id < m.pgid; id++ {
+		if !reached[id] {
+			ids = append(ids, id)
+		}
+	}
+
+	// Write the ids after the page header. Counts that do not fit in the
+	// header are stored in the first element.
+	n := len(ids)
+	size := PageHeaderSize + n*int(unsafe.Sizeof(pgid(0)))
+	if n >= 0xFFFF {
+		size += int(unsafe.Sizeof(pgid(0)))
+	}
+	count := (size + f.pageSize - 1) / f.pageSize
+	buf := make([]byte, count*f.pageSize)
+	p := (*page)(unsafe.Pointer(&buf[0]))
+	p.id, p.flags, p.overflow = m.pgid, freelistPageFlag, uint32(count-1)
+	data := (*[maxAllocSize]pgid)(unsafe.Pointer(&p.ptr))
+	if n < 0xFFFF {
+		p.count = uint16(n)
+		copy(data[:n], ids)
+	} else {
+		p.count = 0xFFFF
+		data[0] = pgid(n)
+		copy(data[1:n+1], ids)
+	}
+	if err := f.writePage(buf); err != nil {
+		return err
+	}
+
+	next := *m
+	next.freelist, next.pgid = p.id, p.id+pgid(count)
+	for slot := range f.metas {
+		if err := f.writeMeta(slot, &next); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(f.w, "Rebuilt freelist with %d free pages at page %d\n", n, p.id)
+	return nil
+}
+
+// reach marks a page, its overflow pages and the pages below it as reached.
+// Pages that cannot be read are marked but not descended into.
+func (f *surgeryFile) reach(id pgid, reached map[pgid]bool) {
+	if reached[id] {
+		return
+	}
+	reached[id] = true
+	buf, err := f.readPage(id)
+	if err != nil {
+		fmt.Fprintf(f.w, "warning: %s: pages below it are not reached\n", err)
+		return
+	}
+	p := (*page)(unsafe.Pointer(&buf[0]))
+	for i := pgid(1); i <= pgid(p.overflow); i++ {
+		reached[id+i] = true
+	}
+	if msg := p.layoutError(len(buf)); msg != "" {
+		fmt.Fprintf(f.w, "warning: page %d: %s: pages below it are not reached\n", id, msg)
+		return
+	}
+
+	switch {
+	case p.flags&branchPageFlag != 0:
+		for i := uint16(0); i < p.count; i++ {
+			f.reach(p.branchPageElement(i).pgid, reached)
+		}
+	case p.flags&leafPageFlag != 0:
+		for i := uint16(0); i < p.count; i++ {
+			e := p.leafPageElement(i)
+			if e.flags&bucketLeafFlag == 0 || len(e.value()) < int(unsafe.Sizeof(bucket{})) {
+				continue
+			}
+			if b := (*bucket)(unsafe.Pointer(&e.value()[0])); b.root != 0 {
+				f.reach(b.root, reached)
+			}
+		}
+	default:
+		fmt.Fprintf(f.w, "warning: page %d: invalid page type: %s: pages below it are not reached\n", id, p.Type())
+	}
+}
+
+// clearPage replaces a page with an empty leaf page.
+func (f *surgeryFile) clearPage(id pgid) error {
+	if _, m := f.active(); id < 2 || id >= m.pgid {
+		return fmt.Errorf("page %d: %s", id, ErrPageNotFound)
+	}
+	buf := make([]byte, f.pageSize)
+	p := (*page)(unsafe.Pointer(&buf[0]))
+	p.id, p.flags = id, leafPageFlag
+	if err := f.writePage(buf); err != nil {
+		return err
+	}
+	fmt.Fprintf(f.w, "Cleared page %d\n", id)
+	return nil
+}
+
+// copyPage copies a page and its overflow pages over another page.
+func (f *surgeryFile) copyPage(from, to pgid) error {
+	if from == to {
+		return fmt.Errorf("cannot copy page %d onto itself", from)
+	}
+	buf, err := f.readPage(from)
+	if err != nil {
+		return err
+	}
+	p := (*page)(unsafe.Pointer(&buf[0]))
+	if _, m := f.active(); to < 2 || to+pgid(p.overflow) >= m.pgid {
+		return fmt.Errorf("page %d: %s", to, ErrPageNotFound)
+	}
+	p.id = to
+	if err := f.writePage(buf); err != nil {
+		return err
+	}
+	fmt.Fprintf(f.w, "Copied page %d to page %d (%d pages)\n", from, to, p.overflow+1)
+	return nil
+}
+
+// surgeryRef is a branch element on the path to a leaf page.
+type surgeryRef struct {
+	id    pgid
+	index int
+}
+
+// removeBucket removes the element of a bucket from its parent's leaf page
+// and decrements the subtree counts of counted pages above it. The pages of
+// the bucket are not read.
+func (f *surgeryFile) removeBucket(names [][]byte) error {
+	_, m := f.active()
+	id := m.root.root
+	for i, name := range names {
+		var refs []surgeryRef
+		for {
+			buf, err := f.readPage(id)
+			if err != nil {
+				return err
+			}
+			p := (*page)(unsafe.Pointer(&buf[0]))
+			if msg := p.layoutError(len(buf)); msg != "" {
+				return fmt.Errorf("page %d: %s", id, msg)
+			}
+
+			// Descend into the last child with a key not after the name.
+			if p.flags&branchPageFlag != 0 {
+				if p.count == 0 {
+					return fmt.Errorf("page %d: empty branch page", id)
+				}
+				index := sort.Search(int(p.count), func(i int) bool {
+					return bytes.Compare(p.branchPageElement(uint16(i)).key(), name) > 0
+				})
+				if index > 0 {
+					index--
+				}
+				refs = append(refs, surgeryRef{id: id, index: index})
+				id = p.branchPageElement(uint16(index)).pgid
+				continue
+			} else if p.flags&leafPageFlag == 0 {
+				return fmt.Errorf("page %d: invalid page type: %s", id, p.Type())
+			}
+
+			index := sort.Search(int(p.count), func(i int) bool {
+				return bytes.Compare(p.leafPageElement(uint16(i)).key(), name) >= 0
+			})
+			if index == int(p.count) || !bytes.Equal(p.leafPageElement(uint16(index)).key(), name) ||
+				p.leafPageElement(uint16(index)).flags&bucketLeafFlag == 0 {
+				return fmt.Errorf("bucket not found: %s", formatPath(names[:i+1]))
+			}
+
+			// Remove the last bucket from the page.
+			if i == len(names)-1 {
+				if err := f.removeElement(buf, index); err != nil {
+					return err
+				}
+				if err := f.decrementCounts(refs); err != nil {
+					return err
+				}
+				fmt.Fprintf(f.w, "Removed bucket %s from page %d\n", formatPath(names), id)
+				return nil
+			}
+
+			// Inline buckets have no nested buckets.
+			v := p.leafPageElement(uint16(index)).value()
+			if len(v) < int(unsafe.Sizeof(bucket{})) {
+				return fmt.Errorf("page %d: bucket header too short: %d bytes", id, len(v))
+			} else if id = (*bucket)(unsafe.Pointer(&v[0])).root; id == 0 {
+				return fmt.Errorf("bucket not found: %s", formatPath(names[:i+2]))
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// removeElement rewrites a leaf page without the element at index.
+func (f *surgeryFile) removeElement(buf []byte, index int) error {
+	p := (*page)(unsafe.Pointer(&buf[0]))
+	out := make([]byte, len(buf))
+	q := (*page)(unsafe.Pointer(&out[0]))
+	q.id, q.flags, q.count, q.overflow = p.id, p.flags, p.count-1, p.overflow
+
+	// Write the elements followed by their keys and values.
+	off := PageHeaderSize + int(q.count)*leafPageElementSize
+	var j uint16
+	for i := uint16(0); i < p.count; i++ {
+		if int(i) == index {
+			continue
+		}
+		e := p.leafPageElement(i)
+		k, v := e.key(), e.value()
+		elem := q.leafPageElement(j)
+		elem.flags = e.flags
+		elem.pos = uint32(off - (PageHeaderSize + int(j)*leafPageElementSize))
+		elem.ksize, elem.vsize = uint32(len(k)), uint32(len(v))
+		off += copy(out[off:], k)
+		off += copy(out[off:], v)
+		j++
+	}
+	return f.writePage(out)
+}
+
+// decrementCounts decrements the subtree count of each branch element on a
+// path through counted pages.
+func (f *surgeryFile) decrementCounts(refs []surgeryRef) error {
+	for _, ref := range refs {
+		buf, err := f.readPage(ref.id)
+		if err != nil {
+			return err
+		}
+		p := (*page)(unsafe.Pointer(&buf[0]))
+		if p.flags&countedPageFlag == 0 {
+			continue
+		}
+		if counts := p.branchPageCounts(); counts[ref.index] > 0 {
+			counts[ref.index]--
+		}
+		if err := f.writePage(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package main_test
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/boltdb/bolt/cmd/bolt"
+)
+
+// Ensure the "surgery remove-bucket" command removes a nested bucket and
+// frees its pages.
+func TestSurgeryCommand_Run_RemoveBucket(t *testing.T) {
+	db := MustOpen(0666, &bolt.Options{OrderStatistics: true})
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			child, err := b.CreateBucket([]byte(fmt.Sprintf("child%04d", i)))
+			if err != nil {
+				return err
+			}
+			if err := child.Put([]byte("foo"), []byte("bar")); err != nil {
+				return err
+			}
+		}
+		big, err := b.Bucket([]byte("child0500")).CreateBucket([]byte("big"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := big.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.DB.Close()
+	defer db.Close()
+
+	// A dry run does not write the output.
+	dst := db.Path + ".out"
+	defer os.Remove(dst)
+	m := NewMain()
+	if err := m.Run("surgery", "remove-bucket", "-dry-run", "-o", dst, db.Path, "widgets", "child0500"); err != nil {
+		t.Fatal(err)
+	} else if out := m.Stdout.String(); !strings.Contains(out, `Removed bucket "widgets"/"child0500"`) ||
+		!strings.Contains(out, "Check: OK\nDry run") {
+		t.Fatalf("unexpected output: %s", out)
+	} else if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Fatal("expected no output file")
+	}
+
+	m = NewMain()
+	if err := m.Run("surgery", "remove-bucket", "-o", dst, db.Path, "widgets", "child0500"); err != nil {
+		t.Fatal(err)
+	} else if !strings.HasSuffix(m.Stdout.String(), "Check: OK\n") {
+		t.Fatalf("unexpected output: %s", m.Stdout.String())
+	}
+
+	d, err := bolt.Open(dst, 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if err := d.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if b.Bucket([]byte("child0500")) != nil {
+			t.Fatal("expected bucket to be removed")
+		} else if n := b.Count(); n != 999 {
+			t.Fatalf("unexpected count: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Missing buckets are reported.
+	if err := NewMain().Run("surgery", "remove-bucket", "-dry-run", db.Path, "widgets", "missing"); err == nil ||
+		err.Error() != `bucket not found: "widgets"/"missing"` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// A failed operation leaves no output behind.
+	dst = db.Path + ".failed"
+	defer os.Remove(dst)
+	if err := NewMain().Run("surgery", "remove-bucket", "-o", dst, db.Path, "widgets", "missing"); err == nil {
+		t.Fatal("expected error")
+	} else if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Fatalf("unexpected destination: %v", err)
+	}
+}
+
+// Ensure the "surgery revert-meta" command discards the last transaction.
+func TestSurgeryCommand_Run_RevertMeta(t *testing.T) {
+	db := MustOpen(0666, nil)
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).Put([]byte("foo"), []byte("bar"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.DB.Close()
+	defer db.Close()
+
+	dst := db.Path + ".out"
+	defer os.Remove(dst)
+	m := NewMain()
+	if err := m.Run("surgery", "revert-meta", "-o", dst, db.Path); err != nil {
+		t.Fatal(err)
+	} else if exp := "Reverted meta page 1 from txid 3 to txid 2\nCheck: OK\n"; m.Stdout.String() != exp {
+		t.Fatalf("unexpected output: %s", m.Stdout.String())
+	}
+
+	d, err := bolt.Open(dst, 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if err := d.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte("widgets")).Get([]byte("foo")); v != nil {
+			t.Fatalf("unexpected value: %s", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The destination must not exist.
+	if err := NewMain().Run("surgery", "revert-meta", "-o", dst, db.Path); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+// Ensure the "surgery clear-page" command empties a corrupted page and frees
+// the pages below it.
+func TestSurgeryCommand_Run_ClearPage(t *testing.T) {
+	db := MustOpen(0666, nil)
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var root int
+	if err := db.View(func(tx *bolt.Tx) error {
+		root = int(tx.Bucket([]byte("widgets")).Root())
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	pageSize := db.Info().PageSize
+	db.DB.Close()
+	defer db.Close()
+
+	// Clear the flags of the bucket's root page.
+	f, err := os.OpenFile(db.Path, os.O_RDWR, 0666)
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := f.WriteAt([]byte{0, 0}, int64(root*pageSize+8)); err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reaching the corrupted page is reported and the check fails.
+	m := NewMain()
+	if err := m.Run("surgery", "rebuild-freelist", "-dry-run", db.Path); err != main.ErrCorrupt {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !strings.Contains(m.Stdout.String(), fmt.Sprintf("warning: page %d: invalid page type", root)) {
+		t.Fatalf("unexpected output: %s", m.Stdout.String())
+	}
+
+	dst := db.Path + ".out"
+	defer os.Remove(dst)
+	m = NewMain()
+	if err := m.Run("surgery", "clear-page", "-page", fmt.Sprint(root), "-o", dst, db.Path); err != nil {
+		t.Fatalf("unexpected error: %v: %s", err, m.Stdout.String())
+	}
+
+	d, err := bolt.Open(dst, 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if err := d.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if k, _ := b.Cursor().First(); k != nil {
+			t.Fatalf("unexpected key: %s", k)
+		}
+		return b.Put([]byte("foo"), []byte("bar"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pages cannot be copied onto themselves.
+	if err := NewMain().Run("surgery", "copy-page", "-from", "3", "-to", "3", "-dry-run", db.Path); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package main
+
+import "errors"
+
+// isTerminal returns false as line editing is not supported on this platform.
+func isTerminal(fd int) bool { return false }
+
+// makeRaw returns an error as line editing is not supported on this platform.
+func makeRaw(fd int) (func(), error) {
+	return nil, errors.New("raw terminal mode not supported")
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+	"unsafe"
+)
+
+// TopCommand represents the "top" command execution.
+type TopCommand struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	Path     string
+	Interval time.Duration
+	Count    int
+	JSON     bool
+}
+
+// newTopCommand returns a TopCommand.
+func newTopCommand(m *Main) *TopCommand {
+	return &TopCommand{
+		Stdin:  m.Stdin,
+		Stdout: m.Stdout,
+		Stderr: m.Stderr,
+	}
+}
+
+// Run executes the command.
+func (cmd *TopCommand) Run(args ...string) error {
+	// Parse flags.
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.DurationVar(&cmd.Interval, "interval", time.Second, "")
+	fs.IntVar(&cmd.Count, "n", 0, "")
+	fs.BoolVar(&cmd.JSON, "json", false, "")
+	if err := fs.Parse(args); err == flag.ErrHelp {
+		fmt.Fprintln(cmd.Stderr, cmd.Usage())
+		return ErrUsage
+	} else if err != nil {
+		return err
+	} else if cmd.Interval <= 0 {
+		return fmt.Errorf("interval must be positive")
+	}
+
+	// Require database path.
+	path, err := requirePath(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	cmd.Path = path
+
+	// Open the file without bolt.Open so that no lock is taken and a
+	// running writer is neither blocked nor blocks us.
+	f, err := os.Open(cmd.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Clear the screen between samples when writing to a terminal.
+	refresh := false
+	if out, ok := cmd.Stdout.(*os.File); ok && !cmd.JSON {
+		refresh = isTerminal(int(out.Fd()))
+	}
+
+	enc := json.NewEncoder(cmd.Stdout)
+	var prev *topSample
+	for i := 0; cmd.Count == 0 || i < cmd.Count; i++ {
+		if i > 0 {
+			time.Sleep(cmd.Interval)
+		}
+		s, err := readTopSample(f)
+		if err != nil {
+			return err
+		}
+		s.rates(prev)
+		prev = s
+
+		if cmd.JSON {
+			if err := enc.Encode(s); err != nil {
+				return err
+			}
+			continue
+		}
+		if refresh {
+			fmt.Fprint(cmd.Stdout, "\x1b[H\x1b[2J")
+		} else if i > 0 {
+			fmt.Fprintln(cmd.Stdout)
+		}
+		cmd.print(s)
+	}
+	return nil
+}
+
+// print writes a sample as text.
+func (cmd *TopCommand) print(s *topSample) {
+	fmt.Fprintf(cmd.Stdout, "%s  %s\n", cmd.Path, s.Time.Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(cmd.Stdout, "Transaction ID: %d (%+.1f/s)\n", s.TxID, s.TxRate)
+	fmt.Fprintf(cmd.Stdout, "Meta page: %d\n", s.Meta)
+	fmt.Fprintf(cmd.Stdout, "File size: %d bytes (%+.0f bytes/s)\n", s.FileSize, s.GrowthRate)
+	fmt.Fprintf(cmd.Stdout, "Page size: %d\n", s.PageSize)
+	fmt.Fprintf(cmd.Stdout, "Pages: %d (%d in use, %d free, %d unallocated)\n", s.Pages+s.UnallocatedPages, s.Pages-s.FreePages, s.FreePages, s.UnallocatedPages)
+	fmt.Fprintf(cmd.Stdout, "Freelist: %d free pages in %d pages\n", s.FreePages, s.FreelistPages)
+}
+
+// Usage returns the help message.
+func (cmd *TopCommand) Usage() string {
+	return strings.TrimLeft(`
+usage: bolt top [options] PATH
+
+Top periodically reads the meta and freelist pages of the database at PATH and
+reports the transaction id, the file size, the number of pages in use, free
+and not yet allocated, the size of the freelist and how fast transactions are
+committed and the file grows. The view is refreshed on a terminal.
+
+The file is read without taking a lock, so a process writing to the database
+is not blocked. The active meta page is chosen by its checksum. If the
+freelist is rewritten while it is read, the sample is read again.
+
+Additional options include:
+
+	-interval DURATION
+		Time between samples. Defaults to 1s.
+
+	-n COUNT
+		Exits after COUNT samples. Defaults to running until interrupted.
+
+	-json
+		Writes one JSON object per sample instead of a refreshing view.
+`, "\n")
+}
+
+// topSample is a snapshot of the meta and freelist pages of a database file.
+type topSample struct {
+	Time             time.Time `json:"time"`
+	TxID             uint64    `json:"txid"`
+	Meta             int       `json:"meta"`
+	FileSize         int64     `json:"file_size"`
+	PageSize         int       `json:"page_size"`
+	Pages            uint64    `json:"pages"`
+	FreePages        uint64    `json:"free_pages"`
+	FreelistPages    int       `json:"freelist_pages"`
+	UnallocatedPages uint64    `json:"unallocated_pages"`
+	TxRate           float64   `json:"tx_per_sec"`
+	GrowthRate       float64   `json:"bytes_per_sec"`
+}
+
+// rates sets the transaction and growth rates since the previous sample.
+func (s *topSample) rates(prev *topSample) {
+	if prev == nil {
+		return
+	}
+	if sec := s.Time.Sub(prev.Time).Seconds(); sec > 0 {
+		s.TxRate = float64(s.TxID-prev.TxID) / sec
+		s.GrowthRate = float64(s.FileSize-prev.FileSize) / sec
+	}
+}
+
+// readTopSample reads a sample from a database file that may be written
+// concurrently. The freelist page is checked against the meta page and the
+// sample is read again if a commit happened in between.
+func readTopSample(f *os.File) (*topSample, error) {
+	var err error
+	for attempt := 0; attempt < 10; attempt++ {
+		var s *topSample
+		if s, err = readTopSampleOnce(f); err == nil {
+			return s, nil
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return nil, err
+}
+
+// readTopSampleOnce reads a sample without retrying.
+func readTopSampleOnce(f *os.File) (*topSample, error) {
+	now := time.Now()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	pageSize, metas, err := readMetas(f)
+	if err != nil {
+		return nil, err
+	}
+	slot, m := activeMeta(metas)
+
+	s := &topSample{
+		Time:     now,
+		TxID:     uint64(m.txid),
+		Meta:     slot,
+		FileSize: info.Size(),
+		PageSize: pageSize,
+		Pages:    uint64(m.pgid),
+	}
+	if n := uint64(info.Size()) / uint64(pageSize); n > s.Pages {
+		s.UnallocatedPages = n - s.Pages
+	}
+
+	// Read the freelist header and, if the count overflows, its first
+	// element.
+	buf := make([]byte, PageHeaderSize+8)
+	if _, err := f.ReadAt(buf, int64(m.freelist)*int64(pageSize)); err != nil {
+		return nil, fmt.Errorf("read freelist: %s", err)
+	}
+	p := (*page)(unsafe.Pointer(&buf[0]))
+	if p.id != m.freelist || p.flags&freelistPageFlag == 0 {
+		return nil, fmt.Errorf("freelist page %d: invalid page header", m.freelist)
+	}
+	s.FreePages, s.FreelistPages = uint64(p.count), int(p.overflow)+1
+	if p.count == 0xFFFF {
+		s.FreePages = *(*uint64)(unsafe.Pointer(&buf[PageHeaderSize]))
+	}
+
+	// Discard the sample if the meta page changed while reading.
+	if _, again := activeMeta([2]*meta{readMeta(f, 0), readMeta(f, int64(pageSize))}); again == nil || again.txid != m.txid {
+		return nil, fmt.Errorf("database changed while reading")
+	}
+	return s, nil
+}
@@ -0,0 +1,106 @@
+package main_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// Ensure top reads a database that is held open by a writer.
+func TestTopCommand(t *testing.T) {
+	db := MustOpen(0666, nil)
+	defer db.Close()
+	update := func(i int) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+			if err != nil {
+				return err
+			}
+			return b.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 1000))
+		})
+	}
+	for i := 0; i < 100; i++ {
+		if err := update(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type sample struct {
+		TxID      uint64  `json:"txid"`
+		FileSize  int64   `json:"file_size"`
+		Pages     uint64  `json:"pages"`
+		FreePages uint64  `json:"free_pages"`
+		TxRate    float64 `json:"tx_per_sec"`
+	}
+	m := NewMain()
+	if err := m.Run("top", "-json", "-n", "1", db.Path); err != nil {
+		t.Fatal(err)
+	}
+	var s sample
+	if err := json.Unmarshal(m.Stdout.Bytes(), &s); err != nil {
+		t.Fatal(err)
+	}
+	stats := db.Stats()
+	if err := db.View(func(tx *bolt.Tx) error {
+		if uint64(tx.ID()) != s.TxID {
+			t.Fatalf("unexpected txid: %d, expected %d", s.TxID, tx.ID())
+		} else if n := uint64(stats.FreePageN + stats.PendingPageN); s.FreePages != n {
+			t.Fatalf("unexpected free pages: %d, expected %d", s.FreePages, n)
+		} else if s.Pages == 0 || s.FileSize < int64(s.Pages)*int64(db.Info().PageSize) {
+			t.Fatalf("unexpected size: %+v", s)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Samples are taken while the database is written.
+	var wg sync.WaitGroup
+	var updateErr error
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 100; updateErr == nil; i++ {
+			select {
+			case <-done:
+				return
+			default:
+				updateErr = update(i)
+			}
+		}
+	}()
+	m = NewMain()
+	err := m.Run("top", "-json", "-n", "3", "-interval", "20ms", db.Path)
+	close(done)
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	} else if updateErr != nil {
+		t.Fatal(updateErr)
+	}
+	lines := strings.Split(strings.TrimSpace(m.Stdout.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("unexpected output: %s", m.Stdout.String())
+	}
+	var first, last sample
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatal(err)
+	} else if err := json.Unmarshal([]byte(lines[2]), &last); err != nil {
+		t.Fatal(err)
+	} else if last.TxID <= first.TxID || last.TxRate <= 0 {
+		t.Fatalf("unexpected samples: %+v, %+v", first, last)
+	}
+
+	// Text output describes each sample.
+	m = NewMain()
+	if err := m.Run("top", "-n", "2", "-interval", "1ms", db.Path); err != nil {
+		t.Fatal(err)
+	} else if out := m.Stdout.String(); strings.Count(out, "Transaction ID: ") != 2 || !strings.Contains(out, "Freelist: ") {
+		t.Fatalf("unexpected output: %s", out)
+	}
+}
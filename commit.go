@@ -0,0 +1,180 @@
+package bolt
+
+// PendingCommit is a transaction that was committed with CommitAsync(). Its
+// changes are visible to new transactions but may not be on disk yet.
+type PendingCommit struct {
+	txid     txid
+	meta     meta
+	prev     *PendingCommit
+	handlers []func()
+	done     chan struct{}
+	err      error
+}
+
+// ID returns the id of the committed transaction.
+func (c *PendingCommit) ID() int {
+	return int(c.txid)
+}
+
+// Done returns a channel that is closed once the commit is durable or has
+// failed.
+func (c *PendingCommit) Done() <-chan struct{} {
+	return c.done
+}
+
+// Wait blocks until the commit is durable. Returns an error if the commit or
+// an earlier asynchronous commit could not be written.
+func (c *PendingCommit) Wait() error {
+	<-c.done
+	return c.err
+}
+
+// CommitAsync writes all changes to disk and returns once the next write
+// transaction can begin. The data pages are synced and the meta page is
+// written in the background. Use the returned PendingCommit to wait until
+// the commit is durable.
+//
+// Asynchronous commits become durable in the order they were made and
+// Commit(), Sync() and Close() wait for all of them first. Pages freed by a
+// commit are not reused until it is durable. Handlers registered with
+// OnCommit() run once the commit is durable.
+//
+// If an asynchronous commit cannot be written then it and every later commit
+// fail with the same error, even though their changes were visible to other
+// transactions. The database should be closed; reopening it discards the
+// failed commits.
+func (tx *Tx) CommitAsync() (_ *PendingCommit, err error) {
+	_assert(!tx.managed, "managed tx commit not allowed")
+	if tx.db == nil {
+		return nil, ErrTxClosed
+	} else if !tx.writable {
+		return nil, ErrTxNotWritable
+	} else if tx.optimistic {
+		rwtx, err := tx.commitOptimistic()
+		if err != nil {
+			return nil, err
+		} else if rwtx == nil {
+			c := &PendingCommit{done: make(chan struct{})}
+			close(c.done)
+			return c, nil
+		}
+		return rwtx.CommitAsync()
+	}
+
+	defer tx.startCommit()(&err)
+	defer tx.abortCorrupted(&err)
+
+	// Write dirty pages without waiting for them to reach the disk.
+	if err := tx.commitPages(false); err != nil {
+		return nil, err
+	}
+
+	db := tx.db
+	c := &PendingCommit{
+		txid:     tx.meta.txid,
+		meta:     *tx.meta,
+		handlers: tx.commitHandlers,
+		done:     make(chan struct{}),
+	}
+
+	// Publish the new meta before releasing the writer lock so that the next
+	// transaction builds on this one.
+	db.synclock.Lock()
+	c.prev = db.lastCommit
+	db.lastCommit = c
+	db.pendingMeta = &c.meta
+	db.synclock.Unlock()
+
+	tx.close()
+	go c.sync(db)
+
+	return c, nil
+}
+
+// sync waits for the previous asynchronous commit, syncs the data pages and
+// then writes and syncs the meta page.
+func (c *PendingCommit) sync(db *DB) {
+	if c.prev != nil {
+		c.err = c.prev.Wait()
+		c.prev = nil
+	}
+	if c.err == nil {
+		if c.err = c.write(db); c.err != nil {
+			db.logger().Error("asynchronous commit failed", "txid", c.txid, "err", c.err)
+		}
+	}
+
+	db.synclock.Lock()
+	if c.err == nil {
+		db.durable = c.txid
+	}
+	if db.pendingMeta == &c.meta {
+		db.pendingMeta = nil
+	}
+	db.synclock.Unlock()
+	close(c.done)
+
+	// Handlers run after the commit is resolved so that they may start
+	// transactions of their own.
+	if c.err == nil {
+		for _, fn := range c.handlers {
+			fn()
+		}
+	}
+}
+
+// write syncs the data pages and writes the meta page to disk.
+func (c *PendingCommit) write(db *DB) (err error) {
+	ev := db.traceStart(TraceEvent{Name: TraceWriteMeta, TxID: int(c.txid), Writable: true})
+	defer func() { db.traceEnd(ev, err) }()
+
+	if !db.NoSync || IgnoreNoSync {
+		if err := db.syncData(); err != nil {
+			return err
+		}
+	}
+
+	// Write a copy of the meta so that transactions reading it are not
+	// affected by the checksum being set.
+	m := c.meta
+	buf := make([]byte, db.pageSize)
+	p := db.pageInBuffer(buf, 0)
+	m.write(p)
+	if _, err := db.ops.writeAt(buf, int64(p.id)*int64(db.pageSize)); err != nil {
+		return err
+	}
+	if !db.NoSync || IgnoreNoSync {
+		if err := db.syncData(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitCommits blocks until all asynchronous commits are durable. Returns the
+// error of the first commit that failed.
+func (db *DB) waitCommits() error {
+	db.synclock.Lock()
+	c := db.lastCommit
+	db.synclock.Unlock()
+
+	if c == nil {
+		return nil
+	}
+	return c.Wait()
+}
+
+// setDurable records the id of the last transaction written to disk.
+func (db *DB) setDurable(id txid) {
+	db.synclock.Lock()
+	db.durable = id
+	db.synclock.Unlock()
+}
+
+// durableTxid returns the id of the newest durable commit. Pages freed by
+// later commits cannot be reused until their meta page has been synced.
+func (db *DB) durableTxid() txid {
+	db.synclock.Lock()
+	defer db.synclock.Unlock()
+	return db.durable
+}
@@ -0,0 +1,226 @@
+package bolt_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// Ensure that an asynchronous commit is visible immediately and durable once
+// it resolves.
+func TestTx_CommitAsync(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := tx.CreateBucket([]byte("widgets"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Put([]byte("foo"), []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	id := tx.ID()
+
+	committed := make(chan struct{})
+	tx.OnCommit(func() { close(committed) })
+	c, err := tx.CommitAsync()
+	if err != nil {
+		t.Fatal(err)
+	} else if c.ID() != id {
+		t.Fatalf("unexpected id: %d", c.ID())
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		if tx.ID() != id {
+			t.Fatalf("unexpected id: %d", tx.ID())
+		} else if v := tx.Bucket([]byte("widgets")).Get([]byte("foo")); string(v) != "bar" {
+			t.Fatalf("unexpected value: %q", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	<-c.Done()
+	<-committed
+}
+
+// Ensure that a chain of asynchronous commits is durable after Sync and
+// survives reopening the database.
+func TestTx_CommitAsync_Pipeline(t *testing.T) {
+	db := MustOpenDB()
+	path := db.Path()
+	defer os.Remove(path)
+
+	var commits []*bolt.PendingCommit
+	for i := 0; i < 100; i++ {
+		tx, err := db.Begin(true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Replace earlier keys so that freed pages are available for reuse.
+		if err := b.Delete(u64tob(uint64(i - 1))); err != nil {
+			t.Fatal(err)
+		}
+		for j := 0; j < 100; j++ {
+			if err := b.Put(u64tob(uint64(i*1000+j)), make([]byte, 100)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		c, err := tx.CommitAsync()
+		if err != nil {
+			t.Fatal(err)
+		}
+		commits = append(commits, c)
+	}
+
+	// A synchronous commit waits for earlier asynchronous commits.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).Put([]byte("last"), []byte("1"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range commits {
+		select {
+		case <-c.Done():
+		default:
+			t.Fatalf("commit %d not resolved", i)
+		}
+		if err := c.Wait(); err != nil {
+			t.Fatal(err)
+		} else if i > 0 && c.ID() != commits[i-1].ID()+1 {
+			t.Fatalf("unexpected id: %d", c.ID())
+		}
+	}
+	if err := db.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	db.MustCheck()
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := bolt.Open(path, 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db = &DB{reopened}
+	defer db.MustClose()
+	if err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if n := b.Stats().KeyN; n != 100*100-99+1 {
+			t.Fatalf("unexpected KeyN: %d", n)
+		} else if v := b.Get([]byte("last")); string(v) != "1" {
+			t.Fatalf("unexpected value: %q", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that commit handlers of an asynchronous commit can start
+// transactions.
+func TestTx_CommitAsync_OnCommitUpdate(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	done := make(chan error, 1)
+	tx, err := db.Begin(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.CreateBucket([]byte("widgets")); err != nil {
+		t.Fatal(err)
+	}
+	tx.OnCommit(func() {
+		done <- db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket([]byte("widgets")).Put([]byte("foo"), []byte("bar"))
+		})
+	})
+	if _, err := tx.CommitAsync(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that an optimistic transaction can be committed asynchronously.
+func TestTx_CommitAsync_Optimistic(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	tx, err := db.BeginOptimistic()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.CreateBucket([]byte("widgets")); err != nil {
+		t.Fatal(err)
+	}
+	c, err := tx.CommitAsync()
+	if err != nil {
+		t.Fatal(err)
+	} else if err := c.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Read-only optimistic transactions resolve immediately.
+	tx, err = db.BeginOptimistic()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.Bucket([]byte("widgets")) == nil {
+		t.Fatal("expected bucket")
+	}
+	c, err = tx.CommitAsync()
+	if err != nil {
+		t.Fatal(err)
+	} else if err := c.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that committing a read-only transaction asynchronously returns an error.
+func TestTx_CommitAsync_ErrTxNotWritable(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	tx, err := db.Begin(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.CommitAsync(); err != bolt.ErrTxNotWritable {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure that committing a closed transaction asynchronously returns an error.
+func TestTx_CommitAsync_ErrTxClosed(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	tx, err := db.Begin(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.CommitAsync(); err != bolt.ErrTxClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -2,7 +2,6 @@ package bolt
 
 import (
 	"bytes"
-	"fmt"
 	"sort"
 )
 
@@ -12,14 +11,26 @@ import (
 //
 // Keys and values returned from the cursor are only valid for the life of the transaction.
 //
-// Changing data while traversing with a cursor may cause it to be invalidated
-// and return unexpected keys and/or values. You must reposition your cursor
-// after mutating data.
+// Data may be changed while traversing with a cursor. If the bucket is modified
+// after the cursor is positioned then the cursor seeks back to the key it was
+// last positioned on before moving. If that key has been deleted then Next()
+// returns the key after it and Prev() returns the key before it.
+//
+// If the transaction was started with a context then Next() and Prev() check
+// it periodically and return a nil key once it is done. Err() returns the
+// context's error.
 type Cursor struct {
-	bucket *Bucket		//使用该句柄来进行node的加载
-	stack  []elemRef	//保留路径,方便回溯
+	bucket  *Bucket		//使用该句柄来进行node的加载
+	stack   []elemRef	//保留路径,方便回溯
+	key     []byte		// key of the current position
+	version uint64		// bucket version when the cursor was positioned
+	steps   int		// moves since the context was last checked
+	err     error		// context or corruption error that stopped the cursor
 }
 
+// ctxCheckInterval is the number of cursor moves between context checks.
+const ctxCheckInterval = 1024
+
 // Bucket returns the bucket that this cursor was created from.
 func (c *Cursor) Bucket() *Bucket {
 	return c.bucket
@@ -35,7 +46,11 @@ func (c *Cursor) Bucket() *Bucket {
 	4. 返回其该叶子节点第一个元素
 */
 func (c *Cursor) First() (key []byte, value []byte) {
+	if l := c.bucket.tx.recorder(); l != nil {
+		return l.move(c, opFirst, nil, 0, c.First)
+	}
 	_assert(c.bucket.tx.db != nil, "tx closed")
+	defer c.catch()
 	// 清空stack
 	c.stack = c.stack[:0]
 	p, n := c.bucket.pageNode(c.bucket.root)
@@ -50,12 +65,7 @@ func (c *Cursor) First() (key []byte, value []byte) {
 		c.next()
 	}
 
-	k, v, flags := c.keyValue()
-	if (flags & uint32(bucketLeafFlag)) != 0 {
-		return k, nil
-	}
-	return k, v
-
+	return c.position(c.keyValue())
 }
 
 // Last moves the cursor to the last item in the bucket and returns its key and value.
@@ -67,7 +77,11 @@ func (c *Cursor) First() (key []byte, value []byte) {
 	3. 返回其最后一个元素，不存在则返回空
 */
 func (c *Cursor) Last() (key []byte, value []byte) {
+	if l := c.bucket.tx.recorder(); l != nil {
+		return l.move(c, opLast, nil, 0, c.Last)
+	}
 	_assert(c.bucket.tx.db != nil, "tx closed")
+	defer c.catch()
 	c.stack = c.stack[:0]
 	p, n := c.bucket.pageNode(c.bucket.root)
 	ref := elemRef{page: p, node: n}
@@ -75,11 +89,13 @@ func (c *Cursor) Last() (key []byte, value []byte) {
 	ref.index = ref.count() - 1
 	c.stack = append(c.stack, ref)
 	c.last()
-	k, v, flags := c.keyValue()
-	if (flags & uint32(bucketLeafFlag)) != 0 {
-		return k, nil
+
+	// If we land on an empty page then move to the previous value.
+	if len(c.stack) > 1 && c.stack[len(c.stack)-1].count() == 0 {
+		c.prev()
 	}
-	return k, v
+
+	return c.position(c.keyValue())
 }
 
 // Next moves the cursor to the next item in the bucket and returns its key and value.
@@ -87,12 +103,22 @@ func (c *Cursor) Last() (key []byte, value []byte) {
 // The returned key and value are only valid for the life of the transaction.
 // 1. 直接调用 c.next 即可
 func (c *Cursor) Next() (key []byte, value []byte) {
+	if l := c.bucket.tx.recorder(); l != nil {
+		return l.move(c, opNext, nil, 0, c.Next)
+	}
 	_assert(c.bucket.tx.db != nil, "tx closed")
-	k, v, flags := c.next()
-	if (flags & uint32(bucketLeafFlag)) != 0 {
-		return k, nil
+	if c.interrupted() {
+		return nil, nil
 	}
-	return k, v
+	defer c.catch()
+
+	// If the last key was removed then the cursor is already on its successor.
+	if c.version != c.bucket.version && !c.restore() {
+		if k, v, flags := c.keyValue(); k != nil {
+			return c.position(k, v, flags)
+		}
+	}
+	return c.position(c.next())
 }
 
 // Prev moves the cursor to the previous item in the bucket and returns its key and value.
@@ -105,33 +131,18 @@ func (c *Cursor) Next() (key []byte, value []byte) {
 	4. 返回其最后一个元素
 */
 func (c *Cursor) Prev() (key []byte, value []byte) {
-	_assert(c.bucket.tx.db != nil, "tx closed")
-
-	// Attempt to move back one element until we're successful.
-	// Move up the stack as we hit the beginning of each page in our stack.
-	for i := len(c.stack) - 1; i >= 0; i-- {
-		elem := &c.stack[i]
-		if elem.index > 0 {
-			// 往前移动一格
-			elem.index--
-			break
-		}
-		c.stack = c.stack[:i]
+	if l := c.bucket.tx.recorder(); l != nil {
+		return l.move(c, opPrev, nil, 0, c.Prev)
 	}
-
-	// If we've hit the end then return nil.
-	if len(c.stack) == 0 {
+	_assert(c.bucket.tx.db != nil, "tx closed")
+	if c.interrupted() {
 		return nil, nil
 	}
-
-	// Move down the stack to find the last element of the last leaf under this branch.
-	// 如果当前节点是叶子节点的话，则直接退出了，什么都不做。否则的话移动到新页的最后一个节点
-	c.last()
-	k, v, flags := c.keyValue()
-	if (flags & uint32(bucketLeafFlag)) != 0 {
-		return k, nil
+	defer c.catch()
+	if c.version != c.bucket.version {
+		c.restore()
 	}
-	return k, v
+	return c.position(c.prev())
 }
 
 // Seek moves the cursor to a given key and returns it.
@@ -143,6 +154,11 @@ func (c *Cursor) Prev() (key []byte, value []byte) {
 	2. 如果 key 正好落在两个叶子节点中间，调用 c.next() 找到下一个非空节点
 */
 func (c *Cursor) Seek(seek []byte) (key []byte, value []byte) {
+	if l := c.bucket.tx.recorder(); l != nil {
+		return l.move(c, opSeek, seek, 0, func() ([]byte, []byte) { return c.Seek(seek) })
+	}
+	defer c.catch()
+
 	k, v, flags := c.seek(seek)
 
 	// If we ended up after the last element of a page then move to the next one.
@@ -152,27 +168,132 @@ func (c *Cursor) Seek(seek []byte) (key []byte, value []byte) {
 		k, v, flags = c.next()
 	}
 
+	return c.position(k, v, flags)
+}
+
+// SeekIndex moves the cursor to the key at the given zero-based position in
+// the bucket and returns it. If the position is negative or past the last
+// key then a nil key and value are returned.
+// The returned key and value are only valid for the life of the transaction.
+func (c *Cursor) SeekIndex(i int) (key []byte, value []byte) {
+	if l := c.bucket.tx.recorder(); l != nil {
+		return l.move(c, opSeekIndex, nil, i, func() ([]byte, []byte) { return c.SeekIndex(i) })
+	}
+	_assert(c.bucket.tx.db != nil, "tx closed")
+	defer c.catch()
+
+	// Position the cursor after the last element if out of range.
+	if i < 0 || i >= c.bucket.Count() {
+		c.Last()
+		if len(c.stack) > 0 {
+			ref := &c.stack[len(c.stack)-1]
+			ref.index = ref.count()
+		}
+		return c.position(nil, nil, 0)
+	}
+
+	// Descend into the child whose subtree contains the position.
+	c.stack = c.stack[:0]
+	p, n := c.bucket.pageNode(c.bucket.root)
+	c.stack = append(c.stack, elemRef{page: p, node: n})
+	for {
+		ref := &c.stack[len(c.stack)-1]
+		if ref.isLeaf() {
+			ref.index = i
+			break
+		}
+
+		for ref.index = 0; ref.index < ref.count()-1; ref.index++ {
+			count := c.bucket.childKeyCount(ref, ref.index)
+			if i < count {
+				break
+			}
+			i -= count
+		}
+
+		var pgid pgid
+		if ref.node != nil {
+			pgid = ref.node.inodes[ref.index].pgid
+		} else {
+			pgid = ref.page.branchPageElement(uint16(ref.index)).pgid
+		}
+		p, n := c.bucket.pageNode(pgid)
+		c.stack = append(c.stack, elemRef{page: p, node: n})
+	}
+
+	return c.position(c.keyValue())
+}
+
+// Rank returns the zero-based position of the current key within the bucket.
+// Returns -1 if the cursor is not positioned on a key or if the position
+// cannot be computed because of corruption.
+func (c *Cursor) Rank() (rank int) {
+	if l := c.bucket.tx.recorder(); l != nil {
+		return l.rank(c)
+	}
+	_assert(c.bucket.tx.db != nil, "tx closed")
+
+	// The rank is left at -1 if a corrupted page is read.
+	rank = -1
+	defer c.catch()
+	if c.version != c.bucket.version && !c.restore() {
+		return -1
+	} else if len(c.stack) == 0 {
+		return -1
+	} else if ref := &c.stack[len(c.stack)-1]; ref.index >= ref.count() {
+		return -1
+	}
+	return c.rank()
+}
+
+// rank returns the number of keys before the current stack position.
+func (c *Cursor) rank() int {
+	var rank int
+	for i := 0; i < len(c.stack)-1; i++ {
+		ref := &c.stack[i]
+		for j := 0; j < ref.index; j++ {
+			rank += c.bucket.childKeyCount(ref, j)
+		}
+	}
+	return rank + c.stack[len(c.stack)-1].index
+}
+
+// SeekLE moves the cursor to the last key that is less than or equal to the
+// given key and returns it. If no such key exists then a nil key is returned.
+// The returned key and value are only valid for the life of the transaction.
+func (c *Cursor) SeekLE(seek []byte) (key []byte, value []byte) {
+	k, v := c.Seek(seek)
 	if k == nil {
-		return nil, nil
-	} else if (flags & uint32(bucketLeafFlag)) != 0 {
-		return k, nil
+		return c.Last()
+	} else if !bytes.Equal(k, seek) {
+		return c.Prev()
 	}
 	return k, v
 }
 
 // Delete removes the current key/value under the cursor from the bucket.
 // Delete fails if current key/value is a bucket or if the transaction is not writable.
-func (c *Cursor) Delete() error {
+func (c *Cursor) Delete() (err error) {
+	if l := c.bucket.tx.recorder(); l != nil {
+		return l.cursorDelete(c)
+	}
 	if c.bucket.tx.db == nil {
 		return ErrTxClosed
 	} else if !c.bucket.Writable() {
 		return ErrTxNotWritable
 	}
 
+	defer recoverCorruption(&err)
+
+	// Nothing to delete if the current key was removed by another mutation.
+	if c.version != c.bucket.version && !c.restore() {
+		return nil
+	}
+
 	key, _, flags := c.keyValue()
 	// Return an error if current value is a bucket.
 	if (flags & bucketLeafFlag) != 0 {
-		return ErrIncompatibleValue
+		return c.bucket.keyError(key, ErrIncompatibleValue)
 	}
 	// 从node中移除，本质上将inode数组进行移动
 	c.node().del(key)
@@ -180,6 +301,80 @@ func (c *Cursor) Delete() error {
 	return nil
 }
 
+// Err returns the error that stopped the cursor. This is the error of the
+// transaction's context or a *CorruptionError if an invalid page was read.
+// Returns nil otherwise.
+func (c *Cursor) Err() error {
+	return c.err
+}
+
+// catch recovers a panic caused by a *CorruptionError and stores it as the
+// cursor's error so that the cursor stops. Other panics are propagated. It
+// must be deferred directly.
+func (c *Cursor) catch() {
+	if r := recover(); r != nil {
+		err, ok := r.(*CorruptionError)
+		if !ok {
+			panic(r)
+		}
+		c.err = err
+	}
+}
+
+// pageID returns the id of the page or node the cursor is positioned on.
+func (c *Cursor) pageID() pgid {
+	if len(c.stack) == 0 {
+		return 0
+	} else if ref := &c.stack[len(c.stack)-1]; ref.node != nil {
+		return ref.node.pgid
+	} else {
+		return ref.page.id
+	}
+}
+
+// interrupted returns true if the transaction's context is done. The context
+// is only checked every ctxCheckInterval moves.
+func (c *Cursor) interrupted() bool {
+	if c.err != nil {
+		return true
+	}
+	ctx := c.bucket.tx.ctx
+	if ctx == nil || ctx.Done() == nil {
+		return false
+	}
+	if c.steps++; c.steps < ctxCheckInterval {
+		return false
+	}
+	c.steps = 0
+	c.err = ctx.Err()
+	return c.err != nil
+}
+
+// position records the element the cursor is positioned on and returns its
+// key and value. A nil value is returned for nested buckets.
+func (c *Cursor) position(k, v []byte, flags uint32) ([]byte, []byte) {
+	c.key, c.version = k, c.bucket.version
+	if k == nil {
+		return nil, nil
+	} else if (flags & uint32(bucketLeafFlag)) != 0 {
+		return k, nil
+	}
+	return k, v
+}
+
+// restore rebuilds the stack after the bucket has been modified by seeking
+// to the key the cursor was last positioned on. Returns true if the key still
+// exists. Otherwise the cursor is left where the key would be inserted.
+func (c *Cursor) restore() bool {
+	c.version = c.bucket.version
+	if c.key == nil {
+		c.stack = c.stack[:0]
+		return false
+	}
+	k, _, _ := c.seek(c.key)
+	return bytes.Equal(k, c.key)
+}
+
 // seek moves the cursor to a given key and returns it.
 // If the key does not exist then the next key is used.
 /*
@@ -304,13 +499,45 @@ func (c *Cursor) next() (key []byte, value []byte, flags uint32) {
 	}
 }
 
+// prev moves to the previous leaf element and returns the key and value.
+// If the cursor is at the first leaf element then the stack is emptied and
+// nil is returned.
+func (c *Cursor) prev() (key []byte, value []byte, flags uint32) {
+	for {
+		// Attempt to move back one element until we're successful.
+		// Move up the stack as we hit the beginning of each page in our stack.
+		for i := len(c.stack) - 1; i >= 0; i-- {
+			elem := &c.stack[i]
+			if elem.index > 0 {
+				// 往前移动一格
+				elem.index--
+				break
+			}
+			c.stack = c.stack[:i]
+		}
+
+		// If we've hit the end then return nil.
+		if len(c.stack) == 0 {
+			return nil, nil, 0
+		}
+
+		// Move down the stack to find the last element of the last leaf under this branch.
+		// 如果当前节点是叶子节点的话，则直接退出了，什么都不做。否则的话移动到新页的最后一个节点
+		c.last()
+
+		// If this is an empty page then restart and move back up the stack.
+		if c.stack[len(c.stack)-1].count() == 0 {
+			continue
+		}
+
+		return c.keyValue()
+	}
+}
+
 // search recursively performs a binary search against a given page/node until it finds a given key.
 // 尾递归,查询key所在的node,并且在cursor中记下路径
 func (c *Cursor) search(key []byte, pgid pgid) {
 	p, n := c.bucket.pageNode(pgid)
-	if p != nil && (p.flags&(branchPageFlag|leafPageFlag)) == 0 {
-		panic(fmt.Sprintf("invalid page type: %d: %x", p.id, p.flags))
-	}
 	e := elemRef{page: p, node: n}
 	//记录遍历过的路径
 	c.stack = append(c.stack, e)
@@ -405,6 +632,9 @@ func (c *Cursor) nsearch(key []byte) {
 // 返回当前cursor stack里的最后一个元素
 func (c *Cursor) keyValue() ([]byte, []byte, uint32) {
 	//最后一个节点为叶子节点
+	if len(c.stack) == 0 {
+		return nil, nil, 0
+	}
 	ref := &c.stack[len(c.stack)-1]
 	if ref.count() == 0 || ref.index >= ref.count() {
 		return nil, nil, 0
@@ -2,7 +2,9 @@ package bolt_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -141,7 +143,7 @@ func TestCursor_Delete(t *testing.T) {
 		}
 
 		c.Seek([]byte("sub"))
-		if err := c.Delete(); err != bolt.ErrIncompatibleValue {
+		if err := c.Delete(); !errors.Is(err, bolt.ErrIncompatibleValue) {
 			t.Fatalf("unexpected error: %s", err)
 		}
 
@@ -161,6 +163,197 @@ func TestCursor_Delete(t *testing.T) {
 	}
 }
 
+// Ensure that a cursor visits keys inserted after its position during iteration.
+func TestCursor_Put_DuringIteration(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	const count = 2000
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < count; i += 2 {
+			if err := b.Put(u64tob(uint64(i)), make([]byte, 100)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Insert an odd key after every even key. The leaf nodes overflow and
+	// are split when the transaction commits.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		c := b.Cursor()
+		var n uint64
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			if btou64(k) != n {
+				t.Fatalf("unexpected key: %d != %d", btou64(k), n)
+			}
+			if n%2 == 0 {
+				if err := b.Put(u64tob(n+1), make([]byte, 500)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			n++
+		}
+		if n != count {
+			t.Fatalf("unexpected key count: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		if n := tx.Bucket([]byte("widgets")).Stats().KeyN; n != count {
+			t.Fatalf("unexpected KeyN: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that a cursor skips keys deleted ahead of it and continues after
+// keys deleted underneath it.
+func TestCursor_Delete_DuringIteration(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	const count = 2000
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < count; i++ {
+			if err := b.Put(u64tob(uint64(i)), make([]byte, 100)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+
+		// Delete the key after the current one.
+		c := b.Cursor()
+		var n uint64
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			if btou64(k) != n {
+				t.Fatalf("unexpected key: %d != %d", btou64(k), n)
+			}
+			if err := b.Delete(u64tob(n + 1)); err != nil {
+				t.Fatal(err)
+			}
+			n += 2
+		}
+		if n != count {
+			t.Fatalf("unexpected end: %d", n)
+		}
+
+		// Delete the current key while moving backwards.
+		n = count
+		for k, _ := c.Last(); k != nil; k, _ = c.Prev() {
+			if n -= 2; btou64(k) != n {
+				t.Fatalf("unexpected key: %d != %d", btou64(k), n)
+			}
+			if n%4 == 0 {
+				if err := b.Delete(k); err != nil {
+					t.Fatal(err)
+				}
+				if r := c.Rank(); r != -1 {
+					t.Fatalf("unexpected rank for deleted key: %d", r)
+				}
+			}
+		}
+		if n != 0 {
+			t.Fatalf("unexpected end: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		if n := tx.Bucket([]byte("widgets")).Stats().KeyN; n != count/4 {
+			t.Fatalf("unexpected KeyN: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that deleting every key through a cursor visits every key and that
+// the emptied pages are rebalanced on commit.
+func TestCursor_Delete_All(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	const count = 2000
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < count; i++ {
+			if err := b.Put(u64tob(uint64(i)), make([]byte, 100)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		c := b.Cursor()
+		var n int
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			if err := c.Delete(); err != nil {
+				t.Fatal(err)
+			}
+			n++
+		}
+		if n != count {
+			t.Fatalf("unexpected delete count: %d", n)
+		}
+
+		// Deleting again is a no-op once the key is gone.
+		if err := c.Delete(); err != nil {
+			t.Fatal(err)
+		}
+		if k, _ := c.First(); k != nil {
+			t.Fatalf("unexpected key: %x", k)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		stats := tx.Bucket([]byte("widgets")).Stats()
+		if stats.KeyN != 0 {
+			t.Fatalf("unexpected KeyN: %d", stats.KeyN)
+		} else if stats.BranchPageN != 0 || stats.LeafPageN != 0 {
+			t.Fatalf("expected rebalanced bucket: %d branch, %d leaf", stats.BranchPageN, stats.LeafPageN)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
 // Ensure that a Tx cursor can seek to the appropriate keys when there are a
 // large number of keys. This test also checks that seek will always move
 // forward to the next key.
@@ -229,6 +422,87 @@ func TestCursor_Seek_Large(t *testing.T) {
 	}
 }
 
+// Ensure that a cursor can seek to a position and report the rank of its key.
+func TestCursor_SeekIndex(t *testing.T) {
+	db := MustOpenWithOption(&bolt.Options{OrderStatistics: true})
+	defer db.MustClose()
+
+	const n = 10000
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < n; i++ {
+			if err := b.Put(u64tob(uint64(i)), []byte("0123456789")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(tx *bolt.Tx, keys []uint64) {
+		c := tx.Bucket([]byte("widgets")).Cursor()
+		for i, exp := range keys {
+			k, _ := c.SeekIndex(i)
+			if k == nil || btou64(k) != exp {
+				t.Fatalf("unexpected key at %d: %x != %d", i, k, exp)
+			} else if r := c.Rank(); r != i {
+				t.Fatalf("unexpected rank at %d: %d", i, r)
+			}
+			if i < len(keys)-1 {
+				if k, _ := c.Next(); btou64(k) != keys[i+1] {
+					t.Fatalf("unexpected next key at %d: %x", i, k)
+				}
+			}
+		}
+		if k, v := c.SeekIndex(len(keys)); k != nil || v != nil {
+			t.Fatalf("expected nil key past end: %x", k)
+		} else if r := c.Rank(); r != -1 {
+			t.Fatalf("expected no rank past end: %d", r)
+		}
+		if k, _ := c.SeekIndex(-1); k != nil {
+			t.Fatalf("expected nil key for negative index: %x", k)
+		}
+	}
+
+	// Remove a block of keys and verify positions before and after commit.
+	var keys []uint64
+	for i := 0; i < n; i++ {
+		if i < 3000 || i >= 7000 {
+			keys = append(keys, uint64(i))
+		}
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		for i := 3000; i < 7000; i++ {
+			if err := b.Delete(u64tob(uint64(i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		check(tx, keys)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		check(tx, keys)
+
+		c := tx.Bucket([]byte("widgets")).Cursor()
+		if k, _ := c.Seek(u64tob(7000)); btou64(k) != 7000 {
+			t.Fatalf("unexpected key: %x", k)
+		} else if r := c.Rank(); r != 3000 {
+			t.Fatalf("unexpected rank: %d", r)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
 // Ensure that a cursor can iterate over an empty bucket without error.
 func TestCursor_EmptyBucket(t *testing.T) {
 	db := MustOpenDB()
@@ -815,3 +1089,56 @@ func ExampleCursor_reverse() {
 	// A dog is fun.
 	// A cat is lame.
 }
+
+// Ensure that a cursor stops once the transaction's context is done.
+func TestCursor_Next_Context(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 10000; i++ {
+			if err := b.Put(u64tob(uint64(i)), []byte{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tx, err := db.BeginTx(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	c := tx.Bucket([]byte("widgets")).Cursor()
+	var n int
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		if n++; n == 1 {
+			cancel()
+		}
+	}
+	if n == 10000 {
+		t.Fatal("expected cursor to stop")
+	} else if err := c.Err(); err != context.Canceled {
+		t.Fatalf("unexpected error: %v", err)
+	} else if k, _ := c.Prev(); k != nil {
+		t.Fatalf("unexpected key: %x", k)
+	}
+
+	it := tx.Bucket([]byte("widgets")).Iterator(&bolt.IteratorOptions{Reverse: true})
+	for n = 0; it.Next(); n++ {
+	}
+	if n == 10000 {
+		t.Fatal("expected iterator to stop")
+	} else if err := it.Err(); err != context.Canceled {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -1,15 +1,19 @@
 package bolt
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"hash/fnv"
-	"log"
 	"os"
 	"runtime"
 	"runtime/debug"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unsafe"
 )
@@ -17,6 +21,9 @@ import (
 // The largest step that can be taken when remapping the mmap.
 const maxMmapStep = 1 << 30 // 1GB
 
+// The longest interval between attempts to acquire a lock with a context.
+const maxLockDelay = time.Millisecond
+
 // The data file format version.
 const version = 2
 
@@ -94,6 +101,52 @@ type DB struct {
 	// of truncate() and fsync() when growing the data file.
 	AllocSize int
 
+	// When enabled, branch pages written by write transactions carry the
+	// number of keys below each child. This allows Bucket.Count(),
+	// Bucket.CountRange(), Cursor.Rank() and Cursor.SeekIndex() to run in
+	// logarithmic time. Existing branch pages are upgraded as they are
+	// rewritten. Counts are kept on pages that already carry them even
+	// when this flag is disabled.
+	OrderStatistics bool
+
+	// When enabled, the id and stack of the goroutine that begins each
+	// transaction are recorded and returned by OpenTransactions(). This helps
+	// to find transactions that are never closed but adds to the cost of
+	// Begin().
+	TxStackTraces bool
+
+	// LongReadTxThreshold is how long a read-only or optimistic transaction
+	// can stay open before LongReadTxHandler is called for it. Open read
+	// transactions prevent pages from being reused. Zero disables the check.
+	LongReadTxThreshold time.Duration
+
+	// LongReadTxHandler is called once for each transaction that stays open
+	// longer than LongReadTxThreshold. It is called from its own goroutine.
+	// If nil, the transaction is logged with DB.Logger.
+	LongReadTxHandler func(TxInfo)
+
+	// Metrics receives commit latencies, lock wait times, remaps and file
+	// growth as they happen. See the Metric constants for the names used.
+	// If nil, no metrics are reported.
+	Metrics MetricsSink
+
+	// Tracer receives the start and end of transaction begins, commit
+	// phases, syncs, remaps, file growth and batches. Defaults to NopTracer.
+	Tracer Tracer
+
+	// Logger receives warnings and other diagnostics, such as transactions
+	// that stay open too long. Defaults to DefaultLogger.
+	Logger Logger
+
+	// SlowCommitThreshold is how long a commit can take before it is logged
+	// with the time spent in each phase. Zero disables the check.
+	SlowCommitThreshold time.Duration
+
+	// SlowLockWaitThreshold is how long a transaction can wait to begin
+	// before it is logged along with the writable transaction that held the
+	// lock. Zero disables the check.
+	SlowLockWaitThreshold time.Duration
+
 	path     string
 	file     *os.File
 	lockfile *os.File          // windows only
@@ -119,6 +172,11 @@ type DB struct {
 	metalock sync.Mutex   // Protects meta page access.
 	mmaplock sync.RWMutex // Protects mmap access during remapping.
 	statlock sync.RWMutex // Protects stats access.
+	synclock sync.Mutex   // Protects asynchronous commit state.
+
+	lastCommit  *PendingCommit // most recent asynchronous commit
+	pendingMeta *meta          // meta of an asynchronous commit not yet written
+	durable     txid           // id of the last commit known to be on disk
 
 	ops struct {
 		writeAt func(b []byte, off int64) (n int, err error)
@@ -156,6 +214,21 @@ func Open(path string, mode os.FileMode, options *Options) (*DB, error) {
 	}
 	db.NoGrowSync = options.NoGrowSync
 	db.MmapFlags = options.MmapFlags
+	db.OrderStatistics = options.OrderStatistics
+	db.TxStackTraces = options.TxStackTraces
+	db.LongReadTxThreshold = options.LongReadTxThreshold
+	db.LongReadTxHandler = options.LongReadTxHandler
+	db.Metrics = options.Metrics
+	db.Tracer = options.Tracer
+	if db.Tracer == nil {
+		db.Tracer = NopTracer{}
+	}
+	db.Logger = options.Logger
+	if db.Logger == nil {
+		db.Logger = DefaultLogger
+	}
+	db.SlowCommitThreshold = options.SlowCommitThreshold
+	db.SlowLockWaitThreshold = options.SlowLockWaitThreshold
 
 	// Set default values for later DB operations.
 	db.MaxBatchSize = DefaultMaxBatchSize
@@ -242,6 +315,7 @@ func Open(path string, mode os.FileMode, options *Options) (*DB, error) {
 	// Read in the freelist.
 	db.freelist = newFreelist()
 	db.freelist.read(db.page(db.meta().freelist))
+	db.durable = db.meta().txid
 
 	// Mark the database as opened and return.
 	return db, nil
@@ -249,9 +323,17 @@ func Open(path string, mode os.FileMode, options *Options) (*DB, error) {
 
 // mmap opens the underlying memory-mapped file and initializes the meta references.
 // minsz is the minimum size that the new mmap can be.
-func (db *DB) mmap(minsz int) error {
+func (db *DB) mmap(minsz int) (err error) {
+	ev := db.traceStart(TraceEvent{Name: TraceRemap})
+	if db.rwtx != nil {
+		ev.TxID, ev.Writable = db.rwtx.ID(), true
+	}
+	defer func() { db.traceEnd(ev, err) }()
+
+	start := time.Now()
 	db.mmaplock.Lock()
 	defer db.mmaplock.Unlock()
+	db.observe(MetricRemapLockWait, start)
 
 	info, err := db.file.Stat()
 	if err != nil {
@@ -269,6 +351,7 @@ func (db *DB) mmap(minsz int) error {
 	if err != nil {
 		return err
 	}
+	ev.Bytes = int64(size)
 
 	// Dereference all mmap references before unmapping.
 	if db.rwtx != nil {
@@ -285,6 +368,8 @@ func (db *DB) mmap(minsz int) error {
 		return err
 	}
 
+	db.count(MetricRemaps, 1)
+
 	// Save references to the meta pages.
 	db.meta0 = db.page(0).meta()
 	db.meta1 = db.page(1).meta()
@@ -429,6 +514,10 @@ func (db *DB) close() error {
 		return nil
 	}
 
+	// Wait for asynchronous commits to be written before closing the file.
+	// The database is closed even if one of them failed.
+	syncErr := db.waitCommits()
+
 	db.opened = false
 
 	db.freelist = nil
@@ -447,7 +536,7 @@ func (db *DB) close() error {
 		if !db.readOnly {
 			// Unlock the file.
 			if err := funlock(db); err != nil {
-				log.Printf("bolt.Close(): funlock error: %s", err)
+				db.logger().Error("funlock failed", "path", db.path, "err", err)
 			}
 		}
 
@@ -459,7 +548,7 @@ func (db *DB) close() error {
 	}
 
 	db.path = ""
-	return nil
+	return syncErr
 }
 
 // Begin starts a new transaction.
@@ -480,25 +569,49 @@ func (db *DB) close() error {
 // IMPORTANT: You must close read-only transactions after you are finished or
 // else the database will not reclaim old pages.
 func (db *DB) Begin(writable bool) (*Tx, error) {
+	return db.BeginTx(context.Background(), writable)
+}
+
+// BeginTx starts a new transaction like Begin. If the context is done while
+// waiting for the writer lock or for the data file to be remapped then
+// ctx.Err() is returned.
+//
+// The context is kept by the transaction. Cursors stop and ForEach() returns
+// ctx.Err() once the context is done. Commit and rollback are not affected.
+func (db *DB) BeginTx(ctx context.Context, writable bool) (*Tx, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	if writable {
 		// 互斥锁
-		return db.beginRWTx()
+		return db.beginRWTx(ctx)
 	}
 	// 读锁
-	return db.beginTx()
+	return db.beginTx(ctx, false)
 }
 
-func (db *DB) beginTx() (*Tx, error) {
+func (db *DB) beginTx(ctx context.Context, optimistic bool) (_ *Tx, err error) {
+	ev := db.traceStart(TraceEvent{Name: TraceBegin, Writable: optimistic})
+	defer func() { db.traceEnd(ev, err) }()
+
 	// Lock the meta pages while we initialize the transaction. We obtain
 	// the meta lock before the mmap lock because that's the order that the
 	// write transaction will obtain them.
-	db.metalock.Lock()
+	start := time.Now()
+	if err := lockContext(ctx, db.metalock.Lock, db.metalock.TryLock); err != nil {
+		return nil, err
+	}
 
 	// Obtain a read-only lock on the mmap. When the mmap is remapped it will
 	// obtain a write lock so all transactions must finish before it can be
 	// remapped.
 	// 会阻塞remmap的写事务
-	db.mmaplock.RLock()
+	if err := lockContext(ctx, db.mmaplock.RLock, db.mmaplock.TryRLock); err != nil {
+		db.metalock.Unlock()
+		return nil, err
+	}
+	wait := time.Since(start)
+	db.observe(MetricReadLockWait, start)
 
 	// Exit if the database is not open yet.
 	if !db.opened {
@@ -508,8 +621,10 @@ func (db *DB) beginTx() (*Tx, error) {
 	}
 
 	// Create a transaction associated with the database.
-	t := &Tx{}
+	t := &Tx{writable: optimistic, optimistic: optimistic, ctx: ctx}
 	t.init(db)
+	db.trackTx(t)
+	ev.TxID = t.ID()
 
 	// Keep track of transaction until it closes.
 	db.txs = append(db.txs, t)
@@ -523,11 +638,16 @@ func (db *DB) beginTx() (*Tx, error) {
 	db.stats.TxN++
 	db.stats.OpenTxN = n
 	db.statlock.Unlock()
+	db.gauge(MetricOpenReadTxs, int64(n))
+	db.logSlowLockWait(t, "read", wait, nil)
 
 	return t, nil
 }
 
-func (db *DB) beginRWTx() (*Tx, error) {
+func (db *DB) beginRWTx(ctx context.Context) (_ *Tx, err error) {
+	ev := db.traceStart(TraceEvent{Name: TraceBegin, Writable: true})
+	defer func() { db.traceEnd(ev, err) }()
+
 	// If the database was opened with Options.ReadOnly, return an error.
 	if db.readOnly {
 		return nil, ErrDatabaseReadOnly
@@ -535,7 +655,18 @@ func (db *DB) beginRWTx() (*Tx, error) {
 
 	// Obtain writer lock. This is released by the transaction when it closes.
 	// This enforces only one writer transaction at a time.
-	db.rwlock.Lock()
+	// The writer that holds the lock is recorded so that slow waits can be
+	// blamed on it.
+	start := time.Now()
+	var holder *TxInfo
+	if !db.rwlock.TryLock() {
+		holder = db.writerInfo()
+		if err := lockContext(ctx, db.rwlock.Lock, db.rwlock.TryLock); err != nil {
+			return nil, err
+		}
+	}
+	wait := time.Since(start)
+	db.observe(MetricWriteLockWait, start)
 
 	// Once we have the writer lock then we can lock the meta pages so that
 	// we can set up the transaction.
@@ -549,29 +680,146 @@ func (db *DB) beginRWTx() (*Tx, error) {
 	}
 
 	// Create a transaction associated with the database.
-	t := &Tx{writable: true}
+	t := &Tx{writable: true, ctx: ctx}
 	t.init(db)
+	db.trackTx(t)
+	ev.TxID = t.ID()
 	db.rwtx = t
+	db.logSlowLockWait(t, "writer", wait, holder)
 
 	// Free any pages associated with closed read-only transactions.
-	var minid txid = 0xFFFFFFFFFFFFFFFF
-	// 找最小的事务id
+	db.freePages()
+
+	return t, nil
+}
+
+// freePages releases pending pages that are no longer visible to any open
+// read transaction. Pages freed before the oldest reader are released, as
+// are pages that were both allocated and freed between two readers or after
+// the newest one. Only pages freed by durable commits are released.
+func (db *DB) freePages() {
+	durable := db.durableTxid()
+	if len(db.txs) == 0 {
+		// Readers opened later see at least the last committed transaction.
+		db.freelist.release(durable)
+		db.freelist.prune(db.rwtx.meta.txid - 1)
+		return
+	}
+
+	ids := make([]txid, 0, len(db.txs))
 	for _, t := range db.txs {
-		if t.meta.txid < minid {
-			minid = t.meta.txid
+		ids = append(ids, t.meta.txid)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	db.freelist.prune(ids[0])
+
+	if ids[0] > 0 {
+		db.freelist.release(min(ids[0]-1, durable))
+	}
+
+	// Release pages whose whole lifetime lies between two open readers.
+	for i := 1; i < len(ids); i++ {
+		if ids[i] > ids[i-1]+1 {
+			db.freelist.releaseRange(ids[i-1]+1, min(ids[i]-1, durable))
 		}
 	}
-	if minid > 0 {
-		// 将之前事务关联的page全部释放了,因为在只读事务中,没法释放,只读事务的页,
-		// 因为可能当前的事务已经完成,但实际上其他的读事务还在用.
-		db.freelist.release(minid - 1)
+	db.freelist.releaseRange(ids[len(ids)-1]+1, durable)
+}
+
+// lockContext acquires a lock, giving up with ctx.Err() once the context is
+// done. The lock is polled with tryLock since mutexes cannot be interrupted.
+func lockContext(ctx context.Context, lock func(), tryLock func() bool) error {
+	if ctx.Done() == nil {
+		lock()
+		return nil
+	} else if tryLock() {
+		return nil
 	}
 
-	return t, nil
+	delay := 10 * time.Microsecond
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+		if tryLock() {
+			return nil
+		}
+		if delay < maxLockDelay {
+			delay *= 2
+		}
+		timer.Reset(delay)
+	}
+}
+
+// trackTx records when and where a transaction began and schedules the
+// long-running transaction check for read transactions.
+func (db *DB) trackTx(t *Tx) {
+	t.info = TxInfo{ID: t.ID(), Writable: t.writable, Start: time.Now()}
+	if db.TxStackTraces {
+		stack := debug.Stack()
+		t.info.Goroutine = goroutineID(stack)
+		t.info.Stack = string(stack)
+	}
+
+	if db.LongReadTxThreshold > 0 && (!t.writable || t.optimistic) {
+		info, fn := t.info, db.LongReadTxHandler
+		if fn == nil {
+			fn = db.logLongReadTx
+		}
+		t.timer = time.AfterFunc(db.LongReadTxThreshold, func() { fn(info) })
+	}
+}
+
+// goroutineID parses the goroutine id from the header of a stack trace.
+func goroutineID(stack []byte) int64 {
+	stack = bytes.TrimPrefix(stack, []byte("goroutine "))
+	if i := bytes.IndexByte(stack, ' '); i > 0 {
+		id, _ := strconv.ParseInt(string(stack[:i]), 10, 64)
+		return id
+	}
+	return 0
+}
+
+// logLongReadTx is the default LongReadTxHandler.
+func (db *DB) logLongReadTx(info TxInfo) {
+	keyvals := []interface{}{
+		"txid", info.ID,
+		"start", info.Start.Format(time.RFC3339),
+		"goroutine", info.Goroutine,
+	}
+	if info.Stack != "" {
+		keyvals = append(keyvals, "stack", info.Stack)
+	}
+	db.logger().Warn("long-running read transaction", keyvals...)
+}
+
+// OpenTransactions returns information about every open transaction,
+// ordered by the time they began.
+func (db *DB) OpenTransactions() []TxInfo {
+	db.metalock.Lock()
+	infos := make([]TxInfo, 0, len(db.txs)+1)
+	if db.rwtx != nil {
+		infos = append(infos, db.rwtx.info)
+	}
+	for _, t := range db.txs {
+		infos = append(infos, t.info)
+	}
+	db.metalock.Unlock()
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Start.Before(infos[j].Start) })
+	return infos
 }
 
 // removeTx removes a transaction from the database.
 func (db *DB) removeTx(tx *Tx) {
+	if tx.timer != nil {
+		tx.timer.Stop()
+	}
+
 	// Release the read lock on the mmap.
 	db.mmaplock.RUnlock()
 
@@ -598,6 +846,7 @@ func (db *DB) removeTx(tx *Tx) {
 	db.stats.OpenTxN = n
 	db.stats.TxStats.add(&tx.stats)
 	db.statlock.Unlock()
+	db.gauge(MetricOpenReadTxs, int64(n))
 }
 
 // Update executes a function within the context of a read-write managed transaction.
@@ -608,17 +857,27 @@ func (db *DB) removeTx(tx *Tx) {
 //
 // Attempting to manually commit or rollback within the function will cause a panic.
 func (db *DB) Update(fn func(*Tx) error) error {
-	t, err := db.Begin(true)
+	return db.UpdateContext(context.Background(), fn)
+}
+
+// UpdateContext executes a function within the context of a read-write
+// managed transaction like Update. The transaction is started with BeginTx.
+// If the context is done by the time the function returns then the
+// transaction is rolled back and ctx.Err() is returned.
+func (db *DB) UpdateContext(ctx context.Context, fn func(*Tx) error) (err error) {
+	t, err := db.BeginTx(ctx, true)
 	if err != nil {
 		return err
 	}
 
 	// Make sure the transaction rolls back in the event of a panic.
+	// Corruption is returned as an error after rolling back.
 	defer func() {
 		if t.db != nil {
 			t.rollback()
 		}
 	}()
+	defer recoverCorruption(&err)
 
 	// Mark as a managed tx so that the inner function cannot manually commit.
 	t.managed = true
@@ -626,6 +885,9 @@ func (db *DB) Update(fn func(*Tx) error) error {
 	// If an error is returned from the function then rollback and return error.
 	err = fn(t)
 	t.managed = false
+	if err == nil {
+		err = ctx.Err()
+	}
 	if err != nil {
 		_ = t.Rollback()
 		return err
@@ -639,17 +901,27 @@ func (db *DB) Update(fn func(*Tx) error) error {
 //
 // Attempting to manually rollback within the function will cause a panic.
 func (db *DB) View(fn func(*Tx) error) error {
-	t, err := db.Begin(false)
+	return db.ViewContext(context.Background(), fn)
+}
+
+// ViewContext executes a function within the context of a managed read-only
+// transaction like View. The transaction is started with BeginTx. If the
+// context is done by the time the function returns then ctx.Err() is
+// returned.
+func (db *DB) ViewContext(ctx context.Context, fn func(*Tx) error) (err error) {
+	t, err := db.BeginTx(ctx, false)
 	if err != nil {
 		return err
 	}
 
 	// Make sure the transaction rolls back in the event of a panic.
+	// Corruption is returned as an error after rolling back.
 	defer func() {
 		if t.db != nil {
 			t.rollback()
 		}
 	}()
+	defer recoverCorruption(&err)
 
 	// Mark as a managed tx so that the inner function cannot manually rollback.
 	t.managed = true
@@ -657,6 +929,12 @@ func (db *DB) View(fn func(*Tx) error) error {
 	// If an error is returned from the function then pass it through.
 	err = fn(t)
 	t.managed = false
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err == nil {
+		err = t.err
+	}
 	if err != nil {
 		_ = t.Rollback()
 		return err
@@ -688,6 +966,32 @@ func (db *DB) View(fn func(*Tx) error) error {
 //
 // Batch is only useful when there are multiple goroutines calling it.
 func (db *DB) Batch(fn func(*Tx) error) error {
+	return db.BatchContext(context.Background(), fn)
+}
+
+// BatchContext calls fn as part of a batch like Batch. If the context is
+// done before the batch calls fn then fn is skipped and ctx.Err() is
+// returned. Once fn has been called, BatchContext waits for the batch to
+// finish. If fn has to be retried on its own then it runs in UpdateContext.
+func (db *DB) BatchContext(ctx context.Context, fn func(*Tx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Track whether the batch has started calling fn so that a cancellation
+	// never races with a commit.
+	var state int32
+	wrapped := fn
+	if ctx.Done() != nil {
+		wrapped = func(tx *Tx) error {
+			if !atomic.CompareAndSwapInt32(&state, callPending, callStarted) &&
+				atomic.LoadInt32(&state) == callCanceled {
+				return ctx.Err()
+			}
+			return fn(tx)
+		}
+	}
+
 	errCh := make(chan error, 1)
 
 	db.batchMu.Lock()
@@ -698,20 +1002,35 @@ func (db *DB) Batch(fn func(*Tx) error) error {
 		}
 		db.batch.timer = time.AfterFunc(db.MaxBatchDelay, db.batch.trigger)
 	}
-	db.batch.calls = append(db.batch.calls, call{fn: fn, err: errCh})
+	db.batch.calls = append(db.batch.calls, call{fn: wrapped, err: errCh})
 	if len(db.batch.calls) >= db.MaxBatchSize {
 		// wake up batch, it's ready to run
 		go db.batch.trigger()
 	}
 	db.batchMu.Unlock()
 
-	err := <-errCh
+	var err error
+	select {
+	case err = <-errCh:
+	case <-ctx.Done():
+		if atomic.CompareAndSwapInt32(&state, callPending, callCanceled) {
+			return ctx.Err()
+		}
+		err = <-errCh
+	}
 	if err == trySolo {
-		err = db.Update(fn)
+		err = db.UpdateContext(ctx, fn)
 	}
 	return err
 }
 
+// States of a call within a batch started with BatchContext.
+const (
+	callPending int32 = iota
+	callStarted
+	callCanceled
+)
+
 type call struct {
 	fn  func(*Tx) error
 	err chan<- error
@@ -741,10 +1060,15 @@ func (b *batch) run() {
 	}
 	b.db.batchMu.Unlock()
 
+	ev := b.db.traceStart(TraceEvent{Name: TraceBatch, Writable: true, Count: len(b.calls)})
+	var err error
+	defer func() { b.db.traceEnd(ev, err) }()
+
 retry:
 	for len(b.calls) > 0 {
 		var failIdx = -1
-		err := b.db.Update(func(tx *Tx) error {
+		err = b.db.Update(func(tx *Tx) error {
+			ev.TxID = tx.ID()
 			for i, c := range b.calls {
 				if err := safelyCall(c.fn, tx); err != nil {
 					failIdx = i
@@ -798,11 +1122,17 @@ func safelyCall(fn func(*Tx) error, tx *Tx) (err error) {
 	return fn(tx)
 }
 
-// Sync executes fdatasync() against the database file handle.
+// Sync executes fdatasync() against the database file handle. It first
+// waits for all transactions committed with CommitAsync() to be durable.
 //
 // This is not necessary under normal operation, however, if you use NoSync
 // then it allows you to force the database file to sync against the disk.
-func (db *DB) Sync() error { return fdatasync(db) }
+func (db *DB) Sync() error {
+	if err := db.waitCommits(); err != nil {
+		return err
+	}
+	return db.syncData()
+}
 
 // Stats retrieves ongoing performance stats for the database.
 // This is only updated when a transaction closes.
@@ -835,6 +1165,14 @@ func (db *DB) meta() *meta {
 	// We have to return the meta with the highest txid which doesn't fail
 	// validation. Otherwise, we can cause errors when in fact the database is
 	// in a consistent state. metaA is the one with the higher txid.
+	// An asynchronous commit that has not been written yet is the newest.
+	db.synclock.Lock()
+	pending := db.pendingMeta
+	db.synclock.Unlock()
+	if pending != nil {
+		return pending
+	}
+
 	metaA := db.meta0
 	metaB := db.meta1
 	if db.meta1.txid > db.meta0.txid {
@@ -860,6 +1198,24 @@ func (db *DB) meta() *meta {
 	首先将 node 的信息写入这个 buffer，之后统一的写入 page id 对应的文件位置.
 */
 func (db *DB) allocate(count int) (*page, error) {
+	p := db.allocateUnmapped(count)
+
+	// Resize mmap() if we're at the end.
+	var minsz = int((p.id+pgid(count))+1) * db.pageSize
+	if minsz >= db.datasz {
+		if err := db.mmap(minsz); err != nil {
+			return nil, fmt.Errorf("mmap allocate error: %s", err)
+		}
+	}
+
+	return p, nil
+}
+
+// allocateUnmapped returns a buffer for a contiguous block of pages like
+// allocate() but never resizes the mmap, so pages taken from the end of the
+// file may lie beyond it. This keeps references into the mmap that were handed
+// out earlier in the transaction valid.
+func (db *DB) allocateUnmapped(count int) *page {
 	// Allocate a temporary buffer for the page.
 	var buf []byte
 	if count == 1 {
@@ -870,32 +1226,45 @@ func (db *DB) allocate(count int) (*page, error) {
 	p := (*page)(unsafe.Pointer(&buf[0]))
 	p.overflow = uint32(count - 1)
 
-	// Use pages from the freelist if they are available.
-	if p.id = db.freelist.allocate(count); p.id != 0 {
-		return p, nil
+	// Use pages from the freelist if they are available. Otherwise move the
+	// page id high water mark.
+	if p.id = db.freelist.allocate(count); p.id == 0 {
+		p.id = db.rwtx.meta.pgid
+		db.rwtx.meta.pgid += pgid(count)
 	}
+	db.freelist.allocated(db.rwtx.meta.txid, p.id)
 
-	// Resize mmap() if we're at the end.
-	p.id = db.rwtx.meta.pgid
-	var minsz = int((p.id+pgid(count))+1) * db.pageSize
-	if minsz >= db.datasz {
-		if err := db.mmap(minsz); err != nil {
-			return nil, fmt.Errorf("mmap allocate error: %s", err)
-		}
+	return p
+}
+
+// recyclePage returns the buffer of a written page to the page pool.
+// Pages with overflow are allocated using make() and are left for the GC.
+func (db *DB) recyclePage(p *page) {
+	if int(p.overflow) != 0 {
+		return
 	}
 
-	// Move the page id high water mark.
-	db.rwtx.meta.pgid += pgid(count)
+	buf := (*[maxAllocSize]byte)(unsafe.Pointer(p))[:db.pageSize]
 
-	return p, nil
+	// See https://go.googlesource.com/go/+/f03c9202c43e0abb130669852082117ca50aa9b1
+	// 清空buf，然后放入pagePool中
+	for i := range buf {
+		buf[i] = 0
+	}
+	db.pagePool.Put(buf)
 }
 
 // grow grows the size of the database to the given sz.
-func (db *DB) grow(sz int) error {
+func (db *DB) grow(sz int) (err error) {
 	// Ignore if the new size is less than available file size.
 	if sz <= db.filesz {
 		return nil
 	}
+	ev := db.traceStart(TraceEvent{Name: TraceGrow})
+	if db.rwtx != nil {
+		ev.TxID, ev.Writable = db.rwtx.ID(), true
+	}
+	defer func() { db.traceEnd(ev, err) }()
 
 	// If the data is smaller than the alloc size then only allocate what's needed.
 	// Once it goes over the allocation size then allocate in chunks.
@@ -918,6 +1287,10 @@ func (db *DB) grow(sz int) error {
 		}
 	}
 
+	ev.Bytes = int64(sz)
+	db.count(MetricGrows, 1)
+	db.count(MetricGrowBytes, int64(sz-db.filesz))
+	db.gauge(MetricFileSize, int64(sz))
 	db.filesz = sz
 	return nil
 }
@@ -952,6 +1325,33 @@ type Options struct {
 	// If initialMmapSize is smaller than the previous database size,
 	// it takes no effect.
 	InitialMmapSize int
+
+	// Sets the DB.OrderStatistics flag after opening the database.
+	OrderStatistics bool
+
+	// Sets the DB.TxStackTraces flag after opening the database.
+	TxStackTraces bool
+
+	// Sets the DB.LongReadTxThreshold value after opening the database.
+	LongReadTxThreshold time.Duration
+
+	// Sets the DB.LongReadTxHandler function after opening the database.
+	LongReadTxHandler func(TxInfo)
+
+	// Sets the DB.Metrics sink before memory mapping the file.
+	Metrics MetricsSink
+
+	// Sets the DB.Tracer before memory mapping the file.
+	Tracer Tracer
+
+	// Sets the DB.Logger before opening the file.
+	Logger Logger
+
+	// Sets the DB.SlowCommitThreshold value after opening the database.
+	SlowCommitThreshold time.Duration
+
+	// Sets the DB.SlowLockWaitThreshold value after opening the database.
+	SlowLockWaitThreshold time.Duration
 }
 
 // DefaultOptions represent the options used if nil options are passed into Open().
@@ -961,6 +1361,15 @@ var DefaultOptions = &Options{
 	NoGrowSync: false,
 }
 
+// TxInfo describes an open transaction.
+type TxInfo struct {
+	ID        int       // transaction id
+	Writable  bool      // true for read-write and optimistic transactions
+	Start     time.Time // time the transaction began
+	Goroutine int64     // id of the goroutine that began the transaction if DB.TxStackTraces is set
+	Stack     string    // stack of that goroutine if DB.TxStackTraces is set
+}
+
 // Stats represents statistics about the database.
 type Stats struct {
 	// Freelist stats
@@ -1067,10 +1476,15 @@ func _assert(condition bool, msg string, v ...interface{}) {
 	}
 }
 
-func warn(v ...interface{})              { fmt.Fprintln(os.Stderr, v...) }
-func warnf(msg string, v ...interface{}) { fmt.Fprintf(os.Stderr, msg+"\n", v...) }
+func (db *DB) warn(v ...interface{}) {
+	db.logger().Warn(strings.TrimSuffix(fmt.Sprintln(v...), "\n"))
+}
+
+func (db *DB) warnf(msg string, v ...interface{}) {
+	db.logger().Warn(fmt.Sprintf(msg, v...))
+}
 
-func printstack() {
+func (db *DB) printstack() {
 	stack := strings.Join(strings.Split(string(debug.Stack()), "\n")[2:], "\n")
-	fmt.Fprintln(os.Stderr, stack)
+	db.logger().Debug("stack", "stack", stack)
 }
@@ -2,6 +2,7 @@ package bolt_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"flag"
@@ -406,7 +407,8 @@ func TestDB_Open_InitialMmapSize(t *testing.T) {
 
 	go func() {
 		if err := wtx.Commit(); err != nil {
-			t.Fatal(err)
+			t.Error(err)
+			return
 		}
 		done <- struct{}{}
 	}()
@@ -479,7 +481,8 @@ func testDB_Close_PendingTx(t *testing.T, writable bool) {
 	done := make(chan struct{})
 	go func() {
 		if err := db.Close(); err != nil {
-			t.Fatal(err)
+			t.Error(err)
+			return
 		}
 		close(done)
 	}()
@@ -1048,6 +1051,340 @@ func TestDB_BatchTime(t *testing.T) {
 	}
 }
 
+// Ensure that waiting for the writer lock gives up when the context is done.
+func TestDB_BeginTx_Deadline(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := db.BeginTx(ctx, true); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Read-only transactions do not wait for the writer.
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	rtx, err := db.BeginTx(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rtx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that a transaction cannot be started with a canceled context.
+func TestDB_BeginTx_Canceled(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, writable := range []bool{false, true} {
+		if _, err := db.BeginTx(ctx, writable); err != context.Canceled {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := db.ViewContext(ctx, func(*bolt.Tx) error { return nil }); err != context.Canceled {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure that UpdateContext rolls back if the context is done when the
+// function returns.
+func TestDB_UpdateContext(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.UpdateContext(context.Background(), func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := db.UpdateContext(ctx, func(tx *bolt.Tx) error {
+		if err := tx.Bucket([]byte("widgets")).Put([]byte("foo"), []byte("bar")); err != nil {
+			t.Fatal(err)
+		}
+		cancel()
+		return nil
+	}); err != context.Canceled {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte("widgets")).Get([]byte("foo")); v != nil {
+			t.Fatalf("unexpected value: %q", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that ForEach stops once the transaction's context is done.
+func TestDB_ViewContext_ForEach(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 10000; i++ {
+			if err := b.Put(u64tob(uint64(i)), []byte{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var n int
+	if err := db.ViewContext(ctx, func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).ForEach(func(k, v []byte) error {
+			if n++; n == 1 {
+				cancel()
+			}
+			return nil
+		})
+	}); err != context.Canceled {
+		t.Fatalf("unexpected error: %v", err)
+	} else if n == 0 || n > 2000 {
+		t.Fatalf("unexpected count: %d", n)
+	}
+}
+
+// Ensure that BatchContext skips the function if the context is done before
+// the batch runs.
+func TestDB_BatchContext(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.BatchContext(context.Background(), func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Hold the writer lock so that the batch cannot run.
+	tx, err := db.Begin(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := db.BatchContext(ctx, func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).Put([]byte("foo"), []byte("bar"))
+	}); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Flush the batch and ensure the function was not applied.
+	if err := db.Batch(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).Put([]byte("baz"), []byte("bat"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte("widgets")).Get([]byte("foo")); v != nil {
+			t.Fatalf("unexpected value: %q", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that OpenTransactions reports every open transaction.
+func TestDB_OpenTransactions(t *testing.T) {
+	db := MustOpenWithOption(&bolt.Options{TxStackTraces: true})
+	defer db.MustClose()
+
+	rtx, err := db.Begin(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otx, err := db.BeginOptimistic()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wtx, err := db.Begin(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	infos := db.OpenTransactions()
+	if len(infos) != 3 {
+		t.Fatalf("unexpected count: %d", len(infos))
+	}
+	for i, tx := range []*bolt.Tx{rtx, otx, wtx} {
+		info := infos[i]
+		if info.ID != tx.ID() {
+			t.Fatalf("%d: unexpected id: %d", i, info.ID)
+		} else if info.Writable != tx.Writable() {
+			t.Fatalf("%d: unexpected writable: %v", i, info.Writable)
+		} else if info.Goroutine == 0 {
+			t.Fatalf("%d: expected goroutine", i)
+		} else if !strings.Contains(info.Stack, "TestDB_OpenTransactions") {
+			t.Fatalf("%d: unexpected stack: %s", i, info.Stack)
+		} else if i > 0 && info.Start.Before(infos[i-1].Start) {
+			t.Fatalf("%d: unexpected order", i)
+		}
+	}
+
+	for _, tx := range []*bolt.Tx{rtx, otx, wtx} {
+		if err := tx.Rollback(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if infos := db.OpenTransactions(); len(infos) != 0 {
+		t.Fatalf("unexpected count: %d", len(infos))
+	}
+}
+
+// Ensure that goroutines and stacks are not recorded by default.
+func TestDB_OpenTransactions_NoStack(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		infos := db.OpenTransactions()
+		if len(infos) != 1 {
+			t.Fatalf("unexpected count: %d", len(infos))
+		} else if infos[0].Stack != "" {
+			t.Fatalf("unexpected stack: %s", infos[0].Stack)
+		} else if infos[0].Goroutine != 0 {
+			t.Fatalf("unexpected goroutine: %d", infos[0].Goroutine)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that the handler is called for read transactions that stay open
+// past the threshold.
+func TestDB_LongReadTxHandler(t *testing.T) {
+	ch := make(chan bolt.TxInfo, 2)
+	db := MustOpenWithOption(&bolt.Options{
+		LongReadTxThreshold: 20 * time.Millisecond,
+		LongReadTxHandler:   func(info bolt.TxInfo) { ch <- info },
+	})
+	defer db.MustClose()
+
+	// Transactions closed before the threshold are not reported.
+	if err := db.View(func(tx *bolt.Tx) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := db.Begin(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	select {
+	case info := <-ch:
+		if info.ID != tx.ID() || info.Writable {
+			t.Fatalf("unexpected info: %+v", info)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler not called")
+	}
+	select {
+	case info := <-ch:
+		t.Fatalf("unexpected call: %+v", info)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// Ensure that pages allocated and freed after a long-lived read transaction
+// started are reused while the reader is still open.
+func TestDB_LongReadTx_PageReuse(t *testing.T) {
+	db := MustOpenWithOption(&bolt.Options{InitialMmapSize: 1 << 24})
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put(u64tob(uint64(i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	rtx, err := db.Begin(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = rtx.Rollback() }()
+	size := rtx.Size()
+
+	// Repeatedly rewrite the same keys while the reader is open.
+	for i := 0; i < 500; i++ {
+		if err := db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket([]byte("widgets")).Put(u64tob(uint64(i%10)), []byte(fmt.Sprint(i)))
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var grown int64
+	if err := db.View(func(tx *bolt.Tx) error {
+		grown = tx.Size() - size
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if max := int64(64 * os.Getpagesize()); grown > max {
+		t.Fatalf("database grew by %d bytes; expected at most %d", grown, max)
+	}
+
+	// The reader still sees its snapshot.
+	c := rtx.Bucket([]byte("widgets")).Cursor()
+	n := 0
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if !bytes.Equal(v, make([]byte, 100)) {
+			t.Fatalf("unexpected value for %d: %q", btou64(k), v)
+		}
+		n++
+	}
+	if n != 1000 {
+		t.Fatalf("unexpected count: %d", n)
+	}
+}
+
 func ExampleDB_Update() {
 	// Open the database.
 	db, err := bolt.Open(tempfile(), 0666, nil)
@@ -1131,7 +1468,7 @@ func ExampleDB_View() {
 	// John's last name is doe.
 }
 
-func ExampleDB_Begin_ReadOnly() {
+func ExampleDB_Begin_readOnly() {
 	// Open the database.
 	db, err := bolt.Open(tempfile(), 0666, nil)
 	if err != nil {
@@ -1316,7 +1653,8 @@ func BenchmarkDBBatchManual10x100(b *testing.B) {
 					return nil
 				}
 				if err := db.Update(insert100); err != nil {
-					b.Fatal(err)
+					b.Error(err)
+					return
 				}
 			}(uint32(major))
 		}
@@ -1377,6 +1715,15 @@ func MustOpenDB() *DB {
 	return &DB{db}
 }
 
+// MustOpenWithOption returns a new, open DB at a temporary location with the given options.
+func MustOpenWithOption(o *bolt.Options) *DB {
+	db, err := bolt.Open(tempfile(), 0666, o)
+	if err != nil {
+		panic(err)
+	}
+	return &DB{db}
+}
+
 // Close closes the database and deletes the underlying file.
 func (db *DB) Close() error {
 	// Log statistics.
@@ -1,6 +1,13 @@
 package bolt
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
 
 // These errors can be returned when opening or calling methods on a DB.
 var (
@@ -41,6 +48,15 @@ var (
 	// ErrDatabaseReadOnly is returned when a mutating transaction is started on a
 	// read-only database.
 	ErrDatabaseReadOnly = errors.New("database is in read-only mode")
+
+	// ErrSavepointNotFound is returned when rolling back to a savepoint that
+	// belongs to another transaction or that has been released.
+	ErrSavepointNotFound = errors.New("savepoint not found")
+
+	// ErrConflict is returned when committing an optimistic transaction that
+	// read data which was changed by another transaction after it began.
+	// The transaction can be retried.
+	ErrConflict = errors.New("transaction conflict")
 )
 
 // These errors can occur when putting or deleting a value or a bucket.
@@ -68,4 +84,115 @@ var (
 	// on an existing non-bucket key or when trying to create or delete a
 	// non-bucket key on an existing bucket key.
 	ErrIncompatibleValue = errors.New("incompatible value")
+
+	// ErrBucketNotEmpty is returned when bulk loading into a bucket that
+	// already contains keys.
+	ErrBucketNotEmpty = errors.New("bucket not empty")
+
+	// ErrKeysUnsorted is returned when bulk loading keys that are not in
+	// strictly ascending order.
+	ErrKeysUnsorted = errors.New("keys not in ascending order")
 )
+
+// ErrCorrupted is matched by errors.Is for every *CorruptionError.
+var ErrCorrupted = errors.New("database corrupted")
+
+// CorruptionError is returned when a transaction reads a page that is not
+// valid. Reads that detect corruption stop and return this error instead
+// of panicking. Cursors report it from Err(), Get() and Bucket() return nil
+// and leave it in Tx.Err(), and View(), Update(), Batch() and Commit()
+// return it after rolling back the transaction.
+type CorruptionError struct {
+	PageID int    // id of the invalid page
+	Reason string // description of the problem
+}
+
+// Error returns a description of the corruption.
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("database corrupted: page %d: %s", e.PageID, e.Reason)
+}
+
+// Is returns true if target is ErrCorrupted.
+func (e *CorruptionError) Is(target error) bool {
+	return target == ErrCorrupted
+}
+
+// corrupted panics with a *CorruptionError. The panic is recovered by
+// recoverCorruption at the boundary of the public API.
+func corrupted(id pgid, format string, v ...interface{}) {
+	panic(&CorruptionError{PageID: int(id), Reason: fmt.Sprintf(format, v...)})
+}
+
+// recoverCorruption recovers a panic caused by a *CorruptionError and
+// stores it in err. Other panics are propagated. It must be deferred
+// directly.
+func recoverCorruption(err *error) {
+	if r := recover(); r != nil {
+		e, ok := r.(*CorruptionError)
+		if !ok {
+			panic(r)
+		}
+		*err = e
+	}
+}
+
+// BucketError is returned by operations on a nested bucket. Path is the
+// names of the buckets from the top-level bucket to the bucket that caused
+// the error. Err is one of the bucket errors above and can be matched
+// with errors.Is.
+type BucketError struct {
+	Path [][]byte
+	Err  error
+}
+
+// Error returns the error and the bucket path.
+func (e *BucketError) Error() string {
+	return fmt.Sprintf("%s: bucket %s", e.Err, formatPath(e.Path))
+}
+
+// Unwrap returns the underlying error.
+func (e *BucketError) Unwrap() error { return e.Err }
+
+// KeyError is returned by operations on a key. Bucket is the path of the
+// bucket containing the key. Err is one of the key errors above and can
+// be matched with errors.Is.
+type KeyError struct {
+	Bucket [][]byte
+	Key    []byte
+	Err    error
+}
+
+// Error returns the error, the bucket path and the key. Long keys are
+// truncated.
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("%s: bucket %s: key %s", e.Err, formatPath(e.Bucket), formatKey(e.Key))
+}
+
+// Unwrap returns the underlying error.
+func (e *KeyError) Unwrap() error { return e.Err }
+
+// formatPath formats a bucket path as "a/b/c". The top-level bucket is "/".
+func formatPath(path [][]byte) string {
+	if len(path) == 0 {
+		return "/"
+	}
+	a := make([]string, len(path))
+	for i, name := range path {
+		a[i] = formatKey(name)
+	}
+	return strings.Join(a, "/")
+}
+
+// formatKey formats a key as quoted text if it is printable and as hex
+// otherwise. Keys longer than 64 bytes are truncated.
+func formatKey(key []byte) string {
+	const max = 64
+	var suffix string
+	if len(key) > max {
+		key, suffix = key[:max], fmt.Sprintf("...(%d bytes)", len(key))
+	}
+	if utf8.Valid(key) && strings.IndexFunc(string(key), func(r rune) bool { return !unicode.IsPrint(r) }) == -1 {
+		return strconv.Quote(string(key)) + suffix
+	}
+	return fmt.Sprintf("%x", key) + suffix
+}
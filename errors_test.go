@@ -0,0 +1,332 @@
+package bolt_test
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// Ensure that bucket errors carry the path of the bucket.
+func TestBucketError(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.CreateBucket([]byte("foo")); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = tx.Bucket([]byte("widgets")).CreateBucket([]byte("foo"))
+		var e *bolt.BucketError
+		if !errors.Is(err, bolt.ErrBucketExists) {
+			t.Fatalf("unexpected error: %s", err)
+		} else if !errors.As(err, &e) {
+			t.Fatalf("expected *BucketError: %T", err)
+		} else if exp := [][]byte{[]byte("widgets"), []byte("foo")}; !reflect.DeepEqual(e.Path, exp) {
+			t.Fatalf("unexpected path: %q", e.Path)
+		} else if err.Error() != `bucket already exists: bucket "widgets"/"foo"` {
+			t.Fatalf("unexpected message: %s", err)
+		}
+
+		if err := b.DeleteBucket([]byte("bar")); !errors.Is(err, bolt.ErrBucketNotFound) {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that errors about top-level buckets and their keys are wrapped
+// like those of nested buckets.
+func TestBucketError_TopLevel(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = tx.CreateBucket([]byte("widgets"))
+		var be *bolt.BucketError
+		if !errors.Is(err, bolt.ErrBucketExists) {
+			t.Fatalf("unexpected error: %v", err)
+		} else if !errors.As(err, &be) || !reflect.DeepEqual(be.Path, [][]byte{[]byte("widgets")}) {
+			t.Fatalf("unexpected bucket error: %#v", err)
+		}
+		if err := tx.DeleteBucket([]byte("foo")); !errors.As(err, &be) || !errors.Is(err, bolt.ErrBucketNotFound) {
+			t.Fatalf("unexpected error: %#v", err)
+		}
+
+		var ke *bolt.KeyError
+		if err := b.Put(nil, []byte("bar")); !errors.As(err, &ke) || !errors.Is(err, bolt.ErrKeyRequired) {
+			t.Fatalf("unexpected error: %#v", err)
+		} else if !reflect.DeepEqual(ke.Bucket, [][]byte{[]byte("widgets")}) {
+			t.Fatalf("unexpected bucket: %q", ke.Bucket)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that key errors carry the bucket path and the key.
+func TestKeyError(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		child, err := b.CreateBucket([]byte{0xff, 0x00})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		key := make([]byte, bolt.MaxKeySize+1)
+		err = child.Put(key, []byte("bar"))
+		var e *bolt.KeyError
+		if !errors.Is(err, bolt.ErrKeyTooLarge) {
+			t.Fatalf("unexpected error: %s", err)
+		} else if !errors.As(err, &e) {
+			t.Fatalf("expected *KeyError: %T", err)
+		} else if !reflect.DeepEqual(e.Bucket, [][]byte{[]byte("widgets"), {0xff, 0x00}}) || !reflect.DeepEqual(e.Key, key) {
+			t.Fatalf("unexpected error fields: %q, %d bytes", e.Bucket, len(e.Key))
+		} else if !strings.HasPrefix(err.Error(), `key too large: bucket "widgets"/ff00: key 0000`) ||
+			!strings.HasSuffix(err.Error(), "...(32769 bytes)") {
+			t.Fatalf("unexpected message: %s", err)
+		}
+
+		// Errors on top-level buckets have an empty path.
+		if err := b.Put([]byte("foo"), nil); err != nil {
+			t.Fatal(err)
+		} else if err := tx.Bucket([]byte("widgets")).Put([]byte{0xff, 0x00}, nil); err == nil {
+			t.Fatal("expected error")
+		} else if err.Error() != `incompatible value: bucket "widgets": key ff00` {
+			t.Fatalf("unexpected message: %s", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that reading a corrupted page returns an error from View.
+func TestCorruptionError_View(t *testing.T) {
+	db, root := mustOpenCorrupted(t)
+	defer db.close()
+
+	err := db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte("widgets")).Get(u64tob(1)); v != nil {
+			t.Fatalf("unexpected value: %x", v)
+		}
+		return nil
+	})
+	var e *bolt.CorruptionError
+	if !errors.Is(err, bolt.ErrCorrupted) {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !errors.As(err, &e) || e.PageID != root {
+		t.Fatalf("unexpected corruption error: %#v", err)
+	}
+
+	// The database is still usable.
+	if err := db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte("other")) == nil {
+			t.Fatal("expected bucket")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that cursors stop and report corruption from Err.
+func TestCorruptionError_Cursor(t *testing.T) {
+	db, root := mustOpenCorrupted(t)
+	defer db.close()
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte("widgets")).Cursor()
+		if k, v := c.First(); k != nil || v != nil {
+			t.Fatalf("unexpected key: %x", k)
+		} else if k, _ := c.Next(); k != nil {
+			t.Fatalf("unexpected key: %x", k)
+		}
+		var e *bolt.CorruptionError
+		if !errors.As(c.Err(), &e) || e.PageID != root {
+			t.Fatalf("unexpected error: %v", c.Err())
+		}
+
+		err := tx.Bucket([]byte("widgets")).ForEach(func(k, v []byte) error { return nil })
+		if !errors.Is(err, bolt.ErrCorrupted) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that Update and Batch roll back and return corruption.
+func TestCorruptionError_Update(t *testing.T) {
+	db, _ := mustOpenCorrupted(t)
+	defer db.close()
+
+	put := func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).Put([]byte("foo"), []byte("bar"))
+	}
+	if err := db.Update(put); !errors.Is(err, bolt.ErrCorrupted) {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := db.Batch(put); !errors.Is(err, bolt.ErrCorrupted) {
+		t.Fatalf("unexpected batch error: %v", err)
+	}
+
+	// Writers are not blocked by the failed transactions.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("other")).Put([]byte("foo"), []byte("bar"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that transactions started by hand return corruption instead of
+// panicking.
+func TestCorruptionError_Begin(t *testing.T) {
+	db, root := mustOpenCorrupted(t)
+	defer db.close()
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := tx.Bucket([]byte("widgets"))
+	if err := b.Put([]byte("foo"), []byte("bar")); !errors.Is(err, bolt.ErrCorrupted) {
+		t.Fatalf("unexpected put error: %v", err)
+	} else if v := b.Get(u64tob(1)); v != nil {
+		t.Fatalf("unexpected value: %x", v)
+	}
+	var e *bolt.CorruptionError
+	if !errors.As(tx.Err(), &e) || e.PageID != root {
+		t.Fatalf("unexpected tx error: %v", tx.Err())
+	} else if err := tx.Commit(); !errors.Is(err, bolt.ErrCorrupted) {
+		t.Fatalf("unexpected commit error: %v", err)
+	}
+
+	// Reads of a read-only transaction return nil and keep the error.
+	tx, err = db.Begin(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	if v := tx.Bucket([]byte("widgets")).Get(u64tob(1)); v != nil {
+		t.Fatalf("unexpected value: %x", v)
+	} else if !errors.Is(tx.Err(), bolt.ErrCorrupted) {
+		t.Fatalf("unexpected tx error: %v", tx.Err())
+	} else if tx.Bucket([]byte("other")) == nil {
+		t.Fatal("expected bucket")
+	}
+}
+
+// Ensure that Check reports corruption instead of crashing.
+func TestCorruptionError_Check(t *testing.T) {
+	db, _ := mustOpenCorrupted(t)
+	defer db.close()
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		var n int
+		for range tx.Check() {
+			n++
+		}
+		if n == 0 {
+			t.Fatal("expected errors")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// corruptedDB is a database with a corrupted bucket root page.
+type corruptedDB struct {
+	*bolt.DB
+}
+
+// close closes the database and removes the file without checking it.
+func (db corruptedDB) close() {
+	defer os.Remove(db.Path())
+	_ = db.DB.Close()
+}
+
+// mustOpenCorrupted creates a database with an "other" bucket and a
+// "widgets" bucket whose root page has an invalid type. Returns the
+// reopened database and the id of the corrupted page.
+func mustOpenCorrupted(t *testing.T) (corruptedDB, int) {
+	return mustOpenCorruptedWith(t, func(f *os.File, root, pageSize int) error {
+		// Clear the page flags which follow the 8-byte page id.
+		_, err := f.WriteAt([]byte{0, 0}, int64(root*pageSize+8))
+		return err
+	})
+}
+
+// mustOpenCorruptedWith creates a database with an "other" bucket and a
+// "widgets" bucket whose root is a branch page, corrupts the file with fn
+// and reopens it. Returns the database and the id of the root page.
+func mustOpenCorruptedWith(t *testing.T, fn func(f *os.File, root, pageSize int) error) (corruptedDB, int) {
+	db := MustOpenDB()
+	var root int
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucket([]byte("other")); err != nil {
+			return err
+		}
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put(u64tob(uint64(i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.View(func(tx *bolt.Tx) error {
+		root = int(tx.Bucket([]byte("widgets")).Root())
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	path, pageSize := db.Path(), db.Info().PageSize
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fn(f, root, pageSize); err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := bolt.Open(path, 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return corruptedDB{d}, root
+}
@@ -0,0 +1,583 @@
+/*
+Package exchange reads and writes the buckets and keys of a Bolt database as
+JSON Lines or CSV so that data can be moved between databases and loaded
+into other tools.
+
+A stream is a sequence of records. A bucket record names a bucket and its
+sequence and precedes the keys of the bucket. A key record holds a key and
+value and the path of the bucket containing it. Bucket names and keys use the
+key encoding and values use the value encoding.
+
+JSON Lines streams contain one object per line. Bucket paths are arrays and
+bucket records have no key:
+
+	{"bucket":["widgets"],"sequence":3}
+	{"bucket":["widgets"],"key":"foo","value":"bar"}
+
+CSV streams start with a header naming the bucket, key, value and sequence
+columns. Bucket paths are joined with "/" after encoding each name, with "%"
+and "/" in the encoded names escaped as "%25" and "%2F":
+
+	bucket,key,value,sequence
+	widgets,,,3
+	widgets,foo,bar,
+*/
+package exchange
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/boltdb/bolt"
+)
+
+// Format is the syntax of a stream.
+type Format string
+
+// Supported formats.
+const (
+	JSONLines Format = "jsonl"
+	CSV       Format = "csv"
+)
+
+// Encoding is the text encoding of keys, values and bucket names.
+type Encoding string
+
+// Supported encodings.
+const (
+	UTF8   Encoding = "utf8"
+	Hex    Encoding = "hex"
+	Base64 Encoding = "base64"
+)
+
+// Validate returns an error if the encoding is not supported.
+func (e Encoding) Validate() error {
+	switch e {
+	case UTF8, Hex, Base64:
+		return nil
+	}
+	return fmt.Errorf("unknown encoding: %s", e)
+}
+
+// Encode returns b as text. UTF-8 text is returned as is without checking
+// that b is valid UTF-8.
+func (e Encoding) Encode(b []byte) string {
+	switch e {
+	case Hex:
+		return hex.EncodeToString(b)
+	case Base64:
+		return base64.StdEncoding.EncodeToString(b)
+	}
+	return string(b)
+}
+
+// Decode returns the bytes of s.
+func (e Encoding) Decode(s string) ([]byte, error) {
+	switch e {
+	case Hex:
+		return hex.DecodeString(s)
+	case Base64:
+		return base64.StdEncoding.DecodeString(s)
+	}
+	return []byte(s), nil
+}
+
+// FormatPath returns a bucket path as the encoded names joined by "/".
+func FormatPath(path [][]byte, e Encoding) string {
+	names := make([]string, len(path))
+	for i, name := range path {
+		s := strings.ReplaceAll(e.Encode(name), "%", "%25")
+		names[i] = strings.ReplaceAll(s, "/", "%2F")
+	}
+	return strings.Join(names, "/")
+}
+
+// ParsePath parses a bucket path written by FormatPath. An empty string is
+// an empty path.
+func ParsePath(s string, e Encoding) ([][]byte, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var path [][]byte
+	for _, name := range strings.Split(s, "/") {
+		name = strings.ReplaceAll(name, "%2F", "/")
+		name = strings.ReplaceAll(name, "%25", "%")
+		b, err := e.Decode(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket path %q: %s", s, err)
+		}
+		path = append(path, b)
+	}
+	return path, nil
+}
+
+// Record is a bucket or a key in a stream.
+type Record struct {
+	// Bucket is the path of the bucket, or of the bucket containing the
+	// key, from the top level.
+	Bucket [][]byte
+
+	// Key and Value are the key and value of a key record. Key is nil for
+	// bucket records.
+	Key   []byte
+	Value []byte
+
+	// Sequence is the sequence of the bucket of a bucket record.
+	Sequence uint64
+}
+
+// Options configures a Reader or Writer.
+type Options struct {
+	// Format is the syntax of the stream. Defaults to JSONLines.
+	Format Format
+
+	// KeyEncoding and ValueEncoding are the encodings of bucket names and
+	// keys and of values. Default to Base64.
+	KeyEncoding   Encoding
+	ValueEncoding Encoding
+}
+
+// withDefaults returns a copy of the options with default values set and
+// returns an error if a value is not supported.
+func (o *Options) withDefaults() (Options, error) {
+	var opts Options
+	if o != nil {
+		opts = *o
+	}
+	if opts.Format == "" {
+		opts.Format = JSONLines
+	}
+	if opts.KeyEncoding == "" {
+		opts.KeyEncoding = Base64
+	}
+	if opts.ValueEncoding == "" {
+		opts.ValueEncoding = Base64
+	}
+
+	if opts.Format != JSONLines && opts.Format != CSV {
+		return opts, fmt.Errorf("unknown format: %s", opts.Format)
+	} else if err := opts.KeyEncoding.Validate(); err != nil {
+		return opts, err
+	} else if err := opts.ValueEncoding.Validate(); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// jsonRecord is a record in a JSON Lines stream.
+type jsonRecord struct {
+	Bucket   []string `json:"bucket,omitempty"`
+	Key      *string  `json:"key,omitempty"`
+	Value    *string  `json:"value,omitempty"`
+	Sequence uint64   `json:"sequence,omitempty"`
+}
+
+// csvHeader is the header of a CSV stream.
+var csvHeader = []string{"bucket", "key", "value", "sequence"}
+
+// Writer writes records to a stream.
+type Writer struct {
+	opts Options
+	w    *bufio.Writer
+	enc  *json.Encoder
+	csv  *csv.Writer
+}
+
+// NewWriter returns a Writer that writes to w. Returns an error if the
+// options are not supported.
+func NewWriter(w io.Writer, opts *Options) (*Writer, error) {
+	o, err := opts.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	wr := &Writer{opts: o, w: bufio.NewWriter(w)}
+	if o.Format == CSV {
+		wr.csv = csv.NewWriter(wr.w)
+		if err := wr.csv.Write(csvHeader); err != nil {
+			return nil, err
+		}
+	} else {
+		wr.enc = json.NewEncoder(wr.w)
+		wr.enc.SetEscapeHTML(false)
+	}
+	return wr, nil
+}
+
+// Write writes a record. Records are buffered until Flush is called.
+// Returns an error if a bucket name, key or value is not valid UTF-8 and is
+// written with the UTF8 encoding, as it would not be read back unchanged.
+func (w *Writer) Write(r *Record) error {
+	if err := w.validate(r); err != nil {
+		return err
+	}
+	if w.csv != nil {
+		row := []string{FormatPath(r.Bucket, w.opts.KeyEncoding), "", "", ""}
+		if r.Key != nil {
+			row[1], row[2] = w.opts.KeyEncoding.Encode(r.Key), w.opts.ValueEncoding.Encode(r.Value)
+		} else {
+			row[3] = strconv.FormatUint(r.Sequence, 10)
+		}
+		return w.csv.Write(row)
+	}
+
+	rec := jsonRecord{Bucket: make([]string, len(r.Bucket))}
+	for i, name := range r.Bucket {
+		rec.Bucket[i] = w.opts.KeyEncoding.Encode(name)
+	}
+	if r.Key != nil {
+		k, v := w.opts.KeyEncoding.Encode(r.Key), w.opts.ValueEncoding.Encode(r.Value)
+		rec.Key, rec.Value = &k, &v
+	} else {
+		rec.Sequence = r.Sequence
+	}
+	return w.enc.Encode(&rec)
+}
+
+// validate returns an error if a record cannot be written with the UTF8
+// encoding.
+func (w *Writer) validate(r *Record) error {
+	if w.opts.KeyEncoding == UTF8 {
+		for _, name := range r.Bucket {
+			if !utf8.Valid(name) {
+				return fmt.Errorf("bucket name %q is not valid UTF-8; use the hex or base64 key encoding", name)
+			}
+		}
+		if r.Key != nil && !utf8.Valid(r.Key) {
+			return fmt.Errorf("bucket %s: key %q is not valid UTF-8; use the hex or base64 key encoding", FormatPath(r.Bucket, Hex), r.Key)
+		}
+	}
+	if w.opts.ValueEncoding == UTF8 && r.Key != nil && !utf8.Valid(r.Value) {
+		return fmt.Errorf("bucket %s: value of key %q is not valid UTF-8; use the hex or base64 value encoding", FormatPath(r.Bucket, Hex), r.Key)
+	}
+	return nil
+}
+
+// Flush writes buffered records to the underlying writer.
+func (w *Writer) Flush() error {
+	if w.csv != nil {
+		w.csv.Flush()
+		if err := w.csv.Error(); err != nil {
+			return err
+		}
+	}
+	return w.w.Flush()
+}
+
+// Reader reads records from a stream.
+type Reader struct {
+	opts Options
+	line int
+	dec  *json.Decoder
+	csv  *csv.Reader
+	cols map[string]int // index of each CSV column
+}
+
+// NewReader returns a Reader that reads from r. Returns an error if the
+// options are not supported.
+func NewReader(r io.Reader, opts *Options) (*Reader, error) {
+	o, err := opts.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	rd := &Reader{opts: o}
+	if o.Format == CSV {
+		rd.csv = csv.NewReader(r)
+	} else {
+		rd.dec = json.NewDecoder(r)
+	}
+	return rd, nil
+}
+
+// Line returns the number of the last record read, counting from 1. CSV
+// headers are counted.
+func (r *Reader) Line() int {
+	return r.line
+}
+
+// Read returns the next record. Returns io.EOF at the end of the stream.
+func (r *Reader) Read() (*Record, error) {
+	rec, err := r.read()
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("line %d: %w", r.line, err)
+	}
+	return rec, err
+}
+
+// read returns the next record.
+func (r *Reader) read() (*Record, error) {
+	if r.csv != nil {
+		return r.readCSV()
+	}
+
+	var rec jsonRecord
+	if err := r.dec.Decode(&rec); err == io.EOF {
+		return nil, io.EOF
+	} else if err != nil {
+		r.line++
+		return nil, err
+	}
+	r.line++
+
+	out := &Record{Sequence: rec.Sequence}
+	for _, name := range rec.Bucket {
+		b, err := r.opts.KeyEncoding.Decode(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket name %q: %s", name, err)
+		}
+		out.Bucket = append(out.Bucket, b)
+	}
+	if rec.Key == nil {
+		return out, nil
+	}
+	return out, r.decodeKeyValue(out, *rec.Key, rec.Value)
+}
+
+// readCSV returns the next record of a CSV stream, reading the header first.
+func (r *Reader) readCSV() (*Record, error) {
+	if r.cols == nil {
+		r.line++
+		header, err := r.csv.Read()
+		if err == io.EOF {
+			return nil, io.EOF
+		} else if err != nil {
+			return nil, err
+		}
+		r.cols = make(map[string]int)
+		for i, name := range header {
+			r.cols[name] = i
+		}
+		for _, name := range csvHeader[:3] {
+			if _, ok := r.cols[name]; !ok {
+				return nil, fmt.Errorf("missing column: %s", name)
+			}
+		}
+		r.csv.FieldsPerRecord = len(header)
+	}
+
+	row, err := r.csv.Read()
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	r.line++
+	if err != nil {
+		return nil, err
+	}
+
+	path, err := ParsePath(row[r.cols["bucket"]], r.opts.KeyEncoding)
+	if err != nil {
+		return nil, err
+	}
+	out := &Record{Bucket: path}
+
+	// Rows without a key are bucket records.
+	if key := row[r.cols["key"]]; key != "" {
+		value := row[r.cols["value"]]
+		return out, r.decodeKeyValue(out, key, &value)
+	}
+	if i, ok := r.cols["sequence"]; ok && row[i] != "" {
+		if out.Sequence, err = strconv.ParseUint(row[i], 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid sequence: %s", err)
+		}
+	}
+	return out, nil
+}
+
+// decodeKeyValue decodes the key and value of a key record.
+func (r *Reader) decodeKeyValue(rec *Record, key string, value *string) error {
+	var err error
+	if rec.Key, err = r.opts.KeyEncoding.Decode(key); err != nil {
+		return fmt.Errorf("invalid key %q: %s", key, err)
+	} else if len(rec.Key) == 0 {
+		return bolt.ErrKeyRequired
+	}
+	rec.Value = []byte{}
+	if value != nil {
+		if rec.Value, err = r.opts.ValueEncoding.Decode(*value); err != nil {
+			return fmt.Errorf("invalid value: %s", err)
+		}
+	}
+	return nil
+}
+
+// Export writes the buckets at the given paths, with their nested buckets
+// and keys, to w. All buckets are written if no path is given. Each bucket
+// record is followed by the keys of the bucket in order. Returns the number
+// of keys written. The writer is not flushed.
+func Export(tx *bolt.Tx, w *Writer, paths ...[][]byte) (int, error) {
+	var n int
+	if len(paths) == 0 {
+		err := tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			return export(w, b, [][]byte{name}, &n)
+		})
+		return n, err
+	}
+
+	for _, path := range paths {
+		if len(path) == 0 {
+			return n, errors.New("bucket path required")
+		}
+		b := tx.Bucket(path[0])
+		for i := 1; b != nil && i < len(path); i++ {
+			b = b.Bucket(path[i])
+		}
+		if b == nil {
+			return n, &bolt.BucketError{Path: path, Err: bolt.ErrBucketNotFound}
+		}
+		if err := export(w, b, path, &n); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// export writes a bucket and everything below it.
+func export(w *Writer, b *bolt.Bucket, path [][]byte, n *int) error {
+	if err := w.Write(&Record{Bucket: path, Sequence: b.Sequence()}); err != nil {
+		return err
+	}
+	return b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			return export(w, b.Bucket(k), append(path[:len(path):len(path)], k), n)
+		}
+		*n++
+		return w.Write(&Record{Bucket: path, Key: k, Value: v})
+	})
+}
+
+// ImportOptions configures Import.
+type ImportOptions struct {
+	// Bucket is prepended to the path of each record, so that records are
+	// imported below it. Records without a path are stored in it.
+	Bucket [][]byte
+
+	// TxMaxSize is the number of bytes of keys and values written in a
+	// single transaction. Zero imports everything in one transaction.
+	TxMaxSize int
+
+	// FillPercent sets Bucket.FillPercent on the buckets written. Defaults
+	// to bolt.DefaultFillPercent.
+	FillPercent float64
+}
+
+// Import reads records from r and writes them to db. Buckets are created if
+// they do not exist, bucket records set the sequence of their bucket and key
+// records overwrite existing keys. Transactions are committed every
+// TxMaxSize bytes, so an error leaves the records before it imported.
+// Returns the number of keys written.
+func Import(db *bolt.DB, r *Reader, opts *ImportOptions) (n int, err error) {
+	if opts == nil {
+		opts = &ImportOptions{}
+	}
+	im := &importer{opts: opts}
+	if im.tx, err = db.Begin(true); err != nil {
+		return 0, err
+	}
+	defer func() { _ = im.tx.Rollback() }()
+
+	var size int
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return n, err
+		}
+
+		// Commit and start a new transaction once we exceed the size limit.
+		sz := len(rec.Key) + len(rec.Value)
+		if size+sz > opts.TxMaxSize && opts.TxMaxSize != 0 {
+			if err := im.tx.Commit(); err != nil {
+				return n, err
+			}
+			if im.tx, err = db.Begin(true); err != nil {
+				return n, err
+			}
+			im.path, im.bucket = nil, nil
+			size = 0
+		}
+		size += sz
+
+		if err := im.write(rec); err != nil {
+			return n, fmt.Errorf("line %d: %w", r.Line(), err)
+		}
+		if rec.Key != nil {
+			n++
+		}
+	}
+	return n, im.tx.Commit()
+}
+
+// importer writes records within a transaction.
+type importer struct {
+	opts   *ImportOptions
+	tx     *bolt.Tx
+	path   [][]byte     // path of the last bucket written
+	bucket *bolt.Bucket // last bucket written
+}
+
+// write writes a record.
+func (im *importer) write(rec *Record) error {
+	path := append(im.opts.Bucket[:len(im.opts.Bucket):len(im.opts.Bucket)], rec.Bucket...)
+	if len(path) == 0 {
+		return errors.New("bucket required")
+	}
+	b, err := im.open(path)
+	if err != nil {
+		return err
+	}
+	if rec.Key == nil {
+		if rec.Sequence == 0 {
+			return nil
+		}
+		return b.SetSequence(rec.Sequence)
+	}
+	return b.Put(rec.Key, rec.Value)
+}
+
+// open returns the bucket at path, creating it and its parents if needed.
+func (im *importer) open(path [][]byte) (*bolt.Bucket, error) {
+	if im.bucket != nil && equalPath(path, im.path) {
+		return im.bucket, nil
+	}
+
+	b, err := im.tx.CreateBucketIfNotExists(path[0])
+	for i := 1; err == nil && i < len(path); i++ {
+		b, err = b.CreateBucketIfNotExists(path[i])
+	}
+	if err != nil {
+		return nil, err
+	}
+	if im.opts.FillPercent != 0 {
+		b.FillPercent = im.opts.FillPercent
+	}
+
+	im.path = make([][]byte, len(path))
+	for i, name := range path {
+		im.path[i] = append([]byte(nil), name...)
+	}
+	im.bucket = b
+	return b, nil
+}
+
+// equalPath returns true if two bucket paths are equal.
+func equalPath(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
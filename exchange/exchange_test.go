@@ -0,0 +1,281 @@
+package exchange_test
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/boltdb/bolt/exchange"
+)
+
+// Ensure that exported buckets, sequences and keys are imported unchanged in
+// every format and encoding.
+func TestExportImport(t *testing.T) {
+	for _, format := range []exchange.Format{exchange.JSONLines, exchange.CSV} {
+		for _, enc := range []exchange.Encoding{exchange.UTF8, exchange.Hex, exchange.Base64} {
+			t.Run(fmt.Sprintf("%s/%s", format, enc), func(t *testing.T) {
+				src := mustOpenDB(t)
+				mustFill(t, src, enc != exchange.UTF8)
+				opts := &exchange.Options{Format: format, KeyEncoding: enc, ValueEncoding: enc}
+
+				var buf bytes.Buffer
+				w, err := exchange.NewWriter(&buf, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := src.View(func(tx *bolt.Tx) error {
+					n, err := exchange.Export(tx, w)
+					if err != nil {
+						return err
+					} else if n != 1002 {
+						t.Fatalf("unexpected key count: %d", n)
+					}
+					return w.Flush()
+				}); err != nil {
+					t.Fatal(err)
+				}
+
+				dst := mustOpenDB(t)
+				r, err := exchange.NewReader(bytes.NewReader(buf.Bytes()), opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if n, err := exchange.Import(dst, r, &exchange.ImportOptions{TxMaxSize: 4096}); err != nil {
+					t.Fatal(err)
+				} else if n != 1002 {
+					t.Fatalf("unexpected import count: %d", n)
+				}
+
+				if exp, got := dump(t, src), dump(t, dst); exp != got {
+					t.Fatalf("unexpected contents:\n%s\nexpected:\n%s", got, exp)
+				}
+			})
+		}
+	}
+}
+
+// Ensure that binary keys are rejected under the utf8 encoding instead of
+// being replaced, and round-trip under a binary key encoding.
+func TestExportImport_BinaryKeys(t *testing.T) {
+	for _, format := range []exchange.Format{exchange.JSONLines, exchange.CSV} {
+		t.Run(string(format), func(t *testing.T) {
+			src := mustOpenDB(t)
+			mustFill(t, src, true)
+
+			export := func(opts *exchange.Options) ([]byte, error) {
+				var buf bytes.Buffer
+				w, err := exchange.NewWriter(&buf, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = src.View(func(tx *bolt.Tx) error {
+					if _, err := exchange.Export(tx, w); err != nil {
+						return err
+					}
+					return w.Flush()
+				})
+				return buf.Bytes(), err
+			}
+
+			opts := &exchange.Options{Format: format, KeyEncoding: exchange.UTF8, ValueEncoding: exchange.UTF8}
+			if _, err := export(opts); err == nil || !strings.Contains(err.Error(), "is not valid UTF-8") {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			opts.KeyEncoding = exchange.Base64
+			data, err := export(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dst := mustOpenDB(t)
+			r, err := exchange.NewReader(bytes.NewReader(data), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := exchange.Import(dst, r, nil); err != nil {
+				t.Fatal(err)
+			}
+			if exp, got := dump(t, src), dump(t, dst); exp != got {
+				t.Fatalf("unexpected contents:\n%s\nexpected:\n%s", got, exp)
+			}
+		})
+	}
+}
+
+// Ensure that selected buckets are exported with their full path and can
+// be imported below another bucket.
+func TestExport_Buckets(t *testing.T) {
+	src := mustOpenDB(t)
+	mustFill(t, src, false)
+
+	var buf bytes.Buffer
+	opts := &exchange.Options{Format: exchange.CSV, KeyEncoding: exchange.UTF8, ValueEncoding: exchange.UTF8}
+	w, _ := exchange.NewWriter(&buf, opts)
+	if err := src.View(func(tx *bolt.Tx) error {
+		if _, err := exchange.Export(tx, w, [][]byte{[]byte("a/b%"), []byte("empty")}); err != nil {
+			return err
+		}
+		return w.Flush()
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if exp := "bucket,key,value,sequence\na%2Fb%25/empty,,,7\n"; buf.String() != exp {
+		t.Fatalf("unexpected output: %q", buf.String())
+	}
+
+	// Missing buckets are reported.
+	if err := src.View(func(tx *bolt.Tx) error {
+		_, err := exchange.Export(tx, w, [][]byte{[]byte("missing")})
+		return err
+	}); err == nil || !strings.Contains(err.Error(), "bucket not found") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	dst := mustOpenDB(t)
+	r, _ := exchange.NewReader(&buf, opts)
+	if _, err := exchange.Import(dst, r, &exchange.ImportOptions{Bucket: [][]byte{[]byte("copy")}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := dst.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte("copy")).Bucket([]byte("a/b%")).Bucket([]byte("empty")); b == nil || b.Sequence() != 7 {
+			t.Fatal("expected bucket with sequence")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that records without a bucket are stored in the import bucket.
+func TestImport_Bucket(t *testing.T) {
+	db := mustOpenDB(t)
+	r, _ := exchange.NewReader(strings.NewReader(`{"key":"Zm9v","value":"YmFy"}`+"\n"), nil)
+	if _, err := exchange.Import(db, r, &exchange.ImportOptions{Bucket: [][]byte{[]byte("widgets")}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte("widgets")).Get([]byte("foo")); string(v) != "bar" {
+			t.Fatalf("unexpected value: %q", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A bucket is required otherwise.
+	r, _ = exchange.NewReader(strings.NewReader(`{"key":"Zm9v","value":"YmFy"}`+"\n"), nil)
+	if _, err := exchange.Import(db, r, nil); err == nil || err.Error() != "line 1: bucket required" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure that invalid input is reported with its line number.
+func TestReader_Errors(t *testing.T) {
+	for _, tt := range []struct {
+		format exchange.Format
+		input  string
+		err    string
+	}{
+		{exchange.JSONLines, `{"bucket":["YQ=="]}` + "\n" + `{"bucket":["YQ=="],"key":"!"}`, `line 2: invalid key "!"`},
+		{exchange.JSONLines, `{"bucket":["YQ=="],"key":""}`, "line 1: key required"},
+		{exchange.CSV, "bucket,key\n", "line 1: missing column: value"},
+		{exchange.CSV, "bucket,key,value,sequence\nYQ==,,,x\n", "line 2: invalid sequence"},
+	} {
+		r, err := exchange.NewReader(strings.NewReader(tt.input), &exchange.Options{Format: tt.format})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for err == nil {
+			_, err = r.Read()
+		}
+		if !strings.HasPrefix(err.Error(), tt.err) {
+			t.Errorf("%s: unexpected error: %v", tt.input, err)
+		}
+	}
+
+	if _, err := exchange.NewReader(nil, &exchange.Options{Format: "xml"}); err == nil {
+		t.Fatal("expected error")
+	} else if _, err := exchange.NewWriter(nil, &exchange.Options{KeyEncoding: "rot13"}); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+// mustFill creates nested buckets with sequences and 1002 keys. Keys are
+// binary if binary is true.
+func mustFill(t *testing.T, db *bolt.DB, binary bool) {
+	if err := db.Update(func(tx *bolt.Tx) error {
+		parent, err := tx.CreateBucket([]byte("a/b%"))
+		if err != nil {
+			return err
+		}
+		if err := parent.SetSequence(3); err != nil {
+			return err
+		}
+		b, err := parent.CreateBucket([]byte("empty"))
+		if err != nil {
+			return err
+		} else if err := b.SetSequence(7); err != nil {
+			return err
+		}
+		if b, err = tx.CreateBucket([]byte("widgets")); err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			k := []byte(fmt.Sprintf("key,%04d", i))
+			if binary {
+				k = append(k, 0xff, '\n', '"')
+			}
+			if err := b.Put(k, []byte(fmt.Sprintf("value %d", i))); err != nil {
+				return err
+			}
+		}
+		if err := parent.Put([]byte("x"), []byte{}); err != nil {
+			return err
+		}
+		return parent.Put([]byte("y"), []byte("z"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// dump returns the contents of a database as text.
+func dump(t *testing.T, db *bolt.DB) string {
+	var buf bytes.Buffer
+	var walk func(b *bolt.Bucket, indent string)
+	walk = func(b *bolt.Bucket, indent string) {
+		fmt.Fprintf(&buf, "%sseq=%d\n", indent, b.Sequence())
+		_ = b.ForEach(func(k, v []byte) error {
+			if v == nil {
+				fmt.Fprintf(&buf, "%s%q:\n", indent, k)
+				walk(b.Bucket(k), indent+"  ")
+				return nil
+			}
+			fmt.Fprintf(&buf, "%s%q=%q\n", indent, k, v)
+			return nil
+		})
+	}
+	if err := db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			fmt.Fprintf(&buf, "%q:\n", name)
+			walk(b, "  ")
+			return nil
+		})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+// mustOpenDB returns a new database that is closed at the end of the test.
+func mustOpenDB(t *testing.T) *bolt.DB {
+	path := filepath.Join(t.TempDir(), "bolt.db")
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
@@ -13,16 +13,22 @@ import (
 	和写事务刚释放的page
 */
 type freelist struct {
-	ids     []pgid          // all free and available free page ids.	所有缓存页ID的排序数组
-	pending map[txid][]pgid // mapping of soon-to-be free page ids by tx.	存储每个事务所缓存的页列表
-	cache   map[pgid]bool   // fast lookup of all free and pending page ids.
+	ids           []pgid          // all free and available free page ids.	所有缓存页ID的排序数组
+	pending       map[txid][]pgid // mapping of soon-to-be free page ids by tx.	存储每个事务所缓存的页列表
+	cache         map[pgid]bool   // fast lookup of all free and pending page ids.
+	allocs        map[pgid]txid   // tx that allocated each page in use, if known.
+	allocTxs      map[txid][]pgid // pages recorded in allocs by each tx.
+	pendingAllocs map[txid][]txid // tx that allocated each pending page id, or zero.
 }
 
 // newFreelist returns an empty, initialized freelist.
 func newFreelist() *freelist {
 	return &freelist{
-		pending: make(map[txid][]pgid),
-		cache:   make(map[pgid]bool),
+		pending:       make(map[txid][]pgid),
+		cache:         make(map[pgid]bool),
+		allocs:        make(map[pgid]txid),
+		allocTxs:      make(map[txid][]pgid),
+		pendingAllocs: make(map[txid][]txid),
 	}
 }
 
@@ -121,6 +127,29 @@ func (f *freelist) allocate(n int) pgid {
 	return 0
 }
 
+// allocated records the transaction that allocated the page starting at id.
+func (f *freelist) allocated(txid txid, id pgid) {
+	f.allocs[id] = txid
+	f.allocTxs[txid] = append(f.allocTxs[txid], id)
+}
+
+// prune forgets the allocating transaction of pages allocated at or before
+// txid. Ranges released by releaseRange start after the oldest open reader so
+// pages allocated before it can only be released by release.
+func (f *freelist) prune(txid txid) {
+	for tid, ids := range f.allocTxs {
+		if tid > txid {
+			continue
+		}
+		for _, id := range ids {
+			if f.allocs[id] == tid {
+				delete(f.allocs, id)
+			}
+		}
+		delete(f.allocTxs, tid)
+	}
+}
+
 // free releases a page and its overflow for a given transaction id.
 // If the page is already free then a panic will occur.
 func (f *freelist) free(txid txid, p *page) {
@@ -128,8 +157,17 @@ func (f *freelist) free(txid txid, p *page) {
 		panic(fmt.Sprintf("cannot free page 0 or 1: %d", p.id))
 	}
 
+	// Look up the transaction that allocated the page. The current freelist
+	// page is always written by the previous transaction.
+	allocTxid, ok := f.allocs[p.id]
+	if ok {
+		delete(f.allocs, p.id)
+	} else if (p.flags & freelistPageFlag) != 0 {
+		allocTxid = txid - 1
+	}
+
 	// Free page and all its overflow pages.
-	var ids = f.pending[txid]
+	var ids, allocs = f.pending[txid], f.pendingAllocs[txid]
 	for id := p.id; id <= p.id+pgid(p.overflow); id++ {
 		// Verify that page is not already free.
 		if f.cache[id] {
@@ -138,9 +176,11 @@ func (f *freelist) free(txid txid, p *page) {
 
 		// Add to the freelist and cache.
 		ids = append(ids, id)
+		allocs = append(allocs, allocTxid)
 		f.cache[id] = true
 	}
 	f.pending[txid] = ids
+	f.pendingAllocs[txid] = allocs
 }
 
 // release moves all page ids for a transaction id (or older) to the freelist.
@@ -152,6 +192,49 @@ func (f *freelist) release(txid txid) {
 			// Don't remove from the cache since the page is still free.
 			m = append(m, ids...)
 			delete(f.pending, tid)
+			delete(f.pendingAllocs, tid)
+		}
+	}
+	sort.Sort(m)
+	f.ids = pgids(f.ids).merge(m)
+}
+
+// releaseRange moves pending pages to the freelist if they were both
+// allocated and freed by transactions with ids between begin and end. No
+// transaction outside of the range can see such pages so they can be reused
+// even while older and newer transactions are open.
+func (f *freelist) releaseRange(begin, end txid) {
+	if begin > end {
+		return
+	}
+	var m pgids
+	for tid, ids := range f.pending {
+		if tid < begin || tid > end {
+			continue
+		}
+		allocs := f.pendingAllocs[tid]
+
+		var keepIDs []pgid
+		var keepAllocs []txid
+		for i, id := range ids {
+			var atx txid
+			if i < len(allocs) {
+				atx = allocs[i]
+			}
+			if atx < begin || atx > end {
+				keepIDs = append(keepIDs, id)
+				keepAllocs = append(keepAllocs, atx)
+				continue
+			}
+			m = append(m, id)
+		}
+
+		if len(keepIDs) == 0 {
+			delete(f.pending, tid)
+			delete(f.pendingAllocs, tid)
+		} else {
+			f.pending[tid] = keepIDs
+			f.pendingAllocs[tid] = keepAllocs
 		}
 	}
 	sort.Sort(m)
@@ -160,13 +243,27 @@ func (f *freelist) release(txid txid) {
 
 // rollback removes the pages from a given pending tx.
 func (f *freelist) rollback(txid txid) {
-	// Remove page ids from cache.
-	for _, id := range f.pending[txid] {
+	// Remove page ids from cache. Pages that were allocated by an earlier
+	// transaction are in use again.
+	allocs := f.pendingAllocs[txid]
+	for i, id := range f.pending[txid] {
 		delete(f.cache, id)
+		if i < len(allocs) && allocs[i] != 0 && allocs[i] != txid {
+			f.allocated(allocs[i], id)
+		}
+	}
+
+	// Pages allocated by the transaction are free again.
+	for _, id := range f.allocTxs[txid] {
+		if f.allocs[id] == txid {
+			delete(f.allocs, id)
+		}
 	}
+	delete(f.allocTxs, txid)
 
 	// Remove pages from pending list.
 	delete(f.pending, txid)
+	delete(f.pendingAllocs, txid)
 }
 
 // freed returns whether a given page is in the free list.
@@ -44,6 +44,89 @@ func TestFreelist_release(t *testing.T) {
 	}
 }
 
+// Ensure that pages allocated and freed within a range of transactions can be
+// released while pages visible outside of the range are kept pending.
+func TestFreelist_releaseRange(t *testing.T) {
+	f := newFreelist()
+	f.allocated(100, 20)
+	f.allocated(103, 30)
+	f.allocated(104, 40)
+	f.allocated(106, 50)
+	f.free(105, &page{id: 20})
+	f.free(105, &page{id: 30, overflow: 1})
+	f.free(105, &page{id: 40})
+	f.free(107, &page{id: 50})
+	f.free(107, &page{id: 60})
+
+	// Readers at 102 and 106: only pages 30 and 40 lived between them.
+	f.releaseRange(103, 105)
+	if exp := []pgid{30, 31, 40}; !reflect.DeepEqual(exp, f.ids) {
+		t.Fatalf("exp=%v; got=%v", exp, f.ids)
+	} else if exp := []pgid{20}; !reflect.DeepEqual(exp, f.pending[105]) {
+		t.Fatalf("exp=%v; got=%v", exp, f.pending[105])
+	} else if exp := []txid{100}; !reflect.DeepEqual(exp, f.pendingAllocs[105]) {
+		t.Fatalf("exp=%v; got=%v", exp, f.pendingAllocs[105])
+	}
+
+	// Pages with an unknown allocating transaction are never released early.
+	f.releaseRange(106, 110)
+	if exp := []pgid{30, 31, 40, 50}; !reflect.DeepEqual(exp, f.ids) {
+		t.Fatalf("exp=%v; got=%v", exp, f.ids)
+	} else if exp := []pgid{60}; !reflect.DeepEqual(exp, f.pending[107]) {
+		t.Fatalf("exp=%v; got=%v", exp, f.pending[107])
+	}
+
+	f.release(107)
+	if exp := []pgid{20, 30, 31, 40, 50, 60}; !reflect.DeepEqual(exp, f.ids) {
+		t.Fatalf("exp=%v; got=%v", exp, f.ids)
+	} else if len(f.pending) != 0 || len(f.pendingAllocs) != 0 {
+		t.Fatalf("unexpected pending: %v %v", f.pending, f.pendingAllocs)
+	}
+}
+
+// Ensure that rolling back a transaction restores the allocating transaction
+// of the pages it freed and forgets the pages it allocated.
+func TestFreelist_rollback(t *testing.T) {
+	f := newFreelist()
+	f.allocated(100, 20)
+	f.allocated(101, 30)
+	f.free(101, &page{id: 20})
+	f.free(101, &page{id: 30})
+	f.allocated(101, 40)
+
+	f.rollback(101)
+	if exp := map[pgid]txid{20: 100}; !reflect.DeepEqual(exp, f.allocs) {
+		t.Fatalf("exp=%v; got=%v", exp, f.allocs)
+	} else if len(f.pending) != 0 || len(f.pendingAllocs) != 0 || len(f.cache) != 0 {
+		t.Fatalf("unexpected pending: %v %v", f.pending, f.pendingAllocs)
+	} else if _, ok := f.allocTxs[101]; ok {
+		t.Fatalf("unexpected allocations: %v", f.allocTxs)
+	}
+}
+
+// Ensure that the allocating transactions of pages allocated at or before a
+// transaction are forgotten while later ones are kept.
+func TestFreelist_prune(t *testing.T) {
+	f := newFreelist()
+	f.allocated(100, 20)
+	f.allocated(101, 30)
+	f.allocated(102, 40)
+	f.free(102, &page{id: 30})
+	f.allocated(102, 30)
+
+	f.prune(101)
+	if exp := map[pgid]txid{30: 102, 40: 102}; !reflect.DeepEqual(exp, f.allocs) {
+		t.Fatalf("exp=%v; got=%v", exp, f.allocs)
+	} else if exp := map[txid][]pgid{102: {40, 30}}; !reflect.DeepEqual(exp, f.allocTxs) {
+		t.Fatalf("exp=%v; got=%v", exp, f.allocTxs)
+	}
+
+	f.prune(102)
+	if len(f.allocs) != 0 || len(f.allocTxs) != 0 {
+		t.Fatalf("unexpected allocations: %v %v", f.allocs, f.allocTxs)
+	}
+}
+
 // Ensure that a freelist can find contiguous blocks of pages.
 func TestFreelist_allocate(t *testing.T) {
 	f := &freelist{ids: []pgid{3, 4, 5, 6, 7, 9, 12, 13, 18}}
@@ -1,8 +1,7 @@
-module bolt
+module github.com/boltdb/bolt
 
-go 1.16
+go 1.23
 
 require (
-	github.com/boltdb/bolt v1.3.1
 	golang.org/x/sys v0.0.0-20210616094352-59db8d763f22
 )
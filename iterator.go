@@ -0,0 +1,190 @@
+package bolt
+
+import (
+	"bytes"
+	"iter"
+)
+
+// IteratorOptions restricts the keys visited by an Iterator.
+type IteratorOptions struct {
+	// LowerBound is the smallest key that is visited. If nil, iteration is
+	// not bounded from below.
+	LowerBound []byte
+
+	// UpperBound is the first key that is not visited. Keys must sort
+	// strictly before it. If nil, iteration is not bounded from above.
+	UpperBound []byte
+
+	// Prefix restricts iteration to keys that start with the prefix. It is
+	// combined with LowerBound and UpperBound.
+	Prefix []byte
+
+	// Reverse iterates from the last key in the range to the first.
+	Reverse bool
+}
+
+// Iterator walks the keys of a bucket within a range in either direction.
+// It wraps a Cursor and handles positioning and bounds checks so callers
+// only need to call Next() until it returns false:
+//
+//	it := b.Iterator(&bolt.IteratorOptions{Prefix: []byte("user:")})
+//	for it.Next() {
+//		fmt.Printf("%s=%s\n", it.Key(), it.Value())
+//	}
+//
+// As with Cursor, nested buckets are returned with a nil value and keys and
+// values are only valid for the life of the transaction.
+type Iterator struct {
+	cursor  *Cursor
+	lower   []byte
+	upper   []byte
+	reverse bool
+
+	key     []byte
+	value   []byte
+	started bool
+	done    bool
+}
+
+// Iterator creates an iterator over the bucket with the given options.
+// Passing nil options iterates over every key in ascending order.
+func (b *Bucket) Iterator(opts *IteratorOptions) *Iterator {
+	return b.Cursor().Iterator(opts)
+}
+
+// All returns an iterator over every key/value pair in the bucket for use
+// with a range statement.
+func (b *Bucket) All() iter.Seq2[[]byte, []byte] {
+	return b.Iterator(nil).All()
+}
+
+// Range returns an iterator over the key/value pairs selected by opts for
+// use with a range statement.
+func (b *Bucket) Range(opts *IteratorOptions) iter.Seq2[[]byte, []byte] {
+	return b.Iterator(opts).All()
+}
+
+// Iterator creates an iterator that moves this cursor. The cursor is
+// repositioned on the first call to Iterator.Next().
+func (c *Cursor) Iterator(opts *IteratorOptions) *Iterator {
+	it := &Iterator{cursor: c}
+	if opts == nil {
+		return it
+	}
+	it.lower, it.upper, it.reverse = opts.LowerBound, opts.UpperBound, opts.Reverse
+
+	// Narrow the bounds to the keys covered by the prefix.
+	if opts.Prefix != nil {
+		if it.lower == nil || bytes.Compare(opts.Prefix, it.lower) > 0 {
+			it.lower = opts.Prefix
+		}
+		if end := prefixEnd(opts.Prefix); end != nil && (it.upper == nil || bytes.Compare(end, it.upper) < 0) {
+			it.upper = end
+		}
+	}
+	return it
+}
+
+// Cursor returns the cursor that the iterator moves.
+func (it *Iterator) Cursor() *Cursor {
+	return it.cursor
+}
+
+// Next moves the iterator to the next key in the range. Returns false once
+// the range has been exhausted.
+func (it *Iterator) Next() bool {
+	if it.done {
+		return false
+	}
+
+	var k, v []byte
+	if !it.started {
+		it.started = true
+		k, v = it.first()
+	} else if it.reverse {
+		k, v = it.cursor.Prev()
+	} else {
+		k, v = it.cursor.Next()
+	}
+
+	// Stop once we move outside of the bounds.
+	if k != nil && !it.contains(k) {
+		k, v = nil, nil
+	}
+	if k == nil {
+		it.done = true
+	}
+	it.key, it.value = k, v
+	return k != nil
+}
+
+// Key returns the key at the current position.
+func (it *Iterator) Key() []byte {
+	return it.key
+}
+
+// Value returns the value at the current position. Nested buckets have a
+// nil value.
+func (it *Iterator) Value() []byte {
+	return it.value
+}
+
+// Err returns the error of the transaction's context if it stopped the
+// iteration. Returns nil otherwise.
+func (it *Iterator) Err() error {
+	return it.cursor.Err()
+}
+
+// All returns the remaining key/value pairs of the iterator for use with a
+// range statement.
+func (it *Iterator) All() iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		for it.Next() {
+			if !yield(it.key, it.value) {
+				return
+			}
+		}
+	}
+}
+
+// first positions the cursor on the first key in iteration order.
+func (it *Iterator) first() ([]byte, []byte) {
+	c := it.cursor
+	if !it.reverse {
+		if it.lower == nil {
+			return c.First()
+		}
+		return c.Seek(it.lower)
+	}
+
+	// Move to the last key before the upper bound.
+	if it.upper == nil {
+		return c.Last()
+	} else if k, _ := c.Seek(it.upper); k == nil {
+		return c.Last()
+	}
+	return c.Prev()
+}
+
+// contains returns whether a key is within the iterator's bounds.
+func (it *Iterator) contains(key []byte) bool {
+	if it.lower != nil && bytes.Compare(key, it.lower) < 0 {
+		return false
+	} else if it.upper != nil && bytes.Compare(key, it.upper) >= 0 {
+		return false
+	}
+	return true
+}
+
+// prefixEnd returns the smallest key that sorts after every key starting
+// with prefix. Returns nil if no such key exists.
+func prefixEnd(prefix []byte) []byte {
+	end := cloneBytes(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] != 0xFF {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
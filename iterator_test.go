@@ -0,0 +1,218 @@
+package bolt_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// Ensure that an iterator respects bounds, prefixes and direction.
+func TestIterator(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	keys := []string{"a", "b", "ba", "bb", "bc", "c", "d"}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range keys {
+			if err := b.Put([]byte(k), []byte(k)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		for i, tt := range []struct {
+			opts *bolt.IteratorOptions
+			exp  []string
+		}{
+			{nil, keys},
+			{&bolt.IteratorOptions{LowerBound: []byte("b"), UpperBound: []byte("c")}, []string{"b", "ba", "bb", "bc"}},
+			{&bolt.IteratorOptions{LowerBound: []byte("bab")}, []string{"bb", "bc", "c", "d"}},
+			{&bolt.IteratorOptions{UpperBound: []byte("bb")}, []string{"a", "b", "ba"}},
+			{&bolt.IteratorOptions{Prefix: []byte("b")}, []string{"b", "ba", "bb", "bc"}},
+			{&bolt.IteratorOptions{Prefix: []byte("b"), LowerBound: []byte("ba"), UpperBound: []byte("bc")}, []string{"ba", "bb"}},
+			{&bolt.IteratorOptions{Prefix: []byte("x")}, nil},
+			{&bolt.IteratorOptions{Reverse: true}, []string{"d", "c", "bc", "bb", "ba", "b", "a"}},
+			{&bolt.IteratorOptions{Reverse: true, UpperBound: []byte("bb")}, []string{"ba", "b", "a"}},
+			{&bolt.IteratorOptions{Reverse: true, UpperBound: []byte("zzz"), LowerBound: []byte("bc")}, []string{"d", "c", "bc"}},
+			{&bolt.IteratorOptions{Reverse: true, Prefix: []byte("b")}, []string{"bc", "bb", "ba", "b"}},
+		} {
+			var got []string
+			it := b.Iterator(tt.opts)
+			for it.Next() {
+				if !bytes.Equal(it.Key(), it.Value()) {
+					t.Fatalf("%d. unexpected value: %s", i, it.Value())
+				}
+				got = append(got, string(it.Key()))
+			}
+			if it.Next() {
+				t.Fatalf("%d. expected exhausted iterator", i)
+			} else if !reflect.DeepEqual(got, tt.exp) {
+				t.Fatalf("%d. unexpected keys: %v", i, got)
+			}
+
+			// Range-over-func should yield the same keys.
+			got = nil
+			for k := range b.Range(tt.opts) {
+				got = append(got, string(k))
+			}
+			if !reflect.DeepEqual(got, tt.exp) {
+				t.Fatalf("%d. unexpected keys from range: %v", i, got)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that breaking out of a range statement stops iteration.
+func TestBucket_All(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put(u64tob(uint64(i)), []byte("0")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := b.CreateBucket([]byte("sub")); err != nil {
+			t.Fatal(err)
+		}
+
+		var n int
+		for k, v := range b.All() {
+			if bytes.Equal(k, []byte("sub")) {
+				if v != nil {
+					t.Fatalf("expected nil value for bucket: %v", v)
+				}
+			} else if btou64(k) != uint64(n) {
+				t.Fatalf("unexpected key: %x", k)
+			}
+			if n++; n == 500 {
+				break
+			}
+		}
+		if n != 500 {
+			t.Fatalf("unexpected count: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that a cursor can seek to the last key less than or equal to a key.
+func TestCursor_SeekLE(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 10; i < 10000; i += 10 {
+			if err := b.Put(u64tob(uint64(i)), []byte("0")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte("widgets")).Cursor()
+		for _, tt := range []struct {
+			seek, exp uint64
+		}{
+			{10, 10},
+			{15, 10},
+			{4999, 4990},
+			{5000, 5000},
+			{9990, 9990},
+			{20000, 9990},
+		} {
+			if k, _ := c.SeekLE(u64tob(tt.seek)); k == nil || btou64(k) != tt.exp {
+				t.Fatalf("unexpected key for %d: %x", tt.seek, k)
+			}
+		}
+		if k, _ := c.SeekLE(u64tob(5)); k != nil {
+			t.Fatalf("expected nil key: %x", k)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that reverse iteration skips pages emptied within the transaction.
+func TestCursor_Last_EmptyPages(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put(u64tob(uint64(i)), []byte{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		for i := 200; i < 1000; i++ {
+			if err := b.Delete(u64tob(uint64(i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i := 300; i < 400; i++ {
+			if err := b.Put(u64tob(uint64(i)), []byte{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i := 300; i < 400; i++ {
+			if err := b.Delete(u64tob(uint64(i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		c := b.Cursor()
+		var n int
+		for k, _ := c.Last(); k != nil; k, _ = c.Prev() {
+			if exp := uint64(199 - n); btou64(k) != exp {
+				t.Fatalf("unexpected key: %d != %d", btou64(k), exp)
+			}
+			n++
+		}
+		if n != 200 {
+			t.Fatalf("unexpected key count: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,139 @@
+package bolt
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Logger receives diagnostic messages from a database. Messages are followed
+// by alternating keys and values. A *slog.Logger satisfies this interface.
+// Implementations must be safe for concurrent use.
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+// DefaultLogger is used when Options.Logger is nil. It writes warnings and
+// errors with the standard log package and discards other messages.
+var DefaultLogger Logger = stdLogger{}
+
+// DiscardLogger discards all messages.
+var DiscardLogger Logger = discardLogger{}
+
+// stdLogger writes warnings and errors with the standard log package.
+type stdLogger struct{}
+
+func (stdLogger) Debug(msg string, keyvals ...interface{}) {}
+func (stdLogger) Info(msg string, keyvals ...interface{})  {}
+func (stdLogger) Warn(msg string, keyvals ...interface{}) {
+	log.Print(formatLog("WARN", msg, keyvals))
+}
+func (stdLogger) Error(msg string, keyvals ...interface{}) {
+	log.Print(formatLog("ERROR", msg, keyvals))
+}
+
+// formatLog formats a message as "bolt: LEVEL msg key=value ...". Values
+// that span several lines, such as stacks, are written after the message.
+func formatLog(level, msg string, keyvals []interface{}) string {
+	var b, tail strings.Builder
+	fmt.Fprintf(&b, "bolt: %s %s", level, msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		var v interface{} = "(missing)"
+		if i+1 < len(keyvals) {
+			v = keyvals[i+1]
+		}
+		s := fmt.Sprint(v)
+		if strings.Contains(s, "\n") {
+			fmt.Fprintf(&tail, "\n%v:\n%s", keyvals[i], strings.TrimRight(s, "\n"))
+			continue
+		} else if strings.ContainsAny(s, " \t\"=") || s == "" {
+			s = fmt.Sprintf("%q", s)
+		}
+		fmt.Fprintf(&b, " %v=%s", keyvals[i], s)
+	}
+	return b.String() + tail.String()
+}
+
+// discardLogger discards all messages.
+type discardLogger struct{}
+
+func (discardLogger) Debug(msg string, keyvals ...interface{}) {}
+func (discardLogger) Info(msg string, keyvals ...interface{})  {}
+func (discardLogger) Warn(msg string, keyvals ...interface{})  {}
+func (discardLogger) Error(msg string, keyvals ...interface{}) {}
+
+// logger returns the logger of the database.
+func (db *DB) logger() Logger {
+	if db.Logger == nil {
+		return DefaultLogger
+	}
+	return db.Logger
+}
+
+// logSlowCommit logs a commit that took longer than SlowCommitThreshold.
+func (db *DB) logSlowCommit(tx *Tx, id int, start time.Time, pages int) {
+	d := time.Since(start)
+	if db.SlowCommitThreshold <= 0 || d < db.SlowCommitThreshold {
+		return
+	}
+	keyvals := []interface{}{
+		"txid", id,
+		"duration", d,
+		"rebalance", tx.stats.RebalanceTime,
+		"spill", tx.stats.SpillTime,
+		"write", tx.stats.WriteTime,
+		"pages", pages,
+		"nodes", tx.stats.NodeCount,
+		"open", time.Since(tx.info.Start),
+		"goroutine", tx.info.Goroutine,
+	}
+	if tx.info.Stack != "" {
+		keyvals = append(keyvals, "stack", tx.info.Stack)
+	}
+	db.logger().Warn("slow commit", keyvals...)
+}
+
+// writerInfo returns information about the open writable transaction, if
+// slow lock waits are logged and there is one.
+func (db *DB) writerInfo() *TxInfo {
+	if db.SlowLockWaitThreshold <= 0 {
+		return nil
+	}
+	db.metalock.Lock()
+	defer db.metalock.Unlock()
+	if db.rwtx == nil {
+		return nil
+	}
+	info := db.rwtx.info
+	return &info
+}
+
+// logSlowLockWait logs a transaction that waited longer than
+// SlowLockWaitThreshold to begin. holder is the transaction that held the
+// lock when the wait began, if known.
+func (db *DB) logSlowLockWait(t *Tx, lock string, wait time.Duration, holder *TxInfo) {
+	if db.SlowLockWaitThreshold <= 0 || wait < db.SlowLockWaitThreshold {
+		return
+	}
+	keyvals := []interface{}{
+		"txid", t.ID(),
+		"lock", lock,
+		"wait", wait,
+		"goroutine", t.info.Goroutine,
+	}
+	if holder != nil {
+		keyvals = append(keyvals,
+			"holder_txid", holder.ID,
+			"holder_start", holder.Start.Format(time.RFC3339Nano),
+			"holder_goroutine", holder.Goroutine,
+		)
+		if holder.Stack != "" {
+			keyvals = append(keyvals, "holder_stack", holder.Stack)
+		}
+	}
+	db.logger().Warn("slow lock wait", keyvals...)
+}
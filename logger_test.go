@@ -0,0 +1,173 @@
+package bolt_test
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Ensure that a *slog.Logger can be used as a Logger.
+var _ bolt.Logger = slog.Default()
+
+// logEntry is a message recorded by testLogger.
+type logEntry struct {
+	level  string
+	msg    string
+	fields map[string]interface{}
+}
+
+// testLogger is a Logger that records messages.
+type testLogger struct {
+	mu      sync.Mutex
+	entries []logEntry
+}
+
+func (l *testLogger) Debug(msg string, keyvals ...interface{}) { l.log("DEBUG", msg, keyvals) }
+func (l *testLogger) Info(msg string, keyvals ...interface{})  { l.log("INFO", msg, keyvals) }
+func (l *testLogger) Warn(msg string, keyvals ...interface{})  { l.log("WARN", msg, keyvals) }
+func (l *testLogger) Error(msg string, keyvals ...interface{}) { l.log("ERROR", msg, keyvals) }
+
+func (l *testLogger) log(level, msg string, keyvals []interface{}) {
+	e := logEntry{level: level, msg: msg, fields: make(map[string]interface{})}
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		e.fields[fmt.Sprint(keyvals[i])] = keyvals[i+1]
+	}
+	l.mu.Lock()
+	l.entries = append(l.entries, e)
+	l.mu.Unlock()
+}
+
+// find returns the first entry with the given message.
+func (l *testLogger) find(msg string) (logEntry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, e := range l.entries {
+		if e.msg == msg {
+			return e, true
+		}
+	}
+	return logEntry{}, false
+}
+
+// Ensure that commits slower than the threshold are logged with their phases.
+func TestDB_SlowCommitThreshold(t *testing.T) {
+	l := &testLogger{}
+	db := MustOpenWithOption(&bolt.Options{Logger: l, SlowCommitThreshold: time.Hour})
+	defer db.MustClose()
+
+	update := func() {
+		if err := db.Update(func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	update()
+	if _, ok := l.find("slow commit"); ok {
+		t.Fatal("unexpected slow commit")
+	}
+
+	db.SlowCommitThreshold = time.Nanosecond
+	update()
+	e, ok := l.find("slow commit")
+	if !ok {
+		t.Fatal("expected slow commit")
+	} else if e.level != "WARN" || e.fields["txid"] != 3 {
+		t.Fatalf("unexpected entry: %+v", e)
+	}
+	for _, key := range []string{"duration", "rebalance", "spill", "write", "pages", "goroutine"} {
+		if _, ok := e.fields[key]; !ok {
+			t.Fatalf("expected %s in %+v", key, e.fields)
+		}
+	}
+}
+
+// Ensure that slow lock waits are logged with the transaction holding the
+// writer lock.
+func TestDB_SlowLockWaitThreshold(t *testing.T) {
+	l := &testLogger{}
+	db := MustOpenWithOption(&bolt.Options{
+		Logger:                l,
+		SlowLockWaitThreshold: 20 * time.Millisecond,
+		TxStackTraces:         true,
+	})
+	defer db.MustClose()
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := tx.ID()
+	done := make(chan error)
+	go func() {
+		done <- db.Update(func(tx *bolt.Tx) error { return nil })
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	} else if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	e, ok := l.find("slow lock wait")
+	if !ok {
+		t.Fatal("expected slow lock wait")
+	} else if e.fields["lock"] != "writer" || e.fields["holder_txid"] != id {
+		t.Fatalf("unexpected entry: %+v", e)
+	} else if d, _ := e.fields["wait"].(time.Duration); d < 20*time.Millisecond {
+		t.Fatalf("unexpected wait: %v", e.fields["wait"])
+	} else if s, _ := e.fields["holder_stack"].(string); !strings.Contains(s, "TestDB_SlowLockWaitThreshold") {
+		t.Fatalf("unexpected holder stack: %s", s)
+	}
+}
+
+// Ensure that long-running read transactions are reported to the logger when
+// no handler is set.
+func TestDB_LongReadTxThreshold_Logger(t *testing.T) {
+	l := &testLogger{}
+	db := MustOpenWithOption(&bolt.Options{Logger: l, LongReadTxThreshold: 10 * time.Millisecond})
+	defer db.MustClose()
+
+	tx, err := db.Begin(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for i := 0; i < 500; i++ {
+		if e, ok := l.find("long-running read transaction"); ok {
+			if e.fields["txid"] != tx.ID() {
+				t.Fatalf("unexpected entry: %+v", e)
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("expected long-running read transaction to be logged")
+}
+
+// Ensure that the default logger writes warnings with the standard logger.
+func TestDefaultLogger(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	}()
+
+	bolt.DefaultLogger.Debug("hidden")
+	bolt.DefaultLogger.Warn("slow commit", "txid", 3, "path", "a b", "stack", "line1\nline2\n")
+	if exp := "bolt: WARN slow commit txid=3 path=\"a b\"\nstack:\nline1\nline2\n"; buf.String() != exp {
+		t.Fatalf("unexpected output: %q", buf.String())
+	}
+}
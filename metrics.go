@@ -0,0 +1,259 @@
+package bolt
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Latency metrics. These are reported with MetricsSink.Observe.
+const (
+	MetricCommit        = "commit"          // time spent in Commit() or CommitAsync()
+	MetricRebalance     = "rebalance"       // time spent rebalancing nodes during commit
+	MetricSpill         = "spill"           // time spent spilling nodes during commit
+	MetricWrite         = "write"           // time spent writing dirty pages during commit
+	MetricSync          = "sync"            // time spent in each fdatasync() call
+	MetricWriteLockWait = "write_lock_wait" // time a writable transaction waited to begin
+	MetricReadLockWait  = "read_lock_wait"  // time a read transaction waited to begin
+	MetricRemapLockWait = "remap_lock_wait" // time a remap waited for read transactions
+)
+
+// Counter metrics. These are reported with MetricsSink.Add.
+const (
+	MetricRemaps    = "remaps"     // number of times the data file was memory mapped
+	MetricGrows     = "grows"      // number of times the data file was grown
+	MetricGrowBytes = "grow_bytes" // total bytes added to the data file
+)
+
+// Gauge metrics. These are reported with MetricsSink.Set.
+const (
+	MetricFreePages    = "free_pages"    // free pages after the last write transaction
+	MetricPendingPages = "pending_pages" // pending pages after the last write transaction
+	MetricOpenReadTxs  = "open_read_txs" // number of open read transactions
+	MetricFileSize     = "file_size"     // size of the data file in bytes
+)
+
+// MetricsSink receives measurements from a database as they happen. Metric
+// names are the Metric constants. Implementations must be safe for concurrent
+// use and should return quickly since they are called while locks are held.
+type MetricsSink interface {
+	// Observe records the duration of an operation.
+	Observe(name string, d time.Duration)
+
+	// Add adds delta to a counter.
+	Add(name string, delta int64)
+
+	// Set sets the current value of a gauge.
+	Set(name string, value int64)
+}
+
+// observe reports the time elapsed since start to the metrics sink, if any.
+func (db *DB) observe(name string, start time.Time) {
+	if db.Metrics != nil {
+		db.Metrics.Observe(name, time.Since(start))
+	}
+}
+
+// count adds delta to a counter in the metrics sink, if any.
+func (db *DB) count(name string, delta int64) {
+	if db.Metrics != nil {
+		db.Metrics.Add(name, delta)
+	}
+}
+
+// gauge sets a gauge in the metrics sink, if any.
+func (db *DB) gauge(name string, value int64) {
+	if db.Metrics != nil {
+		db.Metrics.Set(name, value)
+	}
+}
+
+// syncData calls fdatasync() on the data file and reports its latency.
+func (db *DB) syncData() error {
+	ev := db.traceStart(TraceEvent{Name: TraceSync})
+	err := fdatasync(db)
+	db.observe(MetricSync, ev.Start)
+	db.traceEnd(ev, err)
+	return err
+}
+
+// histogramBounds are the upper bounds of the latency histogram buckets.
+var histogramBounds = []time.Duration{
+	10 * time.Microsecond, 25 * time.Microsecond, 50 * time.Microsecond,
+	100 * time.Microsecond, 250 * time.Microsecond, 500 * time.Microsecond,
+	1 * time.Millisecond, 2500 * time.Microsecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	1 * time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+}
+
+// Metrics is a MetricsSink that keeps latency histograms, counters and gauges
+// in memory. It can be published with expvar.Publish() since its String()
+// method returns JSON, and served to Prometheus as an http.Handler.
+type Metrics struct {
+	mu         sync.Mutex
+	histograms map[string]*Histogram
+	counters   map[string]int64
+	gauges     map[string]int64
+}
+
+// NewMetrics returns an empty set of metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		histograms: make(map[string]*Histogram),
+		counters:   make(map[string]int64),
+		gauges:     make(map[string]int64),
+	}
+}
+
+// Observe adds a duration to the histogram with the given name.
+func (m *Metrics) Observe(name string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := m.histograms[name]
+	if h == nil {
+		h = &Histogram{Bounds: histogramBounds, Counts: make([]uint64, len(histogramBounds))}
+		m.histograms[name] = h
+	}
+	h.Count++
+	h.Sum += d
+	for i := sort.Search(len(h.Bounds), func(i int) bool { return d <= h.Bounds[i] }); i < len(h.Counts); i++ {
+		h.Counts[i]++
+	}
+}
+
+// Add adds delta to the counter with the given name.
+func (m *Metrics) Add(name string, delta int64) {
+	m.mu.Lock()
+	m.counters[name] += delta
+	m.mu.Unlock()
+}
+
+// Set sets the gauge with the given name.
+func (m *Metrics) Set(name string, value int64) {
+	m.mu.Lock()
+	m.gauges[name] = value
+	m.mu.Unlock()
+}
+
+// Snapshot returns a copy of the current metrics.
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := MetricsSnapshot{
+		Histograms: make(map[string]Histogram, len(m.histograms)),
+		Counters:   make(map[string]int64, len(m.counters)),
+		Gauges:     make(map[string]int64, len(m.gauges)),
+	}
+	for name, h := range m.histograms {
+		c := *h
+		c.Counts = append([]uint64(nil), h.Counts...)
+		s.Histograms[name] = c
+	}
+	for name, v := range m.counters {
+		s.Counters[name] = v
+	}
+	for name, v := range m.gauges {
+		s.Gauges[name] = v
+	}
+	return s
+}
+
+// String returns the metrics as JSON. This implements expvar.Var.
+func (m *Metrics) String() string {
+	buf, err := json.Marshal(m.Snapshot())
+	if err != nil {
+		return "{}"
+	}
+	return string(buf)
+}
+
+// WritePrometheus writes the metrics in the Prometheus text exposition
+// format. Metric names are prefixed with "bolt_". Durations are in seconds.
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	s := m.Snapshot()
+	bw := bufio.NewWriter(w)
+
+	for _, name := range sortedKeys(s.Histograms) {
+		h := s.Histograms[name]
+		fmt.Fprintf(bw, "# TYPE bolt_%s_seconds histogram\n", name)
+		for i, bound := range h.Bounds {
+			fmt.Fprintf(bw, "bolt_%s_seconds_bucket{le=%q} %d\n", name, formatSeconds(bound), h.Counts[i])
+		}
+		fmt.Fprintf(bw, "bolt_%s_seconds_bucket{le=\"+Inf\"} %d\n", name, h.Count)
+		fmt.Fprintf(bw, "bolt_%s_seconds_sum %s\n", name, formatSeconds(h.Sum))
+		fmt.Fprintf(bw, "bolt_%s_seconds_count %d\n", name, h.Count)
+	}
+	for _, name := range sortedKeys(s.Counters) {
+		fmt.Fprintf(bw, "# TYPE bolt_%s_total counter\n", name)
+		fmt.Fprintf(bw, "bolt_%s_total %d\n", name, s.Counters[name])
+	}
+	for _, name := range sortedKeys(s.Gauges) {
+		fmt.Fprintf(bw, "# TYPE bolt_%s gauge\n", name)
+		fmt.Fprintf(bw, "bolt_%s %d\n", name, s.Gauges[name])
+	}
+
+	return bw.Flush()
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_ = m.WritePrometheus(w)
+}
+
+// MetricsSnapshot is a point-in-time copy of Metrics.
+type MetricsSnapshot struct {
+	Histograms map[string]Histogram `json:"histograms"`
+	Counters   map[string]int64     `json:"counters"`
+	Gauges     map[string]int64     `json:"gauges"`
+}
+
+// Histogram is a latency distribution. Counts[i] is the number of
+// observations less than or equal to Bounds[i].
+type Histogram struct {
+	Count  uint64          `json:"count"`
+	Sum    time.Duration   `json:"sum"`
+	Bounds []time.Duration `json:"bounds"`
+	Counts []uint64        `json:"counts"`
+}
+
+// Quantile returns an estimate of the q-th quantile, 0 <= q <= 1. The upper
+// bound of the bucket that contains the quantile is returned, or the largest
+// bound if the quantile lies beyond it.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	rank := uint64(q * float64(h.Count))
+	if rank == 0 {
+		rank = 1
+	}
+	for i, n := range h.Counts {
+		if n >= rank {
+			return h.Bounds[i]
+		}
+	}
+	return h.Bounds[len(h.Bounds)-1]
+}
+
+// formatSeconds formats a duration as a number of seconds.
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'g', -1, 64)
+}
+
+// sortedKeys returns the keys of a map in ascending order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
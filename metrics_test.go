@@ -0,0 +1,124 @@
+package bolt_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Ensure that commits, lock waits, remaps and file growth are reported.
+func TestDB_Metrics(t *testing.T) {
+	m := bolt.NewMetrics()
+	db := MustOpenWithOption(&bolt.Options{Metrics: m})
+	defer db.MustClose()
+
+	for i := 0; i < 10; i++ {
+		if err := db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+			if err != nil {
+				return err
+			}
+			for j := 0; j < 1000; j++ {
+				if err := b.Put(u64tob(uint64(i*1000+j)), make([]byte, 100)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.View(func(tx *bolt.Tx) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	s := m.Snapshot()
+	for _, name := range []string{bolt.MetricCommit, bolt.MetricRebalance, bolt.MetricSpill, bolt.MetricWrite, bolt.MetricWriteLockWait} {
+		if h := s.Histograms[name]; h.Count != 10 {
+			t.Fatalf("unexpected %s count: %d", name, h.Count)
+		}
+	}
+	if h := s.Histograms[bolt.MetricSync]; h.Count != 20 {
+		t.Fatalf("unexpected sync count: %d", h.Count)
+	} else if h := s.Histograms[bolt.MetricReadLockWait]; h.Count != 1 {
+		t.Fatalf("unexpected read lock wait count: %d", h.Count)
+	} else if h := s.Histograms[bolt.MetricRemapLockWait]; h.Count != uint64(s.Counters[bolt.MetricRemaps]) {
+		t.Fatalf("unexpected remap lock wait count: %d", h.Count)
+	}
+
+	if n := s.Counters[bolt.MetricRemaps]; n < 2 {
+		t.Fatalf("unexpected remaps: %d", n)
+	} else if n := s.Counters[bolt.MetricGrows]; n < 1 {
+		t.Fatalf("unexpected grows: %d", n)
+	} else if n := s.Gauges[bolt.MetricFileSize]; n <= 0 || n < s.Counters[bolt.MetricGrowBytes] {
+		t.Fatalf("unexpected file size: %d", n)
+	} else if n := s.Gauges[bolt.MetricOpenReadTxs]; n != 0 {
+		t.Fatalf("unexpected open read txs: %d", n)
+	} else if _, ok := s.Gauges[bolt.MetricFreePages]; !ok {
+		t.Fatal("expected free pages gauge")
+	}
+}
+
+// Ensure that a histogram counts observations in cumulative buckets.
+func TestMetrics_Observe(t *testing.T) {
+	m := bolt.NewMetrics()
+	m.Observe("op", 5*time.Microsecond)
+	m.Observe("op", 3*time.Millisecond)
+	m.Observe("op", 3*time.Millisecond)
+	m.Observe("op", time.Minute)
+
+	h := m.Snapshot().Histograms["op"]
+	if h.Count != 4 {
+		t.Fatalf("unexpected count: %d", h.Count)
+	} else if h.Sum != time.Minute+6*time.Millisecond+5*time.Microsecond {
+		t.Fatalf("unexpected sum: %s", h.Sum)
+	} else if h.Counts[0] != 1 || h.Counts[len(h.Counts)-1] != 3 {
+		t.Fatalf("unexpected counts: %v", h.Counts)
+	} else if q := h.Quantile(0.25); q != 10*time.Microsecond {
+		t.Fatalf("unexpected p25: %s", q)
+	} else if q := h.Quantile(0.5); q != 5*time.Millisecond {
+		t.Fatalf("unexpected p50: %s", q)
+	} else if q := h.Quantile(1); q != 10*time.Second {
+		t.Fatalf("unexpected p100: %s", q)
+	}
+}
+
+// Ensure that metrics are published as JSON and in the Prometheus format.
+func TestMetrics_Export(t *testing.T) {
+	m := bolt.NewMetrics()
+	m.Observe(bolt.MetricCommit, 2*time.Millisecond)
+	m.Add(bolt.MetricGrows, 2)
+	m.Set(bolt.MetricFileSize, 4096)
+
+	var s bolt.MetricsSnapshot
+	if err := json.Unmarshal([]byte(m.String()), &s); err != nil {
+		t.Fatal(err)
+	} else if s.Histograms[bolt.MetricCommit].Count != 1 || s.Counters[bolt.MetricGrows] != 2 || s.Gauges[bolt.MetricFileSize] != 4096 {
+		t.Fatalf("unexpected snapshot: %+v", s)
+	}
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	for _, line := range []string{
+		"# TYPE bolt_commit_seconds histogram\n",
+		"bolt_commit_seconds_bucket{le=\"0.001\"} 0\n",
+		"bolt_commit_seconds_bucket{le=\"0.0025\"} 1\n",
+		"bolt_commit_seconds_bucket{le=\"+Inf\"} 1\n",
+		"bolt_commit_seconds_sum 0.002\n",
+		"bolt_commit_seconds_count 1\n",
+		"# TYPE bolt_grows_total counter\nbolt_grows_total 2\n",
+		"# TYPE bolt_file_size gauge\nbolt_file_size 4096\n",
+	} {
+		if !strings.Contains(body, line) {
+			t.Fatalf("expected %q in:\n%s", line, body)
+		}
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Fatalf("unexpected content type: %s", ct)
+	}
+}
@@ -14,6 +14,7 @@ type node struct {
 	isLeaf     bool		//区分树枝和叶子节点
 	unbalanced bool		//是否需要进行合并
 	spilled    bool		//是否需要进行拆分和落盘
+	counted    bool		//whether the inode counts of a branch node are valid
 	key        []byte	//该节点中第一个元素key
 	pgid       pgid		//page id
 	parent     *node	//父节点指针
@@ -65,9 +66,12 @@ func (n *node) sizeLessThan(v int) bool {
 }
 
 // pageElementSize returns the size of each page element based on the type of node.
+// Counted branch nodes also store a subtree key count for every element.
 func (n *node) pageElementSize() int {
 	if n.isLeaf {
 		return leafPageElementSize
+	} else if n.counted {
+		return branchPageElementSize + branchPageCountSize
 	}
 	return branchPageElementSize
 }
@@ -149,6 +153,9 @@ func (n *node) put(oldKey, newKey, value []byte, pgid pgid, flags uint32) {
 	inode.value = value
 	inode.pgid = pgid
 	_assert(len(inode.key) > 0, "put: zero-length inode key")
+
+	// Invalidate the positions of any open cursors.
+	n.bucket.version++
 }
 
 // del removes a key from the node.
@@ -166,6 +173,9 @@ func (n *node) del(key []byte) {
 
 	// Mark the node as needing rebalancing.
 	n.unbalanced = true
+
+	// Invalidate the positions of any open cursors.
+	n.bucket.version++
 }
 
 // read initializes the node from a page.
@@ -173,9 +183,15 @@ func (n *node) del(key []byte) {
 func (n *node) read(p *page) {
 	n.pgid = p.id
 	n.isLeaf = ((p.flags & leafPageFlag) != 0)
+	n.counted = !n.isLeaf && ((p.flags & countedPageFlag) != 0)
 	//一个inode对应一个xxxPageElement对象
 	n.inodes = make(inodes, int(p.count))
 
+	var counts []uint64
+	if n.counted {
+		counts = p.branchPageCounts()
+	}
+
 	// 加载所包含元素 indoes
 	for i := 0; i < int(p.count); i++ {
 		inode := &n.inodes[i]
@@ -188,15 +204,19 @@ func (n *node) read(p *page) {
 			elem := p.branchPageElement(uint16(i))
 			inode.pgid = elem.pgid
 			inode.key = elem.key()
+			if counts != nil {
+				inode.count = counts[i]
+			}
+		}
+		if len(inode.key) == 0 {
+			corrupted(p.id, "zero-length key at index %d", i)
 		}
-		_assert(len(inode.key) > 0, "read: zero-length inode key")
 	}
 
 	// Save first key so we can find the node in the parent when we spill.
 	// 用第一个元素的key作为该node的key,以便父节点以此作为索引进行查找和路由
 	if len(n.inodes) > 0 {
 		n.key = n.inodes[0].key
-		_assert(len(n.key) > 0, "read: zero-length node key")
 	} else {
 		n.key = nil
 	}
@@ -210,6 +230,9 @@ func (n *node) write(p *page) {
 		p.flags |= leafPageFlag
 	} else {
 		p.flags |= branchPageFlag
+		if n.counted {
+			p.flags |= countedPageFlag
+		}
 	}
 
 	// 这里叶子节点不可能溢出,因为溢出会分裂
@@ -223,6 +246,12 @@ func (n *node) write(p *page) {
 		return
 	}
 
+	// Counts are stored between the elements and the key data.
+	var counts []uint64
+	if !n.isLeaf && n.counted {
+		counts = p.branchPageCounts()
+	}
+
 	// Loop over each item and write it to the page.
 	b := (*[maxAllocSize]byte)(unsafe.Pointer(&p.ptr))[n.pageElementSize()*len(n.inodes):]
 	for i, item := range n.inodes {
@@ -244,6 +273,9 @@ func (n *node) write(p *page) {
 			elem.ksize = uint32(len(item.key))
 			elem.pgid = item.pgid
 			_assert(elem.pgid != p.id, "write: circular dependency occurred")
+			if counts != nil {
+				counts[i] = item.count
+			}
 		}
 
 		// If the length of key+value is larger than the max allocation size
@@ -314,11 +346,11 @@ func (n *node) splitTwo(pageSize int) (*node, *node) {
 	// Split node into two separate nodes.
 	// If there's no parent then we'll need to create one.
 	if n.parent == nil {
-		n.parent = &node{bucket: n.bucket, children: []*node{n}}
+		n.parent = &node{bucket: n.bucket, children: []*node{n}, counted: n.bucket.tx.db.OrderStatistics}
 	}
 
 	// Create a new node and add it to the parent.
-	next := &node{bucket: n.bucket, isLeaf: n.isLeaf, parent: n.parent}
+	next := &node{bucket: n.bucket, isLeaf: n.isLeaf, counted: n.counted, parent: n.parent}
 	n.parent.children = append(n.parent.children, next)
 
 	// Split inodes across two nodes.
@@ -412,6 +444,16 @@ func (n *node) spill() error {
 			node.parent.put(key, node.inodes[0].key, nil, node.pgid, 0)
 			node.key = node.inodes[0].key
 			_assert(len(node.key) > 0, "spill: zero-length node key")
+
+			// Keep the parent's subtree count for this node current. A
+			// parent can only stay counted if all of its children are.
+			if node.parent.counted {
+				if count, ok := node.keyCount(); ok {
+					node.parent.inodes[node.parent.childIndex(node)].count = count
+				} else {
+					node.parent.counted = false
+				}
+			}
 		}
 
 		// Update the statistics.
@@ -452,6 +494,7 @@ func (n *node) rebalance() {
 			// Move root's child up.
 			child := n.bucket.node(n.inodes[0].pgid, n)
 			n.isLeaf = child.isLeaf
+			n.counted = child.counted
 			n.inodes = child.inodes[:]
 			n.children = child.children
 
@@ -506,6 +549,7 @@ func (n *node) rebalance() {
 
 		// Copy over inodes from target and remove target.
 		n.inodes = append(n.inodes, target.inodes...)
+		n.counted = n.counted && target.counted
 		n.parent.del(target.key)
 		n.parent.removeChild(target)
 		delete(n.bucket.nodes, target.pgid)
@@ -522,6 +566,7 @@ func (n *node) rebalance() {
 
 		// Copy over inodes to target and remove node.
 		target.inodes = append(target.inodes, n.inodes...)
+		target.counted = target.counted && n.counted
 		n.parent.del(n.key)
 		n.parent.removeChild(n)
 		delete(n.bucket.nodes, n.pgid)
@@ -532,6 +577,22 @@ func (n *node) rebalance() {
 	n.parent.rebalance()
 }
 
+// keyCount returns the number of keys stored under the node as recorded in its
+// inodes. Returns false if the node is a branch without valid subtree counts.
+// This is only accurate once all materialized children have been spilled.
+func (n *node) keyCount() (uint64, bool) {
+	if n.isLeaf {
+		return uint64(len(n.inodes)), true
+	} else if !n.counted {
+		return 0, false
+	}
+	var count uint64
+	for i := range n.inodes {
+		count += n.inodes[i].count
+	}
+	return count, true
+}
+
 // removes a node from the list of in-memory children.
 // This does not affect the inodes.
 func (n *node) removeChild(target *node) {
@@ -583,7 +644,7 @@ func (n *node) free() {
 	}
 }
 
-// dump writes the contents of the node to STDERR for debugging purposes.
+// dump writes the contents of the node to the logger for debugging purposes.
 /*
 func (n *node) dump() {
 	// Write node header.
@@ -591,22 +652,23 @@ func (n *node) dump() {
 	if n.isLeaf {
 		typ = "leaf"
 	}
-	warnf("[NODE %d {type=%s count=%d}]", n.pgid, typ, len(n.inodes))
+	db := n.bucket.tx.db
+	db.warnf("[NODE %d {type=%s count=%d}]", n.pgid, typ, len(n.inodes))
 
 	// Write out abbreviated version of each item.
 	for _, item := range n.inodes {
 		if n.isLeaf {
 			if item.flags&bucketLeafFlag != 0 {
 				bucket := (*bucket)(unsafe.Pointer(&item.value[0]))
-				warnf("+L %08x -> (bucket root=%d)", trunc(item.key, 4), bucket.root)
+				db.warnf("+L %08x -> (bucket root=%d)", trunc(item.key, 4), bucket.root)
 			} else {
-				warnf("+L %08x -> %08x", trunc(item.key, 4), trunc(item.value, 4))
+				db.warnf("+L %08x -> %08x", trunc(item.key, 4), trunc(item.value, 4))
 			}
 		} else {
-			warnf("+B %08x -> pgid=%d", trunc(item.key, 4), item.pgid)
+			db.warnf("+B %08x -> pgid=%d", trunc(item.key, 4), item.pgid)
 		}
 	}
-	warn("")
+	db.warn("")
 }
 */
 
@@ -628,6 +690,7 @@ type inode struct {
 	pgid  pgid		//仅树枝(分支)节点使用. 存放节点的page id
 	key   []byte	//树枝(分支)节点和叶子节点公用
 	value []byte	//仅叶子节点使用,存放普通数据或bucket
+	count uint64	//仅树枝(分支)节点使用. number of keys in the subtree when the node is counted
 }
 
 type inodes []inode
@@ -0,0 +1,492 @@
+package bolt
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/maphash"
+)
+
+// BeginOptimistic starts an optimistic read-write transaction.
+//
+// Unlike Begin(true), optimistic transactions do not take the writer lock so
+// any number of them can run at the same time as each other and as a regular
+// writer. Each one works on a snapshot of the database like a read-only
+// transaction and keeps its changes in memory. Every bucket and cursor
+// operation is recorded along with its result. On commit the writer lock is
+// taken and the operations are replayed against the latest version of the
+// database. If any read returns a different result than it did against the
+// snapshot then the transaction is discarded and ErrConflict is returned.
+// Otherwise the changes are committed as a regular write transaction.
+//
+// Statistics, page information and consistency checks read the snapshot
+// directly and are not validated on commit.
+//
+// IMPORTANT: You must close optimistic transactions after you are finished
+// or else the database will not reclaim old pages. Like read-only
+// transactions, an open optimistic transaction blocks a writer that needs to
+// remap the data file, so do not run a regular write transaction in the same
+// goroutine while one is open.
+func (db *DB) BeginOptimistic() (*Tx, error) {
+	if db.readOnly {
+		return nil, ErrDatabaseReadOnly
+	}
+	return db.beginTx(context.Background(), true)
+}
+
+// UpdateOptimistic executes a function within the context of a managed
+// optimistic transaction. If no error is returned from the function then the
+// transaction is committed. ErrConflict is returned if another transaction
+// changed data that the function read. In that case the function can be run
+// again:
+//
+//	for {
+//		err := db.UpdateOptimistic(fn)
+//		if err != bolt.ErrConflict {
+//			return err
+//		}
+//	}
+//
+// Attempting to manually commit or rollback within the function will cause a panic.
+func (db *DB) UpdateOptimistic(fn func(*Tx) error) error {
+	t, err := db.BeginOptimistic()
+	if err != nil {
+		return err
+	}
+
+	// Make sure the transaction rolls back in the event of a panic.
+	defer func() {
+		if t.db != nil {
+			t.rollback()
+		}
+	}()
+
+	// Mark as a managed tx so that the inner function cannot manually commit.
+	t.managed = true
+
+	// If an error is returned from the function then rollback and return error.
+	err = fn(t)
+	t.managed = false
+	if err != nil {
+		_ = t.Rollback()
+		return err
+	}
+
+	return t.Commit()
+}
+
+// commitOptimistic releases the snapshot of an optimistic transaction and
+// replays its operations within a regular write transaction. The write
+// transaction is returned for the caller to commit. A nil transaction is
+// returned if no changes were made.
+func (tx *Tx) commitOptimistic() (*Tx, error) {
+	db, log, handlers := tx.db, tx.oplog, tx.commitHandlers
+
+	// Close the snapshot before waiting for the writer lock. The writer may
+	// need to remap the data file which waits for all snapshots to close.
+	tx.close()
+
+	// A transaction that made no changes was serializable at its snapshot.
+	if !log.writes {
+		for _, fn := range handlers {
+			fn()
+		}
+		return nil, nil
+	}
+
+	rwtx, err := db.Begin(true)
+	if err != nil {
+		return nil, err
+	}
+	if err := log.replay(rwtx); err != nil {
+		_ = rwtx.Rollback()
+		return nil, err
+	}
+	rwtx.commitHandlers = handlers
+	return rwtx, nil
+}
+
+// opType identifies a recorded operation.
+type opType uint8
+
+const (
+	opBucket opType = iota
+	opCreateBucket
+	opCreateBucketIfNotExists
+	opDeleteBucket
+	opGet
+	opPut
+	opDelete
+	opSequence
+	opSetSequence
+	opNextSequence
+	opCount
+	opCountRange
+	opCursor
+	opFirst
+	opLast
+	opNext
+	opPrev
+	opSeek
+	opSeekIndex
+	opRank
+	opCursorDelete
+	opSavepoint
+	opRollbackTo
+	opRelease
+)
+
+// op is a single operation recorded by an optimistic transaction.
+type op struct {
+	typ    opType
+	target int    // id of the bucket, cursor or savepoint operated on
+	id     int    // id of the bucket, cursor or savepoint returned
+	key    []byte // key, bucket name or lower bound
+	value  []byte // value or upper bound
+	n      uint64 // sequence or index argument
+	result uint64 // scalar result or hash of the returned key/value
+	err    string // error returned, if any
+}
+
+// opLog records the operations of an optimistic transaction so they can be
+// validated and applied when it commits.
+type opLog struct {
+	ops        []op
+	depth      int
+	writes     bool
+	buckets    map[*Bucket]int
+	cursors    map[*Cursor]int
+	savepoints map[*Savepoint]int
+}
+
+// opSeed seeds the hashes of recorded results.
+var opSeed = maphash.MakeSeed()
+
+// newOpLog returns a log for a transaction. The root bucket has an id of zero.
+func newOpLog(tx *Tx) *opLog {
+	return &opLog{
+		buckets:    map[*Bucket]int{&tx.root: 0},
+		cursors:    make(map[*Cursor]int),
+		savepoints: make(map[*Savepoint]int),
+	}
+}
+
+// recorder returns the operation log if the transaction is optimistic and the
+// caller is not already being recorded as part of another operation.
+func (tx *Tx) recorder() *opLog {
+	if tx.oplog == nil || tx.oplog.depth > 0 {
+		return nil
+	}
+	return tx.oplog
+}
+
+// record executes fn and appends the operation to the log. Operations
+// performed by fn are not recorded separately.
+func (l *opLog) record(o op, fn func(o *op)) {
+	l.depth++
+	defer func() { l.depth-- }()
+	fn(&o)
+	switch o.typ {
+	case opCreateBucket, opCreateBucketIfNotExists, opDeleteBucket, opPut, opDelete,
+		opSetSequence, opNextSequence, opCursorDelete:
+		l.writes = true
+	}
+	l.ops = append(l.ops, o)
+}
+
+// bucketID returns the id of a bucket that was returned by a recorded operation.
+func (l *opLog) bucketID(b *Bucket) int {
+	id, ok := l.buckets[b]
+	_assert(ok, "optimistic tx: unrecorded bucket")
+	return id
+}
+
+// addBucket assigns an id to a returned bucket. Returns -1 for nil buckets.
+func (l *opLog) addBucket(b *Bucket) int {
+	if b == nil {
+		return -1
+	} else if id, ok := l.buckets[b]; ok {
+		return id
+	}
+	id := len(l.buckets)
+	l.buckets[b] = id
+	return id
+}
+
+// cursorID returns the id of a cursor that was returned by a recorded operation.
+func (l *opLog) cursorID(c *Cursor) int {
+	id, ok := l.cursors[c]
+	_assert(ok, "optimistic tx: unrecorded cursor")
+	return id
+}
+
+// savepointID returns the id of a savepoint or -1 if it was not created by
+// the transaction.
+func (l *opLog) savepointID(sp *Savepoint) int {
+	if id, ok := l.savepoints[sp]; ok {
+		return id
+	}
+	return -1
+}
+
+func (l *opLog) bucket(b *Bucket, name []byte) (child *Bucket) {
+	l.record(op{typ: opBucket, target: l.bucketID(b), key: cloneArg(name)}, func(o *op) {
+		child = b.Bucket(name)
+		o.id = l.addBucket(child)
+	})
+	return child
+}
+
+func (l *opLog) createBucket(b *Bucket, key []byte, typ opType) (child *Bucket, err error) {
+	l.record(op{typ: typ, target: l.bucketID(b), key: cloneArg(key)}, func(o *op) {
+		if typ == opCreateBucket {
+			child, err = b.CreateBucket(key)
+		} else {
+			child, err = b.CreateBucketIfNotExists(key)
+		}
+		o.id, o.err = l.addBucket(child), errString(err)
+	})
+	return child, err
+}
+
+func (l *opLog) deleteBucket(b *Bucket, key []byte) (err error) {
+	l.record(op{typ: opDeleteBucket, target: l.bucketID(b), key: cloneArg(key)}, func(o *op) {
+		err = b.DeleteBucket(key)
+		o.err = errString(err)
+	})
+	return err
+}
+
+func (l *opLog) get(b *Bucket, key []byte) (v []byte) {
+	l.record(op{typ: opGet, target: l.bucketID(b), key: cloneArg(key)}, func(o *op) {
+		v = b.Get(key)
+		o.result = hashResult(nil, v)
+	})
+	return v
+}
+
+func (l *opLog) put(b *Bucket, key, value []byte) (err error) {
+	l.record(op{typ: opPut, target: l.bucketID(b), key: cloneArg(key), value: cloneArg(value)}, func(o *op) {
+		err = b.Put(key, value)
+		o.err = errString(err)
+	})
+	return err
+}
+
+func (l *opLog) delete(b *Bucket, key []byte) (err error) {
+	l.record(op{typ: opDelete, target: l.bucketID(b), key: cloneArg(key)}, func(o *op) {
+		err = b.Delete(key)
+		o.err = errString(err)
+	})
+	return err
+}
+
+func (l *opLog) sequence(b *Bucket) (seq uint64) {
+	l.record(op{typ: opSequence, target: l.bucketID(b)}, func(o *op) {
+		seq = b.Sequence()
+		o.result = seq
+	})
+	return seq
+}
+
+func (l *opLog) setSequence(b *Bucket, v uint64) (err error) {
+	l.record(op{typ: opSetSequence, target: l.bucketID(b), n: v}, func(o *op) {
+		err = b.SetSequence(v)
+		o.err = errString(err)
+	})
+	return err
+}
+
+func (l *opLog) nextSequence(b *Bucket) (seq uint64, err error) {
+	l.record(op{typ: opNextSequence, target: l.bucketID(b)}, func(o *op) {
+		seq, err = b.NextSequence()
+		o.result, o.err = seq, errString(err)
+	})
+	return seq, err
+}
+
+func (l *opLog) count(b *Bucket) (n int) {
+	l.record(op{typ: opCount, target: l.bucketID(b)}, func(o *op) {
+		n = b.Count()
+		o.result = uint64(n)
+	})
+	return n
+}
+
+func (l *opLog) countRange(b *Bucket, start, end []byte) (n int) {
+	l.record(op{typ: opCountRange, target: l.bucketID(b), key: cloneArg(start), value: cloneArg(end)}, func(o *op) {
+		n = b.CountRange(start, end)
+		o.result = uint64(n)
+	})
+	return n
+}
+
+func (l *opLog) cursor(b *Bucket) (c *Cursor) {
+	l.record(op{typ: opCursor, target: l.bucketID(b)}, func(o *op) {
+		c = b.Cursor()
+		o.id = len(l.cursors)
+		l.cursors[c] = o.id
+	})
+	return c
+}
+
+// move records a cursor movement. fn is called with recording suspended.
+func (l *opLog) move(c *Cursor, typ opType, seek []byte, i int, fn func() ([]byte, []byte)) (k, v []byte) {
+	l.record(op{typ: typ, target: l.cursorID(c), key: cloneArg(seek), n: uint64(i)}, func(o *op) {
+		k, v = fn()
+		o.result = hashResult(k, v)
+	})
+	return k, v
+}
+
+func (l *opLog) rank(c *Cursor) (n int) {
+	l.record(op{typ: opRank, target: l.cursorID(c)}, func(o *op) {
+		n = c.Rank()
+		o.result = uint64(n)
+	})
+	return n
+}
+
+func (l *opLog) cursorDelete(c *Cursor) (err error) {
+	l.record(op{typ: opCursorDelete, target: l.cursorID(c)}, func(o *op) {
+		err = c.Delete()
+		o.err = errString(err)
+	})
+	return err
+}
+
+func (l *opLog) savepoint(tx *Tx) (sp *Savepoint, err error) {
+	l.record(op{typ: opSavepoint}, func(o *op) {
+		sp, err = tx.Savepoint()
+		o.id, o.err = len(l.savepoints), errString(err)
+		if sp != nil {
+			l.savepoints[sp] = o.id
+		}
+	})
+	return sp, err
+}
+
+func (l *opLog) rollbackTo(tx *Tx, sp *Savepoint, typ opType) (err error) {
+	l.record(op{typ: typ, target: l.savepointID(sp)}, func(o *op) {
+		if typ == opRollbackTo {
+			err = tx.RollbackTo(sp)
+		} else {
+			err = tx.Release(sp)
+		}
+		o.err = errString(err)
+	})
+	return err
+}
+
+// replay executes the recorded operations within a write transaction.
+// Returns ErrConflict if any operation returns a different result.
+func (l *opLog) replay(tx *Tx) error {
+	buckets := map[int]*Bucket{0: &tx.root}
+	cursors := make(map[int]*Cursor)
+	savepoints := make(map[int]*Savepoint)
+
+	for i := range l.ops {
+		o := &l.ops[i]
+		var result uint64
+		var err error
+		switch o.typ {
+		case opBucket, opCreateBucket, opCreateBucketIfNotExists:
+			var child *Bucket
+			switch o.typ {
+			case opBucket:
+				child = buckets[o.target].Bucket(o.key)
+			case opCreateBucket:
+				child, err = buckets[o.target].CreateBucket(o.key)
+			default:
+				child, err = buckets[o.target].CreateBucketIfNotExists(o.key)
+			}
+			if (child == nil) != (o.id == -1) {
+				return ErrConflict
+			} else if child != nil {
+				buckets[o.id] = child
+			}
+		case opDeleteBucket:
+			err = buckets[o.target].DeleteBucket(o.key)
+		case opGet:
+			result = hashResult(nil, buckets[o.target].Get(o.key))
+		case opPut:
+			err = buckets[o.target].Put(o.key, o.value)
+		case opDelete:
+			err = buckets[o.target].Delete(o.key)
+		case opSequence:
+			result = buckets[o.target].Sequence()
+		case opSetSequence:
+			err = buckets[o.target].SetSequence(o.n)
+		case opNextSequence:
+			result, err = buckets[o.target].NextSequence()
+		case opCount:
+			result = uint64(buckets[o.target].Count())
+		case opCountRange:
+			result = uint64(buckets[o.target].CountRange(o.key, o.value))
+		case opCursor:
+			cursors[o.id] = buckets[o.target].Cursor()
+		case opFirst:
+			result = hashResult(cursors[o.target].First())
+		case opLast:
+			result = hashResult(cursors[o.target].Last())
+		case opNext:
+			result = hashResult(cursors[o.target].Next())
+		case opPrev:
+			result = hashResult(cursors[o.target].Prev())
+		case opSeek:
+			result = hashResult(cursors[o.target].Seek(o.key))
+		case opSeekIndex:
+			result = hashResult(cursors[o.target].SeekIndex(int(o.n)))
+		case opRank:
+			result = uint64(cursors[o.target].Rank())
+		case opCursorDelete:
+			err = cursors[o.target].Delete()
+		case opSavepoint:
+			var sp *Savepoint
+			sp, err = tx.Savepoint()
+			savepoints[o.id] = sp
+		case opRollbackTo:
+			err = tx.RollbackTo(savepoints[o.target])
+		case opRelease:
+			err = tx.Release(savepoints[o.target])
+		}
+
+		if result != o.result || errString(err) != o.err {
+			return ErrConflict
+		}
+	}
+	return nil
+}
+
+// hashResult returns a hash of a key/value pair returned by an operation.
+// Nil keys and values hash differently from empty ones.
+func hashResult(k, v []byte) uint64 {
+	var h maphash.Hash
+	h.SetSeed(opSeed)
+	for _, b := range [][]byte{k, v} {
+		var buf [9]byte
+		if b != nil {
+			buf[0] = 1
+		}
+		binary.BigEndian.PutUint64(buf[1:], uint64(len(b)))
+		h.Write(buf[:])
+		h.Write(b)
+	}
+	return h.Sum64()
+}
+
+// cloneArg copies an argument so that it stays valid after the snapshot is
+// closed. Unlike cloneBytes, nil is preserved.
+func cloneArg(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return cloneBytes(b)
+}
+
+// errString returns the message of an error or an empty string for nil.
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,467 @@
+package bolt_test
+
+import (
+	"bytes"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// Ensure that an optimistic transaction commits its changes.
+func TestDB_UpdateOptimistic(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	var committed bool
+	if err := db.UpdateOptimistic(func(tx *bolt.Tx) error {
+		tx.OnCommit(func() { committed = true })
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put(u64tob(uint64(i)), []byte("0")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if v := b.Get(u64tob(10)); string(v) != "0" {
+			t.Fatalf("unexpected value: %q", v)
+		}
+		if err := b.Delete(u64tob(10)); err != nil {
+			t.Fatal(err)
+		}
+		if seq, err := b.NextSequence(); err != nil {
+			t.Fatal(err)
+		} else if seq != 1 {
+			t.Fatalf("unexpected sequence: %d", seq)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !committed {
+		t.Fatal("expected commit handler to run")
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if n := b.Stats().KeyN; n != 999 {
+			t.Fatalf("unexpected KeyN: %d", n)
+		} else if v := b.Get(u64tob(10)); v != nil {
+			t.Fatalf("unexpected value: %q", v)
+		} else if seq := b.Sequence(); seq != 1 {
+			t.Fatalf("unexpected sequence: %d", seq)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that optimistic transactions on different buckets run concurrently
+// and both commit.
+func TestDB_BeginOptimistic_Concurrent(t *testing.T) {
+	// Open snapshots block remapping so reserve space for the writer.
+	db := MustOpenWithOption(&bolt.Options{InitialMmapSize: 1 << 20})
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{"a", "b"} {
+			if _, err := tx.CreateBucket([]byte(name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tx0, err := db.BeginOptimistic()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx1, err := db.BeginOptimistic()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, tx := range []*bolt.Tx{tx0, tx1} {
+		b := tx.Bucket([]byte{"ab"[i]})
+		if v := b.Get([]byte("counter")); v != nil {
+			t.Fatalf("unexpected value: %q", v)
+		}
+		if err := b.Put([]byte("counter"), []byte("1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A regular writer can run at the same time.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("c"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tx1.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx0.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		for _, name := range []string{"a", "b"} {
+			if v := tx.Bucket([]byte(name)).Get([]byte("counter")); string(v) != "1" {
+				t.Fatalf("unexpected value in %s: %q", name, v)
+			}
+		}
+		if tx.Bucket([]byte("c")) == nil {
+			t.Fatal("expected bucket")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that a read-modify-write of a key that was changed after the
+// transaction began returns ErrConflict and leaves the database unchanged.
+func TestDB_BeginOptimistic_Conflict(t *testing.T) {
+	db := MustOpenWithOption(&bolt.Options{InitialMmapSize: 1 << 20})
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b.Put([]byte("foo"), []byte("0"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := db.BeginOptimistic()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := tx.Bucket([]byte("widgets"))
+	if v := b.Get([]byte("foo")); string(v) != "0" {
+		t.Fatalf("unexpected value: %q", v)
+	}
+	if err := b.Put([]byte("foo"), []byte("1")); err != nil {
+		t.Fatal(err)
+	} else if err := b.Put([]byte("bar"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).Put([]byte("foo"), []byte("2"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tx.OnCommit(func() { t.Fatal("unexpected commit") })
+	if err := tx.Commit(); err != bolt.ErrConflict {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := tx.Rollback(); err != bolt.ErrTxClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if v := b.Get([]byte("foo")); string(v) != "2" {
+			t.Fatalf("unexpected value: %q", v)
+		} else if v := b.Get([]byte("bar")); v != nil {
+			t.Fatalf("unexpected value: %q", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that keys inserted into a range scanned by a transaction cause a
+// conflict.
+func TestDB_BeginOptimistic_Phantom(t *testing.T) {
+	db := MustOpenWithOption(&bolt.Options{InitialMmapSize: 1 << 20})
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 100; i += 2 {
+			if err := b.Put(u64tob(uint64(i)), []byte("0")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		_, err = tx.CreateBucket([]byte("totals"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := db.BeginOptimistic()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	for range tx.Bucket([]byte("widgets")).Range(&bolt.IteratorOptions{LowerBound: u64tob(10), UpperBound: u64tob(20)}) {
+		n++
+	}
+	if n != 5 {
+		t.Fatalf("unexpected count: %d", n)
+	}
+	if err := tx.Bucket([]byte("totals")).Put([]byte("widgets"), u64tob(uint64(n))); err != nil {
+		t.Fatal(err)
+	}
+
+	// Keys outside of the range do not conflict.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).Put(u64tob(51), []byte("0"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).Put(u64tob(15), []byte("0"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tx.Commit(); err != bolt.ErrConflict {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure that writes that do not depend on reads never conflict.
+func TestDB_BeginOptimistic_BlindWrite(t *testing.T) {
+	db := MustOpenWithOption(&bolt.Options{InitialMmapSize: 1 << 20})
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b.Put([]byte("foo"), []byte("0"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := db.BeginOptimistic()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Bucket([]byte("widgets")).Put([]byte("foo"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).Put([]byte("foo"), []byte("2"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte("widgets")).Get([]byte("foo")); string(v) != "1" {
+			t.Fatalf("unexpected value: %q", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that two transactions creating the same bucket conflict.
+func TestDB_BeginOptimistic_CreateBucketConflict(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	tx0, err := db.BeginOptimistic()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx1, err := db.BeginOptimistic()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tx := range []*bolt.Tx{tx0, tx1} {
+		if _, err := tx.CreateBucket([]byte("widgets")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tx0.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx1.Commit(); err != bolt.ErrConflict {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure that a transaction that only reads commits while a writer is open.
+func TestDB_BeginOptimistic_ReadOnly(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	tx, err := db.BeginOptimistic()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := tx.Bucket([]byte("widgets")); b != nil {
+		t.Fatal("unexpected bucket")
+	}
+
+	rwtx, err := db.Begin(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = rwtx.Rollback() }()
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that deleted buckets and savepoints are replayed on commit.
+func TestDB_BeginOptimistic_Savepoint(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("old"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put(u64tob(uint64(i)), make([]byte, 100)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.UpdateOptimistic(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket([]byte("old")); err != nil {
+			t.Fatal(err)
+		}
+		b, err := tx.CreateBucket([]byte("new"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Put([]byte("foo"), []byte("1")); err != nil {
+			t.Fatal(err)
+		}
+		sp, err := tx.Savepoint()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Put([]byte("bar"), []byte("1")); err != nil {
+			t.Fatal(err)
+		}
+		return tx.RollbackTo(sp)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte("old")) != nil {
+			t.Fatal("unexpected bucket")
+		}
+		b := tx.Bucket([]byte("new"))
+		if v := b.Get([]byte("foo")); string(v) != "1" {
+			t.Fatalf("unexpected value: %q", v)
+		} else if v := b.Get([]byte("bar")); v != nil {
+			t.Fatalf("unexpected value: %q", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that concurrent increments are not lost when conflicts are retried.
+func TestDB_UpdateOptimistic_Retry(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	const n, m = 8, 25
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < m; j++ {
+				for {
+					err := db.UpdateOptimistic(func(tx *bolt.Tx) error {
+						b := tx.Bucket([]byte("widgets"))
+						var v uint64
+						if buf := b.Get([]byte("counter")); buf != nil {
+							v = btou64(buf)
+						}
+						return b.Put([]byte("counter"), u64tob(v+1))
+					})
+					if err == nil {
+						break
+					} else if err != bolt.ErrConflict {
+						errs <- err
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte("widgets")).Get([]byte("counter"))
+		if !bytes.Equal(v, u64tob(n*m)) {
+			t.Fatalf("unexpected counter: %d", btou64(v))
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that an optimistic transaction cannot be started on a read-only database.
+func TestDB_BeginOptimistic_DatabaseReadOnly(t *testing.T) {
+	db := MustOpenDB()
+	path := db.Path()
+	defer os.Remove(path)
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	readOnlyDB, err := bolt.Open(path, 0666, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer readOnlyDB.Close()
+
+	if _, err := readOnlyDB.BeginOptimistic(); err != bolt.ErrDatabaseReadOnly {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
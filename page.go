@@ -2,7 +2,6 @@ package bolt
 
 import (
 	"fmt"
-	"os"
 	"sort"
 	"unsafe"
 )
@@ -12,6 +11,7 @@ const pageHeaderSize = int(unsafe.Offsetof(((*page)(nil)).ptr))		//复习一下
 const minKeysPerPage = 2
 
 const branchPageElementSize = int(unsafe.Sizeof(branchPageElement{}))
+const branchPageCountSize = int(unsafe.Sizeof(uint64(0)))
 const leafPageElementSize = int(unsafe.Sizeof(leafPageElement{}))
 
 const (
@@ -19,6 +19,7 @@ const (
 	leafPageFlag     = 0x02		//2,叶子节点页
 	metaPageFlag     = 0x04		//4,元数据页
 	freelistPageFlag = 0x10		//16,空闲列表页
+	countedPageFlag  = 0x20		//32, branch page that carries subtree key counts
 )
 
 const (
@@ -92,10 +93,21 @@ func (p *page) branchPageElements() []branchPageElement {
 	return ((*[0x7FFFFFF]branchPageElement)(unsafe.Pointer(&p.ptr)))[:]
 }
 
-// dump writes n bytes of the page to STDERR as hex output.
-func (p *page) hexdump(n int) {
+// branchPageCounts retrieves the subtree key counts of a branch page. The
+// counts are stored directly after the branch elements and are only present
+// when the page has the countedPageFlag set.
+func (p *page) branchPageCounts() []uint64 {
+	if p.count == 0 {
+		return nil
+	}
+	ptr := unsafe.Pointer(uintptr(unsafe.Pointer(&p.ptr)) + uintptr(p.count)*uintptr(branchPageElementSize))
+	return ((*[0x7FFFFFF]uint64)(ptr))[:p.count:p.count]
+}
+
+// hexdump logs n bytes of the page as hex output at debug level.
+func (p *page) hexdump(l Logger, n int) {
 	buf := (*[maxAllocSize]byte)(unsafe.Pointer(p))[:n]
-	fmt.Fprintf(os.Stderr, "%x\n", buf)
+	l.Debug("page dump", "page", p.id, "hex", fmt.Sprintf("%x", buf))
 }
 
 type pages []*page
@@ -7,6 +7,7 @@ import (
 	"math/rand"
 	"os"
 	"reflect"
+	"testing"
 	"testing/quick"
 	"time"
 )
@@ -29,9 +30,13 @@ func init() {
 	flag.IntVar(&qmaxitems, "quick.maxitems", 1000, "")
 	flag.IntVar(&qmaxksize, "quick.maxksize", 1024, "")
 	flag.IntVar(&qmaxvsize, "quick.maxvsize", 1024, "")
+}
+
+func TestMain(m *testing.M) {
 	flag.Parse()
 	fmt.Fprintln(os.Stderr, "seed:", qseed)
 	fmt.Fprintf(os.Stderr, "quick settings: count=%v, items=%v, ksize=%v, vsize=%v\n", qcount, qmaxitems, qmaxksize, qmaxvsize)
+	os.Exit(m.Run())
 }
 
 func qconfig() *quick.Config {
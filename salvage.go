@@ -0,0 +1,646 @@
+package bolt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"unsafe"
+)
+
+// salvageTxMaxSize is the number of bytes of keys and values that Salvage()
+// writes to the destination in a single transaction.
+const salvageTxMaxSize = 16 << 20
+
+// maxSalvageWarnings is the maximum number of warnings kept in a report.
+const maxSalvageWarnings = 100
+
+// SalvageOptions configures Salvage().
+type SalvageOptions struct {
+	// PageSize is the page size of the source file. If zero, it is read from
+	// a valid meta page or guessed from common page sizes.
+	PageSize int
+
+	// LostAndFound is the name of the top-level bucket that receives the
+	// keys of orphaned leaf pages that cannot be placed back in their bucket.
+	// The keys of each page are stored in a nested bucket named after the
+	// page id. Defaults to "lost+found".
+	LostAndFound string
+}
+
+// SalvageReport describes what Salvage() recovered and what was lost.
+type SalvageReport struct {
+	PageSize int // page size of the source file
+	Meta     int // meta page used, 0 or 1, or -1 if neither is valid
+	TxID     int // transaction id of the meta page used
+
+	Buckets     int // buckets created in the destination
+	Keys        int // key/value pairs written to the destination
+	OlderKeys   int // keys restored from the older meta page, included in Keys
+	OrphanKeys  int // keys restored from orphaned leaf pages, included in Keys
+	OrphanPages int // orphaned leaf pages found
+	Skipped     int // keys that could not be written to the destination
+
+	// Lost lists the subtrees that could not be read from the meta page
+	// used. Their keys may have been restored from the older meta page or
+	// from orphaned pages.
+	Lost []SalvageLoss
+
+	// Warnings describes other problems found in the source file.
+	Warnings []string
+}
+
+// SalvageLoss describes a subtree of a bucket that could not be read.
+type SalvageLoss struct {
+	Bucket    [][]byte // path of the bucket containing the subtree
+	PageID    int      // id of the page that could not be read
+	Lower     []byte   // first key of the lost range, nil if unbounded
+	Upper     []byte   // key after the lost range, nil if unbounded
+	Reason    string   // why the page could not be read
+	Recovered int      // keys in the range restored from other pages
+}
+
+// contains returns true if key is in the lost range.
+func (l *SalvageLoss) contains(key []byte) bool {
+	return (l.Lower == nil || bytes.Compare(key, l.Lower) >= 0) &&
+		(l.Upper == nil || bytes.Compare(key, l.Upper) < 0)
+}
+
+// Salvage recovers as many buckets and key/value pairs as possible from a
+// damaged database file at src and writes them to a new database at dst. It
+// does not require src to open and does not modify it.
+//
+// The trees of the newest valid meta page are walked first, skipping pages
+// that cannot be read. Keys in the skipped subtrees are then restored from
+// the trees of the other meta page, if it is valid. Finally every page that
+// is neither reachable nor free is scanned and the keys of intact leaf pages
+// are put back into the bucket whose lost range contains them or into the
+// LostAndFound bucket. Keys restored from other pages never overwrite keys
+// already written.
+//
+// Returns an error if dst already exists or if src cannot be read. dst is
+// removed if the salvage fails after creating it.
+func Salvage(src, dst string, opts *SalvageOptions) (*SalvageReport, error) {
+	if opts == nil {
+		opts = &SalvageOptions{}
+	}
+	if _, err := os.Stat(dst); err == nil {
+		return nil, fmt.Errorf("destination exists: %s", dst)
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &salvager{
+		file:    f,
+		size:    info.Size(),
+		report:  &SalvageReport{Meta: -1},
+		reached: make(map[pgid]bool),
+		freed:   make(map[pgid]bool),
+	}
+	if err := s.readMeta(opts.PageSize); err != nil {
+		return nil, err
+	}
+
+	db, err := Open(dst, 0600, nil)
+	if err != nil {
+		_ = os.Remove(dst)
+		return nil, err
+	}
+	s.w = &salvageWriter{db: db, report: s.report}
+
+	lostAndFound := opts.LostAndFound
+	if lostAndFound == "" {
+		lostAndFound = "lost+found"
+	}
+	err = s.run([]byte(lostAndFound))
+	if err == nil {
+		err = s.w.commit()
+	} else if s.w.tx != nil {
+		_ = s.w.tx.Rollback()
+	}
+	if e := db.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		// Remove the partial database so that the salvage can be rerun.
+		_ = os.Remove(dst)
+		return nil, err
+	}
+	return s.report, nil
+}
+
+// salvager holds the state of Salvage().
+type salvager struct {
+	file     *os.File
+	size     int64
+	pageSize int
+	metas    []meta // valid meta pages, newest first
+	report   *SalvageReport
+	w        *salvageWriter
+
+	reached map[pgid]bool // pages reached from either meta page
+	freed   map[pgid]bool // pages in the freelist of the newest meta page
+}
+
+// warn records a warning in the report.
+func (s *salvager) warn(format string, v ...interface{}) {
+	if len(s.report.Warnings) < maxSalvageWarnings {
+		s.report.Warnings = append(s.report.Warnings, fmt.Sprintf(format, v...))
+	}
+}
+
+// readMeta determines the page size and reads the valid meta pages.
+func (s *salvager) readMeta(pageSize int) error {
+	if pageSize == 0 {
+		// Use the page size stored in a valid meta page. The second meta
+		// page can only be found by trying common page sizes.
+		if m, err := s.readMetaAt(0); err == nil {
+			pageSize = int(m.pageSize)
+		} else {
+			for _, sz := range []int{os.Getpagesize(), 4096, 8192, 16384, 32768, 65536, 1024, 2048} {
+				if m, err := s.readMetaAt(int64(sz)); err == nil && int(m.pageSize) == sz {
+					pageSize = sz
+					break
+				}
+			}
+		}
+		if pageSize == 0 {
+			pageSize = os.Getpagesize()
+			s.warn("no valid meta page: assuming page size %d", pageSize)
+		}
+	}
+	if pageSize < pageHeaderSize+int(unsafe.Sizeof(meta{})) {
+		return fmt.Errorf("invalid page size: %d", pageSize)
+	}
+	s.pageSize, s.report.PageSize = pageSize, pageSize
+
+	for i := 0; i < 2; i++ {
+		m, err := s.readMetaAt(int64(i * pageSize))
+		if err != nil {
+			s.warn("meta page %d: %s", i, err)
+			continue
+		}
+		s.metas = append(s.metas, m)
+		if len(s.metas) == 1 || m.txid > s.metas[0].txid {
+			s.report.Meta, s.report.TxID = i, int(m.txid)
+		}
+	}
+	sort.Slice(s.metas, func(i, j int) bool { return s.metas[i].txid > s.metas[j].txid })
+	return nil
+}
+
+// readMetaAt reads and validates the meta page at the given offset.
+func (s *salvager) readMetaAt(off int64) (meta, error) {
+	buf := make([]byte, pageHeaderSize+int(unsafe.Sizeof(meta{})))
+	if _, err := s.file.ReadAt(buf, off); err == io.EOF {
+		return meta{}, ErrInvalid
+	} else if err != nil {
+		return meta{}, err
+	}
+	p := (*page)(unsafe.Pointer(&buf[0]))
+	m := *p.meta()
+	if p.flags&metaPageFlag == 0 {
+		return meta{}, ErrInvalid
+	} else if err := m.validate(); err != nil {
+		return meta{}, err
+	}
+	return m, nil
+}
+
+// readPage reads a page and its overflow pages. Returns the page and its
+// size in bytes.
+func (s *salvager) readPage(id pgid) (*page, int, error) {
+	off := int64(id) * int64(s.pageSize)
+	if off+int64(s.pageSize) > s.size {
+		return nil, 0, fmt.Errorf("page past end of file")
+	}
+	buf := make([]byte, s.pageSize)
+	if _, err := s.file.ReadAt(buf, off); err != nil {
+		return nil, 0, err
+	}
+	p := (*page)(unsafe.Pointer(&buf[0]))
+	if p.id != id {
+		return nil, 0, fmt.Errorf("page header has id %d", p.id)
+	}
+
+	size := (int64(p.overflow) + 1) * int64(s.pageSize)
+	if off+size > s.size || size > maxAllocSize {
+		return nil, 0, fmt.Errorf("overflow past end of file: %d", p.overflow)
+	} else if int(size) > len(buf) {
+		buf = make([]byte, size)
+		if _, err := s.file.ReadAt(buf, off); err != nil {
+			return nil, 0, err
+		}
+		p = (*page)(unsafe.Pointer(&buf[0]))
+	}
+	return p, int(size), nil
+}
+
+// readFreelist reads the ids in the freelist of a meta page.
+func (s *salvager) readFreelist(m *meta) error {
+	p, size, err := s.readPage(m.freelist)
+	if err != nil {
+		return err
+	} else if p.flags&freelistPageFlag == 0 {
+		return fmt.Errorf("invalid page type: %s", p.typ())
+	}
+
+	idx, count := 0, int(p.count)
+	if count == 0xFFFF {
+		idx, count = 1, int(((*[maxAllocSize]pgid)(unsafe.Pointer(&p.ptr)))[0])
+	}
+	if pageHeaderSize+(idx+count)*int(unsafe.Sizeof(pgid(0))) > size {
+		return fmt.Errorf("%d ids past end of page", count)
+	}
+	for i := 0; i <= int(p.overflow); i++ {
+		s.reached[m.freelist+pgid(i)] = true
+	}
+	if count > 0 {
+		for _, id := range ((*[maxAllocSize]pgid)(unsafe.Pointer(&p.ptr)))[idx : idx+count] {
+			s.freed[id] = true
+		}
+	}
+	return nil
+}
+
+// run recovers the source file into the destination.
+func (s *salvager) run(lostAndFound []byte) error {
+	s.reached[0], s.reached[1] = true, true
+	maxID := pgid(s.size / int64(s.pageSize))
+
+	if len(s.metas) > 0 {
+		m := &s.metas[0]
+		if m.pgid < maxID {
+			maxID = m.pgid
+		}
+		if err := s.readFreelist(m); err != nil {
+			s.warn("freelist page %d: %s: freed pages may be restored as orphans", m.freelist, err)
+		}
+
+		// Recover the newest trees and record the subtrees that were lost.
+		w := s.newWalk(sourceMeta, m.pgid)
+		w.walk(nil, m.root.root, nil, nil)
+		if w.err != nil {
+			return w.err
+		}
+		s.report.Lost = w.lost
+	}
+
+	// Restore keys in lost ranges from the older trees.
+	if len(s.metas) > 1 && len(s.report.Lost) > 0 {
+		m := &s.metas[1]
+		w := s.newWalk(sourceOlder, m.pgid)
+		w.walk(nil, m.root.root, nil, nil)
+		if w.err != nil {
+			return w.err
+		}
+	}
+
+	// Scan the remaining pages for intact leaf pages.
+	for id := pgid(2); id < maxID; id++ {
+		if s.reached[id] || s.freed[id] {
+			continue
+		}
+		p, size, err := s.readPage(id)
+		if err != nil || p.flags != leafPageFlag || p.count == 0 || p.layoutError(size) != "" {
+			continue
+		}
+		for i := pgid(0); i <= pgid(p.overflow); i++ {
+			s.reached[id+i] = true
+		}
+		s.report.OrphanPages++
+
+		// Put the keys back into their bucket if only one lost range
+		// contains them. Otherwise use a bucket named after the page.
+		first, last := p.leafPageElement(0).key(), p.leafPageElement(p.count-1).key()
+		var loss *SalvageLoss
+		for i := range s.report.Lost {
+			if l := &s.report.Lost[i]; l.contains(first) && l.contains(last) {
+				if loss != nil {
+					loss = nil
+					break
+				}
+				loss = l
+			}
+		}
+		path := [][]byte{lostAndFound, []byte(strconv.Itoa(int(id)))}
+		if loss != nil {
+			path = loss.Bucket
+		}
+
+		w := s.newWalk(sourceOrphan, maxID)
+		w.loss = loss
+		w.walkPage(path, id, p, nil, nil)
+		if w.err != nil {
+			return w.err
+		}
+		id += pgid(p.overflow)
+	}
+	return nil
+}
+
+// Sources of recovered keys.
+const (
+	sourceMeta = iota
+	sourceOlder
+	sourceOrphan
+)
+
+// salvageWalk recovers the keys of a tree of pages.
+type salvageWalk struct {
+	*salvager
+	source int
+	maxID  pgid
+	seen   map[pgid]bool
+	lost   []SalvageLoss
+	loss   *SalvageLoss // lost range credited with orphaned keys
+	err    error
+}
+
+// newWalk returns a walk of pages below maxID.
+func (s *salvager) newWalk(source int, maxID pgid) *salvageWalk {
+	return &salvageWalk{salvager: s, source: source, maxID: maxID, seen: make(map[pgid]bool)}
+}
+
+// lose records a subtree that could not be read.
+func (w *salvageWalk) lose(path [][]byte, id pgid, lower, upper []byte, format string, v ...interface{}) {
+	l := SalvageLoss{Bucket: clonePath(path), PageID: int(id), Reason: fmt.Sprintf(format, v...)}
+	if lower != nil {
+		l.Lower = cloneBytes(lower)
+	}
+	if upper != nil {
+		l.Upper = cloneBytes(upper)
+	}
+	w.lost = append(w.lost, l)
+}
+
+// walk recovers the subtree of the bucket at path rooted at page id. Keys in
+// the subtree lie between lower and upper.
+func (w *salvageWalk) walk(path [][]byte, id pgid, lower, upper []byte) {
+	if w.err != nil {
+		return
+	} else if id < 2 || id >= w.maxID {
+		w.lose(path, id, lower, upper, "page out of bounds: high water mark %d", w.maxID)
+		return
+	} else if w.seen[id] {
+		w.lose(path, id, lower, upper, "multiple references")
+		return
+	}
+
+	p, size, err := w.readPage(id)
+	if err != nil {
+		w.lose(path, id, lower, upper, "%s", err)
+		return
+	} else if p.flags&(branchPageFlag|leafPageFlag) == 0 {
+		w.lose(path, id, lower, upper, "invalid page type: %s", p.typ())
+		return
+	} else if reason := p.layoutError(size); reason != "" {
+		w.lose(path, id, lower, upper, "%s", reason)
+		return
+	}
+	for i := pgid(0); i <= pgid(p.overflow); i++ {
+		w.seen[id+i], w.reached[id+i] = true, true
+	}
+	w.walkPage(path, id, p, lower, upper)
+}
+
+// walkPage recovers the keys below a page that has been validated.
+func (w *salvageWalk) walkPage(path [][]byte, id pgid, p *page, lower, upper []byte) {
+	if p.flags&branchPageFlag != 0 {
+		for i := 0; i < int(p.count); i++ {
+			lo, hi := lower, upper
+			if i > 0 {
+				lo = p.branchPageElement(uint16(i)).key()
+			}
+			if i+1 < int(p.count) {
+				hi = p.branchPageElement(uint16(i + 1)).key()
+			}
+			w.walk(path, p.branchPageElement(uint16(i)).pgid, lo, hi)
+		}
+		return
+	}
+
+	for i := 0; i < int(p.count) && w.err == nil; i++ {
+		elem := p.leafPageElement(uint16(i))
+		k, v := elem.key(), elem.value()
+		if elem.flags&bucketLeafFlag != 0 {
+			w.walkBucket(path, id, k, v)
+		} else if loss := w.accept(path, k); loss != nil || w.source != sourceOlder {
+			w.put(path, k, v, loss)
+		}
+	}
+}
+
+// walkBucket recovers a nested bucket stored in a leaf element.
+func (w *salvageWalk) walkBucket(path [][]byte, id pgid, k, v []byte) {
+	if len(v) < bucketHeaderSize {
+		w.lose(path, id, k, append(cloneBytes(k), 0), "bucket header too short: %d bytes", len(v))
+		return
+	}
+	child := append(clonePath(path), cloneBytes(k))
+
+	// Buckets of the older tree are only created if their key lies within
+	// a lost range.
+	var b bucket
+	copy((*[unsafe.Sizeof(bucket{})]byte)(unsafe.Pointer(&b))[:], v)
+	if w.source != sourceOlder || w.accept(path, k) != nil {
+		if err := w.w.bucket(child, b.sequence); err != nil {
+			w.fail(child, nil, err)
+			return
+		}
+	}
+
+	if b.root != 0 {
+		w.walk(child, b.root, nil, nil)
+		return
+	}
+
+	// Inline buckets store their only page in the value. Copy it so that
+	// it is aligned.
+	if len(v) < bucketHeaderSize+pageHeaderSize {
+		w.lose(child, 0, nil, nil, "inline bucket too short: %d bytes", len(v))
+		return
+	}
+	buf := cloneBytes(v[bucketHeaderSize:])
+	p := (*page)(unsafe.Pointer(&buf[0]))
+	if p.flags != leafPageFlag {
+		w.lose(child, 0, nil, nil, "invalid inline page type: %s", p.typ())
+		return
+	} else if reason := p.layoutError(len(buf)); reason != "" {
+		w.lose(child, 0, nil, nil, "inline bucket: %s", reason)
+		return
+	}
+	w.walkPage(child, 0, p, nil, nil)
+}
+
+// accept returns the lost range of the newest tree that contains a key of
+// the bucket at path, or a bucket containing it. Returns nil if the key was
+// not lost. Orphaned keys are credited to the range of their page.
+func (w *salvageWalk) accept(path [][]byte, key []byte) *SalvageLoss {
+	if w.source == sourceOrphan {
+		return w.loss
+	}
+	for i := range w.report.Lost {
+		l := &w.report.Lost[i]
+		if len(l.Bucket) > len(path) || !equalPath(l.Bucket, path[:len(l.Bucket)]) {
+			continue
+		}
+		k := key
+		if len(l.Bucket) < len(path) {
+			k = path[len(l.Bucket)]
+		}
+		if l.contains(k) {
+			return l
+		}
+	}
+	return nil
+}
+
+// put writes a key/value pair. Keys from the newest tree are always written.
+// Others are only written if the key does not exist.
+func (w *salvageWalk) put(path [][]byte, k, v []byte, loss *SalvageLoss) {
+	ok, err := w.w.put(path, k, v, w.source != sourceMeta)
+	if err != nil {
+		w.fail(path, k, err)
+		return
+	} else if !ok {
+		return
+	}
+	switch w.source {
+	case sourceOlder:
+		w.report.OlderKeys++
+	case sourceOrphan:
+		w.report.OrphanKeys++
+	}
+	if loss != nil {
+		loss.Recovered++
+	}
+}
+
+// fail records a key or bucket that could not be written. Other errors stop
+// the walk.
+func (w *salvageWalk) fail(path [][]byte, k []byte, err error) {
+	var keyErr *KeyError
+	var bucketErr *BucketError
+	if errors.As(err, &keyErr) || errors.As(err, &bucketErr) || errors.Is(err, ErrKeyRequired) {
+		w.report.Skipped++
+		w.warn("%s", err)
+		return
+	}
+	w.err = err
+}
+
+// salvageWriter writes recovered buckets and keys to the destination,
+// committing regularly.
+type salvageWriter struct {
+	db     *DB
+	tx     *Tx
+	size   int
+	report *SalvageReport
+}
+
+// bucket creates the bucket at path, and its parents, if needed and raises
+// its sequence to seq.
+func (w *salvageWriter) bucket(path [][]byte, seq uint64) error {
+	b, err := w.open(path)
+	if err != nil {
+		return err
+	} else if seq > b.Sequence() {
+		return b.SetSequence(seq)
+	}
+	return nil
+}
+
+// put writes a key/value pair to the bucket at path. If ifAbsent is true,
+// existing keys are left unchanged. Returns true if the key was written.
+func (w *salvageWriter) put(path [][]byte, k, v []byte, ifAbsent bool) (bool, error) {
+	if len(path) == 0 {
+		return false, &KeyError{Key: cloneBytes(k), Err: ErrIncompatibleValue}
+	}
+	b, err := w.open(path)
+	if err != nil {
+		return false, err
+	}
+	if ifAbsent {
+		if key, _ := b.Cursor().Seek(k); bytes.Equal(key, k) {
+			return false, nil
+		}
+	}
+	if err := b.Put(k, v); err != nil {
+		return false, err
+	}
+	w.report.Keys++
+
+	// Commit once the transaction is large enough.
+	if w.size += len(k) + len(v); w.size > salvageTxMaxSize {
+		return true, w.commit()
+	}
+	return true, nil
+}
+
+// open returns the bucket at path, creating it and its parents if needed.
+func (w *salvageWriter) open(path [][]byte) (*Bucket, error) {
+	if w.tx == nil {
+		tx, err := w.db.Begin(true)
+		if err != nil {
+			return nil, err
+		}
+		w.tx, w.size = tx, 0
+	}
+	b := &w.tx.root
+	for _, name := range path {
+		child := b.Bucket(name)
+		if child == nil {
+			var err error
+			if child, err = b.CreateBucket(name); err != nil {
+				return nil, err
+			}
+			w.report.Buckets++
+		}
+		b = child
+	}
+	return b, nil
+}
+
+// commit commits the open transaction, if any.
+func (w *salvageWriter) commit() error {
+	if w.tx == nil {
+		return nil
+	}
+	tx := w.tx
+	w.tx = nil
+	return tx.Commit()
+}
+
+// clonePath returns a copy of a bucket path.
+func clonePath(path [][]byte) [][]byte {
+	c := make([][]byte, len(path))
+	for i, name := range path {
+		c[i] = cloneBytes(name)
+	}
+	return c
+}
+
+// equalPath returns true if two bucket paths are equal.
+func equalPath(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,200 @@
+package bolt_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// Ensure that an intact database is copied in full.
+func TestSalvage(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for i := 0; i < 3; i++ {
+			b, err := tx.CreateBucket([]byte(fmt.Sprintf("bucket%d", i)))
+			if err != nil {
+				return err
+			}
+			if err := b.SetSequence(uint64(i + 10)); err != nil {
+				return err
+			}
+			if _, err := b.CreateBucket([]byte("inline")); err != nil {
+				return err
+			}
+			for j := 0; j < 500*i; j++ {
+				if err := b.Put(u64tob(uint64(j)), []byte(fmt.Sprintf("value%d", j))); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := tempfile()
+	defer os.Remove(dst)
+	report, err := bolt.Salvage(db.Path(), dst, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(report.Lost) != 0 || report.OrphanPages != 0 || report.Skipped != 0 {
+		t.Fatalf("unexpected report: %+v", report)
+	} else if report.Keys != 1500 || report.Buckets != 6 {
+		t.Fatalf("unexpected counts: keys=%d buckets=%d", report.Keys, report.Buckets)
+	}
+
+	mustEqualDB(t, db.DB, dst)
+
+	// The destination must not exist.
+	if _, err := bolt.Salvage(db.Path(), dst, nil); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+// Ensure that keys below a corrupted page are restored from the older meta
+// page and from orphaned pages.
+func TestSalvage_Corrupted(t *testing.T) {
+	db, root := mustOpenCorruptedWith(t, func(f *os.File, root, pageSize int) error { return nil })
+
+	// Write a new key so that the newest tree has its own root page.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).Put(u64tob(5000), []byte("new"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.View(func(tx *bolt.Tx) error {
+		root = int(tx.Bucket([]byte("widgets")).Root())
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	path, pageSize := db.Path(), db.Info().PageSize
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(path)
+
+	// Clear the flags of the new root page.
+	f, err := os.OpenFile(path, os.O_RDWR, 0666)
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := f.WriteAt([]byte{0, 0}, int64(root*pageSize+8)); err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := tempfile()
+	defer os.Remove(dst)
+	report, err := bolt.Salvage(path, dst, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(report.Lost) != 1 || report.Lost[0].PageID != root || report.Lost[0].Recovered != 1001 {
+		t.Fatalf("unexpected losses: %+v", report.Lost)
+	} else if report.OlderKeys != 1000 || report.OrphanKeys != 1 || report.OrphanPages == 0 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+
+	d, err := bolt.Open(dst, 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if err := d.View(func(tx *bolt.Tx) error {
+		for err := range tx.Check() {
+			t.Fatal(err)
+		}
+		b := tx.Bucket([]byte("widgets"))
+		if n := b.Stats().KeyN; n != 1001 {
+			t.Fatalf("unexpected key count: %d", n)
+		} else if v := b.Get(u64tob(5000)); string(v) != "new" {
+			t.Fatalf("unexpected value: %q", v)
+		} else if tx.Bucket([]byte("other")) == nil {
+			t.Fatal("expected bucket")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that the older meta page is used if the newest one is invalid.
+func TestSalvage_Meta(t *testing.T) {
+	db := MustOpenDB()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	path, pageSize := db.Path(), db.Info().PageSize
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(path)
+
+	// The creation of the bucket was written to meta page 1.
+	f, err := os.OpenFile(path, os.O_RDWR, 0666)
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := f.WriteAt(make([]byte, 64), int64(pageSize)); err != nil {
+		t.Fatal(err)
+	} else if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := tempfile()
+	defer os.Remove(dst)
+	report, err := bolt.Salvage(path, dst, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if report.Meta != 0 || report.PageSize != pageSize || len(report.Warnings) == 0 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+}
+
+// mustEqualDB fails if the database at path differs from db.
+func mustEqualDB(t *testing.T, db *bolt.DB, path string) {
+	d, err := bolt.Open(path, 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	var dump func(b *bolt.Bucket, w *bytes.Buffer)
+	dump = func(b *bolt.Bucket, w *bytes.Buffer) {
+		fmt.Fprintf(w, "seq=%d\n", b.Sequence())
+		_ = b.ForEach(func(k, v []byte) error {
+			if child := b.Bucket(k); child != nil {
+				fmt.Fprintf(w, "bucket %x {\n", k)
+				dump(child, w)
+				fmt.Fprintf(w, "}\n")
+				return nil
+			}
+			fmt.Fprintf(w, "%x=%x\n", k, v)
+			return nil
+		})
+	}
+	read := func(db *bolt.DB) string {
+		var buf bytes.Buffer
+		if err := db.View(func(tx *bolt.Tx) error {
+			return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+				fmt.Fprintf(&buf, "bucket %x {\n", name)
+				dump(b, &buf)
+				fmt.Fprintf(&buf, "}\n")
+				return nil
+			})
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+	if exp, got := read(db), read(d); exp != got {
+		t.Fatalf("unexpected contents:\n%s\nexpected:\n%s", got, exp)
+	}
+}
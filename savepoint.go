@@ -0,0 +1,223 @@
+package bolt
+
+// Savepoint is a marker within a writable transaction that changes can be
+// rolled back to without rolling back the whole transaction.
+//
+// A savepoint records the materialized nodes and header of every open bucket,
+// the bucket cache, the page high water mark and the freelist allocations of
+// the transaction. Taking a savepoint copies the nodes that have been changed
+// so far, so its cost grows with the size of the uncommitted changes.
+type Savepoint struct {
+	tx       *Tx
+	meta     meta
+	ids      []pgid
+	pending  []pgid
+	allocs   []txid
+	root     *bucketState
+	handlers int
+}
+
+// bucketState is a copy of the in-memory state of a bucket.
+type bucketState struct {
+	bucket   *Bucket
+	header   bucket
+	page     *page
+	rootNode *node
+	nodes    map[pgid]*node
+	buckets  map[string]*bucketState
+}
+
+// Savepoint records the current state of the transaction. Changes made after
+// the savepoint can be undone with RollbackTo().
+func (tx *Tx) Savepoint() (*Savepoint, error) {
+	if l := tx.recorder(); l != nil {
+		return l.savepoint(tx)
+	}
+	if tx.db == nil {
+		return nil, ErrTxClosed
+	} else if !tx.writable {
+		return nil, ErrTxNotWritable
+	}
+
+	sp := &Savepoint{
+		tx:       tx,
+		meta:     *tx.meta,
+		root:     saveBucket(&tx.root),
+		handlers: len(tx.commitHandlers),
+	}
+
+	// Optimistic transactions do not allocate or free pages.
+	if !tx.optimistic {
+		f := tx.db.freelist
+		sp.ids = append([]pgid(nil), f.ids...)
+		sp.pending = append([]pgid(nil), f.pending[tx.meta.txid]...)
+		sp.allocs = append([]txid(nil), f.pendingAllocs[tx.meta.txid]...)
+	}
+	tx.savepoints = append(tx.savepoints, sp)
+
+	return sp, nil
+}
+
+// RollbackTo undoes all changes made since the savepoint was created. This
+// includes puts, deletes, created and deleted buckets, sequences and pages
+// allocated or freed by the transaction. Handlers registered with OnCommit()
+// after the savepoint are removed.
+//
+// The savepoint remains valid so it can be rolled back to again. Savepoints
+// created after it are released. Buckets created after the savepoint must not
+// be used once it has been rolled back to. Open cursors are repositioned on
+// their next move.
+func (tx *Tx) RollbackTo(sp *Savepoint) error {
+	if l := tx.recorder(); l != nil {
+		return l.rollbackTo(tx, sp, opRollbackTo)
+	}
+	if tx.db == nil {
+		return ErrTxClosed
+	} else if !tx.writable {
+		return ErrTxNotWritable
+	}
+	i := tx.savepointIndex(sp)
+	if i == -1 {
+		return ErrSavepointNotFound
+	}
+	tx.savepoints = tx.savepoints[:i+1]
+
+	// Restore the high water mark and the root bucket header.
+	*tx.meta = sp.meta
+
+	// Pages allocated since the savepoint are free again and pages freed
+	// since the savepoint are in use again.
+	if !tx.optimistic {
+		f := tx.db.freelist
+		ids, allocs := f.pending[tx.meta.txid], f.pendingAllocs[tx.meta.txid]
+		for i := len(sp.pending); i < len(ids) && i < len(allocs); i++ {
+			if allocs[i] != 0 {
+				f.allocated(allocs[i], ids[i])
+			}
+		}
+		f.ids = append([]pgid(nil), sp.ids...)
+		if len(sp.pending) > 0 {
+			f.pending[tx.meta.txid] = append([]pgid(nil), sp.pending...)
+			f.pendingAllocs[tx.meta.txid] = append([]txid(nil), sp.allocs...)
+		} else {
+			delete(f.pending, tx.meta.txid)
+			delete(f.pendingAllocs, tx.meta.txid)
+		}
+		f.reindex()
+	}
+
+	// Pages beyond the mmap may be reallocated and rewritten so drop any
+	// cached copies.
+	tx.unmapped = nil
+
+	sp.root.restore()
+	tx.commitHandlers = tx.commitHandlers[:sp.handlers]
+
+	return nil
+}
+
+// Release discards a savepoint and any savepoints created after it.
+func (tx *Tx) Release(sp *Savepoint) error {
+	if l := tx.recorder(); l != nil {
+		return l.rollbackTo(tx, sp, opRelease)
+	}
+	if tx.db == nil {
+		return ErrTxClosed
+	}
+	i := tx.savepointIndex(sp)
+	if i == -1 {
+		return ErrSavepointNotFound
+	}
+	tx.savepoints = tx.savepoints[:i]
+	return nil
+}
+
+// savepointIndex returns the position of a savepoint in the transaction or -1
+// if it does not belong to the transaction.
+func (tx *Tx) savepointIndex(sp *Savepoint) int {
+	if sp == nil || sp.tx != tx {
+		return -1
+	}
+	for i := range tx.savepoints {
+		if tx.savepoints[i] == sp {
+			return i
+		}
+	}
+	return -1
+}
+
+// saveBucket copies the state of a bucket and its cached child buckets.
+func saveBucket(b *Bucket) *bucketState {
+	st := &bucketState{
+		bucket: b,
+		header: *b.bucket,
+		page:   b.page,
+	}
+	st.rootNode, st.nodes = copyNodes(b.rootNode, b.nodes)
+
+	if b.buckets != nil {
+		st.buckets = make(map[string]*bucketState, len(b.buckets))
+		for name, child := range b.buckets {
+			st.buckets[name] = saveBucket(child)
+		}
+	}
+	return st
+}
+
+// restore resets a bucket and its child buckets to the saved state. Nodes are
+// copied again so the state can be restored more than once.
+func (st *bucketState) restore() {
+	b := st.bucket
+	*b.bucket = st.header
+	b.page = st.page
+	b.rootNode, b.nodes = copyNodes(st.rootNode, st.nodes)
+
+	if st.buckets != nil {
+		b.buckets = make(map[string]*Bucket, len(st.buckets))
+		for name, child := range st.buckets {
+			child.restore()
+			b.buckets[name] = child.bucket
+		}
+	}
+
+	// Invalidate the positions of any open cursors.
+	b.version++
+}
+
+// copyNodes copies a tree of materialized nodes and the cache that indexes it.
+func copyNodes(root *node, cache map[pgid]*node) (*node, map[pgid]*node) {
+	copies := make(map[*node]*node)
+	if root != nil {
+		root = copyNode(root, nil, copies)
+	}
+	if cache == nil {
+		return root, nil
+	}
+
+	nodes := make(map[pgid]*node, len(cache))
+	for id, n := range cache {
+		if c, ok := copies[n]; ok {
+			nodes[id] = c
+		} else {
+			nodes[id] = copyNode(n, copies[n.parent], copies)
+		}
+	}
+	return root, nodes
+}
+
+// copyNode copies a node and its materialized children.
+func copyNode(n *node, parent *node, copies map[*node]*node) *node {
+	c := &node{}
+	*c = *n
+	c.parent = parent
+	c.inodes = append(inodes(nil), n.inodes...)
+	copies[n] = c
+
+	if n.children != nil {
+		c.children = make(nodes, len(n.children))
+		for i, child := range n.children {
+			c.children[i] = copyNode(child, c, copies)
+		}
+	}
+	return c
+}
@@ -0,0 +1,316 @@
+package bolt_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// Ensure that changes made after a savepoint are undone by RollbackTo.
+func TestTx_RollbackTo(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put(u64tob(uint64(i)), []byte("0")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if err := b.Put(u64tob(0), []byte("1")); err != nil {
+			t.Fatal(err)
+		}
+
+		sp, err := tx.Savepoint()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 1000; i += 2 {
+			if err := b.Delete(u64tob(uint64(i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i := 1000; i < 2000; i++ {
+			if err := b.Put(u64tob(uint64(i)), []byte("2")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := b.Put(u64tob(1), []byte("2")); err != nil {
+			t.Fatal(err)
+		}
+
+		// Roll back twice to ensure the savepoint can be reused.
+		for j := 0; j < 2; j++ {
+			if err := tx.RollbackTo(sp); err != nil {
+				t.Fatal(err)
+			}
+			if n := b.Count(); n != 1000 {
+				t.Fatalf("unexpected count: %d", n)
+			} else if v := b.Get(u64tob(0)); string(v) != "1" {
+				t.Fatalf("unexpected value: %q", v)
+			} else if v := b.Get(u64tob(1)); string(v) != "0" {
+				t.Fatalf("unexpected value: %q", v)
+			}
+			if err := b.Delete(u64tob(999)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if n := b.Stats().KeyN; n != 999 {
+			t.Fatalf("unexpected KeyN: %d", n)
+		} else if v := b.Get(u64tob(0)); string(v) != "1" {
+			t.Fatalf("unexpected value: %q", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that RollbackTo restores buckets, sequences and freed pages.
+func TestTx_RollbackTo_Buckets(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{"large", "small"} {
+			b, err := tx.CreateBucket([]byte(name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := b.CreateBucket([]byte("child")); err != nil {
+				t.Fatal(err)
+			}
+			if err := b.SetSequence(10); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("large")).Bucket([]byte("child"))
+		for i := 0; i < 10000; i++ {
+			if err := b.Put(u64tob(uint64(i)), make([]byte, 100)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		small := tx.Bucket([]byte("small"))
+		sp, err := tx.Savepoint()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Free pages by deleting a large bucket, allocate pages with a bulk
+		// load and change buckets and sequences.
+		if err := tx.DeleteBucket([]byte("large")); err != nil {
+			t.Fatal(err)
+		}
+		bulk, err := tx.CreateBucket([]byte("bulk"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := bulk.BulkLoad(seqN(20000)); err != nil {
+			t.Fatal(err)
+		}
+		if err := small.DeleteBucket([]byte("child")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := small.CreateBucket([]byte("other")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := small.NextSequence(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := tx.RollbackTo(sp); err != nil {
+			t.Fatal(err)
+		}
+		if tx.Bucket([]byte("bulk")) != nil {
+			t.Fatal("expected bulk bucket to be removed")
+		} else if small.Bucket([]byte("other")) != nil {
+			t.Fatal("expected other bucket to be removed")
+		} else if small.Bucket([]byte("child")) == nil {
+			t.Fatal("expected child bucket to be restored")
+		} else if seq := small.Sequence(); seq != 10 {
+			t.Fatalf("unexpected sequence: %d", seq)
+		} else if n := tx.Bucket([]byte("large")).Bucket([]byte("child")).Count(); n != 10000 {
+			t.Fatalf("unexpected count: %d", n)
+		}
+
+		// Changes after the rollback are kept.
+		_, err = small.NextSequence()
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Closing the database checks that no pages were leaked or freed twice.
+	if err := db.View(func(tx *bolt.Tx) error {
+		if n := tx.Bucket([]byte("large")).Bucket([]byte("child")).Stats().KeyN; n != 10000 {
+			t.Fatalf("unexpected KeyN: %d", n)
+		} else if seq := tx.Bucket([]byte("small")).Sequence(); seq != 11 {
+			t.Fatalf("unexpected sequence: %d", seq)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that nested savepoints are released when rolling back to an outer
+// savepoint and that commit handlers added after a savepoint are removed.
+func TestTx_RollbackTo_Nested(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	var committed []string
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var sps []*bolt.Savepoint
+		for i := 0; i < 3; i++ {
+			sp, err := tx.Savepoint()
+			if err != nil {
+				t.Fatal(err)
+			}
+			sps = append(sps, sp)
+
+			name := fmt.Sprintf("step%d", i)
+			tx.OnCommit(func() { committed = append(committed, name) })
+			if err := b.Put([]byte(name), []byte("0")); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := tx.RollbackTo(sps[1]); err != nil {
+			t.Fatal(err)
+		} else if err := tx.RollbackTo(sps[2]); err != bolt.ErrSavepointNotFound {
+			t.Fatalf("unexpected error: %v", err)
+		} else if err := tx.Release(sps[1]); err != nil {
+			t.Fatal(err)
+		} else if err := tx.RollbackTo(sps[1]); err != bolt.ErrSavepointNotFound {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		c := b.Cursor()
+		if k, _ := c.First(); string(k) != "step0" {
+			t.Fatalf("unexpected key: %s", k)
+		} else if k, _ := c.Next(); k != nil {
+			t.Fatalf("unexpected key: %s", k)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(committed) != 1 || committed[0] != "step0" {
+		t.Fatalf("unexpected commit handlers: %v", committed)
+	}
+}
+
+// Ensure that savepoints can only be used with the writable transaction that
+// created them.
+func TestTx_Savepoint_Invalid(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	var sp *bolt.Savepoint
+	if err := db.Update(func(tx *bolt.Tx) error {
+		var err error
+		sp, err = tx.Savepoint()
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if err := tx.RollbackTo(sp); err != bolt.ErrSavepointNotFound {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		if _, err := tx.Savepoint(); err != bolt.ErrTxNotWritable {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that a cursor is repositioned after rolling back to a savepoint.
+func TestTx_RollbackTo_Cursor(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 100; i += 2 {
+			if err := b.Put(u64tob(uint64(i)), []byte("0")); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		sp, err := tx.Savepoint()
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := b.Cursor()
+		for k, _ := c.First(); btou64(k) < 50; k, _ = c.Next() {
+			if err := b.Put(u64tob(btou64(k)+1), []byte("1")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if k, _ := c.Prev(); btou64(k) != 49 {
+			t.Fatalf("unexpected key: %d", btou64(k))
+		}
+
+		if err := tx.RollbackTo(sp); err != nil {
+			t.Fatal(err)
+		}
+		if k, _ := c.Prev(); btou64(k) != 48 {
+			t.Fatalf("unexpected key: %d", btou64(k))
+		} else if k, _ := c.Next(); btou64(k) != 50 {
+			t.Fatalf("unexpected key: %d", btou64(k))
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -71,7 +71,8 @@ func testSimulate(t *testing.T, threadCount, parallelism int) {
 			// Start transaction.
 			tx, err := db.Begin(writable)
 			if err != nil {
-				t.Fatal("tx begin: ", err)
+				t.Error("tx begin: ", err)
+				return
 			}
 
 			// Obtain current state of the dataset.
@@ -90,7 +91,8 @@ func testSimulate(t *testing.T, threadCount, parallelism int) {
 					mutex.Unlock()
 
 					if err := tx.Commit(); err != nil {
-						t.Fatal(err)
+						t.Error(err)
+						return
 					}
 				}()
 			} else {
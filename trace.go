@@ -0,0 +1,176 @@
+package bolt
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// Traced phases. These are the names of the events passed to a Tracer.
+const (
+	TraceBegin     = "begin"            // beginning a transaction, including lock waits
+	TraceCommit    = "commit"           // Commit() or CommitAsync(); Count is pages written
+	TraceRebalance = "commit.rebalance" // rebalancing nodes with deletions
+	TraceSpill     = "commit.spill"     // splitting nodes into dirty pages
+	TraceFreelist  = "commit.freelist"  // writing the freelist; Count is pages, Bytes is size
+	TraceWrite     = "commit.write"     // writing dirty pages; Count is pages, Bytes is size
+	TraceWriteMeta = "commit.meta"      // writing and syncing the meta page
+	TraceSync      = "sync"             // a single fdatasync() call
+	TraceRemap     = "remap"            // memory mapping the file; Bytes is the mmap size
+	TraceGrow      = "grow"             // growing the file; Bytes is the new file size
+	TraceBatch     = "batch"            // running a batch; Count is the number of calls
+)
+
+// Tracer receives the start and end of the phases of a transaction's
+// lifecycle. Phases of the same transaction nest and are reported from one
+// goroutine, but phases of different transactions may be reported
+// concurrently. Implementations must be safe for concurrent use and should
+// return quickly since they are called while locks are held.
+type Tracer interface {
+	// Start is called when a phase begins.
+	Start(ev TraceEvent)
+
+	// End is called when a phase ends. Duration, Count, Bytes and Err are set.
+	End(ev TraceEvent)
+}
+
+// TraceEvent describes a traced phase.
+type TraceEvent struct {
+	Name     string        // phase name, one of the Trace constants
+	TxID     int           // transaction id, or zero if unknown
+	Writable bool          // true for read-write and optimistic transactions
+	Start    time.Time     // time the phase began
+	Duration time.Duration // time the phase took
+	Count    int           // number of pages or calls, depending on the phase
+	Bytes    int64         // number of bytes, depending on the phase
+	Err      error         // error that ended the phase, if any
+}
+
+// NopTracer is a Tracer that ignores all events. It is the default tracer.
+type NopTracer struct{}
+
+// Start does nothing.
+func (NopTracer) Start(ev TraceEvent) {}
+
+// End does nothing.
+func (NopTracer) End(ev TraceEvent) {}
+
+// traceStart reports the start of a phase and returns the event to pass to
+// traceEnd.
+func (db *DB) traceStart(ev TraceEvent) TraceEvent {
+	ev.Start = time.Now()
+	if db.Tracer != nil {
+		db.Tracer.Start(ev)
+	}
+	return ev
+}
+
+// traceEnd reports the end of a phase.
+func (db *DB) traceEnd(ev TraceEvent, err error) {
+	if db.Tracer != nil {
+		ev.Duration = time.Since(ev.Start)
+		ev.Err = err
+		db.Tracer.End(ev)
+	}
+}
+
+// FileTracer is a Tracer that writes completed phases to a file in the Chrome
+// trace event format. The file can be opened with chrome://tracing or
+// https://ui.perfetto.dev. Each transaction is shown as a separate thread.
+type FileTracer struct {
+	mu    sync.Mutex
+	file  *os.File
+	w     *bufio.Writer
+	n     int
+	epoch time.Time
+	err   error
+}
+
+// NewFileTracer creates a trace file at path.
+func NewFileTracer(path string) (*FileTracer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	t := &FileTracer{file: f, w: bufio.NewWriter(f), epoch: time.Now()}
+	if _, err := t.w.WriteString("[\n"); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+// Start does nothing. Phases are written when they end.
+func (t *FileTracer) Start(ev TraceEvent) {}
+
+// End writes a completed phase to the trace file.
+func (t *FileTracer) End(ev TraceEvent) {
+	args := map[string]interface{}{}
+	if ev.Count != 0 {
+		args["count"] = ev.Count
+	}
+	if ev.Bytes != 0 {
+		args["bytes"] = ev.Bytes
+	}
+	if ev.Writable {
+		args["writable"] = true
+	}
+	if ev.Err != nil {
+		args["error"] = ev.Err.Error()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil || t.file == nil {
+		return
+	}
+	buf, err := json.Marshal(struct {
+		Name  string                 `json:"name"`
+		Phase string                 `json:"ph"`
+		TS    float64                `json:"ts"`
+		Dur   float64                `json:"dur"`
+		PID   int                    `json:"pid"`
+		TID   int                    `json:"tid"`
+		Args  map[string]interface{} `json:"args,omitempty"`
+	}{
+		Name:  ev.Name,
+		Phase: "X",
+		TS:    float64(ev.Start.Sub(t.epoch).Nanoseconds()) / 1000,
+		Dur:   float64(ev.Duration.Nanoseconds()) / 1000,
+		PID:   1,
+		TID:   ev.TxID,
+		Args:  args,
+	})
+	if err != nil {
+		t.err = err
+		return
+	}
+	if t.n > 0 {
+		_, _ = t.w.WriteString(",\n")
+	}
+	_, t.err = t.w.Write(buf)
+	t.n++
+}
+
+// Close finishes and closes the trace file. Returns the first error that
+// occurred while writing events.
+func (t *FileTracer) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.file == nil {
+		return t.err
+	}
+	if t.err == nil {
+		_, t.err = t.w.WriteString("\n]\n")
+	}
+	if t.err == nil {
+		t.err = t.w.Flush()
+	}
+	if err := t.file.Close(); t.err == nil {
+		t.err = err
+	}
+	t.file = nil
+	return t.err
+}
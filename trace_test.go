@@ -0,0 +1,209 @@
+package bolt_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// recorder is a Tracer that records the events it receives.
+type recorder struct {
+	mu     sync.Mutex
+	starts []bolt.TraceEvent
+	ends   []bolt.TraceEvent
+}
+
+func (r *recorder) Start(ev bolt.TraceEvent) {
+	r.mu.Lock()
+	r.starts = append(r.starts, ev)
+	r.mu.Unlock()
+}
+
+func (r *recorder) End(ev bolt.TraceEvent) {
+	r.mu.Lock()
+	r.ends = append(r.ends, ev)
+	r.mu.Unlock()
+}
+
+// reset discards the recorded events.
+func (r *recorder) reset() {
+	r.mu.Lock()
+	r.starts, r.ends = nil, nil
+	r.mu.Unlock()
+}
+
+// names returns the names of the recorded end events except remaps, which
+// happen whenever the transaction allocates past the end of the mmap.
+func (r *recorder) names() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var a []string
+	for _, ev := range r.ends {
+		if ev.Name != bolt.TraceRemap {
+			a = append(a, ev.Name)
+		}
+	}
+	return a
+}
+
+// find returns the last end event with the given name.
+func (r *recorder) find(name string) (bolt.TraceEvent, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := len(r.ends) - 1; i >= 0; i-- {
+		if r.ends[i].Name == name {
+			return r.ends[i], true
+		}
+	}
+	return bolt.TraceEvent{}, false
+}
+
+// Ensure that the phases of a commit are traced in order.
+func TestDB_Tracer(t *testing.T) {
+	r := &recorder{}
+	db := MustOpenWithOption(&bolt.Options{Tracer: r})
+	defer db.MustClose()
+
+	if _, ok := r.find(bolt.TraceRemap); !ok {
+		t.Fatal("expected remap event")
+	}
+	r.reset()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put(u64tob(uint64(i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Syncs are reported within the write phases and the file grows after
+	// the freelist is written.
+	exp := []string{
+		bolt.TraceBegin,
+		bolt.TraceRebalance,
+		bolt.TraceSpill,
+		bolt.TraceFreelist,
+		bolt.TraceGrow,
+		bolt.TraceSync,
+		bolt.TraceWrite,
+		bolt.TraceSync,
+		bolt.TraceWriteMeta,
+		bolt.TraceCommit,
+	}
+	if names := r.names(); !reflect.DeepEqual(exp, names) {
+		t.Fatalf("unexpected events:\nexp=%v\ngot=%v", exp, names)
+	} else if len(r.starts) != len(r.ends) {
+		t.Fatalf("unbalanced events: %d starts, %d ends", len(r.starts), len(r.ends))
+	}
+
+	commit, _ := r.find(bolt.TraceCommit)
+	write, _ := r.find(bolt.TraceWrite)
+	grow, _ := r.find(bolt.TraceGrow)
+	remap, _ := r.find(bolt.TraceRemap)
+	if commit.TxID != 2 || !commit.Writable || commit.Err != nil {
+		t.Fatalf("unexpected commit event: %+v", commit)
+	} else if commit.Count <= write.Count || write.Count <= 1 {
+		t.Fatalf("unexpected page counts: commit=%d, write=%d", commit.Count, write.Count)
+	} else if write.Bytes != int64(write.Count*db.Info().PageSize) {
+		t.Fatalf("unexpected write bytes: %d", write.Bytes)
+	} else if grow.Bytes == 0 || grow.TxID != 2 {
+		t.Fatalf("unexpected grow event: %+v", grow)
+	} else if remap.Bytes == 0 || remap.TxID != 2 {
+		t.Fatalf("unexpected remap event: %+v", remap)
+	} else if commit.Duration < write.Duration || commit.Start.After(write.Start) {
+		t.Fatalf("write not within commit: %+v, %+v", commit, write)
+	}
+
+	// Read transactions are traced.
+	r.reset()
+	if err := db.View(func(tx *bolt.Tx) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if ev, ok := r.find(bolt.TraceBegin); !ok || ev.TxID != 2 || ev.Writable {
+		t.Fatalf("unexpected begin event: %+v", ev)
+	}
+}
+
+// Ensure that batches are traced with the number of calls.
+func TestDB_Tracer_Batch(t *testing.T) {
+	r := &recorder{}
+	db := MustOpenWithOption(&bolt.Options{Tracer: r})
+	defer db.MustClose()
+
+	if err := db.Batch(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if ev, ok := r.find(bolt.TraceBatch); !ok || ev.Count != 1 || ev.TxID != 2 || ev.Err != nil {
+		t.Fatalf("unexpected batch event: %+v", ev)
+	}
+}
+
+// Ensure that the file tracer writes a Chrome trace file.
+func TestFileTracer(t *testing.T) {
+	path := tempfile()
+	defer os.Remove(path)
+	tr, err := bolt.NewFileTracer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := MustOpenWithOption(&bolt.Options{Tracer: tr})
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.MustClose()
+	if err := tr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []struct {
+		Name  string                 `json:"name"`
+		Phase string                 `json:"ph"`
+		TS    float64                `json:"ts"`
+		Dur   float64                `json:"dur"`
+		TID   int                    `json:"tid"`
+		Args  map[string]interface{} `json:"args"`
+	}
+	if err := json.Unmarshal(buf, &events); err != nil {
+		t.Fatalf("invalid trace: %s\n%s", err, buf)
+	}
+
+	var found bool
+	for _, ev := range events {
+		if ev.Phase != "X" || ev.TS < 0 || ev.Dur < 0 {
+			t.Fatalf("unexpected event: %+v", ev)
+		}
+		if ev.Name == bolt.TraceCommit && ev.TID == 2 {
+			found = true
+			if ev.Args["count"] == nil || ev.Args["writable"] != true {
+				t.Fatalf("unexpected commit args: %+v", ev.Args)
+			}
+		}
+	}
+	if !found {
+		t.Fatalf("commit not found in trace:\n%s", buf)
+	}
+}
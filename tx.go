@@ -1,11 +1,13 @@
 package bolt
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 	"unsafe"
 )
@@ -25,12 +27,21 @@ type txid uint64
 type Tx struct {
 	writable       bool
 	managed        bool
+	optimistic     bool
+	ctx            context.Context
 	db             *DB
 	meta           *meta
 	root           Bucket
 	pages          map[pgid]*page
+	unmapped       map[pgid]*page	// pages read from beyond the mmap
+	unmappedlock   sync.Mutex	// protects unmapped during a parallel check
+	err            error	// first corruption read by the transaction
 	stats          TxStats
 	commitHandlers []func()		// 提交时执行的动作
+	savepoints     []*Savepoint
+	oplog          *opLog
+	info           TxInfo
+	timer          *time.Timer
 
 	// WriteFlag specifies the flag for write-related methods like WriteTo().
 	// Tx opens the database file with the specified flag to copy the data.
@@ -58,7 +69,10 @@ func (tx *Tx) init(db *DB) {
 	*tx.root.bucket = tx.meta.root
 
 	// Increment the transaction id and add a page cache for writable transactions.
-	if tx.writable {
+	// Optimistic transactions only record their operations until they commit.
+	if tx.optimistic {
+		tx.oplog = newOpLog(tx)
+	} else if tx.writable {
 		tx.pages = make(map[pgid]*page)
 		tx.meta.txid += txid(1)
 	}
@@ -136,6 +150,32 @@ func (tx *Tx) ForEach(fn func(name []byte, b *Bucket) error) error {
 	})
 }
 
+// Err returns the first *CorruptionError read by the transaction. Methods
+// that return an error also return it and Get(), Bucket(), Count(),
+// CountRange() and Stats() return a nil or zero result instead. Commit()
+// rolls back a transaction that read a corruption. Returns nil otherwise.
+func (tx *Tx) Err() error {
+	return tx.err
+}
+
+// catch recovers a panic caused by a *CorruptionError, remembers it as the
+// transaction's error and stores it in err if err is not nil. Other panics
+// are propagated. It must be deferred directly.
+func (tx *Tx) catch(err *error) {
+	if r := recover(); r != nil {
+		e, ok := r.(*CorruptionError)
+		if !ok {
+			panic(r)
+		}
+		if tx.err == nil {
+			tx.err = e
+		}
+		if err != nil {
+			*err = e
+		}
+	}
+}
+
 // OnCommit adds a handler function to be executed after the transaction successfully commits.
 func (tx *Tx) OnCommit(fn func()) {
 	tx.commitHandlers = append(tx.commitHandlers, fn)
@@ -145,32 +185,119 @@ func (tx *Tx) OnCommit(fn func()) {
 // Returns an error if a disk write error occurs, or if Commit is
 // called on a read-only transaction.
 // 先更新数据再更新元信息
-func (tx *Tx) Commit() error {
+func (tx *Tx) Commit() (err error) {
 	_assert(!tx.managed, "managed tx commit not allowed")
 	if tx.db == nil {
 		return ErrTxClosed
 	} else if !tx.writable {
 		return ErrTxNotWritable
+	} else if tx.err != nil {
+		tx.rollback()
+		return tx.err
+	} else if tx.optimistic {
+		rwtx, err := tx.commitOptimistic()
+		if err != nil || rwtx == nil {
+			return err
+		}
+		return rwtx.Commit()
+	}
+	defer tx.startCommit()(&err)
+	defer tx.abortCorrupted(&err)
+
+	// Write dirty pages to disk.
+	if err := tx.commitPages(true); err != nil {
+		return err
+	}
+
+	// Meta pages must be written in order so wait for any asynchronous
+	// commits to finish first.
+	if err := tx.db.waitCommits(); err != nil {
+		tx.rollback()
+		return err
+	}
+
+	// Write meta to disk.
+	// 元信息写入磁盘
+	var startTime = time.Now()
+	if err := tx.writeMeta(); err != nil {
+		tx.rollback()
+		return err
+	}
+	tx.stats.WriteTime += time.Since(startTime)
+	tx.db.setDurable(tx.meta.txid)
+
+	// Finalize the transaction.
+	tx.close()
+
+	// Execute commit handlers now that the locks have been removed.
+	for _, fn := range tx.commitHandlers {
+		fn()
 	}
 
+	return nil
+}
+
+// abortCorrupted recovers a panic caused by a *CorruptionError during a
+// commit, rolls back the transaction and stores the error in err. Other
+// panics are propagated. It must be deferred directly.
+func (tx *Tx) abortCorrupted(err *error) {
+	if r := recover(); r != nil {
+		e, ok := r.(*CorruptionError)
+		if !ok {
+			panic(r)
+		}
+		if tx.db != nil {
+			tx.rollback()
+		}
+		*err = e
+	}
+}
+
+// startCommit reports the start of a commit to the tracer. The returned
+// function reports its end to the tracer and the metrics sink and logs it if
+// it was slow.
+func (tx *Tx) startCommit() func(*error) {
+	db, writes := tx.db, tx.stats.Write
+	ev := db.traceStart(TraceEvent{Name: TraceCommit, TxID: tx.ID(), Writable: true})
+	return func(err *error) {
+		ev.Count = tx.stats.Write - writes
+		db.traceEnd(ev, *err)
+		db.observe(MetricCommit, ev.Start)
+		if *err == nil {
+			db.logSlowCommit(tx, ev.TxID, ev.Start, ev.Count)
+		}
+	}
+}
+
+// commitPages rebalances and spills the transaction, writes the freelist and
+// writes all dirty pages to disk. The pages are synced to disk if sync is
+// true. The transaction is rolled back if an error occurs.
+func (tx *Tx) commitPages(sync bool) error {
 	// TODO(benbjohnson): Use vectorized I/O to write out dirty pages.
 
 	// Rebalance nodes which have had deletions.
 	// 删除时，进行平衡，页合并
+	ev := tx.db.traceStart(TraceEvent{Name: TraceRebalance, TxID: tx.ID(), Writable: true})
 	var startTime = time.Now()
 	tx.root.rebalance()
 	if tx.stats.Rebalance > 0 {
 		tx.stats.RebalanceTime += time.Since(startTime)
 	}
+	tx.db.observe(MetricRebalance, startTime)
+	tx.db.traceEnd(ev, nil)
 
 	// spill data onto dirty pages.
 	// 页分裂
+	ev = tx.db.traceStart(TraceEvent{Name: TraceSpill, TxID: tx.ID(), Writable: true})
 	startTime = time.Now()
 	if err := tx.root.spill(); err != nil {
+		tx.db.traceEnd(ev, err)
 		tx.rollback()
 		return err
 	}
 	tx.stats.SpillTime += time.Since(startTime)
+	tx.db.observe(MetricSpill, startTime)
+	tx.db.traceEnd(ev, nil)
 
 	// Free the old root bucket.
 	tx.meta.root.root = tx.root.root
@@ -180,21 +307,26 @@ func (tx *Tx) Commit() error {
 	// Free the freelist and allocate new pages for it. This will overestimate
 	// the size of the freelist but not underestimate the size (which would be bad).
 	// 分配新的页面给freelist，然后将freelist写入新的页面
+	ev = tx.db.traceStart(TraceEvent{Name: TraceFreelist, TxID: tx.ID(), Writable: true})
 	tx.db.freelist.free(tx.meta.txid, tx.db.page(tx.meta.freelist))
 	// 空闲列表可能会增加，因此需要重新分配页用来存储空闲列表
 	// 因为在开启写事务的时候，有去释放之前读事务占用的页信息，因此此处需要判断是否freelist会有溢出的问题
 	p, err := tx.allocate((tx.db.freelist.size() / tx.db.pageSize) + 1)
 	if err != nil {
+		tx.db.traceEnd(ev, err)
 		tx.rollback()
 		return err
 	}
 	// 将freelist写入到连续的新页中
 	if err := tx.db.freelist.write(p); err != nil {
+		tx.db.traceEnd(ev, err)
 		tx.rollback()
 		return err
 	}
 	// 更新元数据的页id
 	tx.meta.freelist = p.id
+	ev.Count, ev.Bytes = int(p.overflow)+1, int64(tx.db.freelist.size())
+	tx.db.traceEnd(ev, nil)
 
 	// If the high water mark has moved up then attempt to grow the database.
 	// 在allocate中有可能会更改meta.pgid
@@ -205,10 +337,19 @@ func (tx *Tx) Commit() error {
 		}
 	}
 
+	// Pages that BulkLoad wrote beyond the mmap must be mapped before the meta
+	// page makes them visible to new transactions.
+	if minsz := int(tx.meta.pgid+1) * tx.db.pageSize; minsz > tx.db.datasz {
+		if err := tx.db.mmap(minsz); err != nil {
+			tx.rollback()
+			return fmt.Errorf("mmap allocate error: %s", err)
+		}
+	}
+
 	// Write dirty pages to disk.
 	startTime = time.Now()
 	// 写数据
-	if err := tx.write(); err != nil {
+	if err := tx.write(sync); err != nil {
 		tx.rollback()
 		return err
 	}
@@ -229,22 +370,8 @@ func (tx *Tx) Commit() error {
 			panic("check fail: " + strings.Join(errs, "\n"))
 		}
 	}
-
-	// Write meta to disk.
-	// 元信息写入磁盘
-	if err := tx.writeMeta(); err != nil {
-		tx.rollback()
-		return err
-	}
 	tx.stats.WriteTime += time.Since(startTime)
-
-	// Finalize the transaction.
-	tx.close()
-
-	// Execute commit handlers now that the locks have been removed.
-	for _, fn := range tx.commitHandlers {
-		fn()
-	}
+	tx.db.observe(MetricWrite, startTime)
 
 	return nil
 }
@@ -268,7 +395,7 @@ func (tx *Tx) rollback() {
 	if tx.db == nil {
 		return
 	}
-	if tx.writable {
+	if tx.writable && !tx.optimistic {
 		// 移除该事务相关的pages
 		tx.db.freelist.rollback(tx.meta.txid)
 		// 重新从freelist页中读取构建空闲列表
@@ -281,14 +408,16 @@ func (tx *Tx) close() {
 	if tx.db == nil {
 		return
 	}
-	if tx.writable {
+	if tx.writable && !tx.optimistic {
 		// Grab freelist stats.
 		var freelistFreeN = tx.db.freelist.free_count()
 		var freelistPendingN = tx.db.freelist.pending_count()
 		var freelistAlloc = tx.db.freelist.size()
 
 		// Remove transaction ref & writer lock.
+		tx.db.metalock.Lock()
 		tx.db.rwtx = nil
+		tx.db.metalock.Unlock()
 		tx.db.rwlock.Unlock()
 
 		// Merge statistics.
@@ -299,6 +428,8 @@ func (tx *Tx) close() {
 		tx.db.stats.FreelistInuse = freelistAlloc
 		tx.db.stats.TxStats.add(&tx.stats)
 		tx.db.statlock.Unlock()
+		tx.db.gauge(MetricFreePages, int64(freelistFreeN))
+		tx.db.gauge(MetricPendingPages, int64(freelistPendingN))
 	} else {
 		tx.db.removeTx(tx)
 	}
@@ -308,6 +439,9 @@ func (tx *Tx) close() {
 	tx.meta = nil
 	tx.root = Bucket{tx: tx}
 	tx.pages = nil
+	tx.unmapped = nil
+	tx.savepoints = nil
+	tx.oplog = nil
 }
 
 // Copy writes the entire database to a writer.
@@ -394,83 +528,10 @@ func (tx *Tx) CopyFile(path string, mode os.FileMode) error {
 // because of caching. This overhead can be removed if running on a read-only
 // transaction, however, it is not safe to execute other writer transactions at
 // the same time.
+//
+// Check is equivalent to CheckWithOptions(nil).
 func (tx *Tx) Check() <-chan error {
-	ch := make(chan error)
-	go tx.check(ch)
-	return ch
-}
-
-func (tx *Tx) check(ch chan error) {
-	// Check if any pages are double freed.
-	freed := make(map[pgid]bool)
-	all := make([]pgid, tx.db.freelist.count())
-	tx.db.freelist.copyall(all)
-	for _, id := range all {
-		if freed[id] {
-			ch <- fmt.Errorf("page %d: already freed", id)
-		}
-		freed[id] = true
-	}
-
-	// Track every reachable page.
-	reachable := make(map[pgid]*page)
-	reachable[0] = tx.page(0) // meta0
-	reachable[1] = tx.page(1) // meta1
-	for i := uint32(0); i <= tx.page(tx.meta.freelist).overflow; i++ {
-		reachable[tx.meta.freelist+pgid(i)] = tx.page(tx.meta.freelist)
-	}
-
-	// Recursively check buckets.
-	tx.checkBucket(&tx.root, reachable, freed, ch)
-
-	// Ensure all pages below high water mark are either reachable or freed.
-	for i := pgid(0); i < tx.meta.pgid; i++ {
-		_, isReachable := reachable[i]
-		if !isReachable && !freed[i] {
-			ch <- fmt.Errorf("page %d: unreachable unfreed", int(i))
-		}
-	}
-
-	// Close the channel to signal completion.
-	close(ch)
-}
-
-func (tx *Tx) checkBucket(b *Bucket, reachable map[pgid]*page, freed map[pgid]bool, ch chan error) {
-	// Ignore inline buckets.
-	if b.root == 0 {
-		return
-	}
-
-	// Check every page used by this bucket.
-	b.tx.forEachPage(b.root, 0, func(p *page, _ int) {
-		if p.id > tx.meta.pgid {
-			ch <- fmt.Errorf("page %d: out of bounds: %d", int(p.id), int(b.tx.meta.pgid))
-		}
-
-		// Ensure each page is only referenced once.
-		for i := pgid(0); i <= pgid(p.overflow); i++ {
-			var id = p.id + i
-			if _, ok := reachable[id]; ok {
-				ch <- fmt.Errorf("page %d: multiple references", int(id))
-			}
-			reachable[id] = p
-		}
-
-		// We should only encounter un-freed leaf and branch pages.
-		if freed[p.id] {
-			ch <- fmt.Errorf("page %d: reachable freed", int(p.id))
-		} else if (p.flags&branchPageFlag) == 0 && (p.flags&leafPageFlag) == 0 {
-			ch <- fmt.Errorf("page %d: invalid type: %s", int(p.id), p.typ())
-		}
-	})
-
-	// Check each bucket within this bucket.
-	_ = b.ForEach(func(k, v []byte) error {
-		if child := b.Bucket(k); child != nil {
-			tx.checkBucket(child, reachable, freed, ch)
-		}
-		return nil
-	})
+	return tx.CheckWithOptions(nil)
 }
 
 // allocate returns a contiguous block of memory starting at a given page.
@@ -492,86 +553,90 @@ func (tx *Tx) allocate(count int) (*page, error) {
 }
 
 // write writes any dirty pages to disk.
-func (tx *Tx) write() error {
+func (tx *Tx) write(sync bool) (err error) {
+	ev := tx.db.traceStart(TraceEvent{Name: TraceWrite, TxID: tx.ID(), Writable: true})
+	defer func() { tx.db.traceEnd(ev, err) }()
+
 	// Sort pages by id.
 	// 保证写的页是有序的
 	pages := make(pages, 0, len(tx.pages))
 	for _, p := range tx.pages {
 		pages = append(pages, p)
+		ev.Count += int(p.overflow) + 1
 	}
+	ev.Bytes = int64(ev.Count) * int64(tx.db.pageSize)
 	// Clear out page cache early.
 	tx.pages = make(map[pgid]*page)
 	sort.Sort(pages)
 
 	// Write pages to disk in order.
 	for _, p := range pages {
-		// 页数和偏移量
-		size := (int(p.overflow) + 1) * tx.db.pageSize
-		offset := int64(p.id) * int64(tx.db.pageSize)
-
-		// Write out page in "max allocation" sized chunks.
-		ptr := (*[maxAllocSize]byte)(unsafe.Pointer(p))
-		// 循环写某一页
-		for {
-			// Limit our write to our max allocation size.
-			sz := size
-			if sz > maxAllocSize-1 {
-				sz = maxAllocSize - 1
-			}
-
-			// Write chunk to disk.
-			buf := ptr[:sz]
-			if _, err := tx.db.ops.writeAt(buf, offset); err != nil {
-				return err
-			}
-
-			// Update statistics.
-			tx.stats.Write++
-
-			// Exit inner for loop if we've written all the chunks.
-			size -= sz
-			if size == 0 {
-				break
-			}
-
-			// Otherwise move offset forward and move pointer to next chunk.
-			// 移动偏移量
-			offset += int64(sz)
-			// 同时指针也移动
-			ptr = (*[maxAllocSize]byte)(unsafe.Pointer(&ptr[sz]))
+		if err := tx.writePage(p); err != nil {
+			return err
 		}
 	}
 
 	// Ignore file sync if flag is set on DB.
-	if !tx.db.NoSync || IgnoreNoSync {
-		if err := fdatasync(tx.db); err != nil {
+	if sync && (!tx.db.NoSync || IgnoreNoSync) {
+		if err := tx.db.syncData(); err != nil {
 			return err
 		}
 	}
 
 	// Put small pages back to page pool.
 	for _, p := range pages {
-		// Ignore page sizes over 1 page.
-		// These are allocated using make() instead of the page pool.
-		if int(p.overflow) != 0 {
-			continue
+		tx.db.recyclePage(p)
+	}
+
+	return nil
+}
+
+// writePage writes a single dirty page and its overflow pages to disk.
+func (tx *Tx) writePage(p *page) error {
+	// 页数和偏移量
+	size := (int(p.overflow) + 1) * tx.db.pageSize
+	offset := int64(p.id) * int64(tx.db.pageSize)
+
+	// Write out page in "max allocation" sized chunks.
+	ptr := (*[maxAllocSize]byte)(unsafe.Pointer(p))
+	// 循环写某一页
+	for {
+		// Limit our write to our max allocation size.
+		sz := size
+		if sz > maxAllocSize-1 {
+			sz = maxAllocSize - 1
+		}
+
+		// Write chunk to disk.
+		buf := ptr[:sz]
+		if _, err := tx.db.ops.writeAt(buf, offset); err != nil {
+			return err
 		}
 
-		buf := (*[maxAllocSize]byte)(unsafe.Pointer(p))[:tx.db.pageSize]
+		// Update statistics.
+		tx.stats.Write++
 
-		// See https://go.googlesource.com/go/+/f03c9202c43e0abb130669852082117ca50aa9b1
-		// 清空buf，然后放入pagePool中
-		for i := range buf {
-			buf[i] = 0
+		// Exit if we've written all the chunks.
+		size -= sz
+		if size == 0 {
+			break
 		}
-		tx.db.pagePool.Put(buf)
+
+		// Otherwise move offset forward and move pointer to next chunk.
+		// 移动偏移量
+		offset += int64(sz)
+		// 同时指针也移动
+		ptr = (*[maxAllocSize]byte)(unsafe.Pointer(&ptr[sz]))
 	}
 
 	return nil
 }
 
 // writeMeta writes the meta to the disk.
-func (tx *Tx) writeMeta() error {
+func (tx *Tx) writeMeta() (err error) {
+	ev := tx.db.traceStart(TraceEvent{Name: TraceWriteMeta, TxID: tx.ID(), Writable: true})
+	defer func() { tx.db.traceEnd(ev, err) }()
+
 	// Create a temporary buffer for the meta page.
 	buf := make([]byte, tx.db.pageSize)
 	p := tx.db.pageInBuffer(buf, 0)
@@ -583,7 +648,7 @@ func (tx *Tx) writeMeta() error {
 		return err
 	}
 	if !tx.db.NoSync || IgnoreNoSync {
-		if err := fdatasync(tx.db); err != nil {
+		if err := tx.db.syncData(); err != nil {
 			return err
 		}
 	}
@@ -605,10 +670,52 @@ func (tx *Tx) page(id pgid) *page {
 		}
 	}
 
+	// Pages written directly to the file during this transaction can lie
+	// beyond the end of the mmap.
+	if tx.writable {
+		n := pgid(tx.db.datasz / tx.db.pageSize)
+		if id >= n || id+pgid(tx.db.page(id).overflow) >= n {
+			return tx.unmappedPage(id)
+		}
+	}
+
 	// Otherwise return directly from the mmap.
 	return tx.db.page(id)
 }
 
+// unmappedPage reads a page that is not covered by the mmap from the data
+// file. The page is cached so that it stays valid for the life of the
+// transaction. It is safe to call from the goroutines of a parallel check.
+// Panics with a *CorruptionError if the page cannot be read.
+func (tx *Tx) unmappedPage(id pgid) *page {
+	tx.unmappedlock.Lock()
+	defer tx.unmappedlock.Unlock()
+
+	if p, ok := tx.unmapped[id]; ok {
+		return p
+	}
+
+	// Read the header first to find the number of overflow pages.
+	var pageSize = tx.db.pageSize
+	buf := make([]byte, pageSize)
+	if _, err := tx.db.file.ReadAt(buf, int64(id)*int64(pageSize)); err != nil {
+		corrupted(id, "read unmapped page: %s", err)
+	}
+	if p := (*page)(unsafe.Pointer(&buf[0])); p.overflow > 0 {
+		buf = make([]byte, (int(p.overflow)+1)*pageSize)
+		if _, err := tx.db.file.ReadAt(buf, int64(id)*int64(pageSize)); err != nil {
+			corrupted(id, "read unmapped page: %s", err)
+		}
+	}
+
+	if tx.unmapped == nil {
+		tx.unmapped = make(map[pgid]*page)
+	}
+	p := (*page)(unsafe.Pointer(&buf[0]))
+	tx.unmapped[id] = p
+	return p
+}
+
 // forEachPage iterates over every page within a given page and executes a function.
 func (tx *Tx) forEachPage(pgid pgid, depth int, fn func(*page, int)) {
 	p := tx.page(pgid)
@@ -627,7 +734,8 @@ func (tx *Tx) forEachPage(pgid pgid, depth int, fn func(*page, int)) {
 
 // Page returns page information for a given page number.
 // This is only safe for concurrent use when used by a writable transaction.
-func (tx *Tx) Page(id int) (*PageInfo, error) {
+func (tx *Tx) Page(id int) (_ *PageInfo, err error) {
+	defer tx.catch(&err)
 	if tx.db == nil {
 		return nil, ErrTxClosed
 	} else if pgid(id) >= tx.meta.pgid {
@@ -635,7 +743,7 @@ func (tx *Tx) Page(id int) (*PageInfo, error) {
 	}
 
 	// Build the page info.
-	p := tx.db.page(pgid(id))
+	p := tx.page(pgid(id))
 	info := &PageInfo{
 		ID:            id,
 		Count:         int(p.count),
@@ -242,11 +242,11 @@ func TestTx_CreateBucketIfNotExists_ErrBucketNameRequired(t *testing.T) {
 	db := MustOpenDB()
 	defer db.MustClose()
 	if err := db.Update(func(tx *bolt.Tx) error {
-		if _, err := tx.CreateBucketIfNotExists([]byte{}); err != bolt.ErrBucketNameRequired {
+		if _, err := tx.CreateBucketIfNotExists([]byte{}); !errors.Is(err, bolt.ErrBucketNameRequired) {
 			t.Fatalf("unexpected error: %s", err)
 		}
 
-		if _, err := tx.CreateBucketIfNotExists(nil); err != bolt.ErrBucketNameRequired {
+		if _, err := tx.CreateBucketIfNotExists(nil); !errors.Is(err, bolt.ErrBucketNameRequired) {
 			t.Fatalf("unexpected error: %s", err)
 		}
 
@@ -273,7 +273,7 @@ func TestTx_CreateBucket_ErrBucketExists(t *testing.T) {
 
 	// Create the same bucket again.
 	if err := db.Update(func(tx *bolt.Tx) error {
-		if _, err := tx.CreateBucket([]byte("widgets")); err != bolt.ErrBucketExists {
+		if _, err := tx.CreateBucket([]byte("widgets")); !errors.Is(err, bolt.ErrBucketExists) {
 			t.Fatalf("unexpected error: %s", err)
 		}
 		return nil
@@ -287,7 +287,7 @@ func TestTx_CreateBucket_ErrBucketNameRequired(t *testing.T) {
 	db := MustOpenDB()
 	defer db.MustClose()
 	if err := db.Update(func(tx *bolt.Tx) error {
-		if _, err := tx.CreateBucket(nil); err != bolt.ErrBucketNameRequired {
+		if _, err := tx.CreateBucket(nil); !errors.Is(err, bolt.ErrBucketNameRequired) {
 			t.Fatalf("unexpected error: %s", err)
 		}
 		return nil
@@ -378,7 +378,7 @@ func TestTx_DeleteBucket_NotFound(t *testing.T) {
 	db := MustOpenDB()
 	defer db.MustClose()
 	if err := db.Update(func(tx *bolt.Tx) error {
-		if err := tx.DeleteBucket([]byte("widgets")); err != bolt.ErrBucketNotFound {
+		if err := tx.DeleteBucket([]byte("widgets")); !errors.Is(err, bolt.ErrBucketNotFound) {
 			t.Fatalf("unexpected error: %s", err)
 		}
 		return nil